@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/services"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ApiKey runs `main apikey issue|list|revoke` against the DBDRIVER database,
+// to issue the first admin key before the endpoints can be used. The keys are
+// those of the tenant given with -tenant.
+func ApiKey(args []string) {
+	flags := flag.NewFlagSet("apikey", flag.ExitOnError)
+	tenant := flags.String("tenant", domain.DefaultTenant, "tenant the keys belong to")
+	_ = flags.Parse(args)
+	args = flags.Args()
+	if len(args) == 0 {
+		log.Fatal("usage: main apikey [-tenant <id>] issue <name> <scope>... | list | revoke <id>")
+	}
+	if err := domain.ValidateTenant(*tenant); err != nil {
+		log.Fatal(err.Message())
+	}
+
+	db := openDatabase()
+	if db == nil {
+		log.Fatalf("the %s driver doesn't keep API keys across runs", domain.DriverMemory)
+	}
+	defer db.Close()
+	ctx := domain.WithTenant(context.Background(), *tenant)
+
+	switch args[0] {
+	case "issue":
+		if len(args) < 3 {
+			log.Fatal("usage: main apikey issue <name> <scope>...")
+		}
+		key, err := services.ApiKeysService.IssueApiKey(ctx, &domain.ApiKey{Name: args[1], Scopes: args[2:]})
+		if err != nil {
+			log.Fatal(err.Message())
+		}
+		fmt.Printf("issued API key %d of tenant %s, it won't be shown again:\n%s\n", key.Id, key.Tenant, key.Key)
+	case "list":
+		keys, err := services.ApiKeysService.GetAllApiKeys(ctx)
+		if err != nil {
+			log.Fatal(err.Message())
+		}
+		for _, key := range keys {
+			state := "never used"
+			if key.LastUsedAt != nil {
+				state = "used " + key.LastUsedAt.Format("2006-01-02 15:04:05")
+			}
+			if key.RevokedAt != nil {
+				state = "revoked " + key.RevokedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%d\t%s\t%s…\t%s\t%s\n", key.Id, key.Name, key.Prefix, strings.Join(key.Scopes, ","), state)
+		}
+	case "revoke":
+		if len(args) != 2 {
+			log.Fatal("usage: main apikey revoke <id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			log.Fatalf("the API key id should be a number, got %q", args[1])
+		}
+		if _, err := services.ApiKeysService.RevokeApiKey(ctx, id); err != nil {
+			log.Fatal(err.Message())
+		}
+		fmt.Printf("revoked API key %d\n", id)
+	default:
+		log.Fatalf("unknown apikey command %q, use issue, list or revoke", args[0])
+	}
+}
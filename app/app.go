@@ -2,12 +2,20 @@ package app
 
 import (
 	"context"
+	"database/sql"
+	"github.com/SemmiDev/lets-tests/auth"
 	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/events"
+	"github.com/SemmiDev/lets-tests/phone"
+	"github.com/SemmiDev/lets-tests/realtime"
+	"github.com/SemmiDev/lets-tests/services"
+	"github.com/SemmiDev/lets-tests/webhooks"
 	chimiddleware "github.com/go-chi/chi/middleware"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/httprate"
 	"github.com/joho/godotenv"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -27,28 +35,202 @@ func init() {
 }
 
 func StartApp() {
-	dbdriver := os.Getenv("DBDRIVER")
-	username := os.Getenv("USERNAME")
-	password := os.Getenv("PASSWORD")
-	host := os.Getenv("HOST")
-	database := os.Getenv("DATABASE")
-	port := os.Getenv("PORT")
 	httpRateLimitRequest := os.Getenv("HTTP_RATE_LIMIT_REQUEST")
 	httpRateLimitTime := os.Getenv("HTTP_RATE_LIMIT_TIME")
 	limitRequest, _ := strconv.Atoi(httpRateLimitRequest)
 	limitTime, _ := time.ParseDuration(httpRateLimitTime)
 
-	domain.ChatRepo.Initialize(dbdriver, username, password, port, host, database)
+	configurePhones()
 
+	if length := os.Getenv("CHAT_BODY_MAX_LENGTH"); length != "" {
+		maxLength, err := strconv.Atoi(length)
+		if err != nil || maxLength < 1 || maxLength > domain.MaxTextBodyLength {
+			log.Fatalf("CHAT_BODY_MAX_LENGTH should be a number of characters from 1 to %d", domain.MaxTextBodyLength)
+		}
+		domain.MaxBodyLength = maxLength
+	}
+
+	db := openDatabase()
+	if autoMigrate, _ := strconv.ParseBool(os.Getenv("DB_AUTO_MIGRATE")); autoMigrate && db != nil {
+		migrateUp(db)
+	}
+
+	router.Use(chimiddleware.RequestID)
 	router.Use(httprate.LimitByIP(limitRequest, limitTime))
 	router.Use(cors.AllowAll().Handler)
-	router.Use(chimiddleware.Logger)
+	router.Use(requestLogger())
 	router.Use(chimiddleware.Recoverer)
 
-	routes(router)
+	if replaySize, err := strconv.Atoi(os.Getenv("SSE_REPLAY_SIZE")); err == nil {
+		realtime.DefaultStream = realtime.NewStream(replaySize)
+	}
+	events.Default.Subscribe(realtime.DefaultHub.Publish)
+	events.Default.Subscribe(realtime.DefaultStream.Publish)
+	startOutboxDispatcher()
+
+	if retention, _ := time.ParseDuration(os.Getenv("SOFT_DELETE_RETENTION")); retention > 0 {
+		go purgeDeletedChats(retention, purgeInterval())
+	}
+	if ttl, _ := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL")); ttl > 0 {
+		domain.IdempotencyKeyTTL = ttl
+	}
+	go purgeIdempotencyKeys(time.Hour)
+
+	routes(router, authenticator())
 	run(":3333")
 }
 
+// authenticator verifies the API keys, and the bearer tokens with the keys
+// given in the environment. AUTH_DISABLED=true serves the API without
+// authentication.
+func authenticator() func(http.Handler) http.Handler {
+	if disabled, _ := strconv.ParseBool(os.Getenv("AUTH_DISABLED")); disabled {
+		log.Println("authentication is disabled, every caller can read and change every chat")
+		return nil
+	}
+
+	verifier := &auth.Verifier{
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+	//set but empty is a mistake, left out means HS256 isn't used
+	if secret, ok := os.LookupEnv("JWT_HS256_SECRET"); ok {
+		if err := auth.ValidateHMACSecret(secret); err != nil {
+			log.Fatalf("invalid JWT_HS256_SECRET: %s", err)
+		}
+		verifier.HMACSecret = []byte(secret)
+	}
+	publicKey := os.Getenv("JWT_RS256_PUBLIC_KEY")
+	if file := os.Getenv("JWT_RS256_PUBLIC_KEY_FILE"); file != "" {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatalf("could not read JWT_RS256_PUBLIC_KEY_FILE: %s", err)
+		}
+		publicKey = string(raw)
+	}
+	if publicKey != "" {
+		key, err := auth.ParseRSAPublicKey(publicKey)
+		if err != nil {
+			log.Fatalf("invalid RS256 public key: %s", err)
+		}
+		verifier.RSAPublicKey = key
+	}
+	if verifier.HMACSecret == nil && verifier.RSAPublicKey == nil {
+		log.Println("no JWT_HS256_SECRET nor JWT_RS256_PUBLIC_KEY, only API keys are accepted")
+		verifier = nil
+	}
+	return auth.Authenticate(verifier, services.ApiKeysService, queryTokenPaths...)
+}
+
+// openDatabase selects the chat repository for DBDRIVER and connects it.
+// The returned pool is nil for drivers that don't use SQL.
+func openDatabase() *sql.DB {
+	dbdriver := os.Getenv("DBDRIVER")
+	username := os.Getenv("USERNAME")
+	password := os.Getenv("PASSWORD")
+	host := os.Getenv("HOST")
+	database := os.Getenv("DATABASE")
+	port := os.Getenv("PORT")
+
+	repo, err := domain.NewRepository(dbdriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if queryTimeout := os.Getenv("DB_QUERY_TIMEOUT"); queryTimeout != "" {
+		timeout, err := time.ParseDuration(queryTimeout)
+		if err != nil || timeout < 0 {
+			log.Fatalf("DB_QUERY_TIMEOUT should be a duration such as 5s, got %q", queryTimeout)
+		}
+		domain.QueryTimeout = timeout
+	}
+	domain.ChatRepo = repo
+	if domain.OutboxRepo, err = domain.NewOutboxRepository(repo); err != nil {
+		log.Fatal(err)
+	}
+	if domain.WebhookRepo, err = domain.NewWebhookRepository(repo); err != nil {
+		log.Fatal(err)
+	}
+	if domain.ApiKeyRepo, err = domain.NewApiKeyRepository(repo); err != nil {
+		log.Fatal(err)
+	}
+	if domain.ChatSearcher, err = domain.NewSearcher(repo); err != nil {
+		log.Fatal(err)
+	}
+	return domain.ChatRepo.Initialize(dbdriver, username, password, port, host, database)
+}
+
+// startOutboxDispatcher delivers the events recorded in the outbox to the
+// real-time endpoints and the webhooks.
+func startOutboxDispatcher() {
+	dispatcher := events.NewDispatcher(domain.OutboxRepo)
+	if interval, err := time.ParseDuration(os.Getenv("OUTBOX_POLL_INTERVAL")); err == nil && interval > 0 {
+		dispatcher.PollInterval = interval
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		dispatcher.MaxAttempts = attempts
+	}
+	dispatcher.Handle("realtime", events.PublishTo(events.Default))
+	dispatcher.Handle("webhooks", startWebhookSender().Enqueue)
+
+	events.DefaultDispatcher = dispatcher
+	go dispatcher.Run(context.Background())
+}
+
+func startWebhookSender() *webhooks.Sender {
+	timeout, err := time.ParseDuration(os.Getenv("WEBHOOK_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	domain.AllowPrivateWebhookTargets, _ = strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE_TARGETS"))
+	sender := webhooks.NewSender(domain.WebhookRepo, timeout)
+	if attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		sender.MaxAttempts = attempts
+	}
+
+	webhooks.DefaultSender = sender
+	go sender.Run(context.Background())
+	return sender
+}
+
+func purgeInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("SOFT_DELETE_PURGE_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return time.Hour
+	}
+	return interval
+}
+
+// purgeDeletedChats hard-deletes chats once they have been soft-deleted
+// for longer than retention.
+func purgeDeletedChats(retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := services.ChatsService.PurgeDeletedChats(context.Background(), retention)
+		if err != nil {
+			log.Printf("purging deleted chats: %s", err.Message())
+		} else if purged > 0 {
+			log.Printf("purged %d chats deleted more than %s ago", purged, retention)
+		}
+		<-ticker.C
+	}
+}
+
+// purgeIdempotencyKeys forgets the keys older than IdempotencyKeyTTL, they
+// are already ignored by lookups.
+func purgeIdempotencyKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := domain.ChatRepo.PurgeIdempotencyKeys(context.Background(), time.Now().Add(-domain.IdempotencyKeyTTL)); err != nil {
+			log.Printf("purging idempotency keys: %s", err.Message())
+		}
+		<-ticker.C
+	}
+}
+
 func run(addr string) {
 	ctx, cancel := context.WithCancel(context.Background())
 	httpServer := &http.Server{
@@ -83,16 +265,28 @@ func run(addr string) {
 	gracefullCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelShutdown()
 
-	if err := httpServer.Shutdown(gracefullCtx); err != nil {
+	//requests still running after the grace period see their context
+	//canceled, which aborts their database calls
+	err := httpServer.Shutdown(gracefullCtx)
+	cancel()
+	if err != nil {
 		log.Printf("shutdown error: %v\n", err)
 		defer os.Exit(1)
 		return
-	} else {
-		log.Printf("gracefully stopped\n")
 	}
-
-	cancel()
+	log.Printf("gracefully stopped\n")
 
 	defer os.Exit(0)
 	return
 }
+
+// configurePhones sets the calling code of numbers typed without one, which
+// the migrations are given as much as the handlers use it.
+func configurePhones() {
+	if code := os.Getenv("PHONE_DEFAULT_COUNTRY_CODE"); code != "" {
+		if !phone.ValidCountryCode(code) {
+			log.Fatalf("PHONE_DEFAULT_COUNTRY_CODE %q is not a country calling code", code)
+		}
+		phone.DefaultCountryCode = code
+	}
+}
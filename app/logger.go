@@ -0,0 +1,30 @@
+package app
+
+import (
+	"github.com/SemmiDev/lets-tests/auth"
+	chimiddleware "github.com/go-chi/chi/middleware"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+)
+
+// requestLogger logs requests as chimiddleware.Logger does, with the
+// access_token query parameter hidden so the logs don't hold live tokens.
+func requestLogger() func(http.Handler) http.Handler {
+	formatter := &chimiddleware.DefaultLogFormatter{
+		Logger:  log.New(os.Stdout, "", log.LstdFlags),
+		NoColor: runtime.GOOS == "windows",
+	}
+	return chimiddleware.RequestLogger(redactingLogFormatter{formatter})
+}
+
+type redactingLogFormatter struct {
+	chimiddleware.LogFormatter
+}
+
+func (f redactingLogFormatter) NewLogEntry(r *http.Request) chimiddleware.LogEntry {
+	logged := *r
+	logged.RequestURI = auth.RedactAccessToken(r.RequestURI)
+	return f.LogFormatter.NewLogEntry(&logged)
+}
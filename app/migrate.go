@@ -0,0 +1,79 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/migrations"
+	"github.com/SemmiDev/lets-tests/phone"
+	"log"
+	"os"
+)
+
+// Migrate runs `main migrate up|down|status` against the DBDRIVER database.
+func Migrate(args []string) {
+	if len(args) != 1 {
+		log.Fatal("usage: main migrate up|down|status")
+	}
+
+	configurePhones()
+	db := openDatabase()
+	if db == nil {
+		log.Fatalf("the %s driver has no schema to migrate", os.Getenv("DBDRIVER"))
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		migrateUp(db)
+	case "down":
+		runner := newMigrationRunner(db)
+		reverted, err := runner.Down()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if reverted == nil {
+			log.Print("no migration to revert")
+			return
+		}
+		log.Printf("reverted migration %d_%s", reverted.Version, reverted.Name)
+	case "status":
+		runner := newMigrationRunner(db)
+		statuses, err := runner.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+	default:
+		log.Fatalf("unknown migrate command %q, use up, down or status", args[0])
+	}
+}
+
+func migrateUp(db *sql.DB) {
+	applied, err := newMigrationRunner(db).Up()
+	for _, m := range applied {
+		log.Printf("applied migration %d_%s", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(applied) == 0 {
+		log.Print("database schema is up to date")
+	}
+}
+
+// newMigrationRunner hands the data migrations the calling code set by
+// configurePhones, the one the handlers normalize numbers with.
+func newMigrationRunner(db *sql.DB) *migrations.Runner {
+	input := migrations.Input{DefaultCountryCode: phone.DefaultCountryCode}
+	runner, err := migrations.NewRunner(db, os.Getenv("DBDRIVER"), input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return runner
+}
@@ -2,33 +2,100 @@ package app
 
 import (
 	"fmt"
+	"github.com/SemmiDev/lets-tests/auth"
 	"github.com/SemmiDev/lets-tests/controllers"
+	"github.com/SemmiDev/lets-tests/domain"
 	"github.com/go-chi/chi/v5"
 	"log"
 	"net/http"
 )
 
-func routes(router *chi.Mux) {
+// queryTokenPaths are the streams browsers open without headers, which may
+// authenticate with the access_token query parameter.
+var queryTokenPaths = []string{"/api/v1/chats/stream", "/api/v1/ws"}
+
+// routes registers the API. authenticate guards every endpoint of it, nil
+// leaves them open. Either way each request is scoped to its tenant.
+func routes(router *chi.Mux, authenticate func(http.Handler) http.Handler) {
 	router.Options("/*", func(w http.ResponseWriter, r *http.Request) {})
-	api := router.Route("/api/v1", func(router chi.Router) {})
+	api := router.Route("/api/v1", func(router chi.Router) {
+		if authenticate != nil {
+			router.Use(authenticate)
+		}
+		router.Use(auth.ResolveTenant)
+	})
+
+	read := auth.Require(domain.ScopeChatsRead)
+	write := auth.Require(domain.ScopeChatsWrite)
+	admin := auth.Require(domain.ScopeChatsAdmin)
 
 	api.Route("/chats", func(r chi.Router) {
-		r.Post("/", controllers.CreateChat)
-		r.Get("/", controllers.GetAllChats)
-		r.Get("/{chat_id}", controllers.GetChat)
-		r.Put("/{chat_id}", controllers.UpdateChat)
-		r.Delete("/{chat_id}", controllers.DeleteChat)
+		r.With(write).Post("/", controllers.CreateChat)
+		r.With(read).Get("/", controllers.GetAllChats)
+		r.With(read).Get("/stream", controllers.StreamChats)
+		r.With(read).Get("/search", controllers.SearchChats)
+		r.With(read).Get("/{chat_id}", controllers.GetChat)
+		r.With(write).Put("/{chat_id}", controllers.UpdateChat)
+		r.With(write).Delete("/{chat_id}", controllers.DeleteChat)
+		r.With(write).Post("/{chat_id}/restore", controllers.RestoreChat)
+		r.With(read).Get("/{chat_id}/revisions", controllers.GetChatRevisions)
+		r.With(write).Post("/{chat_id}/read", controllers.ReadChat)
 	})
 
+	api.Route("/conversations", func(r chi.Router) {
+		r.With(read).Get("/unread", controllers.GetUnreadCounts)
+		r.With(read).Get("/{a}/{b}", controllers.GetConversation)
+		r.With(write).Post("/{peer}/read-up-to/{chat_id}", controllers.ReadConversation)
+	})
+
+	api.With(read).Get("/inbox/{phone}", controllers.GetInbox)
+
+	api.Route("/webhooks", func(r chi.Router) {
+		r.With(write).Post("/", controllers.CreateWebhook)
+		r.With(read).Get("/", controllers.GetAllWebhooks)
+		r.With(read).Get("/{webhook_id}", controllers.GetWebhook)
+		r.With(write).Put("/{webhook_id}", controllers.UpdateWebhook)
+		r.With(write).Delete("/{webhook_id}", controllers.DeleteWebhook)
+		r.With(read).Get("/{webhook_id}/deliveries", controllers.GetWebhookDeliveries)
+	})
+
+	api.Route("/api-keys", func(r chi.Router) {
+		r.Use(admin)
+		r.Post("/", controllers.IssueApiKey)
+		r.Get("/", controllers.GetAllApiKeys)
+		r.Delete("/{api_key_id}", controllers.RevokeApiKey)
+	})
+
+	api.With(read).Get("/ws", controllers.ServeWebSocket)
+
 	fmt.Println()
-	registeredEndpointLog("/", "POST", "CreateChat")
-	registeredEndpointLog("/", "GET", "GetAllChat")
-	registeredEndpointLog("/{chat_id}", "GET", "GetChat")
-	registeredEndpointLog("/{chat_id}", "PUT", "UpdateChat")
-	registeredEndpointLog("/{chat_id}", "DELETE", "DeleteChat")
+	registeredEndpointLog("/chats", "POST", "CreateChat")
+	registeredEndpointLog("/chats", "GET", "GetAllChat")
+	registeredEndpointLog("/chats/stream", "GET", "StreamChats")
+	registeredEndpointLog("/chats/search?q={q}", "GET", "SearchChats")
+	registeredEndpointLog("/chats/{chat_id}", "GET", "GetChat")
+	registeredEndpointLog("/chats/{chat_id}", "PUT", "UpdateChat")
+	registeredEndpointLog("/chats/{chat_id}", "DELETE", "DeleteChat")
+	registeredEndpointLog("/chats/{chat_id}/restore", "POST", "RestoreChat")
+	registeredEndpointLog("/chats/{chat_id}/revisions", "GET", "GetChatRevisions")
+	registeredEndpointLog("/chats/{chat_id}/read", "POST", "ReadChat")
+	registeredEndpointLog("/conversations/unread?phone={phone}", "GET", "GetUnreadCounts")
+	registeredEndpointLog("/conversations/{a}/{b}", "GET", "GetConversation")
+	registeredEndpointLog("/conversations/{peer}/read-up-to/{chat_id}", "POST", "ReadConversation")
+	registeredEndpointLog("/inbox/{phone}", "GET", "GetInbox")
+	registeredEndpointLog("/webhooks", "POST", "CreateWebhook")
+	registeredEndpointLog("/webhooks", "GET", "GetAllWebhooks")
+	registeredEndpointLog("/webhooks/{webhook_id}", "GET", "GetWebhook")
+	registeredEndpointLog("/webhooks/{webhook_id}", "PUT", "UpdateWebhook")
+	registeredEndpointLog("/webhooks/{webhook_id}", "DELETE", "DeleteWebhook")
+	registeredEndpointLog("/webhooks/{webhook_id}/deliveries", "GET", "GetWebhookDeliveries")
+	registeredEndpointLog("/api-keys", "POST", "IssueApiKey")
+	registeredEndpointLog("/api-keys", "GET", "GetAllApiKeys")
+	registeredEndpointLog("/api-keys/{api_key_id}", "DELETE", "RevokeApiKey")
+	registeredEndpointLog("/ws?phone={phone}", "GET", "ServeWebSocket")
 
 }
 
 func registeredEndpointLog(path, act, handler string) {
-	log.Println("Registered Endpoint :: " + act + " ::  " + " localhost:3333/api/v1/chats" + path + " :: " + "Handler -> " + handler)
+	log.Println("Registered Endpoint :: " + act + " ::  " + " localhost:3333/api/v1" + path + " :: " + "Handler -> " + handler)
 }
@@ -0,0 +1,67 @@
+// Package auth identifies the caller of the API and carries that identity
+// down to the services through the request context.
+package auth
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/domain"
+)
+
+// Identity is the authenticated caller. Users have the Phone they send and
+// receive chats as. Services calling with an API key have no Phone and act
+// for every number, within their Scopes. Both only reach the chats of their
+// Tenant.
+type Identity struct {
+	Phone  string
+	Scopes []string
+	Tenant string
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying identity.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the caller of the request ctx belongs to. ok is false
+// for work that doesn't come from a request, such as background jobs, which
+// is trusted.
+func FromContext(ctx context.Context) (identity Identity, ok bool) {
+	identity, ok = ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// UserFromContext returns the caller when it is a user, who is limited to
+// their own chats. ok is false for services and background jobs.
+func UserFromContext(ctx context.Context) (identity Identity, ok bool) {
+	identity, ok = FromContext(ctx)
+	return identity, ok && identity.Phone != ""
+}
+
+// HasScope tells whether the caller was granted scope, or the admin scope
+// which includes every other.
+func (i Identity) HasScope(scope string) bool {
+	for _, granted := range i.Scopes {
+		if granted == scope || granted == domain.ScopeChatsAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead tells whether the caller may see a chat between sender and
+// receiver.
+func (i Identity) CanRead(sender, receiver string) bool {
+	return i.Phone == "" || i.Phone == sender || i.Phone == receiver
+}
+
+// CanWrite tells whether the caller may change a chat sent by sender.
+func (i Identity) CanWrite(sender string) bool {
+	return i.Phone == "" || i.Phone == sender
+}
+
+// CanMarkRead tells whether the caller may mark a chat sent to receiver read.
+func (i Identity) CanMarkRead(receiver string) bool {
+	return i.Phone == "" || i.Phone == receiver
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+)
+
+// Claims are the JWT claims read from a bearer token. The caller's phone
+// number is the OpenID phone_number claim, or the subject without it. Tokens
+// without a tenant_id claim belong to domain.DefaultTenant.
+type Claims struct {
+	PhoneNumber string `json:"phone_number,omitempty"`
+	TenantId    string `json:"tenant_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verifier checks bearer tokens signed with HS256, RS256 or both, depending
+// on the keys it was given.
+type Verifier struct {
+	HMACSecret   []byte
+	RSAPublicKey *rsa.PublicKey
+	// Issuer and Audience are checked when set.
+	Issuer   string
+	Audience string
+}
+
+// PlaceholderHMACSecret is the JWT_HS256_SECRET of .env.example. It is
+// public, so tokens signed with it prove nothing.
+const PlaceholderHMACSecret = "change-me"
+
+// knownHMACSecrets were shipped in the repository at some point and can't be
+// trusted either.
+var knownHMACSecrets = []string{PlaceholderHMACSecret, "local-development-secret-change-me"}
+
+// ValidateHMACSecret refuses an empty secret or a publicly known one, with
+// which anyone could forge a token for any phone number.
+func ValidateHMACSecret(secret string) error {
+	if strings.TrimSpace(secret) == "" {
+		return fmt.Errorf("the HS256 secret is empty")
+	}
+	for _, known := range knownHMACSecrets {
+		if secret == known {
+			return fmt.Errorf("the HS256 secret is a placeholder, set a random one")
+		}
+	}
+	return nil
+}
+
+// ParseRSAPublicKey reads a PEM encoded RSA public key, as given in
+// JWT_RS256_PUBLIC_KEY.
+func ParseRSAPublicKey(pem string) (*rsa.PublicKey, error) {
+	//env files can't hold line breaks
+	pem = strings.Replace(pem, `\n`, "\n", -1)
+	return jwt.ParseRSAPublicKeyFromPEM([]byte(pem))
+}
+
+// userScopes are granted to every user, who only reach their own chats.
+var userScopes = []string{domain.ScopeChatsRead, domain.ScopeChatsWrite}
+
+// Verify returns the identity a token was issued for.
+func (v *Verifier) Verify(token string) (Identity, utils.ChatErr) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, v.key, jwt.WithValidMethods([]string{"HS256", "RS256"}))
+	if err != nil {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, fmt.Sprintf("invalid token: %s", err.Error()))
+	}
+	if claims.ExpiresAt == nil {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "invalid token: the exp claim is required")
+	}
+	if v.Issuer != "" && !claims.VerifyIssuer(v.Issuer, true) {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "invalid token: unexpected issuer")
+	}
+	if v.Audience != "" && !claims.VerifyAudience(v.Audience, true) {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "invalid token: unexpected audience")
+	}
+
+	phone := claims.PhoneNumber
+	if phone == "" {
+		phone = claims.Subject
+	}
+	normalized, phoneErr := domain.NormalizePhone(phone)
+	if phoneErr != nil {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "invalid token: the subject should be a phone number")
+	}
+	tenant := claims.TenantId
+	if tenant == "" {
+		tenant = domain.DefaultTenant
+	}
+	if err := domain.ValidateTenant(tenant); err != nil {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "invalid token: "+err.Message())
+	}
+	return Identity{Phone: normalized, Scopes: userScopes, Tenant: tenant}, nil
+}
+
+// key hands the token the key of its algorithm, so an RS256 public key can
+// never be used as an HS256 secret.
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(v.HMACSecret) == 0 {
+			return nil, fmt.Errorf("HS256 tokens are not accepted")
+		}
+		return v.HMACSecret, nil
+	case *jwt.SigningMethodRSA:
+		if v.RSAPublicKey == nil {
+			return nil, fmt.Errorf("RS256 tokens are not accepted")
+		}
+		return v.RSAPublicKey, nil
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Header["alg"])
+}
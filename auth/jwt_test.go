@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const phone = "+6282323231"
+
+var hmacSecret = []byte("a-test-secret-of-some-length")
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+	return token
+}
+
+func claimsFor(subject string, expiresIn time.Duration) Claims {
+	return Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+	}}
+}
+
+func TestVerifier_Verify_HS256(t *testing.T) {
+	verifier := &Verifier{HMACSecret: hmacSecret}
+
+	identity, err := verifier.Verify(sign(t, jwt.SigningMethodHS256, hmacSecret, claimsFor(phone, time.Hour)))
+	assert.Nil(t, err)
+	assert.EqualValues(t, phone, identity.Phone)
+
+	//phone_number wins over the subject
+	claims := claimsFor("user-42", time.Hour)
+	claims.PhoneNumber = phone
+	identity, err = verifier.Verify(sign(t, jwt.SigningMethodHS256, hmacSecret, claims))
+	assert.Nil(t, err)
+	assert.EqualValues(t, phone, identity.Phone)
+	assert.EqualValues(t, domain.DefaultTenant, identity.Tenant)
+
+	claims.TenantId = "acme"
+	identity, err = verifier.Verify(sign(t, jwt.SigningMethodHS256, hmacSecret, claims))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "acme", identity.Tenant)
+}
+
+func TestVerifier_Verify_RS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey() error = %v", err)
+	}
+	encoded := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	//as written on a single line of an env file
+	publicKey, parseErr := ParseRSAPublicKey(strings.Replace(encoded, "\n", `\n`, -1))
+	if parseErr != nil {
+		t.Fatalf("ParseRSAPublicKey() error = %v", parseErr)
+	}
+	verifier := &Verifier{RSAPublicKey: publicKey}
+
+	identity, verifyErr := verifier.Verify(sign(t, jwt.SigningMethodRS256, key, claimsFor(phone, time.Hour)))
+	assert.Nil(t, verifyErr)
+	assert.EqualValues(t, phone, identity.Phone)
+
+	//the public key must not be usable as an HMAC secret
+	_, verifyErr = verifier.Verify(sign(t, jwt.SigningMethodHS256, []byte(encoded), claimsFor(phone, time.Hour)))
+	assert.NotNil(t, verifyErr)
+}
+
+func TestVerifier_Verify_Invalid(t *testing.T) {
+	verifier := &Verifier{HMACSecret: hmacSecret, Issuer: "https://auth.example.com", Audience: "chats"}
+	valid := func() Claims {
+		claims := claimsFor(phone, time.Hour)
+		claims.Issuer = "https://auth.example.com"
+		claims.Audience = jwt.ClaimStrings{"chats"}
+		return claims
+	}
+
+	_, err := verifier.Verify(sign(t, jwt.SigningMethodHS256, hmacSecret, valid()))
+	assert.Nil(t, err)
+
+	expired := valid()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noExpiry := valid()
+	noExpiry.ExpiresAt = nil
+	otherIssuer := valid()
+	otherIssuer.Issuer = "https://evil.example.com"
+	otherAudience := valid()
+	otherAudience.Audience = jwt.ClaimStrings{"billing"}
+	notAPhone := valid()
+	notAPhone.Subject = "user-42"
+	invalidTenant := valid()
+	invalidTenant.TenantId = "Acme Corp"
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"Expired", sign(t, jwt.SigningMethodHS256, hmacSecret, expired)},
+		{"Without Expiry", sign(t, jwt.SigningMethodHS256, hmacSecret, noExpiry)},
+		{"Other Issuer", sign(t, jwt.SigningMethodHS256, hmacSecret, otherIssuer)},
+		{"Other Audience", sign(t, jwt.SigningMethodHS256, hmacSecret, otherAudience)},
+		{"Subject Is Not A Phone", sign(t, jwt.SigningMethodHS256, hmacSecret, notAPhone)},
+		{"Invalid Tenant", sign(t, jwt.SigningMethodHS256, hmacSecret, invalidTenant)},
+		{"Wrong Secret", sign(t, jwt.SigningMethodHS256, []byte("another-secret"), valid())},
+		{"Unsigned", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid())},
+		{"Not A Token", "abc.def.ghi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifier.Verify(tt.token)
+			if assert.NotNil(t, err) {
+				assert.EqualValues(t, http.StatusUnauthorized, err.Status())
+			}
+		})
+	}
+}
+
+func TestValidateHMACSecret(t *testing.T) {
+	assert.NotNil(t, ValidateHMACSecret(""))
+	assert.NotNil(t, ValidateHMACSecret("  "))
+	assert.NotNil(t, ValidateHMACSecret(PlaceholderHMACSecret))
+	assert.NotNil(t, ValidateHMACSecret("local-development-secret-change-me"))
+	assert.Nil(t, ValidateHMACSecret(string(hmacSecret)))
+}
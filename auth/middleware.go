@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ApiKeyHeader carries the API key of service clients.
+const ApiKeyHeader = "X-API-Key"
+
+// AccessTokenParam carries the bearer token of the clients that can't set
+// headers.
+const AccessTokenParam = "access_token"
+
+// TenantHeader names the tenant of a request. It may only repeat the tenant
+// of the credentials, and picks one when authentication is disabled.
+const TenantHeader = "X-Tenant-ID"
+
+// KeyVerifier returns the identity an API key was issued for.
+type KeyVerifier interface {
+	VerifyKey(ctx context.Context, key string) (Identity, utils.ChatErr)
+}
+
+// Authenticate rejects the requests without a valid bearer token or API key
+// and puts the identity of the others in their context. A nil verifier
+// refuses bearer tokens, nil keys refuse API keys.
+//
+// Browsers can't set headers on WebSocket and EventSource connections, so
+// the requests to queryTokenPaths may pass the token as the AccessTokenParam
+// query parameter instead. Other requests would leave it in logs and
+// browser histories for nothing.
+func Authenticate(verifier *Verifier, keys KeyVerifier, queryTokenPaths ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, err := authenticate(r, verifier, keys, queryTokenPaths)
+			if err != nil {
+				unauthorized(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+		})
+	}
+}
+
+func authenticate(r *http.Request, verifier *Verifier, keys KeyVerifier, queryTokenPaths []string) (Identity, utils.ChatErr) {
+	if key := r.Header.Get(ApiKeyHeader); key != "" {
+		if keys == nil {
+			return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "API keys are not accepted")
+		}
+		return keys.VerifyKey(r.Context(), key)
+	}
+	token := bearerToken(r, queryTokenPaths)
+	if token == "" {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "Authorization should be a bearer token, or X-API-Key an API key")
+	}
+	if verifier == nil {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "bearer tokens are not accepted")
+	}
+	return verifier.Verify(token)
+}
+
+// Require answers 403 to the callers that weren't granted scope. Requests
+// without an identity, when authentication is disabled, go through.
+func Require(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if identity, ok := FromContext(r.Context()); ok && !identity.HasScope(scope) {
+				writeError(w, r, utils.ErrorKind(utils.ForbiddenError, "missing the "+scope+" scope"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ResolveTenant scopes the context of every request to a tenant, that of the
+// caller's credentials or, without an identity, the one of TenantHeader. The
+// repositories then never reach the chats of another tenant.
+func ResolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := resolveTenant(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(domain.WithTenant(r.Context(), tenant)))
+	})
+}
+
+func resolveTenant(r *http.Request) (string, utils.ChatErr) {
+	requested := strings.TrimSpace(r.Header.Get(TenantHeader))
+	identity, ok := FromContext(r.Context())
+	if !ok {
+		if requested == "" {
+			return domain.DefaultTenant, nil
+		}
+		return requested, domain.ValidateTenant(requested)
+	}
+
+	tenant := identity.Tenant
+	if tenant == "" {
+		tenant = domain.DefaultTenant
+	}
+	if requested != "" && requested != tenant {
+		return "", utils.ErrorKind(utils.ForbiddenError, "the credentials don't grant access to tenant "+requested)
+	}
+	return tenant, nil
+}
+
+func bearerToken(r *http.Request, queryTokenPaths []string) string {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		for _, path := range queryTokenPaths {
+			if r.URL.Path == path {
+				return r.URL.Query().Get(AccessTokenParam)
+			}
+		}
+		return ""
+	}
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// RedactAccessToken hides the value of AccessTokenParam in uri, a request
+// URI about to be logged.
+func RedactAccessToken(uri string) string {
+	target, err := url.ParseRequestURI(uri)
+	if err != nil || !strings.Contains(target.RawQuery, AccessTokenParam) {
+		return uri
+	}
+	query := target.Query()
+	if _, ok := query[AccessTokenParam]; !ok {
+		return uri
+	}
+	query.Set(AccessTokenParam, "REDACTED")
+	target.RawQuery = query.Encode()
+	return target.RequestURI()
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, err utils.ChatErr) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	writeError(w, r, err)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err utils.ChatErr) {
+	utils.WriteError(w, r, err.Status(), err)
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// keys accepts the single key "lt_valid", with the read scope.
+type keys struct{}
+
+func (keys) VerifyKey(ctx context.Context, key string) (Identity, utils.ChatErr) {
+	if key != "lt_valid" {
+		return Identity{}, utils.ErrorKind(utils.UnauthorizedError, "invalid API key")
+	}
+	return Identity{Scopes: []string{domain.ScopeChatsRead}}, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	handler := Authenticate(&Verifier{HMACSecret: hmacSecret}, keys{}, "/api/v1/ws")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := FromContext(r.Context())
+		assert.True(t, ok)
+		_, _ = w.Write([]byte(identity.Phone))
+	}))
+	token := sign(t, jwt.SigningMethodHS256, hmacSecret, claimsFor(phone, time.Hour))
+
+	tests := []struct {
+		name          string
+		authorization string
+		apiKey        string
+		target        string
+		wantStatus    int
+		wantPhone     string
+	}{
+		{name: "Bearer Header", authorization: "Bearer " + token, wantStatus: http.StatusOK, wantPhone: phone},
+		{name: "Lower Case Scheme", authorization: "bearer " + token, wantStatus: http.StatusOK, wantPhone: phone},
+		{name: "Query Parameter", target: "/api/v1/ws?access_token=" + token, wantStatus: http.StatusOK, wantPhone: phone},
+		{name: "Query Parameter Elsewhere", target: "/api/v1/chats?access_token=" + token, wantStatus: http.StatusUnauthorized},
+		{name: "API Key", apiKey: "lt_valid", wantStatus: http.StatusOK},
+		{name: "Invalid API Key", apiKey: "lt_invalid", wantStatus: http.StatusUnauthorized},
+		{name: "Missing", wantStatus: http.StatusUnauthorized},
+		{name: "Basic Scheme", authorization: "Basic dXNlcjpwYXNz", wantStatus: http.StatusUnauthorized},
+		{name: "Invalid Token", authorization: "Bearer abc", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			if target == "" {
+				target = "/api/v1/chats"
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set(ApiKeyHeader, tt.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.EqualValues(t, tt.wantStatus, rr.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.EqualValues(t, tt.wantPhone, rr.Body.String())
+			} else {
+				assert.EqualValues(t, `Bearer realm="api"`, rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRedactAccessToken(t *testing.T) {
+	assert.EqualValues(t, "/api/v1/ws?access_token=REDACTED&phone=%2B6282323231", RedactAccessToken("/api/v1/ws?phone=%2B6282323231&access_token=secret.jwt.value"))
+	assert.EqualValues(t, "/api/v1/chats?limit=10", RedactAccessToken("/api/v1/chats?limit=10"))
+	assert.EqualValues(t, "/api/v1/chats", RedactAccessToken("/api/v1/chats"))
+}
+
+func TestAuthenticate_Without_Keys(t *testing.T) {
+	handler := Authenticate(nil, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request should have been refused")
+	}))
+
+	for _, header := range []string{"Authorization", ApiKeyHeader} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/chats", nil)
+		req.Header.Set(header, "Bearer lt_valid")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.EqualValues(t, http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	handler := Require(domain.ScopeChatsWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		wantStatus int
+	}{
+		{"Without Identity", context.Background(), http.StatusOK},
+		{"User", WithIdentity(context.Background(), Identity{Phone: phone, Scopes: userScopes}), http.StatusOK},
+		{"Admin Key", WithIdentity(context.Background(), Identity{Scopes: []string{domain.ScopeChatsAdmin}}), http.StatusOK},
+		{"Read Only Key", WithIdentity(context.Background(), Identity{Scopes: []string{domain.ScopeChatsRead}}), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/chats", nil).WithContext(tt.ctx)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.EqualValues(t, tt.wantStatus, rr.Code)
+		})
+	}
+}
+
+func TestResolveTenant(t *testing.T) {
+	handler := ResolveTenant(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(domain.TenantFromContext(r.Context())))
+	}))
+	acme := WithIdentity(context.Background(), Identity{Phone: phone, Scopes: userScopes, Tenant: "acme"})
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		header     string
+		wantStatus int
+		wantTenant string
+	}{
+		{name: "Default", ctx: context.Background(), wantStatus: http.StatusOK, wantTenant: domain.DefaultTenant},
+		{name: "Header Without Identity", ctx: context.Background(), header: "acme", wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "Invalid Header", ctx: context.Background(), header: "Acme Corp", wantStatus: http.StatusBadRequest},
+		{name: "Tenant Of The Identity", ctx: acme, wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "Same Header", ctx: acme, header: "acme", wantStatus: http.StatusOK, wantTenant: "acme"},
+		//the header never widens what the credentials were issued for
+		{name: "Other Header", ctx: acme, header: "globex", wantStatus: http.StatusForbidden},
+		{name: "Identity Without Tenant", ctx: WithIdentity(context.Background(), Identity{Scopes: userScopes}), header: "acme", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/chats", nil).WithContext(tt.ctx)
+			if tt.header != "" {
+				req.Header.Set(TenantHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.EqualValues(t, tt.wantStatus, rr.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.EqualValues(t, tt.wantTenant, rr.Body.String())
+			}
+		})
+	}
+}
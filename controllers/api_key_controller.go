@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/services"
+	"net/http"
+)
+
+func IssueApiKey(w http.ResponseWriter, r *http.Request) {
+	var key domain.ApiKey
+	if err := DecodeBody(w, r, &key); err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	res, theErr := services.ApiKeysService.IssueApiKey(r.Context(), &key)
+	if theErr != nil {
+		MarshalError(w, r, theErr.Status(), theErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusCreated, "CREATED", res)
+}
+
+func GetAllApiKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := services.ApiKeysService.GetAllApiKeys(r.Context())
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", keys)
+}
+
+func RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	keyId, err := GetUrlPathInt64(r, "api_key_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	key, revokeErr := services.ApiKeysService.RevokeApiKey(r.Context(), keyId)
+	if revokeErr != nil {
+		MarshalError(w, r, revokeErr.Status(), revokeErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", key)
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"bytes"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIssueApiKey_Invalid_UTF8(t *testing.T) {
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/api-keys", bytes.NewReader([]byte("{\"name\": \"ci \xff\"}")))
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/api-keys", IssueApiKey)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, apiErr.Status())
+	assert.EqualValues(t, "Name should be valid UTF-8", apiErr.Message())
+}
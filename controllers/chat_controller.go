@@ -2,27 +2,42 @@ package controllers
 
 import (
 	"encoding/json"
+	"github.com/SemmiDev/lets-tests/auth"
 	"github.com/SemmiDev/lets-tests/domain"
 	"github.com/SemmiDev/lets-tests/services"
-	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/go-chi/chi/v5"
 	"net/http"
 )
 
 func CreateChat(w http.ResponseWriter, r *http.Request) {
 	var chat domain.Chat
-	err := json.NewDecoder(r.Body).Decode(&chat)
-	if err != nil {
-		theErr := utils.ErrorKind(utils.UnprocessableEntityError, "invalid json body")
-		MarshalError(w, theErr.Status(), theErr)
+	if err := DecodeBody(w, r, &chat); err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	//with an Idempotency-Key a retried request answers like the first one
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		res, replayed, theErr := services.ChatsService.CreateChatWithKey(r.Context(), key, &chat)
+		if theErr != nil {
+			MarshalError(w, r, theErr.Status(), theErr)
+			return
+		}
+		if replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+		SetETag(w, res)
+		MarshallSuccess(w, http.StatusCreated, "CREATED", res)
 		return
 	}
 
-	res, theErr := services.ChatsService.CreateChat(&chat)
+	res, theErr := services.ChatsService.CreateChat(r.Context(), &chat)
 	if theErr != nil {
-		MarshalError(w, theErr.Status(), theErr)
+		MarshalError(w, r, theErr.Status(), theErr)
 		return
 	}
 
+	SetETag(w, res)
 	MarshallSuccess(w, http.StatusCreated, "CREATED", res)
 	return
 }
@@ -30,24 +45,78 @@ func CreateChat(w http.ResponseWriter, r *http.Request) {
 func GetChat(w http.ResponseWriter, r *http.Request) {
 	chatId, err := GetUrlPathInt64(r, "chat_id")
 	if err != nil {
-		MarshalError(w, err.Status(), err)
+		MarshalError(w, r, err.Status(), err)
 		return
 	}
 
-	chat, getErr := services.ChatsService.GetChat(chatId)
+	chat, getErr := services.ChatsService.GetChat(r.Context(), chatId)
 	if getErr != nil {
-		MarshalError(w, getErr.Status(), getErr)
+		MarshalError(w, r, getErr.Status(), getErr)
 		return
 	}
 
+	SetETag(w, chat)
 	MarshallSuccess(w, http.StatusOK, "OK", chat)
 	return
 }
 
-func GetAllChats(w http.ResponseWriter, _ *http.Request) {
-	chats, getErr := services.ChatsService.GetAllChats()
+func GetAllChats(w http.ResponseWriter, r *http.Request) {
+	filter, err := domain.NewChatFilter(r.URL.Query())
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	page, err := GetPageRequest(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	chats, getErr := services.ChatsService.GetAllChats(r.Context(), filter, page)
 	if getErr != nil {
-		MarshalError(w, getErr.Status(), getErr)
+		MarshalError(w, r, getErr.Status(), getErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", chats)
+	return
+}
+
+// SearchChats finds chats whose body holds the words and phrases of the q
+// query parameter, best matches first.
+func SearchChats(w http.ResponseWriter, r *http.Request) {
+	page, err := GetPageRequest(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	request, err := domain.NewSearchRequest(r.URL.Query(), page)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	hits, searchErr := services.ChatsService.SearchChats(r.Context(), request)
+	if searchErr != nil {
+		MarshalError(w, r, searchErr.Status(), searchErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", hits)
+}
+
+func GetConversation(w http.ResponseWriter, r *http.Request) {
+	page, err := GetPageRequest(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	chats, getErr := services.ChatsService.GetConversation(r.Context(), chi.URLParam(r, "a"), chi.URLParam(r, "b"), page)
+	if getErr != nil {
+		MarshalError(w, r, getErr.Status(), getErr)
 		return
 	}
 
@@ -58,28 +127,34 @@ func GetAllChats(w http.ResponseWriter, _ *http.Request) {
 func UpdateChat(w http.ResponseWriter, r *http.Request) {
 	chatId, err := GetUrlPathInt64(r, "chat_id")
 	if err != nil {
-		MarshalError(w, err.Status(), err)
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	version, err := GetIfMatch(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
 		return
 	}
 
 	var req domain.UpdateChatRequest
-	reqErr := json.NewDecoder(r.Body).Decode(&req)
-	if reqErr != nil {
-		theErr := utils.ErrorKind(utils.UnprocessableEntityError, "invalid json body")
-		MarshalError(w, theErr.Status(), theErr)
+	if err := DecodeBody(w, r, &req); err != nil {
+		MarshalError(w, r, err.Status(), err)
 		return
 	}
 
 	chat := domain.Chat{
-		Id:   chatId,
-		Body: req.Body,
+		Id:      chatId,
+		Body:    req.Body,
+		Version: version,
 	}
-	update, theErr := services.ChatsService.UpdateChat(&chat)
+	update, theErr := services.ChatsService.UpdateChat(r.Context(), &chat)
 	if theErr != nil {
-		MarshalError(w, theErr.Status(), theErr)
+		MarshalError(w, r, theErr.Status(), theErr)
 		return
 	}
 
+	SetETag(w, update)
 	MarshallSuccess(w, http.StatusOK, "OK", update)
 	return
 }
@@ -87,13 +162,19 @@ func UpdateChat(w http.ResponseWriter, r *http.Request) {
 func DeleteChat(w http.ResponseWriter, r *http.Request) {
 	chatId, err := GetUrlPathInt64(r, "chat_id")
 	if err != nil {
-		MarshalError(w, err.Status(), err)
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	version, err := GetIfMatch(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
 		return
 	}
 
-	err = services.ChatsService.DeleteChat(chatId)
+	err = services.ChatsService.DeleteChat(r.Context(), chatId, version)
 	if err != nil {
-		MarshalError(w, err.Status(), err)
+		MarshalError(w, r, err.Status(), err)
 		return
 	}
 
@@ -104,3 +185,115 @@ func DeleteChat(w http.ResponseWriter, r *http.Request) {
 	})
 	return
 }
+
+func RestoreChat(w http.ResponseWriter, r *http.Request) {
+	chatId, err := GetUrlPathInt64(r, "chat_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	chat, restoreErr := services.ChatsService.RestoreChat(r.Context(), chatId)
+	if restoreErr != nil {
+		MarshalError(w, r, restoreErr.Status(), restoreErr)
+		return
+	}
+
+	SetETag(w, chat)
+	MarshallSuccess(w, http.StatusOK, "OK", chat)
+	return
+}
+
+func GetChatRevisions(w http.ResponseWriter, r *http.Request) {
+	chatId, err := GetUrlPathInt64(r, "chat_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	revisions, getErr := services.ChatsService.GetChatRevisions(r.Context(), chatId)
+	if getErr != nil {
+		MarshalError(w, r, getErr.Status(), getErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", revisions)
+	return
+}
+
+func ReadChat(w http.ResponseWriter, r *http.Request) {
+	chatId, err := GetUrlPathInt64(r, "chat_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	chat, readErr := services.ChatsService.ReadChat(r.Context(), chatId)
+	if readErr != nil {
+		MarshalError(w, r, readErr.Status(), readErr)
+		return
+	}
+
+	SetETag(w, chat)
+	MarshallSuccess(w, http.StatusOK, "OK", chat)
+	return
+}
+
+func ReadConversation(w http.ResponseWriter, r *http.Request) {
+	upTo, err := GetUrlPathInt64(r, "chat_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	read, readErr := services.ChatsService.ReadConversation(r.Context(), readerPhone(r), chi.URLParam(r, "peer"), upTo)
+	if readErr != nil {
+		MarshalError(w, r, readErr.Status(), readErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", map[string]int64{
+		"read": read,
+	})
+	return
+}
+
+func GetUnreadCounts(w http.ResponseWriter, r *http.Request) {
+	counts, getErr := services.ChatsService.GetUnreadCounts(r.Context(), readerPhone(r))
+	if getErr != nil {
+		MarshalError(w, r, getErr.Status(), getErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", map[string][]domain.UnreadCount{
+		"data": counts,
+	})
+	return
+}
+
+func GetInbox(w http.ResponseWriter, r *http.Request) {
+	page, err := GetPageRequest(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	inbox, getErr := services.ChatsService.GetInbox(r.Context(), chi.URLParam(r, "phone"), page)
+	if getErr != nil {
+		MarshalError(w, r, getErr.Status(), getErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", inbox)
+	return
+}
+
+// readerPhone is the receiver whose chats get read: the phone query
+// parameter, or the user calling when it is left out.
+func readerPhone(r *http.Request) string {
+	if phone := r.URL.Query().Get("phone"); phone != "" {
+		return phone
+	}
+	identity, _ := auth.UserFromContext(r.Context())
+	return identity.Phone
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/SemmiDev/lets-tests/domain"
 	"github.com/SemmiDev/lets-tests/services"
@@ -11,6 +12,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,27 +20,67 @@ import (
 var (
 	getChatService    func(chatId int64) (*domain.Chat, utils.ChatErr)
 	createChatService func(message *domain.Chat) (*domain.Chat, utils.ChatErr)
+	createWithKey     func(key string, message *domain.Chat) (*domain.Chat, bool, utils.ChatErr)
 	updateChatService func(message *domain.Chat) (*domain.Chat, utils.ChatErr)
-	deleteChatService func(chatId int64) utils.ChatErr
-	getAllChatService func() ([]domain.Chat, utils.ChatErr)
+	deleteChatService func(chatId int64, version int64) utils.ChatErr
+	getAllChatService func(filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr)
+	getConversation   func(a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr)
+	restoreChat       func(chatId int64) (*domain.Chat, utils.ChatErr)
+	purgeChats        func(retention time.Duration) (int64, utils.ChatErr)
+	getChatRevisions  func(chatId int64) ([]domain.ChatRevision, utils.ChatErr)
+	searchChats       func(request domain.SearchRequest) (*domain.SearchPage, utils.ChatErr)
+	readChat          func(chatId int64) (*domain.Chat, utils.ChatErr)
+	readConversation  func(reader, peer string, upTo int64) (int64, utils.ChatErr)
+	getUnreadCounts   func(phone string) ([]domain.UnreadCount, utils.ChatErr)
+	getInbox          func(phone string, page domain.PageRequest) (*domain.InboxPage, utils.ChatErr)
 )
 
 type serviceMock struct{}
 
-func (sm *serviceMock) GetChat(chatId int64) (*domain.Chat, utils.ChatErr) {
+func (sm *serviceMock) GetChat(ctx context.Context, chatId int64) (*domain.Chat, utils.ChatErr) {
 	return getChatService(chatId)
 }
-func (sm *serviceMock) CreateChat(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
+func (sm *serviceMock) CreateChat(ctx context.Context, message *domain.Chat) (*domain.Chat, utils.ChatErr) {
 	return createChatService(message)
 }
-func (sm *serviceMock) UpdateChat(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
+func (sm *serviceMock) CreateChatWithKey(ctx context.Context, key string, message *domain.Chat) (*domain.Chat, bool, utils.ChatErr) {
+	return createWithKey(key, message)
+}
+func (sm *serviceMock) UpdateChat(ctx context.Context, message *domain.Chat) (*domain.Chat, utils.ChatErr) {
 	return updateChatService(message)
 }
-func (sm *serviceMock) DeleteChat(chatId int64) utils.ChatErr {
-	return deleteChatService(chatId)
+func (sm *serviceMock) DeleteChat(ctx context.Context, chatId int64, version int64) utils.ChatErr {
+	return deleteChatService(chatId, version)
+}
+func (sm *serviceMock) GetAllChats(ctx context.Context, filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+	return getAllChatService(filter, page)
+}
+func (sm *serviceMock) GetConversation(ctx context.Context, a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+	return getConversation(a, b, page)
+}
+func (sm *serviceMock) RestoreChat(ctx context.Context, chatId int64) (*domain.Chat, utils.ChatErr) {
+	return restoreChat(chatId)
+}
+func (sm *serviceMock) PurgeDeletedChats(ctx context.Context, retention time.Duration) (int64, utils.ChatErr) {
+	return purgeChats(retention)
+}
+func (sm *serviceMock) GetChatRevisions(ctx context.Context, chatId int64) ([]domain.ChatRevision, utils.ChatErr) {
+	return getChatRevisions(chatId)
 }
-func (sm *serviceMock) GetAllChats() ([]domain.Chat, utils.ChatErr) {
-	return getAllChatService()
+func (sm *serviceMock) SearchChats(ctx context.Context, request domain.SearchRequest) (*domain.SearchPage, utils.ChatErr) {
+	return searchChats(request)
+}
+func (sm *serviceMock) ReadChat(ctx context.Context, chatId int64) (*domain.Chat, utils.ChatErr) {
+	return readChat(chatId)
+}
+func (sm *serviceMock) ReadConversation(ctx context.Context, reader, peer string, upTo int64) (int64, utils.ChatErr) {
+	return readConversation(reader, peer, upTo)
+}
+func (sm *serviceMock) GetUnreadCounts(ctx context.Context, phone string) ([]domain.UnreadCount, utils.ChatErr) {
+	return getUnreadCounts(phone)
+}
+func (sm *serviceMock) GetInbox(ctx context.Context, phone string, page domain.PageRequest) (*domain.InboxPage, utils.ChatErr) {
+	return getInbox(phone, page)
 }
 
 func TestGetChat_Success(t *testing.T) {
@@ -56,6 +98,7 @@ func TestGetChat_Success(t *testing.T) {
 			Receiver:  receiver,
 			Body:      body,
 			CreatedAt: now,
+			Version:   4,
 		}, nil
 	}
 
@@ -79,6 +122,7 @@ func TestGetChat_Success(t *testing.T) {
 	assert.EqualValues(t, sender, message.Sender)
 	assert.EqualValues(t, receiver, message.Receiver)
 	assert.EqualValues(t, body, message.Body)
+	assert.EqualValues(t, `"4"`, rr.Header().Get("ETag"))
 }
 
 func TestGetChat_Invalid_Id(t *testing.T) {
@@ -118,6 +162,28 @@ func TestGet_Chat_Not_Found(t *testing.T) {
 	assert.EqualValues(t, "not_found", apiErr.Error())
 }
 
+func TestGet_Chat_Not_Found_Problem(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	getChatService = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return nil, utils.ErrorKind(utils.NotFoundError, "chat not found")
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/chats/1", nil)
+	req.Header.Set("Accept", "application/problem+json")
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/chats/{chat_id}", GetChat)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusNotFound, rr.Code)
+	assert.EqualValues(t, utils.ProblemContentType, rr.Header().Get("Content-Type"))
+	var problem utils.Problem
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.EqualValues(t, "Not Found", problem.Title)
+	assert.EqualValues(t, "chat not found", problem.Detail)
+	assert.EqualValues(t, "/api/v1/chats/1", problem.Instance)
+}
+
 func TestGetChat_Chat_Database_Error(t *testing.T) {
 	services.ChatsService = &serviceMock{}
 	getChatService = func(chatId int64) (*domain.Chat, utils.ChatErr) {
@@ -197,7 +263,83 @@ func TestCreateChat_Invalid_Json(t *testing.T) {
 	assert.EqualValues(t, "invalid_request", apiErr.Error())
 }
 
-//This test is not really necessary here, because it has been handled in the service test
+// encoding/json would turn the \xff into U+FFFD and store it
+func TestCreateChat_Invalid_UTF8(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	createChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
+		t.Fatal("the service should not be called")
+		return nil, nil
+	}
+
+	inputJson := "{\"sender\": \"+6282323231\", \"receiver\": \"+6282323232\", \"body\": \"hello \xff\"}"
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/chats", bytes.NewBufferString(inputJson))
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/chats", CreateChat)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+
+	assert.Nil(t, err)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, apiErr.Status())
+	assert.EqualValues(t, "invalid_request", apiErr.Error())
+	assert.EqualValues(t, []utils.FieldError{{Field: "body", Code: domain.FieldEncoding, Message: "Body should be valid UTF-8"}}, apiErr.Fields())
+}
+
+func TestCreateChat_Request_Too_Large(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	createChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
+		t.Fatal("the service should not be called")
+		return nil, nil
+	}
+
+	inputJson := `{"sender": "+6282323231", "receiver": "+6282323232", "body": "` + strings.Repeat("a", MaxRequestBodySize) + `"}`
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/chats", bytes.NewBufferString(inputJson))
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/chats", CreateChat)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+
+	assert.Nil(t, err)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, apiErr.Status())
+	assert.EqualValues(t, "payload_too_large", apiErr.Error())
+}
+
+// The longest valid body still fits, however JSON escapes it.
+func TestCreateChat_Longest_Body(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	var got string
+	createChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
+		got = message.Body
+		return message, nil
+	}
+
+	body := strings.Repeat("\U0001F600", domain.MaxTextBodyLength)
+	inputJson := `{"sender": "+6282323231", "receiver": "+6282323232", "body": "` + strings.Repeat(`\ud83d\ude00`, domain.MaxTextBodyLength) + `"}`
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/chats", bytes.NewBufferString(inputJson))
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/chats", CreateChat)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusCreated, rr.Code)
+	assert.EqualValues(t, body, got)
+}
+
+// This test is not really necessary here, because it has been handled in the service test
 func TestCreateChat_Empty_Body(t *testing.T) {
 	services.ChatsService = &serviceMock{}
 	createChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
@@ -383,7 +525,7 @@ func TestUpdateChat_Success(t *testing.T) {
 	assert.EqualValues(t, "update body", message.Body)
 }
 
-//We dont need to mock the service method here, because we wont call it
+// We dont need to mock the service method here, because we wont call it
 func TestUpdateChat_Invalid_Id(t *testing.T) {
 	jsonBody := `{"body": "update body"}`
 	r := chi.NewRouter()
@@ -404,7 +546,7 @@ func TestUpdateChat_Invalid_Id(t *testing.T) {
 	assert.EqualValues(t, "bad_request", apiErr.Error())
 }
 
-//When for instance an integer is provided instead of a string
+// When for instance an integer is provided instead of a string
 func TestUpdateChat_Invalid_Json(t *testing.T) {
 	inputJson := `{"body": 21231}`
 	r := chi.NewRouter()
@@ -426,7 +568,34 @@ func TestUpdateChat_Invalid_Json(t *testing.T) {
 	assert.EqualValues(t, "invalid_request", apiErr.Error())
 }
 
-//This test is not really necessary here, because it has been handled in the service test
+func TestUpdateChat_Invalid_UTF8(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	updateChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
+		t.Fatal("the service should not be called")
+		return nil, nil
+	}
+
+	inputJson := "{\"body\": \"\xc3\x28\"}"
+	r := chi.NewRouter()
+	id := "1"
+	req, err := http.NewRequest(http.MethodPut, "/api/v1/chats/"+id, bytes.NewBufferString(inputJson))
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Put("/api/v1/chats/{chat_id}", UpdateChat)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+
+	assert.Nil(t, err)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, apiErr.Status())
+	assert.EqualValues(t, "invalid_request", apiErr.Error())
+	assert.EqualValues(t, []utils.FieldError{{Field: "body", Code: domain.FieldEncoding, Message: "Body should be valid UTF-8"}}, apiErr.Fields())
+}
+
+// This test is not really necessary here, because it has been handled in the service test
 func TestUpdateChat_Empty_Body(t *testing.T) {
 	services.ChatsService = &serviceMock{}
 	updateChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
@@ -450,7 +619,7 @@ func TestUpdateChat_Empty_Body(t *testing.T) {
 	assert.EqualValues(t, "invalid_request", apiErr.Error())
 }
 
-//Other errors can happen when we try to update the message
+// Other errors can happen when we try to update the message
 func TestUpdateChat_Error_Updating(t *testing.T) {
 	services.ChatsService = &serviceMock{}
 	updateChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
@@ -478,7 +647,7 @@ func TestUpdateChat_Error_Updating(t *testing.T) {
 
 func TestDeleteChat_Success(t *testing.T) {
 	services.ChatsService = &serviceMock{}
-	deleteChatService = func(msg int64) utils.ChatErr {
+	deleteChatService = func(msg int64, version int64) utils.ChatErr {
 		return nil
 	}
 	r := chi.NewRouter()
@@ -500,6 +669,69 @@ func TestDeleteChat_Success(t *testing.T) {
 	assert.EqualValues(t, response["status"], "deleted")
 }
 
+func TestUpdateChat_If_Match(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	updateChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
+		if message.Version != 2 {
+			return nil, utils.ErrorKind(utils.PreconditionFailedError, "stale version")
+		}
+		message.Version = 3
+		return message, nil
+	}
+	r := chi.NewRouter()
+	r.Put("/api/v1/chats/{chat_id}", UpdateChat)
+
+	tests := []struct {
+		name       string
+		ifMatch    string
+		statusCode int
+		etag       string
+	}{
+		{name: "Current Version", ifMatch: `"2"`, statusCode: http.StatusOK, etag: `"3"`},
+		{name: "Weak ETag", ifMatch: `W/"2"`, statusCode: http.StatusPreconditionFailed},
+		{name: "Stale Version", ifMatch: `"1"`, statusCode: http.StatusPreconditionFailed},
+		{name: "Malformed", ifMatch: "abc", statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, "/api/v1/chats/1", bytes.NewBufferString(`{"body": "update body"}`))
+			if err != nil {
+				t.Errorf("this is the error: %v\n", err)
+			}
+			req.Header.Set("If-Match", tt.ifMatch)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.EqualValues(t, tt.statusCode, rr.Code)
+			assert.EqualValues(t, tt.etag, rr.Header().Get("ETag"))
+		})
+	}
+}
+
+func TestDeleteChat_If_Match(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	var gotVersion int64
+	deleteChatService = func(msg int64, version int64) utils.ChatErr {
+		gotVersion = version
+		return nil
+	}
+	r := chi.NewRouter()
+	r.Delete("/api/v1/chats/{chat_id}", DeleteChat)
+
+	for ifMatch, want := range map[string]int64{`"5"`: 5, "*": 0, "": 0} {
+		req, err := http.NewRequest(http.MethodDelete, "/api/v1/chats/1", nil)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req.Header.Set("If-Match", ifMatch)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		assert.EqualValues(t, http.StatusOK, rr.Code)
+		assert.EqualValues(t, want, gotVersion, "If-Match: %s", ifMatch)
+	}
+}
+
 func TestDeleteChat_Invalid_Id(t *testing.T) {
 	r := chi.NewRouter()
 	id := "abc"
@@ -524,7 +756,7 @@ func TestDeleteChat_Invalid_Id(t *testing.T) {
 
 func TestDeleteChat_Failure(t *testing.T) {
 	services.ChatsService = &serviceMock{}
-	deleteChatService = func(msg int64) utils.ChatErr {
+	deleteChatService = func(msg int64, version int64) utils.ChatErr {
 		return utils.ErrorKind(utils.InternalServerError, "error deleting chat")
 	}
 	r := chi.NewRouter()
@@ -559,24 +791,32 @@ func TestGetAllChats_Success(t *testing.T) {
 	receiver2 := utils.RandomReceiver()
 	body2 := utils.RandomBody()
 
-	getAllChatService = func() ([]domain.Chat, utils.ChatErr) {
-		return []domain.Chat{
-			{
-				Id:       1,
-				Sender:   sender1,
-				Receiver: receiver1,
-				Body:     body1,
-			},
-			{
-				Id:       2,
-				Sender:   sender2,
-				Receiver: receiver2,
-				Body:     body2,
+	getAllChatService = func(filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+		assert.EqualValues(t, "+6282323231", filter.Sender)
+		assert.EqualValues(t, domain.SortCreatedAtDesc, filter.Sort)
+		assert.EqualValues(t, 2, page.Limit)
+		assert.EqualValues(t, "abc", page.Cursor)
+		return &domain.ChatPage{
+			Chats: []domain.Chat{
+				{
+					Id:       1,
+					Sender:   sender1,
+					Receiver: receiver1,
+					Body:     body1,
+				},
+				{
+					Id:       2,
+					Sender:   sender2,
+					Receiver: receiver2,
+					Body:     body2,
+				},
 			},
+			NextCursor: "def",
+			HasMore:    true,
 		}, nil
 	}
 	r := chi.NewRouter()
-	req, err := http.NewRequest(http.MethodGet, "/api/v1/chats/", nil)
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/chats/?limit=2&cursor=abc&sender=%2B6282323231&sort=-created_at", nil)
 	if err != nil {
 		t.Errorf("this is the error: %v\n", err)
 	}
@@ -584,12 +824,17 @@ func TestGetAllChats_Success(t *testing.T) {
 	r.Get("/api/v1/chats/", GetAllChats)
 	r.ServeHTTP(rr, req)
 
-	var messages []domain.Chat
-	theErr := json.Unmarshal(rr.Body.Bytes(), &messages)
+	var page domain.ChatPage
+	theErr := json.Unmarshal(rr.Body.Bytes(), &page)
 	if theErr != nil {
 		t.Errorf("this is the error: %v\n", err)
 	}
 	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.True(t, page.HasMore)
+	assert.EqualValues(t, "def", page.NextCursor)
+
+	messages := page.Chats
 	assert.NotNil(t, messages)
 
 	assert.EqualValues(t, messages[0].Id, 1)
@@ -603,10 +848,10 @@ func TestGetAllChats_Success(t *testing.T) {
 	assert.EqualValues(t, messages[1].Body, body2)
 }
 
-//For any reason we could not get the messages
+// For any reason we could not get the messages
 func TestGetAllChats_Failure(t *testing.T) {
 	services.ChatsService = &serviceMock{}
-	getAllChatService = func() ([]domain.Chat, utils.ChatErr) {
+	getAllChatService = func(filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
 		return nil, utils.ErrorKind(utils.InternalServerError, "error getting chats")
 	}
 
@@ -626,3 +871,410 @@ func TestGetAllChats_Failure(t *testing.T) {
 	assert.EqualValues(t, "server_error", apiErr.Error())
 	assert.EqualValues(t, http.StatusInternalServerError, apiErr.Status())
 }
+
+func TestGetAllChats_Invalid_Limit(t *testing.T) {
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/chats/?limit=abc", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/chats/", GetAllChats)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, "limit should be a number", apiErr.Message())
+	assert.EqualValues(t, "bad_request", apiErr.Error())
+	assert.EqualValues(t, http.StatusBadRequest, apiErr.Status())
+}
+
+func TestGetAllChats_Zero_Limit(t *testing.T) {
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/chats/?limit=0", nil)
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/chats/", GetAllChats)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.EqualValues(t, "limit should be between 1 and 100", apiErr.Message())
+	assert.EqualValues(t, http.StatusBadRequest, apiErr.Status())
+}
+
+func TestGetConversation_Success(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+
+	getConversation = func(a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+		assert.EqualValues(t, "+6282323231", a)
+		assert.EqualValues(t, "+6282323232", b)
+		assert.EqualValues(t, 10, page.Limit)
+		return &domain.ChatPage{
+			Chats: []domain.Chat{
+				{Id: 1, Sender: a, Receiver: b, Body: "hi"},
+				{Id: 2, Sender: b, Receiver: a, Body: "hello"},
+			},
+		}, nil
+	}
+
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/conversations/+6282323231/+6282323232?limit=10", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/conversations/{a}/{b}", GetConversation)
+	r.ServeHTTP(rr, req)
+
+	var page domain.ChatPage
+	err = json.Unmarshal(rr.Body.Bytes(), &page)
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, 2, len(page.Chats))
+	assert.False(t, page.HasMore)
+	assert.EqualValues(t, "hello", page.Chats[1].Body)
+}
+
+func TestGetConversation_Failure(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	getConversation = func(a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+		return nil, utils.ErrorKind(utils.BadRequestError, "Conversation participants must different")
+	}
+
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/conversations/+6282323231/+6282323231", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/conversations/{a}/{b}", GetConversation)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, "Conversation participants must different", apiErr.Message())
+	assert.EqualValues(t, http.StatusBadRequest, apiErr.Status())
+}
+
+func TestGetAllChats_Unknown_Parameter(t *testing.T) {
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/chats/?colour=red", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/chats/", GetAllChats)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, "unknown query parameter: colour", apiErr.Message())
+	assert.EqualValues(t, http.StatusBadRequest, apiErr.Status())
+}
+
+func TestRestoreChat_Success(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	restoreChat = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return &domain.Chat{Id: chatId, Sender: "+6282323231", Receiver: "+6282323232", Body: "hello"}, nil
+	}
+
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/chats/1/restore", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/chats/{chat_id}/restore", RestoreChat)
+	r.ServeHTTP(rr, req)
+
+	var message domain.Chat
+	err = json.Unmarshal(rr.Body.Bytes(), &message)
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, 1, message.Id)
+	assert.Nil(t, message.DeletedAt)
+}
+
+func TestRestoreChat_Not_Deleted(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	restoreChat = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return nil, utils.ErrorKind(utils.NotFoundError, "no deleted record matching given id")
+	}
+
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/chats/1/restore", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/chats/{chat_id}/restore", RestoreChat)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, http.StatusNotFound, apiErr.Status())
+	assert.EqualValues(t, "no deleted record matching given id", apiErr.Message())
+}
+
+func TestGetChatRevisions_Success(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	getChatRevisions = func(chatId int64) ([]domain.ChatRevision, utils.ChatErr) {
+		return []domain.ChatRevision{
+			{Id: 1, ChatId: chatId, Revision: 1, Body: "original", ReplacedAt: time.Now()},
+			{Id: 2, ChatId: chatId, Revision: 2, Body: "first edit", ReplacedAt: time.Now()},
+		}, nil
+	}
+
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/chats/1/revisions", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/chats/{chat_id}/revisions", GetChatRevisions)
+	r.ServeHTTP(rr, req)
+
+	var revisions []domain.ChatRevision
+	err = json.Unmarshal(rr.Body.Bytes(), &revisions)
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.Len(t, revisions, 2)
+	assert.EqualValues(t, "first edit", revisions[1].Body)
+	assert.EqualValues(t, 2, revisions[1].Revision)
+}
+
+func TestGetChatRevisions_Invalid_Id(t *testing.T) {
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/chats/abc/revisions", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/chats/{chat_id}/revisions", GetChatRevisions)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.NotNil(t, apiErr)
+	assert.EqualValues(t, http.StatusBadRequest, apiErr.Status())
+	assert.EqualValues(t, "chat id should be a number", apiErr.Message())
+}
+
+func TestCreateChat_Idempotency_Key(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	replayed := false
+	createWithKey = func(key string, message *domain.Chat) (*domain.Chat, bool, utils.ChatErr) {
+		assert.EqualValues(t, "retry-1", key)
+		message.Id, message.Version = 1, 1
+		defer func() { replayed = true }()
+		return message, replayed, nil
+	}
+
+	body, _ := json.Marshal(domain.Chat{Sender: utils.RandomSender(), Receiver: utils.RandomReceiver(), Body: utils.RandomBody()})
+	r := chi.NewRouter()
+	r.Post("/api/v1/chats", CreateChat)
+
+	var responses []string
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/chats", bytes.NewReader(body))
+		req.Header.Set("Idempotency-Key", "retry-1")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		assert.EqualValues(t, http.StatusCreated, rr.Code)
+		assert.EqualValues(t, `"1"`, rr.Header().Get("ETag"))
+		assert.EqualValues(t, i == 1, rr.Header().Get("Idempotent-Replayed") == "true")
+		responses = append(responses, rr.Body.String())
+	}
+	assert.EqualValues(t, responses[0], responses[1])
+}
+
+func TestCreateChat_Field_Errors(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	createChatService = func(message *domain.Chat) (*domain.Chat, utils.ChatErr) {
+		return nil, message.Validate("")
+	}
+
+	inputJson := `{"sender": "", "receiver": "abc", "body": " "}`
+	r := chi.NewRouter()
+	req, err := http.NewRequest(http.MethodPost, "/api/v1/chats", bytes.NewBufferString(inputJson))
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/chats", CreateChat)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, apiErr.Status())
+	assert.EqualValues(t, "invalid_request", apiErr.Error())
+	assert.EqualValues(t, []utils.FieldError{
+		{Field: "sender", Code: utils.FieldRequired, Message: "Required Sender"},
+		{Field: "receiver", Code: utils.FieldInvalid, Message: "Invalid Receiver Phone Number"},
+		{Field: "body", Code: utils.FieldRequired, Message: "Required Body"},
+	}, apiErr.Fields())
+}
+
+func TestSearchChats_Success(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+
+	searchChats = func(request domain.SearchRequest) (*domain.SearchPage, utils.ChatErr) {
+		assert.EqualValues(t, `"green room" meet*`, request.Query)
+		assert.EqualValues(t, "+6282323231", request.Filter.Sender)
+		assert.EqualValues(t, 5, request.Limit)
+		return &domain.SearchPage{
+			Hits: []domain.SearchHit{{
+				Chat:       domain.Chat{Id: 1, Body: "meeting in the green room"},
+				Score:      1.5,
+				Highlights: []string{"<mark>meeting</mark> in the <mark>green room</mark>"},
+			}},
+		}, nil
+	}
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/chats/search?q=%22green+room%22+meet*&sender=0823-2323-1&limit=5", nil)
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/chats/search", SearchChats)
+	r.ServeHTTP(rr, req)
+
+	var page domain.SearchPage
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	if assert.Len(t, page.Hits, 1) {
+		assert.EqualValues(t, 1, page.Hits[0].Id)
+		assert.EqualValues(t, 1.5, page.Hits[0].Score)
+		assert.EqualValues(t, []string{"<mark>meeting</mark> in the <mark>green room</mark>"}, page.Hits[0].Highlights)
+	}
+}
+
+func TestSearchChats_Invalid_Query(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	searchChats = func(request domain.SearchRequest) (*domain.SearchPage, utils.ChatErr) {
+		t.Errorf("SearchChats() called with %+v", request)
+		return nil, nil
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/chats/search", SearchChats)
+	for _, target := range []string{"/api/v1/chats/search", "/api/v1/chats/search?q=***", "/api/v1/chats/search?q=pizza&sort=id"} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.EqualValues(t, http.StatusBadRequest, rr.Code, target)
+	}
+}
+
+func TestReadChat_Success(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	readAt := time.Now().UTC()
+	readChat = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return &domain.Chat{Id: chatId, Sender: "+6282323231", Receiver: "+6282323232", Body: "hello", DeliveredAt: &readAt, ReadAt: &readAt}, nil
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/chats/1/read", nil)
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/chats/{chat_id}/read", ReadChat)
+	r.ServeHTTP(rr, req)
+
+	var message domain.Chat
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &message))
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, 1, message.Id)
+	if assert.NotNil(t, message.ReadAt) {
+		assert.True(t, readAt.Equal(*message.ReadAt))
+	}
+}
+
+func TestReadChat_Not_Receiver(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	readChat = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return nil, utils.ErrorKind(utils.ForbiddenError, "only the receiver can mark a chat read")
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/chats/1/read", nil)
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/chats/{chat_id}/read", ReadChat)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusForbidden, rr.Code)
+}
+
+func TestReadConversation_Success(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	readConversation = func(reader, peer string, upTo int64) (int64, utils.ChatErr) {
+		assert.EqualValues(t, "+6282323232", reader)
+		assert.EqualValues(t, "+6282323231", peer)
+		assert.EqualValues(t, 7, upTo)
+		return 3, nil
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/conversations/+6282323231/read-up-to/7?phone=%2B6282323232", nil)
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/conversations/{peer}/read-up-to/{chat_id}", ReadConversation)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"read": 3}`, rr.Body.String())
+}
+
+func TestGetUnreadCounts_Success(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	getUnreadCounts = func(phone string) ([]domain.UnreadCount, utils.ChatErr) {
+		assert.EqualValues(t, "+6282323232", phone)
+		return []domain.UnreadCount{{Peer: "+6282323231", Unread: 2}}, nil
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/conversations/unread?phone=%2B6282323232", nil)
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/conversations/unread", GetUnreadCounts)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"data": [{"peer": "+6282323231", "unread": 2}]}`, rr.Body.String())
+}
+
+func TestGetInbox_Success(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	lastActivity := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	getInbox = func(phone string, page domain.PageRequest) (*domain.InboxPage, utils.ChatErr) {
+		assert.EqualValues(t, "+6282323232", phone)
+		assert.EqualValues(t, 10, page.Limit)
+		return &domain.InboxPage{
+			Entries: []domain.InboxEntry{{Peer: "+6282323231", LastChatId: 7, LastSender: "+6282323231", Preview: "see you", LastActivity: lastActivity, Unread: 2}},
+		}, nil
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/inbox/+6282323232?limit=10", nil)
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/inbox/{phone}", GetInbox)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"data": [{"peer": "+6282323231", "last_chat_id": 7, "last_sender": "+6282323231", "preview": "see you", "last_activity": "2021-05-01T10:00:00Z", "unread": 2}], "next_cursor": "", "has_more": false}`, rr.Body.String())
+}
+
+func TestGetInbox_Invalid_Limit(t *testing.T) {
+	services.ChatsService = &serviceMock{}
+	getInbox = func(phone string, page domain.PageRequest) (*domain.InboxPage, utils.ChatErr) {
+		t.Errorf("GetInbox() called with %s", phone)
+		return nil, nil
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/inbox/+6282323232?limit=ten", nil)
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/inbox/{phone}", GetInbox)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusBadRequest, rr.Code)
+}
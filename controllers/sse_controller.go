@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/auth"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/events"
+	"github.com/SemmiDev/lets-tests/realtime"
+	"github.com/SemmiDev/lets-tests/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseHeartbeat keeps proxies from closing an idle stream.
+const sseHeartbeat = 15 * time.Second
+
+// streamPhone is the E.164 form of the phone query parameter of a stream.
+// An authenticated caller only follows their own chats, the parameter
+// defaults to them.
+func streamPhone(r *http.Request) (string, utils.ChatErr) {
+	phone := r.URL.Query().Get("phone")
+	if phone != "" {
+		normalized, err := domain.NormalizePhone(phone)
+		if err != nil {
+			return "", err
+		}
+		phone = normalized
+	}
+	identity, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		return phone, nil
+	}
+	if phone == "" {
+		return identity.Phone, nil
+	}
+	if phone != identity.Phone {
+		return "", utils.ErrorKind(utils.ForbiddenError, "you can only follow your own chats")
+	}
+	return phone, nil
+}
+
+// StreamChats serves the chat events of the tenant of the request as
+// Server-Sent Events. The optional phone query parameter keeps only the
+// chats that number takes part in. A client reconnecting with Last-Event-ID
+// first gets the events it missed; when they are no longer buffered it gets
+// a "reset" event and should reload the chats.
+func StreamChats(w http.ResponseWriter, r *http.Request) {
+	phone, err := streamPhone(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	var lastId int64
+	if header := strings.TrimSpace(r.Header.Get("Last-Event-ID")); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || id < 0 {
+			theErr := utils.ErrorKind(utils.BadRequestError, "Last-Event-ID should be the id of a received event")
+			MarshalError(w, r, theErr.Status(), theErr)
+			return
+		}
+		lastId = id
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		theErr := utils.ErrorKind(utils.InternalServerError, "streaming is not supported")
+		MarshalError(w, r, theErr.Status(), theErr)
+		return
+	}
+
+	tenant := domain.TenantFromContext(r.Context())
+	sub, missed, complete := realtime.DefaultStream.Subscribe(lastId)
+	defer realtime.DefaultStream.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, entry := range missed {
+		writeStreamEntry(w, entry, tenant, phone)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, ok := <-sub.Entries():
+			if !ok {
+				//too slow to keep up, the client reconnects with Last-Event-ID
+				return
+			}
+			writeStreamEntry(w, entry, tenant, phone)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeStreamEntry(w http.ResponseWriter, entry realtime.Entry, tenant, phone string) {
+	if entry.Event.Tenant() != tenant || (phone != "" && !takesPart(entry.Event, phone)) {
+		return
+	}
+	data, err := json.Marshal(entry.Event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", entry.Id, entry.Event.Type, data)
+}
+
+func takesPart(event events.Event, phone string) bool {
+	for _, participant := range event.Participants() {
+		if participant == phone {
+			return true
+		}
+	}
+	return false
+}
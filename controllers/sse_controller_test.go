@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/auth"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/events"
+	"github.com/SemmiDev/lets-tests/realtime"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readStreamEvents reads n events off a text/event-stream body, skipping
+// comments, and returns their id, event and data lines joined.
+func readStreamEvents(t *testing.T, reader *bufio.Reader, n int) []string {
+	var got []string
+	var current []string
+	for len(got) < n {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString() error = %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(current) > 0:
+			got = append(got, strings.Join(current, "|"))
+			current = nil
+		case strings.HasPrefix(line, ":"), line == "":
+		default:
+			current = append(current, line)
+		}
+	}
+	return got
+}
+
+func TestStreamChats_Invalid_Request(t *testing.T) {
+	r := chi.NewRouter()
+	r.Get("/api/v1/chats/stream", StreamChats)
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventId string
+		errMsg      string
+	}{
+		{name: "Invalid Phone", query: "?phone=abc", errMsg: "Invalid Phone Number"},
+		{name: "Invalid Last-Event-ID", lastEventId: "abc", errMsg: "Last-Event-ID should be the id of a received event"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/api/v1/chats/stream"+tt.query, nil)
+			if err != nil {
+				t.Errorf("this is the error: %v\n", err)
+			}
+			if tt.lastEventId != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventId)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+			assert.Nil(t, err)
+			assert.EqualValues(t, http.StatusBadRequest, apiErr.Status())
+			assert.EqualValues(t, tt.errMsg, apiErr.Message())
+		})
+	}
+}
+
+func TestStreamChats_Other_Phone_Forbidden(t *testing.T) {
+	r := chi.NewRouter()
+	r.Get("/api/v1/chats/stream", StreamChats)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/chats/stream?phone="+url.QueryEscape("+6282323232"), nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Phone: "+6282323231"}))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusForbidden, apiErr.Status())
+}
+
+func TestStreamChats_Replay_And_Live(t *testing.T) {
+	sender := "+6282323231"
+	receiver := "+6282323232"
+	realtime.DefaultStream = realtime.NewStream(10)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/chats/stream", StreamChats)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	published, _, _ := realtime.DefaultStream.Subscribe(0)
+	defer realtime.DefaultStream.Unsubscribe(published)
+	realtime.DefaultStream.Publish(events.Event{Id: 11, Type: events.ChatCreated, Chat: domain.Chat{Id: 1, Sender: sender, Receiver: receiver}})
+	realtime.DefaultStream.Publish(events.Event{Id: 12, Type: events.ChatCreated, Chat: domain.Chat{Id: 2, Sender: sender, Receiver: "+6282323233"}})
+	realtime.DefaultStream.Publish(events.Event{Id: 13, Type: events.ChatUpdated, Chat: domain.Chat{Id: 1, Sender: sender, Receiver: receiver}})
+	first := (<-published.Entries()).Id
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/chats/stream?phone="+url.QueryEscape(receiver), nil)
+	if err != nil {
+		t.Fatalf("this is the error: %v\n", err)
+	}
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(first, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+	assert.EqualValues(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	replayed := readStreamEvents(t, reader, 1)
+	assert.True(t, strings.HasPrefix(replayed[0], fmt.Sprintf(`id: %d|event: updated|data: {"type":"updated"`, first+2)), replayed[0])
+
+	realtime.DefaultStream.Publish(events.Event{Id: 14, Type: events.ChatDeleted, Chat: domain.Chat{Id: 1, Sender: sender, Receiver: receiver}})
+	live := readStreamEvents(t, reader, 1)
+	assert.True(t, strings.HasPrefix(live[0], fmt.Sprintf("id: %d|event: deleted|", first+3)), live[0])
+}
+
+func TestStreamChats_Reset(t *testing.T) {
+	realtime.DefaultStream = realtime.NewStream(10)
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/chats/stream", StreamChats)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	//the client saw event 7 before the server restarted
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/chats/stream", nil)
+	if err != nil {
+		t.Fatalf("this is the error: %v\n", err)
+	}
+	req.Header.Set("Last-Event-ID", "7")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	got := readStreamEvents(t, bufio.NewReader(resp.Body), 1)
+	assert.EqualValues(t, "event: reset|data: {}", got[0])
+}
@@ -1,32 +1,136 @@
 package controllers
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/domain"
 	"github.com/SemmiDev/lets-tests/utils"
 	"github.com/go-chi/chi/v5"
+	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
-type Response struct {
-	Code   int         `json:"code"`
-	Status string      `json:"status"`
-	Error  bool        `json:"error"`
-	Data   interface{} `json:"data"`
+// GetUrlPathInt64 reads an id from the path, key such as chat_id also
+// names it in the error.
+func GetUrlPathInt64(r *http.Request, key string) (int64, utils.ChatErr) {
+	id, err := strconv.ParseInt(chi.URLParam(r, key), 10, 64)
+	if err != nil {
+		return 0, utils.ErrorKind(utils.BadRequestError, strings.Replace(key, "_", " ", -1)+" should be a number")
+	}
+	return id, nil
 }
 
-func GetUrlPathInt64(r *http.Request, key string) (int64, utils.ChatErr) {
-	chatId, err := strconv.ParseInt(chi.URLParam(r, key), 10, 64)
+// MaxRequestBodySize is how many bytes DecodeBody reads. The largest valid
+// request is a chat of MaxTextBodyLength emoji, each escaped by JSON as a
+// surrogate pair of 12 bytes, with room left for the other fields.
+const MaxRequestBodySize = 12*domain.MaxTextBodyLength + 16<<10
+
+// DecodeBody decodes the JSON request body into v. Bodies over
+// MaxRequestBodySize are refused unread. The body is checked to be UTF-8
+// first, as encoding/json would quietly turn invalid bytes into U+FFFD; the
+// fields holding invalid bytes are reported like those Chat.Validate finds.
+func DecodeBody(w http.ResponseWriter, r *http.Request, v interface{}) utils.ChatErr {
+	raw, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestBodySize))
 	if err != nil {
-		return 0, utils.ErrorKind(utils.BadRequestError, "chat id should be a number")
+		//MaxBytesReader fails once it returned every byte it allows
+		if len(raw) == MaxRequestBodySize {
+			return utils.ErrorKind(utils.PayloadTooLargeError, fmt.Sprintf("request body should be at most %d bytes", MaxRequestBodySize))
+		}
+		return utils.ErrorKind(utils.BadRequestError, "request body can't be read")
+	}
+	if !utf8.Valid(raw) {
+		return encodingErrors(raw)
 	}
-	return chatId, nil
+	if err := json.NewDecoder(bytes.NewReader(raw)).Decode(v); err != nil {
+		return utils.ErrorKind(utils.UnprocessableEntityError, "invalid json body")
+	}
+	return nil
 }
 
-func MarshalError(w http.ResponseWriter, code int, err utils.ChatErr) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	_ = json.NewEncoder(w).Encode(err)
+// encodingErrors reports the top-level fields of raw that aren't valid
+// UTF-8 with the invalid_encoding code. A RawMessage keeps the bytes of a
+// field as they were sent.
+func encodingErrors(raw []byte) utils.ChatErr {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return utils.ErrorKind(utils.UnprocessableEntityError, "request body is not valid UTF-8")
+	}
+	names := make([]string, 0, len(values))
+	for name, value := range values {
+		if !utf8.Valid(value) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		//the invalid bytes are in a field name
+		return utils.ErrorKind(utils.UnprocessableEntityError, "request body is not valid UTF-8")
+	}
+	sort.Strings(names)
+	var fields utils.FieldErrors
+	for _, name := range names {
+		message := name + " should be valid UTF-8"
+		if name != "" {
+			message = strings.ToUpper(name[:1]) + message[1:]
+		}
+		fields.Add(name, domain.FieldEncoding, message)
+	}
+	return fields.Err()
+}
+
+func GetPageRequest(r *http.Request) (domain.PageRequest, utils.ChatErr) {
+	limit, err := GetLimit(r)
+	return domain.PageRequest{Limit: limit, Cursor: r.URL.Query().Get("cursor")}, err
+}
+
+// GetLimit reads the limit query parameter. It is 0, the default of the
+// listing, only when the parameter is left out; an explicit 0 is refused.
+func GetLimit(r *http.Request) (int, utils.ChatErr) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, utils.ErrorKind(utils.BadRequestError, "limit should be a number")
+	}
+	if n == 0 {
+		return 0, utils.ErrorKind(utils.BadRequestError, "limit should be between 1 and 100")
+	}
+	return n, nil
+}
+
+// GetIfMatch reads the chat version from an If-Match header holding an
+// ETag such as "3". A missing header or * means any version and gives 0.
+// If-Match compares strongly, so a weak ETag never matches.
+func GetIfMatch(r *http.Request) (int64, utils.ChatErr) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	if strings.HasPrefix(value, "W/") {
+		return 0, utils.ErrorKind(utils.PreconditionFailedError, "If-Match needs the strong ETag of the chat")
+	}
+	version, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return 0, utils.ErrorKind(utils.BadRequestError, "If-Match should be the ETag of the chat")
+	}
+	return version, nil
+}
+
+// SetETag exposes the version of a chat so it can be sent back in If-Match.
+func SetETag(w http.ResponseWriter, chat *domain.Chat) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, chat.Version))
+}
+
+// MarshalError answers r with err, as application/problem+json when the
+// client accepts it.
+func MarshalError(w http.ResponseWriter, r *http.Request, code int, err utils.ChatErr) {
+	utils.WriteError(w, r, code, err)
 }
 
 func MarshallSuccess(w http.ResponseWriter, code int, status string, payload interface{}) {
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/services"
+	"net/http"
+)
+
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook domain.Webhook
+	if err := DecodeBody(w, r, &webhook); err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	res, theErr := services.WebhooksService.CreateWebhook(r.Context(), &webhook)
+	if theErr != nil {
+		MarshalError(w, r, theErr.Status(), theErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusCreated, "CREATED", res)
+}
+
+func GetWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookId, err := GetUrlPathInt64(r, "webhook_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	webhook, getErr := services.WebhooksService.GetWebhook(r.Context(), webhookId)
+	if getErr != nil {
+		MarshalError(w, r, getErr.Status(), getErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", webhook)
+}
+
+func GetAllWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := services.WebhooksService.GetAllWebhooks(r.Context())
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", webhooks)
+}
+
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookId, err := GetUrlPathInt64(r, "webhook_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	var webhook domain.Webhook
+	if err := DecodeBody(w, r, &webhook); err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+	webhook.Id = webhookId
+
+	res, updateErr := services.WebhooksService.UpdateWebhook(r.Context(), &webhook)
+	if updateErr != nil {
+		MarshalError(w, r, updateErr.Status(), updateErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", res)
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookId, err := GetUrlPathInt64(r, "webhook_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	if err := services.WebhooksService.DeleteWebhook(r.Context(), webhookId); err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"status": "deleted",
+	})
+}
+
+// GetWebhookDeliveries is the delivery log of a webhook, newest first.
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhookId, err := GetUrlPathInt64(r, "webhook_id")
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	limit, err := GetLimit(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+
+	deliveries, getErr := services.WebhooksService.GetDeliveries(r.Context(), webhookId, limit)
+	if getErr != nil {
+		MarshalError(w, r, getErr.Status(), getErr)
+		return
+	}
+
+	MarshallSuccess(w, http.StatusOK, "OK", deliveries)
+}
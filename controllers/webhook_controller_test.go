@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/services"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var (
+	createWebhookService func(webhook *domain.Webhook) (*domain.Webhook, utils.ChatErr)
+	getWebhookService    func(webhookId int64) (*domain.Webhook, utils.ChatErr)
+	getWebhooksService   func() ([]domain.Webhook, utils.ChatErr)
+	updateWebhookService func(webhook *domain.Webhook) (*domain.Webhook, utils.ChatErr)
+	deleteWebhookService func(webhookId int64) utils.ChatErr
+	getDeliveriesService func(webhookId int64, limit int) ([]domain.WebhookDelivery, utils.ChatErr)
+)
+
+type webhookServiceMock struct{}
+
+func (sm *webhookServiceMock) CreateWebhook(ctx context.Context, webhook *domain.Webhook) (*domain.Webhook, utils.ChatErr) {
+	return createWebhookService(webhook)
+}
+func (sm *webhookServiceMock) GetWebhook(ctx context.Context, webhookId int64) (*domain.Webhook, utils.ChatErr) {
+	return getWebhookService(webhookId)
+}
+func (sm *webhookServiceMock) GetAllWebhooks(ctx context.Context) ([]domain.Webhook, utils.ChatErr) {
+	return getWebhooksService()
+}
+func (sm *webhookServiceMock) UpdateWebhook(ctx context.Context, webhook *domain.Webhook) (*domain.Webhook, utils.ChatErr) {
+	return updateWebhookService(webhook)
+}
+func (sm *webhookServiceMock) DeleteWebhook(ctx context.Context, webhookId int64) utils.ChatErr {
+	return deleteWebhookService(webhookId)
+}
+func (sm *webhookServiceMock) GetDeliveries(ctx context.Context, webhookId int64, limit int) ([]domain.WebhookDelivery, utils.ChatErr) {
+	return getDeliveriesService(webhookId, limit)
+}
+
+func TestCreateWebhook(t *testing.T) {
+	services.WebhooksService = &webhookServiceMock{}
+	createWebhookService = func(webhook *domain.Webhook) (*domain.Webhook, utils.ChatErr) {
+		assert.EqualValues(t, "https://example.com/hooks", webhook.Url)
+		assert.EqualValues(t, []string{"created"}, webhook.Events)
+		webhook.Id = 1
+		webhook.Secret = "generated-secret-value"
+		return webhook, nil
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"url": "https://example.com/hooks", "events": []string{"created"}})
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/webhooks", CreateWebhook)
+	r.ServeHTTP(rr, req)
+
+	var webhook domain.Webhook
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &webhook))
+	assert.EqualValues(t, http.StatusCreated, rr.Code)
+	assert.EqualValues(t, 1, webhook.Id)
+	assert.EqualValues(t, "generated-secret-value", webhook.Secret)
+}
+
+func TestCreateWebhook_Invalid_Json(t *testing.T) {
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewReader([]byte(`{"url": 1}`)))
+	rr := httptest.NewRecorder()
+	r.Post("/api/v1/webhooks", CreateWebhook)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusUnprocessableEntity, apiErr.Status())
+	assert.EqualValues(t, "invalid json body", apiErr.Message())
+}
+
+// An invalid byte would otherwise become U+FFFD in the url or the secret.
+func TestWebhook_Invalid_UTF8(t *testing.T) {
+	r := chi.NewRouter()
+	r.Post("/api/v1/webhooks", CreateWebhook)
+	r.Put("/api/v1/webhooks/{webhook_id}", UpdateWebhook)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		target := "/api/v1/webhooks"
+		if method == http.MethodPut {
+			target += "/3"
+		}
+		body := []byte("{\"url\": \"https://example.com/hooks\", \"secret\": \"\xff\"}")
+		req, _ := http.NewRequest(method, target, bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+		assert.Nil(t, err)
+		assert.EqualValues(t, http.StatusUnprocessableEntity, apiErr.Status(), method)
+		assert.EqualValues(t, []utils.FieldError{{Field: "secret", Code: domain.FieldEncoding, Message: "Secret should be valid UTF-8"}}, apiErr.Fields(), method)
+	}
+}
+
+func TestUpdateWebhook(t *testing.T) {
+	services.WebhooksService = &webhookServiceMock{}
+	updateWebhookService = func(webhook *domain.Webhook) (*domain.Webhook, utils.ChatErr) {
+		assert.EqualValues(t, 3, webhook.Id)
+		return webhook, nil
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"url": "https://example.com/hooks", "events": []string{"deleted"}})
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodPut, "/api/v1/webhooks/3", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	r.Put("/api/v1/webhooks/{webhook_id}", UpdateWebhook)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+}
+
+func TestDeleteWebhook_Not_Found(t *testing.T) {
+	services.WebhooksService = &webhookServiceMock{}
+	deleteWebhookService = func(webhookId int64) utils.ChatErr {
+		return utils.ErrorKind(utils.NotFoundError, "no record matching given id")
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v1/webhooks/3", nil)
+	rr := httptest.NewRecorder()
+	r.Delete("/api/v1/webhooks/{webhook_id}", DeleteWebhook)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusNotFound, rr.Code)
+}
+
+func TestGetWebhook_Invalid_Id(t *testing.T) {
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/webhooks/abc", nil)
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/webhooks/{webhook_id}", GetWebhook)
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, apiErr.Status())
+	assert.EqualValues(t, "webhook id should be a number", apiErr.Message())
+}
+
+func TestGetWebhookDeliveries(t *testing.T) {
+	services.WebhooksService = &webhookServiceMock{}
+	getDeliveriesService = func(webhookId int64, limit int) ([]domain.WebhookDelivery, utils.ChatErr) {
+		assert.EqualValues(t, 3, webhookId)
+		assert.EqualValues(t, 10, limit)
+		return []domain.WebhookDelivery{{Id: 1, WebhookId: 3, EventId: 9, Status: domain.DeliveryFailed, Attempts: 8, StatusCode: 500, LastError: "unexpected status 500", Payload: "{}", Secret: "hidden"}}, nil
+	}
+
+	r := chi.NewRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/webhooks/3/deliveries?limit=10", nil)
+	rr := httptest.NewRecorder()
+	r.Get("/api/v1/webhooks/{webhook_id}/deliveries", GetWebhookDeliveries)
+	r.ServeHTTP(rr, req)
+
+	assert.EqualValues(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "hidden")
+	var deliveries []domain.WebhookDelivery
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &deliveries))
+	if assert.Len(t, deliveries, 1) {
+		assert.EqualValues(t, domain.DeliveryFailed, deliveries[0].Status)
+		assert.EqualValues(t, "unexpected status 500", deliveries[0].LastError)
+	}
+
+	for _, target := range []string{"/api/v1/webhooks/3/deliveries?limit=ten", "/api/v1/webhooks/3/deliveries?limit=0"} {
+		req, _ = http.NewRequest(http.MethodGet, target, nil)
+		rr = httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.EqualValues(t, http.StatusBadRequest, rr.Code, target)
+	}
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/realtime"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"time"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// the API is served with cors.AllowAll, browsers from any origin may connect
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// ServeWebSocket streams the created, updated, deleted and restored events
+// of every chat the phone query parameter sends or receives.
+func ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	phone, err := streamPhone(r)
+	if err != nil {
+		MarshalError(w, r, err.Status(), err)
+		return
+	}
+	if phone == "" {
+		theErr := utils.ErrorKind(utils.BadRequestError, "Required Phone Number")
+		MarshalError(w, r, theErr.Status(), theErr)
+		return
+	}
+
+	conn, upgradeErr := upgrader.Upgrade(w, r, nil)
+	if upgradeErr != nil {
+		//the upgrader already answered with an error status
+		return
+	}
+
+	client := realtime.DefaultHub.Register(domain.TenantFromContext(r.Context()), phone)
+	go readWebSocket(conn, client)
+	writeWebSocket(conn, client)
+}
+
+// readWebSocket only watches for the connection going away, clients have
+// nothing to send.
+func readWebSocket(conn *websocket.Conn, client *realtime.Client) {
+	defer realtime.DefaultHub.Unregister(client)
+
+	conn.SetReadLimit(512)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func writeWebSocket(conn *websocket.Conn, client *realtime.Client) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case event, ok := <-client.Events():
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
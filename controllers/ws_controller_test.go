@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"github.com/SemmiDev/lets-tests/auth"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/events"
+	"github.com/SemmiDev/lets-tests/realtime"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeWebSocket_Invalid_Phone(t *testing.T) {
+	r := chi.NewRouter()
+	r.Get("/api/v1/ws", ServeWebSocket)
+
+	for _, phone := range []string{"", "abc"} {
+		req, err := http.NewRequest(http.MethodGet, "/api/v1/ws?phone="+phone, nil)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+		assert.Nil(t, err)
+		assert.EqualValues(t, http.StatusBadRequest, apiErr.Status())
+	}
+}
+
+func TestServeWebSocket_Other_Phone_Forbidden(t *testing.T) {
+	r := chi.NewRouter()
+	r.Get("/api/v1/ws", ServeWebSocket)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/ws?phone="+url.QueryEscape("+6282323232"), nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Phone: "+6282323231"}))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	apiErr, err := utils.NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.EqualValues(t, http.StatusForbidden, apiErr.Status())
+}
+
+func TestServeWebSocket_Receives_Events(t *testing.T) {
+	sender := "+6282323231"
+	receiver := "+6282323232"
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/ws", ServeWebSocket)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/ws?phone=" + url.QueryEscape(receiver)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	//the hub registers the client right after the upgrade
+	for i := 0; realtime.DefaultHub.Clients(domain.DefaultTenant, receiver) == 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	realtime.DefaultHub.Publish(events.Event{Type: events.ChatCreated, Chat: domain.Chat{Id: 3, Sender: sender, Receiver: receiver, Body: "hi"}})
+	realtime.DefaultHub.Publish(events.Event{Type: events.ChatCreated, Chat: domain.Chat{Id: 4, Sender: sender, Receiver: "+6282323233", Body: "not for you"}})
+	realtime.DefaultHub.Publish(events.Event{Type: events.ChatDeleted, Chat: domain.Chat{Id: 3, Sender: sender, Receiver: receiver, Body: "hi"}})
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got []events.Event
+	for len(got) < 2 {
+		var event events.Event
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		got = append(got, event)
+	}
+	assert.EqualValues(t, events.ChatCreated, got[0].Type)
+	assert.EqualValues(t, 3, got[0].Chat.Id)
+	assert.EqualValues(t, events.ChatDeleted, got[1].Type)
+
+	conn.Close()
+	for i := 0; realtime.DefaultHub.Clients(domain.DefaultTenant, receiver) != 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.EqualValues(t, 0, realtime.DefaultHub.Clients(domain.DefaultTenant, receiver))
+}
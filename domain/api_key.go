@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/utils"
+	"strings"
+	"time"
+)
+
+// Scopes an API key can be issued with. ScopeChatsAdmin includes the other
+// two and manages the API keys.
+const (
+	ScopeChatsRead  = "chats:read"
+	ScopeChatsWrite = "chats:write"
+	ScopeChatsAdmin = "chats:admin"
+)
+
+var apiKeyScopes = []string{ScopeChatsRead, ScopeChatsWrite, ScopeChatsAdmin}
+
+// ApiKeyPrefix starts every API key, so leaked keys are easy to search for.
+const ApiKeyPrefix = "lt_"
+
+// MaxApiKeyNameLength bounds the name an API key is issued under.
+const MaxApiKeyNameLength = 100
+
+// ApiKey lets a service call the API on behalf of every phone number of its
+// tenant. Only the hash of the key is stored; Key is only returned when it is
+// issued.
+type ApiKey struct {
+	Id     int64    `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Prefix is the start of the key, to tell keys apart when listing them.
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	Hash       string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// Tenant is the only one whose chats the key reaches, set by the
+	// repository.
+	Tenant string `json:"tenant"`
+}
+
+func (k *ApiKey) Validate() utils.ChatErr {
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" {
+		return utils.ErrorKind(utils.UnprocessableEntityError, "Required Name")
+	}
+	if len(k.Name) > MaxApiKeyNameLength {
+		return utils.ErrorKind(utils.UnprocessableEntityError, fmt.Sprintf("Name should be at most %d characters", MaxApiKeyNameLength))
+	}
+	if len(k.Scopes) == 0 {
+		return utils.ErrorKind(utils.UnprocessableEntityError, "Required Scopes")
+	}
+	seen := make(map[string]bool)
+	scopes := make([]string, 0, len(k.Scopes))
+	for _, scope := range k.Scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !isApiKeyScope(scope) {
+			return utils.ErrorKind(utils.UnprocessableEntityError, fmt.Sprintf("Invalid Scope %q, should be one of %s", scope, strings.Join(apiKeyScopes, ", ")))
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	k.Scopes = scopes
+	return nil
+}
+
+func isApiKeyScope(scope string) bool {
+	for _, known := range apiKeyScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// HashApiKey is what is stored and looked up in place of a key. Keys are
+// random, a plain SHA-256 is enough to keep them out of the database.
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type apiKeyRepoInterface interface {
+	Create(ctx context.Context, key *ApiKey) (*ApiKey, utils.ChatErr)
+	Get(ctx context.Context, Id int64) (*ApiKey, utils.ChatErr)
+	// GetByHash returns the key, revoked or not, whose hash is hash. It looks
+	// through every tenant, the key tells which one it belongs to.
+	GetByHash(ctx context.Context, hash string) (*ApiKey, utils.ChatErr)
+	GetAll(ctx context.Context) ([]ApiKey, utils.ChatErr)
+	// Revoke marks a live key as revoked at, it is refused from then on.
+	Revoke(ctx context.Context, Id int64, at time.Time) (*ApiKey, utils.ChatErr)
+	// Touch records that a key was used at.
+	Touch(ctx context.Context, Id int64, at time.Time) utils.ChatErr
+}
+
+// ApiKeyRepo is set next to ChatRepo, from the same storage.
+var ApiKeyRepo apiKeyRepoInterface
+
+// NewApiKeyRepository returns the API keys stored alongside chats.
+func NewApiKeyRepository(chats chatRepoInterface) (apiKeyRepoInterface, error) {
+	switch repo := chats.(type) {
+	case *chatRepo:
+		return &apiKeyRepo{chats: repo}, nil
+	case *memoryChatRepo:
+		return NewMemoryApiKeyRepository(), nil
+	}
+	return nil, fmt.Errorf("no API key storage for chat repository %T", chats)
+}
@@ -0,0 +1,146 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyColumns = `id, name, scopes, prefix, key_hash, created_at, last_used_at, revoked_at, tenant_id`
+
+	queryInsertApiKey    = `INSERT INTO api_keys(tenant_id, name, scopes, prefix, key_hash, created_at) VALUES (?,?,?,?,?,?);`
+	queryGetApiKey       = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id=? AND tenant_id=?;`
+	queryGetApiKeyByHash = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash=?;`
+	queryGetAllApiKeys   = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE tenant_id=? ORDER BY id;`
+	queryRevokeApiKey    = `UPDATE api_keys SET revoked_at=? WHERE id=? AND tenant_id=? AND revoked_at IS NULL;`
+	queryTouchApiKey     = `UPDATE api_keys SET last_used_at=? WHERE id=?;`
+)
+
+// apiKeyScopesDivider joins the scopes of a key in one column.
+const apiKeyScopesDivider = ","
+
+// apiKeyRepo stores API keys in the database of the chats.
+type apiKeyRepo struct {
+	chats *chatRepo
+}
+
+func (m *apiKeyRepo) Create(ctx context.Context, key *ApiKey) (*ApiKey, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	tenant := TenantFromContext(ctx)
+	args := []interface{}{tenant, key.Name, strings.Join(key.Scopes, apiKeyScopesDivider), key.Prefix, key.Hash, now}
+	var id int64
+	if m.chats.driver == DriverPostgres {
+		query := strings.TrimSuffix(queryInsertApiKey, ";") + " RETURNING id;"
+		if err := m.chats.db.QueryRowContext(ctx, m.chats.rebind(query), args...).Scan(&id); err != nil {
+			return nil, parseError(ctx, err)
+		}
+	} else {
+		result, err := m.chats.db.ExecContext(ctx, m.chats.rebind(queryInsertApiKey), args...)
+		if err != nil {
+			return nil, parseError(ctx, err)
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to save API key: %s", err.Error()))
+		}
+	}
+
+	created := *key
+	created.Id = id
+	created.Key = ""
+	created.CreatedAt = now
+	created.Tenant = tenant
+	return &created, nil
+}
+
+func (m *apiKeyRepo) Get(ctx context.Context, keyId int64) (*ApiKey, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var key ApiKey
+	if err := scanApiKey(m.chats.db.QueryRowContext(ctx, m.chats.rebind(queryGetApiKey), keyId, TenantFromContext(ctx)), &key); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return &key, nil
+}
+
+func (m *apiKeyRepo) GetByHash(ctx context.Context, hash string) (*ApiKey, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var key ApiKey
+	if err := scanApiKey(m.chats.db.QueryRowContext(ctx, m.chats.rebind(queryGetApiKeyByHash), hash), &key); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return &key, nil
+}
+
+func (m *apiKeyRepo) GetAll(ctx context.Context) ([]ApiKey, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.chats.db.QueryContext(ctx, m.chats.rebind(queryGetAllApiKeys), TenantFromContext(ctx))
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	results := make([]ApiKey, 0)
+	for rows.Next() {
+		var key ApiKey
+		if err := scanApiKey(rows, &key); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get API key: %s", err.Error()))
+		}
+		results = append(results, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return results, nil
+}
+
+func (m *apiKeyRepo) Revoke(ctx context.Context, keyId int64, at time.Time) (*ApiKey, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tenant := TenantFromContext(ctx)
+	result, err := m.chats.db.ExecContext(ctx, m.chats.rebind(queryRevokeApiKey), at, keyId, tenant)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	if err := checkAffected(result); err != nil {
+		return nil, err
+	}
+
+	var key ApiKey
+	if err := scanApiKey(m.chats.db.QueryRowContext(ctx, m.chats.rebind(queryGetApiKey), keyId, tenant), &key); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return &key, nil
+}
+
+// Touch is called once the key was verified, whatever its tenant.
+func (m *apiKeyRepo) Touch(ctx context.Context, keyId int64, at time.Time) ChatErr {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.chats.db.ExecContext(ctx, m.chats.rebind(queryTouchApiKey), at, keyId)
+	if err != nil {
+		return parseError(ctx, err)
+	}
+	return checkAffected(result)
+}
+
+func scanApiKey(row rowScanner, key *ApiKey) error {
+	var scopes string
+	if err := row.Scan(&key.Id, &key.Name, &scopes, &key.Prefix, &key.Hash, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt, &key.Tenant); err != nil {
+		return err
+	}
+	key.Scopes = strings.Split(scopes, apiKeyScopesDivider)
+	return nil
+}
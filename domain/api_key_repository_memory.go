@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"context"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryApiKeyRepo keeps API keys in process memory, next to the chats of
+// memoryChatRepo.
+type memoryApiKeyRepo struct {
+	mu     sync.RWMutex
+	keys   map[int64]ApiKey
+	lastId int64
+}
+
+func NewMemoryApiKeyRepository() apiKeyRepoInterface {
+	return &memoryApiKeyRepo{keys: make(map[int64]ApiKey)}
+}
+
+func (m *memoryApiKeyRepo) Create(ctx context.Context, key *ApiKey) (*ApiKey, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.keys {
+		if stored.Hash == key.Hash {
+			return nil, ErrorKind(ConflictError, "API key already exists")
+		}
+	}
+	m.lastId++
+	stored := *key
+	stored.Id = m.lastId
+	stored.Key = ""
+	stored.Scopes = append([]string(nil), key.Scopes...)
+	stored.CreatedAt = time.Now()
+	stored.Tenant = TenantFromContext(ctx)
+	m.keys[stored.Id] = stored
+	return &stored, nil
+}
+
+func (m *memoryApiKeyRepo) Get(ctx context.Context, keyId int64) (*ApiKey, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.keys[keyId]
+	if !ok || key.Tenant != TenantFromContext(ctx) {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	return &key, nil
+}
+
+func (m *memoryApiKeyRepo) GetByHash(ctx context.Context, hash string) (*ApiKey, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.Hash == hash {
+			return &key, nil
+		}
+	}
+	return nil, ErrorKind(NotFoundError, "no record matching given id")
+}
+
+func (m *memoryApiKeyRepo) GetAll(ctx context.Context) ([]ApiKey, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tenant := TenantFromContext(ctx)
+	results := make([]ApiKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		if key.Tenant != tenant {
+			continue
+		}
+		results = append(results, key)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	return results, nil
+}
+
+func (m *memoryApiKeyRepo) Revoke(ctx context.Context, keyId int64, at time.Time) (*ApiKey, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[keyId]
+	if !ok || key.Tenant != TenantFromContext(ctx) || key.RevokedAt != nil {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	key.RevokedAt = &at
+	m.keys[keyId] = key
+	return &key, nil
+}
+
+func (m *memoryApiKeyRepo) Touch(ctx context.Context, keyId int64, at time.Time) ChatErr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[keyId]
+	if !ok {
+		return ErrorKind(NotFoundError, "no record matching given id")
+	}
+	key.LastUsedAt = &at
+	m.keys[keyId] = key
+	return nil
+}
@@ -0,0 +1,125 @@
+package domain
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestApiKey_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     ApiKey
+		message string
+	}{
+		{"missing name", ApiKey{Scopes: []string{ScopeChatsRead}}, "Required Name"},
+		{"missing scopes", ApiKey{Name: "reports"}, "Required Scopes"},
+		{"unknown scope", ApiKey{Name: "reports", Scopes: []string{"chats:delete"}}, `Invalid Scope "chats:delete", should be one of chats:read, chats:write, chats:admin`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.key.Validate()
+			if assert.NotNil(t, err) {
+				assert.EqualValues(t, tc.message, err.Message())
+			}
+		})
+	}
+
+	key := ApiKey{Name: " reports ", Scopes: []string{"Chats:Read", " chats:read", "chats:write"}}
+	assert.Nil(t, key.Validate())
+	assert.EqualValues(t, "reports", key.Name)
+	assert.EqualValues(t, []string{ScopeChatsRead, ScopeChatsWrite}, key.Scopes)
+}
+
+func TestMemoryApiKeyRepo(t *testing.T) {
+	repo := NewMemoryApiKeyRepository()
+	ctx := context.Background()
+
+	created, err := repo.Create(ctx, &ApiKey{Name: "reports", Scopes: []string{ScopeChatsRead}, Prefix: "lt_01234567", Hash: HashApiKey("lt_0123456789"), Key: "lt_0123456789"})
+	assert.Nil(t, err)
+	assert.Empty(t, created.Key)
+	_, err = repo.Create(ctx, &ApiKey{Name: "again", Scopes: []string{ScopeChatsRead}, Hash: HashApiKey("lt_0123456789")})
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusConflict, err.Status())
+	}
+
+	found, err := repo.GetByHash(ctx, HashApiKey("lt_0123456789"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, created.Id, found.Id)
+	_, err = repo.GetByHash(ctx, HashApiKey("lt_unknown"))
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusNotFound, err.Status())
+	}
+
+	//the key is found by its hash from any tenant, managed only from its own
+	other := WithTenant(ctx, "acme")
+	found, err = repo.GetByHash(other, HashApiKey("lt_0123456789"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, DefaultTenant, found.Tenant)
+	_, err = repo.Get(other, created.Id)
+	assert.NotNil(t, err)
+	others, _ := repo.GetAll(other)
+	assert.Len(t, others, 0)
+	_, err = repo.Revoke(other, created.Id, time.Now())
+	assert.NotNil(t, err)
+
+	usedAt := time.Now()
+	assert.Nil(t, repo.Touch(ctx, created.Id, usedAt))
+	revoked, err := repo.Revoke(ctx, created.Id, usedAt)
+	assert.Nil(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	assert.NotNil(t, revoked.LastUsedAt)
+	_, err = repo.Revoke(ctx, created.Id, usedAt)
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusNotFound, err.Status())
+	}
+}
+
+func TestApiKeyRepo_SQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s := &apiKeyRepo{chats: &chatRepo{db: db, driver: DriverMySQL}}
+	ctx := WithTenant(context.Background(), "acme")
+	hash := HashApiKey("lt_0123456789")
+
+	mock.ExpectExec("INSERT INTO api_keys\\(tenant_id, name, scopes, prefix, key_hash, created_at\\) VALUES \\(\\?,\\?,\\?,\\?,\\?,\\?\\);").
+		WithArgs("acme", "reports", "chats:read,chats:write", "lt_01234567", hash, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	created, chatErr := s.Create(ctx, &ApiKey{Name: "reports", Scopes: []string{ScopeChatsRead, ScopeChatsWrite}, Prefix: "lt_01234567", Hash: hash})
+	assert.Nil(t, chatErr)
+	assert.EqualValues(t, 4, created.Id)
+	assert.EqualValues(t, "acme", created.Tenant)
+
+	columns := []string{"id", "name", "scopes", "prefix", "key_hash", "created_at", "last_used_at", "revoked_at", "tenant_id"}
+	mock.ExpectQuery("SELECT id, name, scopes, prefix, key_hash, created_at, last_used_at, revoked_at, tenant_id FROM api_keys WHERE key_hash=\\?;").
+		WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(4, "reports", "chats:read,chats:write", "lt_01234567", hash, createdAt, nil, nil, "acme"))
+	found, chatErr := s.GetByHash(ctx, hash)
+	assert.Nil(t, chatErr)
+	assert.EqualValues(t, []string{ScopeChatsRead, ScopeChatsWrite}, found.Scopes)
+	assert.Nil(t, found.LastUsedAt)
+	assert.EqualValues(t, "acme", found.Tenant)
+
+	now := time.Now()
+	mock.ExpectExec("UPDATE api_keys SET last_used_at=\\? WHERE id=\\?;").WithArgs(now, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Nil(t, s.Touch(ctx, 4, now))
+
+	mock.ExpectExec("UPDATE api_keys SET revoked_at=\\? WHERE id=\\? AND tenant_id=\\? AND revoked_at IS NULL;").WithArgs(now, 4, "acme").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE id=\\? AND tenant_id=\\?;").
+		WithArgs(4, "acme").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(4, "reports", "chats:read,chats:write", "lt_01234567", hash, createdAt, now, now, "acme"))
+	revoked, chatErr := s.Revoke(ctx, 4, now)
+	assert.Nil(t, chatErr)
+	assert.NotNil(t, revoked.RevokedAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -1,71 +1,210 @@
 package domain
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/phone"
 	"github.com/SemmiDev/lets-tests/utils"
-	"regexp"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Chat struct {
-	Id        int64     `json:"id"`
-	Sender    string    `json:"sender"`
-	Receiver  string    `json:"receiver"`
-	Body      string    `json:"body"`
-	CreatedAt time.Time `json:"created_at"`
+	Id        int64      `json:"id"`
+	Sender    string     `json:"sender"`
+	Receiver  string     `json:"receiver"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	EditedAt      *time.Time `json:"edited_at,omitempty"`
+	RevisionCount int        `json:"revision_count"`
+
+	// Version is bumped on every change and backs the ETag of the chat.
+	Version int64 `json:"version"`
+
+	// Tenant is the workspace the chat belongs to, set by the repository.
+	Tenant string `json:"tenant,omitempty"`
+
+	// SenderRaw and ReceiverRaw are the numbers as they were typed, Sender
+	// and Receiver their E.164 form.
+	SenderRaw   string `json:"sender_raw,omitempty"`
+	ReceiverRaw string `json:"receiver_raw,omitempty"`
+
+	// DeliveredAt is when the receiver first fetched the chat, ReadAt when
+	// they marked it read. Reading delivers it too.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// UnreadCount is how many chats Peer sent that the receiver didn't read.
+type UnreadCount struct {
+	Peer   string `json:"peer"`
+	Unread int64  `json:"unread"`
+}
+
+// ChatRevision is a previous body of an edited chat. Revision counts from 1
+// for the original body, ReplacedAt is when the edit overwrote it.
+type ChatRevision struct {
+	Id         int64     `json:"id"`
+	ChatId     int64     `json:"chat_id"`
+	Revision   int       `json:"revision"`
+	Body       string    `json:"body"`
+	ReplacedAt time.Time `json:"replaced_at"`
 }
 
 type UpdateChatRequest struct {
 	Body string `json:"body"`
 }
 
+// Codes of the FieldError of a chat: a receiver that is the sender itself,
+// a number dialled with an extension, which chats can't reach, and a body
+// that is too long, isn't UTF-8 or holds control characters.
+const (
+	FieldSameAsSender = "same_as_sender"
+	FieldExtension    = "extension_not_allowed"
+	FieldTooLong      = "too_long"
+	FieldEncoding     = "invalid_encoding"
+	FieldControl      = "control_characters"
+)
+
+// Limits of MaxBodyLength. The body column is varchar(255) up to migration
+// 0011_chats_body_text, TEXT after it: 65535 bytes, of up to 4 per character.
+const (
+	DefaultMaxBodyLength = 255
+	MaxTextBodyLength    = 16383
+)
+
+// MaxBodyLength is how many characters, counted as Unicode code points, a
+// body may have. It is set from CHAT_BODY_MAX_LENGTH.
+var MaxBodyLength = DefaultMaxBodyLength
+
+// Validate reports every invalid field of the chat at once, as the Fields of
+// the error.
 func (m *Chat) Validate(kind interface{}) utils.ChatErr {
 	kind = kind.(string)
+	var fields utils.FieldErrors
 
 	if kind == "update" {
 		m.Body = strings.TrimSpace(m.Body)
-		if m.Body == "" {
-			return utils.ErrorKind(utils.UnprocessableEntityError, "Required Body")
-		}
-		return nil
+		validateBody(&fields, m.Body)
+		return fields.Err()
 	}
 
-	m.Sender = strings.TrimSpace(m.Sender)
-	m.Receiver = strings.TrimSpace(m.Receiver)
+	m.SenderRaw = strings.TrimSpace(m.Sender)
+	m.ReceiverRaw = strings.TrimSpace(m.Receiver)
 	m.Body = strings.TrimSpace(m.Body)
 
-	if m.Sender == "" {
-		return utils.ErrorKind(utils.UnprocessableEntityError, "Required Sender")
-	}
-	if m.Receiver == "" {
-		return utils.ErrorKind(utils.UnprocessableEntityError, "Required Receiver")
+	m.Sender = normalizePhone(&fields, "sender", "Sender", m.SenderRaw)
+	m.Receiver = normalizePhone(&fields, "receiver", "Receiver", m.ReceiverRaw)
+	validateBody(&fields, m.Body)
+
+	if len(fields) == 0 && m.Sender == m.Receiver {
+		fields.Add("receiver", FieldSameAsSender, "Sender and Receiver must different")
 	}
-	if m.Body == "" {
-		return utils.ErrorKind(utils.UnprocessableEntityError, "Required Body")
+	return fields.Err()
+}
+
+// validateBody checks the body before the database would refuse it.
+func validateBody(fields *utils.FieldErrors, body string) {
+	switch {
+	case body == "":
+		fields.Add("body", utils.FieldRequired, "Required Body")
+	case !utf8.ValidString(body):
+		fields.Add("body", FieldEncoding, "Body should be valid UTF-8")
+	case strings.IndexFunc(body, isControl) >= 0:
+		fields.Add("body", FieldControl, "Body can't have control characters")
+	case utf8.RuneCountInString(body) > MaxBodyLength:
+		fields.Add("body", FieldTooLong, fmt.Sprintf("Body should be at most %d characters", MaxBodyLength))
 	}
+}
 
-	phoneRegexp := regexp.MustCompile(`^(?:(?:\(?(?:00|\+)([1-4]\d\d|[1-9]\d?)\)?)?[\-\.\ \\\/]?)?((?:\(?\d{1,}\)?[\-\.\ \\\/]?){0,})(?:[\-\.\ \\\/]?(?:#|ext\.?|extension|x)[\-\.\ \\\/]?(\d+))?$`)
+// isControl tells the control characters a body can't have, line breaks and
+// tabs are fine in a message.
+func isControl(r rune) bool {
+	return unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t'
+}
 
-	if from := phoneRegexp.MatchString(m.Sender); from == false {
-		return utils.ErrorKind(utils.UnprocessableEntityError, "Invalid Sender Phone Number")
-	}
-	if to := phoneRegexp.MatchString(m.Receiver); to == false {
-		return utils.ErrorKind(utils.UnprocessableEntityError, "Invalid Receiver Phone Number")
+// normalizePhone returns the E.164 form of the phone number in field, label
+// names it in the messages. An invalid number is reported and kept as is.
+func normalizePhone(fields *utils.FieldErrors, field, label, raw string) string {
+	number, err := phone.Parse(raw)
+	switch {
+	case err == phone.ErrRequired:
+		fields.Add(field, utils.FieldRequired, "Required "+label)
+	case err != nil:
+		fields.Add(field, utils.FieldInvalid, "Invalid "+label+" Phone Number")
+	case number.Extension != "":
+		fields.Add(field, FieldExtension, label+" Phone Number can't have an extension")
+	default:
+		return number.E164
 	}
+	return raw
+}
 
-	if m.Sender == m.Receiver {
-		return utils.ErrorKind(utils.UnprocessableEntityError, "Sender and Receiver must different")
+// NormalizeConversation checks the two participants of a conversation
+// lookup and returns their E.164 form.
+func NormalizeConversation(a, b string) (string, string, utils.ChatErr) {
+	if strings.TrimSpace(a) == "" || strings.TrimSpace(b) == "" {
+		return a, b, utils.ErrorKind(utils.BadRequestError, "Required Conversation Participants")
 	}
-
-	return nil
+	first, errA := NormalizePhone(a)
+	second, errB := NormalizePhone(b)
+	if errA != nil || errB != nil {
+		return a, b, utils.ErrorKind(utils.BadRequestError, "Invalid Conversation Phone Number")
+	}
+	if first == second {
+		return a, b, utils.ErrorKind(utils.BadRequestError, "Conversation participants must different")
+	}
+	return first, second, nil
 }
 
 type chatRepoInterface interface {
-	Get(Id int64) (*Chat, utils.ChatErr)
-	Create(chat *Chat) (*Chat, utils.ChatErr)
-	Update(chat *Chat) (*Chat, utils.ChatErr)
-	Delete(Id int64) utils.ChatErr
-	GetAll() ([]Chat, utils.ChatErr)
+	Get(ctx context.Context, Id int64) (*Chat, utils.ChatErr)
+	Create(ctx context.Context, chat *Chat) (*Chat, utils.ChatErr)
+	Update(ctx context.Context, chat *Chat) (*Chat, utils.ChatErr)
+	Delete(ctx context.Context, Id int64, version int64) utils.ChatErr
+	GetAll(ctx context.Context, filter ChatFilter, page PageRequest) (*ChatPage, utils.ChatErr)
+	GetConversation(ctx context.Context, a, b string, page PageRequest) (*ChatPage, utils.ChatErr)
+	GetDeleted(ctx context.Context, Id int64) (*Chat, utils.ChatErr)
+	Restore(ctx context.Context, Id int64) (*Chat, utils.ChatErr)
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, utils.ChatErr)
+	Revisions(ctx context.Context, Id int64) ([]ChatRevision, utils.ChatErr)
+	CreateWithKey(ctx context.Context, chat *Chat, key *IdempotencyKey) (*Chat, utils.ChatErr)
+	GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, utils.ChatErr)
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, utils.ChatErr)
+
+	// MarkRead sets the read time of a chat, a chat read before is returned
+	// as it is.
+	MarkRead(ctx context.Context, Id int64, readAt time.Time) (*Chat, utils.ChatErr)
+	// MarkConversationRead reads the unread chats sender sent receiver up to
+	// the chat upTo, and tells how many there were. upTo must be a chat
+	// between the two, in either direction, or the result is a NotFound.
+	MarkConversationRead(ctx context.Context, receiver, sender string, upTo int64, readAt time.Time) (int64, utils.ChatErr)
+	// MarkDelivered sets the delivery time of the chats of ids sent to
+	// receiver that weren't delivered yet.
+	MarkDelivered(ctx context.Context, receiver string, ids []int64, deliveredAt time.Time) utils.ChatErr
+	// UnreadCounts counts the unread chats of receiver by sender.
+	UnreadCounts(ctx context.Context, receiver string) ([]UnreadCount, utils.ChatErr)
+	// Inbox lists the conversations of phone, the one active last first.
+	Inbox(ctx context.Context, phone string, page PageRequest) (*InboxPage, utils.ChatErr)
 	Initialize(DbDriver, DbUser, DbPassword, DbPort, DbHost, DbName string) *sql.DB
 }
+
+// NormalizePhone returns the E.164 form of a phone number given on its own,
+// such as the one a client subscribes to chat events with.
+func NormalizePhone(raw string) (string, utils.ChatErr) {
+	number, err := phone.Parse(raw)
+	switch {
+	case err == phone.ErrRequired:
+		return raw, utils.ErrorKind(utils.BadRequestError, "Required Phone Number")
+	case err != nil:
+		return raw, utils.ErrorKind(utils.BadRequestError, "Invalid Phone Number")
+	case number.Extension != "":
+		return raw, utils.ErrorKind(utils.BadRequestError, "Phone Number can't have an extension")
+	}
+	return number.E164, nil
+}
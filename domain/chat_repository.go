@@ -1,35 +1,87 @@
 package domain
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	. "github.com/SemmiDev/lets-tests/utils"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	queryInsertChat  = `INSERT INTO chats(sender, receiver, body, created_at) VALUES (?,?,?,?);`
-	queryGetChat     = `SELECT id, sender, receiver, body, created_at FROM chats WHERE id=?;`
-	queryUpdateChat  = `UPDATE chats SET body=? WHERE id=?;`
-	queryDeleteChat  = `DELETE FROM chats WHERE id=?;`
-	queryGetAllChats = `SELECT id, sender, receiver, body, created_at FROM chats;`
+	chatColumns = `id, sender, receiver, body, created_at, deleted_at, edited_at, revision_count, version, sender_raw, receiver_raw, delivered_at, read_at`
+
+	// Every query on chats names the tenant, so no id reaches the chats of
+	// another one.
+	queryInsertChat  = `INSERT INTO chats(tenant_id, sender, receiver, body, created_at, sender_raw, receiver_raw) VALUES (?,?,?,?,?,?,?);`
+	queryGetChat     = `SELECT ` + chatColumns + ` FROM chats WHERE id=? AND tenant_id=? AND deleted_at IS NULL;`
+	queryGetDeleted  = `SELECT ` + chatColumns + ` FROM chats WHERE id=? AND tenant_id=? AND deleted_at IS NOT NULL;`
+	queryLockChat    = `SELECT body, revision_count, version FROM chats WHERE id=? AND tenant_id=? AND deleted_at IS NULL FOR UPDATE;`
+	queryUpdateChat  = `UPDATE chats SET body=?, edited_at=?, revision_count=?, version=version+1 WHERE id=? AND tenant_id=?;`
+	queryDeleteChat  = `UPDATE chats SET deleted_at=?, version=version+1 WHERE id=? AND tenant_id=? AND deleted_at IS NULL AND (? = 0 OR version = ?);`
+	queryRestoreChat = `UPDATE chats SET deleted_at=NULL, version=version+1 WHERE id=? AND tenant_id=? AND deleted_at IS NOT NULL;`
+	//purging is maintenance, it runs over every tenant
+	queryPurgeChats = `DELETE FROM chats WHERE deleted_at IS NOT NULL AND deleted_at < ?;`
+
+	//revisions are only read once their chat was found in the tenant
+	queryInsertRevision = `INSERT INTO chat_revisions(chat_id, revision, body, replaced_at) VALUES (?,?,?,?);`
+	queryGetRevisions   = `SELECT id, chat_id, revision, body, replaced_at FROM chat_revisions WHERE chat_id=? ORDER BY revision;`
+)
+
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
 )
 
+// chatRepo stores chats in a SQL database. Queries are written with MySQL
+// style placeholders and rebound for the other supported drivers.
 type chatRepo struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
 }
 
 var ChatRepo chatRepoInterface = &chatRepo{}
 
+// QueryTimeout bounds each repository call on top of the deadline of the
+// caller's context. Zero leaves only the caller's deadline.
+var QueryTimeout = 5 * time.Second
+
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, QueryTimeout)
+}
+
 func NewChatRepository(db *sql.DB) chatRepoInterface {
-	return &chatRepo{db: db}
+	return &chatRepo{db: db, driver: DriverMySQL}
+}
+
+// NewRepository returns an uninitialized repository for the given DBDRIVER.
+func NewRepository(driver string) (chatRepoInterface, error) {
+	switch driver {
+	case DriverMySQL, DriverPostgres:
+		return &chatRepo{driver: driver}, nil
+	case DriverMemory:
+		return NewMemoryChatRepository(), nil
+	}
+	return nil, fmt.Errorf("unsupported database driver %q", driver)
 }
 
 func (m *chatRepo) Initialize(Dbdriver, DbUser, DbPassword, DbPort, DbHost, DbName string) *sql.DB {
 	var err error
-	DBURL := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local", DbUser, DbPassword, DbHost, DbPort, DbName)
+	DBURL := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local&clientFoundRows=true", DbUser, DbPassword, DbHost, DbPort, DbName)
+	if Dbdriver == DriverPostgres {
+		DBURL = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", DbUser, DbPassword, DbHost, DbPort, DbName)
+	}
 
+	m.driver = Dbdriver
 	m.db, err = sql.Open(Dbdriver, DBURL)
 	if err != nil {
 		log.Fatal("This is the error connecting to the database:", err)
@@ -39,94 +91,358 @@ func (m *chatRepo) Initialize(Dbdriver, DbUser, DbPassword, DbPort, DbHost, DbNa
 	return m.db
 }
 
-func (m *chatRepo) Get(chatId int64) (*Chat, ChatErr) {
-	stmt, err := m.db.Prepare(queryGetChat)
+// rebind rewrites ? placeholders into the $1, $2... form used by Postgres.
+func (m *chatRepo) rebind(query string) string {
+	if m.driver != DriverPostgres {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func (m *chatRepo) Get(ctx context.Context, chatId int64) (*Chat, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt, err := m.db.PrepareContext(ctx, m.rebind(queryGetChat))
 	if err != nil {
 		return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to prepare chat: %s", err.Error()))
 	}
 	defer stmt.Close()
 
+	tenant := TenantFromContext(ctx)
 	var msg Chat
-	result := stmt.QueryRow(chatId)
-	if getError := result.Scan(&msg.Id, &msg.Sender, &msg.Receiver, &msg.Body, &msg.CreatedAt); getError != nil {
+	result := stmt.QueryRowContext(ctx, chatId, tenant)
+	if getError := scanChat(result, &msg); getError != nil {
 		fmt.Println("this is the error man: ", getError)
-		return nil, ParseError(getError)
+		return nil, parseError(ctx, getError)
 	}
 	log.Println(result)
+	msg.Tenant = tenant
 	return &msg, nil
 }
 
-func (m *chatRepo) Create(msg *Chat) (*Chat, ChatErr) {
-	stmt, err := m.db.Prepare(queryInsertChat)
+// Create stores a new chat and records its created event.
+func (m *chatRepo) Create(ctx context.Context, msg *Chat) (*Chat, ChatErr) {
+	return m.create(ctx, msg, nil)
+}
+
+// create inserts a chat and, when key is given, stores key in the same
+// transaction with the created chat as its response.
+func (m *chatRepo) create(ctx context.Context, msg *Chat, key *IdempotencyKey) (*Chat, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to prepare user to save: %s", err.Error()))
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to start chat creation: %s", err.Error()))
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	insertResult, createErr := stmt.Exec(msg.Sender, msg.Receiver, msg.Body, msg.CreatedAt)
-	if createErr != nil {
-		return nil, ParseError(createErr)
+	tenant := TenantFromContext(ctx)
+	if m.driver == DriverPostgres {
+		//lib/pq has no LastInsertId, the id has to be returned by the statement
+		query := strings.TrimSuffix(queryInsertChat, ";") + " RETURNING id;"
+		if createErr := tx.QueryRowContext(ctx, m.rebind(query), tenant, msg.Sender, msg.Receiver, msg.Body, msg.CreatedAt, msg.SenderRaw, msg.ReceiverRaw).Scan(&msg.Id); createErr != nil {
+			return nil, parseError(ctx, createErr)
+		}
+	} else {
+		insertResult, createErr := tx.ExecContext(ctx, m.rebind(queryInsertChat), tenant, msg.Sender, msg.Receiver, msg.Body, msg.CreatedAt, msg.SenderRaw, msg.ReceiverRaw)
+		if createErr != nil {
+			return nil, parseError(ctx, createErr)
+		}
+		if msg.Id, err = insertResult.LastInsertId(); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to save chat: %s", err.Error()))
+		}
 	}
 
-	msgId, err := insertResult.LastInsertId()
-	if err != nil {
+	created, recordErr := m.recordEvent(ctx, tx, ChatCreatedEvent, msg.Id)
+	if recordErr != nil {
+		return nil, recordErr
+	}
+	if key != nil {
+		if keyErr := m.storeIdempotencyKey(ctx, tx, key, created); keyErr != nil {
+			return nil, keyErr
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to save chat: %s", err.Error()))
 	}
-	msg.Id = msgId
-	return msg, nil
+	return created, nil
 }
 
-func (m *chatRepo) Update(msg *Chat) (*Chat, ChatErr) {
-	stmt, err := m.db.Prepare(queryUpdateChat)
+// Update replaces the body of a chat and keeps the previous one as a
+// revision. Writing the same body again doesn't count as an edit. When
+// msg.Version is set the update only happens if the stored chat still has
+// that version, otherwise it fails with a ConflictError.
+func (m *chatRepo) Update(ctx context.Context, msg *Chat) (*Chat, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to start chat update: %s", err.Error()))
+	}
+	defer tx.Rollback()
+
+	var previous string
+	var revisionCount int
+	var version int64
+	tenant := TenantFromContext(ctx)
+	if err := tx.QueryRowContext(ctx, m.rebind(queryLockChat), msg.Id, tenant).Scan(&previous, &revisionCount, &version); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	if msg.Version != 0 && msg.Version != version {
+		return nil, staleVersion(msg.Id)
+	}
+	if previous == msg.Body {
+		msg.Version = version
+		return msg, nil
+	}
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, m.rebind(queryInsertRevision), msg.Id, revisionCount+1, previous, now); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	if _, err := tx.ExecContext(ctx, m.rebind(queryUpdateChat), msg.Body, now, revisionCount+1, msg.Id, tenant); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	updated, recordErr := m.recordEvent(ctx, tx, ChatUpdatedEvent, msg.Id)
+	if recordErr != nil {
+		return nil, recordErr
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to update chat: %s", err.Error()))
+	}
+	return updated, nil
+}
+
+func (m *chatRepo) Revisions(ctx context.Context, msgId int64) ([]ChatRevision, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if _, err := m.Get(ctx, msgId); err != nil {
+		return nil, err
+	}
+
+	stmt, err := m.db.PrepareContext(ctx, m.rebind(queryGetRevisions))
 	if err != nil {
-		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to prepare user to update: %s", err.Error()))
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to prepare chat revisions: %s", err.Error()))
 	}
 	defer stmt.Close()
 
-	_, updateErr := stmt.Exec(msg.Body, msg.Id)
-	if updateErr != nil {
-		return nil, ParseError(updateErr)
+	rows, err := stmt.QueryContext(ctx, msgId)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	results := make([]ChatRevision, 0)
+	for rows.Next() {
+		var revision ChatRevision
+		if getError := rows.Scan(&revision.Id, &revision.ChatId, &revision.Revision, &revision.Body, &revision.ReplacedAt); getError != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get chat revision: %s", getError.Error()))
+		}
+		results = append(results, revision)
 	}
-	return msg, nil
+	return results, nil
 }
 
-func (m *chatRepo) Delete(msgId int64) ChatErr {
-	stmt, err := m.db.Prepare(queryDeleteChat)
+// Delete only marks the chat as deleted, it stays restorable until purged.
+// A non zero version makes the delete conditional, like in Update.
+func (m *chatRepo) Delete(ctx context.Context, msgId int64, version int64) ChatErr {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return ErrorKind(InternalServerError, fmt.Sprintf("error when trying to delete chat: %s", err.Error()))
 	}
+	defer tx.Rollback()
+
+	tenant := TenantFromContext(ctx)
+	deleteResult, err := tx.ExecContext(ctx, m.rebind(queryDeleteChat), time.Now(), msgId, tenant, version, version)
+	if err != nil {
+		return parseError(ctx, err)
+	}
+	if affectedErr := checkAffected(deleteResult); affectedErr != nil {
+		if version == 0 {
+			return affectedErr
+		}
+		//nothing matched, tell a missing chat apart from a stale version
+		var msg Chat
+		if err := scanChat(tx.QueryRowContext(ctx, m.rebind(queryGetChat), msgId, tenant), &msg); err != nil {
+			return parseError(ctx, err)
+		}
+		return staleVersion(msgId)
+	}
+
+	if _, recordErr := m.recordEvent(ctx, tx, ChatDeletedEvent, msgId); recordErr != nil {
+		return recordErr
+	}
+	if err := tx.Commit(); err != nil {
+		return ErrorKind(InternalServerError, fmt.Sprintf("error when trying to delete chat: %s", err.Error()))
+	}
+	return nil
+}
+
+// GetDeleted returns a soft-deleted chat, the ones Get doesn't see.
+func (m *chatRepo) GetDeleted(ctx context.Context, chatId int64) (*Chat, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tenant := TenantFromContext(ctx)
+	var msg Chat
+	if err := scanChat(m.db.QueryRowContext(ctx, m.rebind(queryGetDeleted), chatId, tenant), &msg); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrorKind(NotFoundError, "no deleted record matching given id")
+		}
+		return nil, parseError(ctx, err)
+	}
+	msg.Tenant = tenant
+	return &msg, nil
+}
+
+func (m *chatRepo) Restore(ctx context.Context, msgId int64) (*Chat, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to restore chat: %s", err.Error()))
+	}
+	defer tx.Rollback()
+
+	restoreResult, err := tx.ExecContext(ctx, m.rebind(queryRestoreChat), msgId, TenantFromContext(ctx))
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	if err := checkAffected(restoreResult); err != nil {
+		return nil, ErrorKind(NotFoundError, "no deleted record matching given id")
+	}
+
+	restored, recordErr := m.recordEvent(ctx, tx, ChatRestoredEvent, msgId)
+	if recordErr != nil {
+		return nil, recordErr
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to restore chat: %s", err.Error()))
+	}
+	return restored, nil
+}
+
+// Purge permanently removes the chats deleted before deletedBefore.
+func (m *chatRepo) Purge(ctx context.Context, deletedBefore time.Time) (int64, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	stmt, err := m.db.PrepareContext(ctx, m.rebind(queryPurgeChats))
+	if err != nil {
+		return 0, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to prepare chats to purge: %s", err.Error()))
+	}
 	defer stmt.Close()
 
-	if _, err := stmt.Exec(msgId); err != nil {
-		return ErrorKind(InternalServerError, fmt.Sprintf("error when trying to delete chat %s", err.Error()))
+	purgeResult, err := stmt.ExecContext(ctx, deletedBefore)
+	if err != nil {
+		return 0, parseError(ctx, err)
+	}
+	purged, err := purgeResult.RowsAffected()
+	if err != nil {
+		return 0, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to count purged chats: %s", err.Error()))
+	}
+	return purged, nil
+}
+
+// parseError is ParseError that also blames an expired context, drivers
+// report a canceled query in their own words.
+func parseError(ctx context.Context, err error) ChatErr {
+	if ctx.Err() != nil {
+		return ParseError(ctx.Err())
+	}
+	return ParseError(err)
+}
+
+func staleVersion(msgId int64) ChatErr {
+	return ErrorKind(ConflictError, fmt.Sprintf("chat %d was modified by another request", msgId))
+}
+
+// checkAffected reports a missing row the same way on every driver. MySQL
+// connections are opened with clientFoundRows so unchanged rows still count.
+func checkAffected(result sql.Result) ChatErr {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return ErrorKind(InternalServerError, fmt.Sprintf("error when trying to count affected chats: %s", err.Error()))
+	}
+	if affected == 0 {
+		return ErrorKind(NotFoundError, "no record matching given id")
 	}
 	return nil
 }
 
-func (m *chatRepo) GetAll() ([]Chat, ChatErr) {
-	stmt, err := m.db.Prepare(queryGetAllChats)
+func (m *chatRepo) GetAll(ctx context.Context, filter ChatFilter, page PageRequest) (*ChatPage, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	if err := page.Validate(); err != nil {
+		return nil, err
+	}
+	if err := page.checkCursor(filter.Sort, filter.fingerprint()); err != nil {
+		return nil, err
+	}
+
+	filter.tenant = TenantFromContext(ctx)
+	query, args := buildChatQuery(filter, page)
+	return m.queryPage(ctx, query, args, page.Limit, filter)
+}
+
+func (m *chatRepo) GetConversation(ctx context.Context, a, b string, page PageRequest) (*ChatPage, ChatErr) {
+	return m.GetAll(ctx, ChatFilter{participants: []string{a, b}}, page)
+}
+
+func (m *chatRepo) queryPage(ctx context.Context, query string, args []interface{}, limit int, filter ChatFilter) (*ChatPage, ChatErr) {
+	stmt, err := m.db.PrepareContext(ctx, m.rebind(query))
 	if err != nil {
 		return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to prepare all chats: %s", err.Error()))
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		return nil, ParseError(err)
+		return nil, parseError(ctx, err)
 	}
 	defer rows.Close()
 
+	tenant := TenantFromContext(ctx)
 	results := make([]Chat, 0)
 
 	for rows.Next() {
 		var msg Chat
-		if getError := rows.Scan(&msg.Id, &msg.Sender, &msg.Receiver, &msg.Body, &msg.CreatedAt); getError != nil {
+		if getError := scanChat(rows, &msg); getError != nil {
 			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get chat: %s", getError.Error()))
 		}
+		msg.Tenant = tenant
 		results = append(results, msg)
 	}
-	if len(results) == 0 {
-		return nil, ErrorKind(NotFoundError, "no records found")
-	}
-	return results, nil
+	return newChatPage(results, limit, filter), nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanChat reads a row selected with chatColumns.
+func scanChat(row rowScanner, msg *Chat) error {
+	return row.Scan(&msg.Id, &msg.Sender, &msg.Receiver, &msg.Body, &msg.CreatedAt, &msg.DeletedAt, &msg.EditedAt, &msg.RevisionCount, &msg.Version, &msg.SenderRaw, &msg.ReceiverRaw, &msg.DeliveredAt, &msg.ReadAt)
 }
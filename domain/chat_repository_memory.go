@@ -0,0 +1,237 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryChatRepo keeps chats in process memory. It is meant for local
+// development and tests, everything is lost when the process exits.
+type memoryChatRepo struct {
+	mu        sync.RWMutex
+	chats     map[int64]Chat
+	revisions map[int64][]ChatRevision
+	nextId    int64
+	revId     int64
+
+	// outbox holds the events recorded with each change, oldest first.
+	outbox   []memoryOutboxEntry
+	outboxId int64
+
+	keys map[memoryKey]IdempotencyKey
+
+	// index holds the words of the bodies for the memorySearcher.
+	index searchIndex
+}
+
+// memoryKey is an idempotency key of a tenant.
+type memoryKey struct {
+	tenant string
+	key    string
+}
+
+func NewMemoryChatRepository() chatRepoInterface {
+	return &memoryChatRepo{
+		chats:     make(map[int64]Chat),
+		revisions: make(map[int64][]ChatRevision),
+		keys:      make(map[memoryKey]IdempotencyKey),
+		index:     make(searchIndex),
+	}
+}
+
+func (m *memoryChatRepo) Initialize(string, string, string, string, string, string) *sql.DB {
+	return nil
+}
+
+func (m *memoryChatRepo) Get(ctx context.Context, chatId int64) (*Chat, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msg, ok := m.lookup(ctx, chatId)
+	if !ok || msg.DeletedAt != nil {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	return &msg, nil
+}
+
+func (m *memoryChatRepo) Create(ctx context.Context, msg *Chat) (*Chat, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.create(ctx, msg), nil
+}
+
+// lookup finds a chat of the tenant of ctx, it expects m.mu to be held.
+func (m *memoryChatRepo) lookup(ctx context.Context, chatId int64) (Chat, bool) {
+	msg, ok := m.chats[chatId]
+	if !ok || msg.Tenant != TenantFromContext(ctx) {
+		return Chat{}, false
+	}
+	return msg, true
+}
+
+// create expects m.mu to be held for writing.
+func (m *memoryChatRepo) create(ctx context.Context, msg *Chat) *Chat {
+	m.nextId++
+	msg.Id = m.nextId
+	msg.Version = 1
+	msg.Tenant = TenantFromContext(ctx)
+	m.chats[msg.Id] = *msg
+	m.index.add(*msg)
+	m.recordEvent(ChatCreatedEvent, *msg)
+	return msg
+}
+
+func (m *memoryChatRepo) Update(ctx context.Context, msg *Chat) (*Chat, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.lookup(ctx, msg.Id)
+	if !ok || current.DeletedAt != nil {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	if msg.Version != 0 && msg.Version != current.Version {
+		return nil, staleVersion(msg.Id)
+	}
+	if current.Body == msg.Body {
+		msg.Version = current.Version
+		return msg, nil
+	}
+
+	now := time.Now()
+	m.revId++
+	m.revisions[msg.Id] = append(m.revisions[msg.Id], ChatRevision{
+		Id:         m.revId,
+		ChatId:     msg.Id,
+		Revision:   current.RevisionCount + 1,
+		Body:       current.Body,
+		ReplacedAt: now,
+	})
+	m.index.remove(current)
+	current.Body = msg.Body
+	m.index.add(current)
+	current.EditedAt = &now
+	current.RevisionCount++
+	current.Version++
+	m.chats[msg.Id] = current
+	m.recordEvent(ChatUpdatedEvent, current)
+	return &current, nil
+}
+
+func (m *memoryChatRepo) Revisions(ctx context.Context, msgId int64) ([]ChatRevision, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	current, ok := m.lookup(ctx, msgId)
+	if !ok || current.DeletedAt != nil {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	return append(make([]ChatRevision, 0), m.revisions[msgId]...), nil
+}
+
+func (m *memoryChatRepo) Delete(ctx context.Context, msgId int64, version int64) ChatErr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.lookup(ctx, msgId)
+	if !ok || current.DeletedAt != nil {
+		return ErrorKind(NotFoundError, "no record matching given id")
+	}
+	if version != 0 && version != current.Version {
+		return staleVersion(msgId)
+	}
+	now := time.Now()
+	current.DeletedAt = &now
+	current.Version++
+	m.chats[msgId] = current
+	m.recordEvent(ChatDeletedEvent, current)
+	return nil
+}
+
+func (m *memoryChatRepo) GetDeleted(ctx context.Context, msgId int64) (*Chat, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msg, ok := m.lookup(ctx, msgId)
+	if !ok || msg.DeletedAt == nil {
+		return nil, ErrorKind(NotFoundError, "no deleted record matching given id")
+	}
+	return &msg, nil
+}
+
+func (m *memoryChatRepo) Restore(ctx context.Context, msgId int64) (*Chat, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.lookup(ctx, msgId)
+	if !ok || current.DeletedAt == nil {
+		return nil, ErrorKind(NotFoundError, "no deleted record matching given id")
+	}
+	current.DeletedAt = nil
+	current.Version++
+	m.chats[msgId] = current
+	m.recordEvent(ChatRestoredEvent, current)
+	return &current, nil
+}
+
+func (m *memoryChatRepo) Purge(ctx context.Context, deletedBefore time.Time) (int64, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for id, msg := range m.chats {
+		if msg.DeletedAt != nil && msg.DeletedAt.Before(deletedBefore) {
+			delete(m.chats, id)
+			delete(m.revisions, id)
+			m.index.remove(msg)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *memoryChatRepo) GetAll(ctx context.Context, filter ChatFilter, page PageRequest) (*ChatPage, ChatErr) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	if err := page.Validate(); err != nil {
+		return nil, err
+	}
+	if err := page.checkCursor(filter.Sort, filter.fingerprint()); err != nil {
+		return nil, err
+	}
+
+	filter.tenant = TenantFromContext(ctx)
+	m.mu.RLock()
+	results := make([]Chat, 0)
+	for _, msg := range m.chats {
+		if filter.matches(msg) {
+			results = append(results, msg)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return filter.less(results[i], results[j])
+	})
+
+	if page.after != nil {
+		after := Chat{Id: page.after.Id, CreatedAt: page.after.CreatedAt}
+		start := sort.Search(len(results), func(i int) bool {
+			return filter.less(after, results[i])
+		})
+		results = results[start:]
+	}
+	if len(results) > page.Limit+1 {
+		results = results[:page.Limit+1]
+	}
+	return newChatPage(results, page.Limit, filter), nil
+}
+
+func (m *memoryChatRepo) GetConversation(ctx context.Context, a, b string, page PageRequest) (*ChatPage, ChatErr) {
+	return m.GetAll(ctx, ChatFilter{participants: []string{a, b}}, page)
+}
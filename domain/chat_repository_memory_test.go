@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryChatRepo_GetAll(t *testing.T) {
+	s := NewMemoryChatRepository()
+	tm := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for i, chat := range []Chat{
+		{Sender: "+6282323231", Receiver: "+6282323232", Body: "hello there", CreatedAt: tm},
+		{Sender: "+6282323232", Receiver: "+6282323231", Body: "general kenobi", CreatedAt: tm},
+		{Sender: "+6282323233", Receiver: "+6282323231", Body: "Hello again", CreatedAt: tm.Add(time.Minute)},
+	} {
+		chat := chat
+		if _, err := s.Create(context.Background(), &chat); err != nil {
+			t.Fatalf("Create(%d) error = %v", i, err)
+		}
+	}
+
+	//walk every chat one page at a time
+	var ids []int64
+	page := PageRequest{Limit: 1}
+	for {
+		got, err := s.GetAll(context.Background(), ChatFilter{}, page)
+		if err != nil {
+			t.Fatalf("GetAll() error = %v", err)
+		}
+		for _, chat := range got.Chats {
+			ids = append(ids, chat.Id)
+		}
+		if !got.HasMore {
+			break
+		}
+		page.Cursor = got.NextCursor
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("GetAll() walked ids %v, want [1 2 3]", ids)
+	}
+
+	got, err := s.GetAll(context.Background(), ChatFilter{Query: "HELLO", Sort: SortCreatedAtDesc}, PageRequest{})
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	if len(got.Chats) != 2 || got.Chats[0].Id != 3 || got.Chats[1].Id != 1 {
+		t.Errorf("GetAll() = %v, want chats 3 and 1", got.Chats)
+	}
+
+	conversation, err := s.GetConversation(context.Background(), "+6282323231", "+6282323232", PageRequest{})
+	if err != nil {
+		t.Fatalf("GetConversation() error = %v", err)
+	}
+	if len(conversation.Chats) != 2 {
+		t.Errorf("GetConversation() = %v, want both directions", conversation.Chats)
+	}
+}
+
+func TestMemoryChatRepo_NotFound(t *testing.T) {
+	s := NewMemoryChatRepository()
+
+	if _, err := s.Get(context.Background(), 1); err == nil || err.Error() != "not_found" {
+		t.Errorf("Get() error = %v, want not_found", err)
+	}
+	if _, err := s.Update(context.Background(), &Chat{Id: 1, Body: "hi"}); err == nil || err.Error() != "not_found" {
+		t.Errorf("Update() error = %v, want not_found", err)
+	}
+	if err := s.Delete(context.Background(), 1, 0); err == nil || err.Error() != "not_found" {
+		t.Errorf("Delete() error = %v, want not_found", err)
+	}
+}
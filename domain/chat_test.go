@@ -1,10 +1,13 @@
 package domain
 
 import (
+	"context"
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/stretchr/testify/assert"
 	"log"
+	"net/http"
 	"reflect"
 	"testing"
 	"time"
@@ -15,6 +18,9 @@ var receiver = utils.RandomReceiver()
 var body = utils.RandomBody()
 var createdAt = time.Now()
 
+// chatRowColumns mirrors chatColumns, rows added to it must match.
+var chatRowColumns = []string{"Id", "Sender", "Receiver", "Body", "CreatedAt", "DeletedAt", "EditedAt", "RevisionCount", "Version", "SenderRaw", "ReceiverRaw", "DeliveredAt", "ReadAt"}
+
 func TestMessageRepo_Get(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -38,8 +44,8 @@ func TestMessageRepo_Get(t *testing.T) {
 			msgId: 1,
 			mock: func() {
 				//We added one row
-				rows := sqlmock.NewRows([]string{"Id", "Sender", "Receiver", "Body", "CreatedAt"}).AddRow(1, sender, receiver, body, createdAt)
-				mock.ExpectPrepare("SELECT (.+) FROM chats").ExpectQuery().WithArgs(1).WillReturnRows(rows)
+				rows := sqlmock.NewRows(chatRowColumns).AddRow(1, sender, receiver, body, createdAt, nil, nil, 0, 1, "", "", nil, nil)
+				mock.ExpectPrepare("SELECT (.+) FROM chats").ExpectQuery().WithArgs(1, DefaultTenant).WillReturnRows(rows)
 			},
 			want: &Chat{
 				Id:        1,
@@ -47,6 +53,8 @@ func TestMessageRepo_Get(t *testing.T) {
 				Receiver:  receiver,
 				Body:      body,
 				CreatedAt: createdAt,
+				Version:   1,
+				Tenant:    DefaultTenant,
 			},
 		},
 		{
@@ -55,8 +63,8 @@ func TestMessageRepo_Get(t *testing.T) {
 			s:     s,
 			msgId: 1,
 			mock: func() {
-				rows := sqlmock.NewRows([]string{"Id", "Sender", "Receiver", "Body", "CreatedAt"}) //observe that we didnt add any role here
-				mock.ExpectPrepare("SELECT (.+) FROM chats").ExpectQuery().WithArgs(1).WillReturnRows(rows)
+				rows := sqlmock.NewRows(chatRowColumns) //observe that we didnt add any role here
+				mock.ExpectPrepare("SELECT (.+) FROM chats").ExpectQuery().WithArgs(1, DefaultTenant).WillReturnRows(rows)
 			},
 			wantErr: true,
 		},
@@ -66,8 +74,8 @@ func TestMessageRepo_Get(t *testing.T) {
 			s:     s,
 			msgId: 1,
 			mock: func() {
-				rows := sqlmock.NewRows([]string{"Id", "Sender", "Receiver", "Body", "CreatedAt"})
-				mock.ExpectPrepare("SELECT (.+) FROM wrong_table").ExpectQuery().WithArgs(1).WillReturnRows(rows)
+				rows := sqlmock.NewRows(chatRowColumns)
+				mock.ExpectPrepare("SELECT (.+) FROM wrong_table").ExpectQuery().WithArgs(1, DefaultTenant).WillReturnRows(rows)
 			},
 			wantErr: true,
 		},
@@ -75,7 +83,7 @@ func TestMessageRepo_Get(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.mock()
-			got, err := tt.s.Get(tt.msgId)
+			got, err := tt.s.Get(context.Background(), tt.msgId)
 			log.Println(got)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Get() error new = %v, wantErr %v", err, tt.wantErr)
@@ -130,7 +138,7 @@ func TestMessageRepo_Get(t *testing.T) {
 //	for _, tt := range tests {
 //		t.Run(tt.name, func(t *testing.T) {
 //			tt.mock()
-//			got, err := tt.s.Create(tt.request)
+//			got, err := tt.s.Create(context.Background(), tt.request)
 //			if (err != nil) != tt.wantErr {
 //				fmt.Println("this is the error message: ", err.Message())
 //				t.Errorf("Create() error = %v, wantErr %v", err, tt.wantErr)
@@ -143,8 +151,8 @@ func TestMessageRepo_Get(t *testing.T) {
 //	}
 //}
 
-//When the right number of arguments are passed
-//This test is just to improve coverage
+// When the right number of arguments are passed
+// This test is just to improve coverage
 func TestChatRepo_Initialize(t *testing.T) {
 	dbdriver := "mysql"
 	username := "root"
@@ -155,3 +163,413 @@ func TestChatRepo_Initialize(t *testing.T) {
 	dbConnect := ChatRepo.Initialize(dbdriver, username, password, port, host, database)
 	fmt.Println("this is the pool: ", dbConnect)
 }
+
+func TestChatRepo_GetAll(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+
+	//cursors travel as JSON, so use a timestamp that survives the round trip
+	tm := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	first := Chat{Id: 1, Sender: sender, Receiver: receiver, Body: body, CreatedAt: tm, Version: 1, Tenant: DefaultTenant}
+	second := Chat{Id: 2, Sender: sender, Receiver: receiver, Body: body, CreatedAt: tm, Version: 1, Tenant: DefaultTenant}
+	third := Chat{Id: 3, Sender: sender, Receiver: receiver, Body: body, CreatedAt: tm.Add(time.Second), Version: 1, Tenant: DefaultTenant}
+
+	tests := []struct {
+		name     string
+		page     PageRequest
+		mock     func()
+		want     []Chat
+		wantMore bool
+		wantErr  bool
+	}{
+		{
+			//The extra row tells us there is another page
+			name: "First Page",
+			page: PageRequest{Limit: 2},
+			mock: func() {
+				rows := sqlmock.NewRows(chatRowColumns).
+					AddRow(first.Id, first.Sender, first.Receiver, first.Body, first.CreatedAt, nil, nil, 0, 1, "", "", nil, nil).
+					AddRow(second.Id, second.Sender, second.Receiver, second.Body, second.CreatedAt, nil, nil, 0, 1, "", "", nil, nil).
+					AddRow(third.Id, third.Sender, third.Receiver, third.Body, third.CreatedAt, nil, nil, 0, 1, "", "", nil, nil)
+				mock.ExpectPrepare("SELECT (.+) FROM chats WHERE tenant_id = \\? AND deleted_at IS NULL ORDER BY created_at, id LIMIT").ExpectQuery().WithArgs(DefaultTenant, 3).WillReturnRows(rows)
+			},
+			want:     []Chat{first, second},
+			wantMore: true,
+		},
+		{
+			name: "Next Page",
+			page: PageRequest{Limit: 2, Cursor: encodeCursor(second, SortCreatedAt, ChatFilter{}.fingerprint())},
+			mock: func() {
+				rows := sqlmock.NewRows(chatRowColumns).
+					AddRow(third.Id, third.Sender, third.Receiver, third.Body, third.CreatedAt, nil, nil, 0, 1, "", "", nil, nil)
+				mock.ExpectPrepare("SELECT (.+) FROM chats WHERE (.+) ORDER BY created_at, id LIMIT").ExpectQuery().WithArgs(DefaultTenant, second.CreatedAt, second.CreatedAt, second.Id, 3).WillReturnRows(rows)
+			},
+			want: []Chat{third},
+		},
+		{
+			name:    "Invalid Cursor",
+			page:    PageRequest{Cursor: "not a cursor"},
+			mock:    func() {},
+			wantErr: true,
+		},
+		{
+			name:    "Cursor From Another Sort",
+			page:    PageRequest{Cursor: encodeCursor(second, SortId, ChatFilter{}.fingerprint())},
+			mock:    func() {},
+			wantErr: true,
+		},
+		{
+			name:    "Cursor From Other Filters",
+			page:    PageRequest{Cursor: encodeCursor(second, SortCreatedAt, ChatFilter{Query: "hello"}.fingerprint())},
+			mock:    func() {},
+			wantErr: true,
+		},
+		{
+			name:    "Limit Too Large",
+			page:    PageRequest{Limit: MaxPageLimit + 1},
+			mock:    func() {},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mock()
+			got, err := s.GetAll(context.Background(), ChatFilter{}, tt.page)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetAll() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.Chats, tt.want) {
+				t.Errorf("GetAll() = %v, want %v", got.Chats, tt.want)
+			}
+			if got.HasMore != tt.wantMore || (got.NextCursor != "") != tt.wantMore {
+				t.Errorf("GetAll() has_more = %v, next_cursor = %q, want has_more %v", got.HasMore, got.NextCursor, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestChatRepo_GetConversation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+
+	a, b := "+6282323231", "+6282323232"
+	rows := sqlmock.NewRows(chatRowColumns).
+		AddRow(1, a, b, body, createdAt, nil, nil, 0, 1, "", "", nil, nil).
+		AddRow(2, b, a, body, createdAt, nil, nil, 0, 1, "", "", nil, nil)
+	mock.ExpectPrepare("SELECT (.+) FROM chats WHERE tenant_id = \\? AND deleted_at IS NULL AND \\(\\(sender = \\? AND receiver = \\?\\) OR \\(sender = \\? AND receiver = \\?\\)\\) ORDER BY created_at, id").
+		ExpectQuery().WithArgs(DefaultTenant, a, b, b, a, DefaultPageLimit+1).WillReturnRows(rows)
+
+	got, err := s.GetConversation(context.Background(), a, b, PageRequest{})
+	if err != nil {
+		t.Fatalf("GetConversation() error = %v", err)
+	}
+	if len(got.Chats) != 2 || got.HasMore {
+		t.Errorf("GetConversation() = %v, want both directions on a single page", got)
+	}
+	if got.Chats[0].Sender != a || got.Chats[1].Sender != b {
+		t.Errorf("GetConversation() = %v, want messages in both directions", got.Chats)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestNewRepository(t *testing.T) {
+	for _, driver := range []string{DriverMySQL, DriverPostgres, DriverMemory} {
+		repo, err := NewRepository(driver)
+		if err != nil || repo == nil {
+			t.Errorf("NewRepository(%q) = %v, %v", driver, repo, err)
+		}
+	}
+	if _, err := NewRepository("oracle"); err == nil {
+		t.Errorf("NewRepository() expected an error for an unsupported driver")
+	}
+}
+
+// expectEvent expects a change to be read back and recorded in the outbox
+// within its transaction. A nil row reads back a plain chat.
+func expectEvent(mock sqlmock.Sqlmock, eventType string, chatId int64, row *sqlmock.Rows) {
+	if row == nil {
+		row = sqlmock.NewRows(chatRowColumns).AddRow(chatId, sender, receiver, body, createdAt, nil, nil, 0, 1, "", "", nil, nil)
+	}
+	mock.ExpectQuery("SELECT (.+) FROM chats WHERE id=(\\?|\\$1) AND tenant_id=(\\?|\\$2);").WithArgs(chatId, DefaultTenant).WillReturnRows(row)
+	mock.ExpectExec("INSERT INTO outbox").WithArgs(eventType, chatId, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestChatRepo_Postgres(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := &chatRepo{db: db, driver: DriverPostgres}
+
+	//placeholders are rebound and the id comes back through RETURNING
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO chats\\(tenant_id, sender, receiver, body, created_at, sender_raw, receiver_raw\\) VALUES \\(\\$1,\\$2,\\$3,\\$4,\\$5,\\$6,\\$7\\) RETURNING id").
+		WithArgs(DefaultTenant, sender, receiver, body, createdAt, "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	expectEvent(mock, ChatCreatedEvent, 42, nil)
+	mock.ExpectCommit()
+
+	got, createErr := s.Create(context.Background(), &Chat{Sender: sender, Receiver: receiver, Body: body, CreatedAt: createdAt})
+	if createErr != nil {
+		t.Fatalf("Create() error = %v", createErr)
+	}
+	if got.Id != 42 {
+		t.Errorf("Create() id = %d, want 42", got.Id)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE chats SET deleted_at=\\$1, version=version\\+1 WHERE id=\\$2 AND tenant_id=\\$3 AND deleted_at IS NULL AND \\(\\$4 = 0 OR version = \\$5\\)").WithArgs(sqlmock.AnyArg(), 7, DefaultTenant, 0, 0).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if deleteErr := s.Delete(context.Background(), 7, 0); deleteErr == nil || deleteErr.Error() != "not_found" {
+		t.Errorf("Delete() error = %v, want not_found", deleteErr)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChatRepo_Restore_And_Purge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE chats SET deleted_at=NULL, version=version\\+1 WHERE id=\\? AND tenant_id=\\? AND deleted_at IS NOT NULL").WithArgs(1, DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEvent(mock, ChatRestoredEvent, 1, nil)
+	mock.ExpectCommit()
+
+	restored, restoreErr := s.Restore(context.Background(), 1)
+	if restoreErr != nil {
+		t.Fatalf("Restore() error = %v", restoreErr)
+	}
+	if restored.Id != 1 || restored.DeletedAt != nil {
+		t.Errorf("Restore() = %+v, want chat 1 without deleted_at", restored)
+	}
+
+	//restoring a chat that was never deleted is a not found
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE chats SET deleted_at=NULL").WithArgs(2, DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if _, restoreErr := s.Restore(context.Background(), 2); restoreErr == nil || restoreErr.Error() != "not_found" {
+		t.Errorf("Restore() error = %v, want not_found", restoreErr)
+	}
+
+	before := time.Now()
+	mock.ExpectPrepare("DELETE FROM chats WHERE deleted_at IS NOT NULL AND deleted_at < \\?").ExpectExec().WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 3))
+	purged, purgeErr := s.Purge(context.Background(), before)
+	if purgeErr != nil || purged != 3 {
+		t.Errorf("Purge() = %d, %v, want 3 chats purged", purged, purgeErr)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChatRepo_Update(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+
+	//the previous body is kept as revision 2, after the original and one edit
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT body, revision_count, version FROM chats WHERE id=\\? AND tenant_id=\\? AND deleted_at IS NULL FOR UPDATE").WithArgs(1, DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"body", "revision_count", "version"}).AddRow("first edit", 1, 2))
+	mock.ExpectExec("INSERT INTO chat_revisions").WithArgs(1, 2, "first edit", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec("UPDATE chats SET body=\\?, edited_at=\\?, revision_count=\\?, version=version\\+1 WHERE id=\\? AND tenant_id=\\?").WithArgs("second edit", sqlmock.AnyArg(), 2, 1, DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 1))
+	edited := time.Date(2021, 5, 1, 11, 0, 0, 0, time.UTC)
+	expectEvent(mock, ChatUpdatedEvent, 1, sqlmock.NewRows(chatRowColumns).AddRow(1, sender, receiver, "second edit", createdAt, nil, edited, 2, 3, "", "", nil, nil))
+	mock.ExpectCommit()
+
+	got, updateErr := s.Update(context.Background(), &Chat{Id: 1, Body: "second edit"})
+	if updateErr != nil {
+		t.Fatalf("Update() error = %v", updateErr)
+	}
+	if got.RevisionCount != 2 || got.EditedAt == nil || got.Version != 3 {
+		t.Errorf("Update() = %+v, want revision_count 2, version 3 and edited_at set", got)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT body, revision_count, version FROM chats").WithArgs(2, DefaultTenant).WillReturnRows(sqlmock.NewRows([]string{"body", "revision_count", "version"}))
+	mock.ExpectRollback()
+	if _, updateErr := s.Update(context.Background(), &Chat{Id: 2, Body: "edit"}); updateErr == nil || updateErr.Error() != "not_found" {
+		t.Errorf("Update() error = %v, want not_found", updateErr)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChatRepo_Stale_Version(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+
+	//the chat moved to version 3 since the caller read version 2
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT body, revision_count, version FROM chats").WithArgs(1, DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"body", "revision_count", "version"}).AddRow("first edit", 1, 3))
+	mock.ExpectRollback()
+	if _, updateErr := s.Update(context.Background(), &Chat{Id: 1, Body: "second edit", Version: 2}); updateErr == nil || updateErr.Status() != http.StatusConflict {
+		t.Errorf("Update() error = %v, want conflict", updateErr)
+	}
+
+	//nothing deleted but the chat exists, so the version was stale
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE chats SET deleted_at=\\?").WithArgs(sqlmock.AnyArg(), 1, DefaultTenant, 2, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT (.+) FROM chats WHERE id=\\? AND tenant_id=\\? AND deleted_at IS NULL").WithArgs(1, DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(chatRowColumns).AddRow(1, sender, receiver, body, createdAt, nil, nil, 0, 3, "", "", nil, nil))
+	mock.ExpectRollback()
+	if deleteErr := s.Delete(context.Background(), 1, 2); deleteErr == nil || deleteErr.Status() != http.StatusConflict {
+		t.Errorf("Delete() error = %v, want conflict", deleteErr)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChatRepo_Query_Timeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+
+	defer func(timeout time.Duration) { QueryTimeout = timeout }(QueryTimeout)
+	QueryTimeout = 10 * time.Millisecond
+
+	mock.ExpectPrepare("SELECT (.+) FROM chats").ExpectQuery().WithArgs(1, DefaultTenant).WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows(chatRowColumns).AddRow(1, sender, receiver, body, createdAt, nil, nil, 0, 1, "", "", nil, nil))
+
+	_, getErr := s.Get(context.Background(), 1)
+	if getErr == nil || getErr.Status() != http.StatusGatewayTimeout {
+		t.Errorf("Get() error = %v, want timeout", getErr)
+	}
+}
+
+func TestChat_Validate(t *testing.T) {
+	chat := Chat{Sender: "", Receiver: "abc", Body: " "}
+	err := chat.Validate("")
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusUnprocessableEntity, err.Status())
+		assert.EqualValues(t, "Required Sender; Invalid Receiver Phone Number; Required Body", err.Message())
+		assert.EqualValues(t, []utils.FieldError{
+			{Field: "sender", Code: utils.FieldRequired, Message: "Required Sender"},
+			{Field: "receiver", Code: utils.FieldInvalid, Message: "Invalid Receiver Phone Number"},
+			{Field: "body", Code: utils.FieldRequired, Message: "Required Body"},
+		}, err.Fields())
+	}
+
+	//a single failure reads as it always did
+	chat = Chat{Sender: "+6282323231", Receiver: "+6282323231", Body: "hello"}
+	err = chat.Validate("")
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, "Sender and Receiver must different", err.Message())
+		assert.EqualValues(t, []utils.FieldError{{Field: "receiver", Code: FieldSameAsSender, Message: "Sender and Receiver must different"}}, err.Fields())
+	}
+
+	chat = Chat{Sender: " +6282323231 ", Receiver: "+6282323232", Body: " hello "}
+	assert.Nil(t, chat.Validate(""))
+	assert.EqualValues(t, "+6282323231", chat.Sender)
+	assert.EqualValues(t, "hello", chat.Body)
+}
+
+func TestChat_Validate_Normalizes_Phones(t *testing.T) {
+	chat := Chat{Sender: " 0823-2323-1 ", Receiver: "(+62) 82323232", Body: "hello"}
+	assert.Nil(t, chat.Validate(""))
+	assert.EqualValues(t, "+6282323231", chat.Sender)
+	assert.EqualValues(t, "0823-2323-1", chat.SenderRaw)
+	assert.EqualValues(t, "+6282323232", chat.Receiver)
+	assert.EqualValues(t, "(+62) 82323232", chat.ReceiverRaw)
+
+	//the same number typed twice differently
+	chat = Chat{Sender: "+62-811-132-431", Receiver: "0811132431", Body: "hello"}
+	err := chat.Validate("")
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, []utils.FieldError{{Field: "receiver", Code: FieldSameAsSender, Message: "Sender and Receiver must different"}}, err.Fields())
+	}
+
+	chat = Chat{Sender: "+62811132431 ext. 12", Receiver: "+6282323232", Body: "hello"}
+	err = chat.Validate("")
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, []utils.FieldError{{Field: "sender", Code: FieldExtension, Message: "Sender Phone Number can't have an extension"}}, err.Fields())
+	}
+}
+
+func TestNormalizeConversation(t *testing.T) {
+	a, b, err := NormalizeConversation("0811132431", "+62 823 2323 232")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "+62811132431", a)
+	assert.EqualValues(t, "+628232323232", b)
+
+	_, _, err = NormalizeConversation("+62811132431", "0811-132-431")
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusBadRequest, err.Status())
+	}
+}
+
+func TestChat_Validate_Body(t *testing.T) {
+	defer func(length int) { MaxBodyLength = length }(MaxBodyLength)
+	MaxBodyLength = 5
+
+	tests := []struct {
+		name string
+		body string
+		code string
+	}{
+		{name: "Code Points", body: "héllo"},
+		{name: "Emoji", body: "👋👋👋👋👋"},
+		{name: "Line Breaks", body: "a\nb\tc"},
+		{name: "Too Long", body: "hello!", code: FieldTooLong},
+		{name: "Invalid UTF-8", body: "he\xffo", code: FieldEncoding},
+		{name: "Control Character", body: "he\x00lo", code: FieldControl},
+		{name: "Escape", body: "\x1b[2J", code: FieldControl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := Chat{Sender: "+6282323231", Receiver: "+6282323232", Body: tt.body}
+			err := chat.Validate("")
+			update := Chat{Body: tt.body}
+			updateErr := update.Validate("update")
+			if tt.code == "" {
+				assert.Nil(t, err)
+				assert.Nil(t, updateErr)
+				return
+			}
+			if assert.NotNil(t, err) && assert.NotNil(t, updateErr) {
+				assert.EqualValues(t, http.StatusUnprocessableEntity, err.Status())
+				assert.EqualValues(t, tt.code, err.Fields()[0].Code)
+				assert.EqualValues(t, err.Fields(), updateErr.Fields())
+			}
+		})
+	}
+
+	chat := Chat{Sender: "+6282323231", Receiver: "+6282323232", Body: "hello!"}
+	if err := chat.Validate(""); assert.NotNil(t, err) {
+		assert.EqualValues(t, "Body should be at most 5 characters", err.Message())
+	}
+}
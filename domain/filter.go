@@ -0,0 +1,300 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/utils"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SortCreatedAt     = "created_at"
+	SortCreatedAtDesc = "-created_at"
+	SortId            = "id"
+)
+
+// chatListParams are the query parameters understood by the chat list,
+// including the pagination ones handled by PageRequest.
+var chatListParams = map[string]bool{
+	"sender":   true,
+	"receiver": true,
+	"since":    true,
+	"until":    true,
+	"q":        true,
+	"sort":     true,
+	"limit":    true,
+	"cursor":   true,
+
+	"include_deleted": true,
+}
+
+// ChatFilter narrows down and orders a chat listing. Zero values mean
+// "no restriction", an empty Sort means SortCreatedAt.
+type ChatFilter struct {
+	Sender   string
+	Receiver string
+	Since    *time.Time
+	Until    *time.Time
+	Query    string
+	Sort     string
+
+	// IncludeDeleted also lists soft-deleted chats, for admins.
+	IncludeDeleted bool
+
+	// Participant keeps the chats that phone number sent or received. It
+	// is set from the caller, never from the query string.
+	Participant string
+
+	// participants restricts the listing to the conversation between two
+	// phone numbers, in both directions.
+	participants []string
+
+	// tenant is set by the repository from the context of the call.
+	tenant string
+}
+
+// NewChatFilter parses the query string of a chat list request.
+func NewChatFilter(query url.Values) (ChatFilter, utils.ChatErr) {
+	for key := range query {
+		if !chatListParams[key] {
+			return ChatFilter{}, utils.ErrorKind(utils.BadRequestError, fmt.Sprintf("unknown query parameter: %s", key))
+		}
+	}
+
+	filter, err := parseFilterParams(query)
+	if err != nil {
+		return filter, err
+	}
+	filter.Query = strings.TrimSpace(query.Get("q"))
+	filter.Sort = query.Get("sort")
+
+	if includeDeleted := query.Get("include_deleted"); includeDeleted != "" {
+		include, err := strconv.ParseBool(includeDeleted)
+		if err != nil {
+			return filter, utils.ErrorKind(utils.BadRequestError, "include_deleted should be true or false")
+		}
+		filter.IncludeDeleted = include
+	}
+	return filter, filter.Validate()
+}
+
+// parseFilterParams reads the sender, receiver, since and until parameters
+// shared by the chat list and the search.
+func parseFilterParams(query url.Values) (ChatFilter, utils.ChatErr) {
+	var filter ChatFilter
+	filter.Sender = strings.TrimSpace(query.Get("sender"))
+	filter.Receiver = strings.TrimSpace(query.Get("receiver"))
+	//chats are stored with E.164 numbers, however the filter was typed
+	for _, param := range []struct {
+		name  string
+		value *string
+	}{{"sender", &filter.Sender}, {"receiver", &filter.Receiver}} {
+		if *param.value == "" {
+			continue
+		}
+		normalized, err := NormalizePhone(*param.value)
+		if err != nil {
+			return filter, utils.ErrorKind(utils.BadRequestError, param.name+" should be a phone number")
+		}
+		*param.value = normalized
+	}
+
+	var err utils.ChatErr
+	if filter.Since, err = parseFilterTime("since", query.Get("since"), false); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseFilterTime("until", query.Get("until"), true); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func (f *ChatFilter) Validate() utils.ChatErr {
+	switch f.Sort {
+	case "":
+		f.Sort = SortCreatedAt
+	case SortCreatedAt, SortCreatedAtDesc, SortId:
+	default:
+		return utils.ErrorKind(utils.BadRequestError, fmt.Sprintf("unknown sort field: %s", f.Sort))
+	}
+	if f.Since != nil && f.Until != nil && f.Since.After(*f.Until) {
+		return utils.ErrorKind(utils.BadRequestError, "since should be before until")
+	}
+	return nil
+}
+
+// parseFilterTime reads a timestamp or a date. A date is its midnight, or
+// with throughDay its last microsecond, the precision of the stored times,
+// so an until date keeps the chats of that day.
+func parseFilterTime(name, value string, throughDay bool) (*time.Time, utils.ChatErr) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if throughDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Microsecond)
+		}
+		return &t, nil
+	}
+	return nil, utils.ErrorKind(utils.BadRequestError, fmt.Sprintf("%s should be an RFC 3339 timestamp or a date", name))
+}
+
+// fingerprint identifies the filters of a listing, so a page or search
+// cursor can tell it is replayed under other ones. The sort is left out,
+// cursors keep it on its own.
+func (f ChatFilter) fingerprint() string {
+	//a conversation lists the same chats whichever way round it is asked for
+	participants := append([]string(nil), f.participants...)
+	sort.Strings(participants)
+	parts := []string{f.Sender, f.Receiver, "", "", f.Query, strconv.FormatBool(f.IncludeDeleted), f.Participant, strings.Join(participants, ",")}
+	if f.Since != nil {
+		parts[2] = f.Since.UTC().Format(time.RFC3339Nano)
+	}
+	if f.Until != nil {
+		parts[3] = f.Until.UTC().Format(time.RFC3339Nano)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// buildChatQuery translates a filter and a page into a parameterised
+// SELECT. Values never end up in the SQL text, only in the returned args.
+func buildChatQuery(filter ChatFilter, page PageRequest) (string, []interface{}) {
+	where, args := filterConditions(filter)
+
+	orderBy := "created_at, id"
+	switch filter.Sort {
+	case SortCreatedAtDesc:
+		orderBy = "created_at DESC, id DESC"
+		if page.after != nil {
+			where = append(where, "(created_at < ? OR (created_at = ? AND id < ?))")
+			args = append(args, page.after.CreatedAt, page.after.CreatedAt, page.after.Id)
+		}
+	case SortId:
+		orderBy = "id"
+		if page.after != nil {
+			where = append(where, "id > ?")
+			args = append(args, page.after.Id)
+		}
+	default:
+		if page.after != nil {
+			where = append(where, "(created_at > ? OR (created_at = ? AND id > ?))")
+			args = append(args, page.after.CreatedAt, page.after.CreatedAt, page.after.Id)
+		}
+	}
+
+	query := "SELECT " + chatColumns + " FROM chats"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + orderBy + " LIMIT ?;"
+	args = append(args, page.Limit+1)
+	return query, args
+}
+
+// filterConditions are the WHERE conditions of filter and their args.
+func filterConditions(filter ChatFilter) ([]string, []interface{}) {
+	where := []string{"tenant_id = ?"}
+	args := []interface{}{filter.tenant}
+
+	if !filter.IncludeDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	if len(filter.participants) == 2 {
+		a, b := filter.participants[0], filter.participants[1]
+		where = append(where, "((sender = ? AND receiver = ?) OR (sender = ? AND receiver = ?))")
+		args = append(args, a, b, b, a)
+	}
+	if filter.Participant != "" {
+		where = append(where, "(sender = ? OR receiver = ?)")
+		args = append(args, filter.Participant, filter.Participant)
+	}
+	if filter.Sender != "" {
+		where = append(where, "sender = ?")
+		args = append(args, filter.Sender)
+	}
+	if filter.Receiver != "" {
+		where = append(where, "receiver = ?")
+		args = append(args, filter.Receiver)
+	}
+	if filter.Since != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, *filter.Since)
+	}
+	if filter.Until != nil {
+		where = append(where, "created_at <= ?")
+		args = append(args, *filter.Until)
+	}
+	if filter.Query != "" {
+		where = append(where, "LOWER(body) LIKE LOWER(?)")
+		args = append(args, "%"+escapeLike(filter.Query)+"%")
+	}
+	return where, args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// matches is the in-process counterpart of the WHERE clause built by
+// buildChatQuery, used by repositories that don't speak SQL.
+func (f ChatFilter) matches(chat Chat) bool {
+	if chat.Tenant != f.tenant {
+		return false
+	}
+	if !f.IncludeDeleted && chat.DeletedAt != nil {
+		return false
+	}
+	if len(f.participants) == 2 {
+		a, b := f.participants[0], f.participants[1]
+		if !(chat.Sender == a && chat.Receiver == b) && !(chat.Sender == b && chat.Receiver == a) {
+			return false
+		}
+	}
+	if f.Participant != "" && chat.Sender != f.Participant && chat.Receiver != f.Participant {
+		return false
+	}
+	if f.Sender != "" && chat.Sender != f.Sender {
+		return false
+	}
+	if f.Receiver != "" && chat.Receiver != f.Receiver {
+		return false
+	}
+	if f.Since != nil && chat.CreatedAt.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && chat.CreatedAt.After(*f.Until) {
+		return false
+	}
+	if f.Query != "" && !strings.Contains(strings.ToLower(chat.Body), strings.ToLower(f.Query)) {
+		return false
+	}
+	return true
+}
+
+// less reports whether a sorts before b in the filter's ordering.
+func (f ChatFilter) less(a, b Chat) bool {
+	switch f.Sort {
+	case SortCreatedAtDesc:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.Id > b.Id
+	case SortId:
+		return a.Id < b.Id
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.Id < b.Id
+}
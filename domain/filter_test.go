@@ -0,0 +1,151 @@
+package domain
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewChatFilter(t *testing.T) {
+	since := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 5, 2, 10, 30, 0, 0, time.UTC)
+	endOfSince := time.Date(2021, 5, 1, 23, 59, 59, 999999000, time.UTC)
+	endOfUntil := time.Date(2021, 5, 2, 23, 59, 59, 999999000, time.UTC)
+
+	tests := []struct {
+		name    string
+		query   string
+		want    ChatFilter
+		wantErr string
+	}{
+		{
+			name:  "Defaults",
+			query: "",
+			want:  ChatFilter{Sort: SortCreatedAt},
+		},
+		{
+			name:  "All Fields",
+			query: "sender=%2B6282323231&receiver=%2B6282323232&since=2021-05-01&until=2021-05-02T10:30:00Z&q=hello&sort=-created_at&limit=5",
+			want: ChatFilter{
+				Sender:   "+6282323231",
+				Receiver: "+6282323232",
+				Since:    &since,
+				Until:    &until,
+				Query:    "hello",
+				Sort:     SortCreatedAtDesc,
+			},
+		},
+		{
+			name:  "Include Deleted",
+			query: "include_deleted=true",
+			want:  ChatFilter{Sort: SortCreatedAt, IncludeDeleted: true},
+		},
+		{
+			name:    "Invalid Include Deleted",
+			query:   "include_deleted=maybe",
+			wantErr: "include_deleted should be true or false",
+		},
+		{
+			name:    "Unknown Parameter",
+			query:   "body=hello",
+			wantErr: "unknown query parameter: body",
+		},
+		{
+			name:    "Unknown Sort",
+			query:   "sort=body",
+			wantErr: "unknown sort field: body",
+		},
+		{
+			name:    "Invalid Since",
+			query:   "since=yesterday",
+			wantErr: "since should be an RFC 3339 timestamp or a date",
+		},
+		{
+			name:  "Until Date",
+			query: "since=2021-05-01&until=2021-05-02",
+			want:  ChatFilter{Since: &since, Until: &endOfUntil, Sort: SortCreatedAt},
+		},
+		{
+			name:  "Since And Until Same Date",
+			query: "since=2021-05-01&until=2021-05-01",
+			want:  ChatFilter{Since: &since, Until: &endOfSince, Sort: SortCreatedAt},
+		},
+		{
+			name:    "Since After Until",
+			query:   "since=2021-05-02&until=2021-05-01",
+			wantErr: "since should be before until",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			got, err := NewChatFilter(query)
+			if tt.wantErr != "" {
+				if err == nil || err.Message() != tt.wantErr {
+					t.Errorf("NewChatFilter() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewChatFilter() unexpected error = %v", err.Message())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewChatFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildChatQuery(t *testing.T) {
+	after := &pageCursor{Id: 7, CreatedAt: createdAt}
+
+	tests := []struct {
+		name      string
+		filter    ChatFilter
+		page      PageRequest
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{
+			name:      "No Filter",
+			filter:    ChatFilter{Sort: SortCreatedAt, tenant: DefaultTenant},
+			page:      PageRequest{Limit: 10},
+			wantQuery: "SELECT " + chatColumns + " FROM chats WHERE tenant_id = ? AND deleted_at IS NULL ORDER BY created_at, id LIMIT ?;",
+			wantArgs:  []interface{}{DefaultTenant, 11},
+		},
+		{
+			//user input must only ever show up as an argument
+			name:      "Sender And Search",
+			filter:    ChatFilter{Sender: "+62'; DROP TABLE chats; --", Query: "100%_done", Sort: SortId, tenant: "acme"},
+			page:      PageRequest{Limit: 10, after: after},
+			wantQuery: "SELECT " + chatColumns + " FROM chats WHERE tenant_id = ? AND deleted_at IS NULL AND sender = ? AND LOWER(body) LIKE LOWER(?) AND id > ? ORDER BY id LIMIT ?;",
+			wantArgs:  []interface{}{"acme", "+62'; DROP TABLE chats; --", `%100\%\_done%`, int64(7), 11},
+		},
+		{
+			name:      "Newest First",
+			filter:    ChatFilter{Receiver: "+6282323232", Sort: SortCreatedAtDesc, IncludeDeleted: true, tenant: DefaultTenant},
+			page:      PageRequest{Limit: 10, after: after},
+			wantQuery: "SELECT " + chatColumns + " FROM chats WHERE tenant_id = ? AND receiver = ? AND (created_at < ? OR (created_at = ? AND id < ?)) ORDER BY created_at DESC, id DESC LIMIT ?;",
+			wantArgs:  []interface{}{DefaultTenant, "+6282323232", createdAt, createdAt, int64(7), 11},
+		},
+		{
+			name:      "Participant",
+			filter:    ChatFilter{Participant: "+6282323231", Receiver: "+6282323232", tenant: DefaultTenant},
+			page:      PageRequest{Limit: 10},
+			wantQuery: "SELECT " + chatColumns + " FROM chats WHERE tenant_id = ? AND deleted_at IS NULL AND (sender = ? OR receiver = ?) AND receiver = ? ORDER BY created_at, id LIMIT ?;",
+			wantArgs:  []interface{}{DefaultTenant, "+6282323231", "+6282323231", "+6282323232", 11},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := buildChatQuery(tt.filter, tt.page)
+			if query != tt.wantQuery {
+				t.Errorf("buildChatQuery() query = %q, want %q", query, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("buildChatQuery() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
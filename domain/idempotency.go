@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/utils"
+	"time"
+)
+
+// IdempotencyKey remembers the chat created for a key chosen by the client,
+// so a retried creation answers with that chat instead of a duplicate.
+// Fingerprint identifies the request the key was first used with.
+type IdempotencyKey struct {
+	Key         string
+	Fingerprint string
+	Chat        Chat
+	CreatedAt   time.Time
+}
+
+// IdempotencyKeyTTL is how long a key is remembered. Once it expired the
+// key can be used for a new chat.
+var IdempotencyKeyTTL = 24 * time.Hour
+
+// MaxIdempotencyKeyLength is the size of the stored column.
+const MaxIdempotencyKeyLength = 255
+
+// Fingerprint hashes the fields a client sends to create the chat, so a
+// key reused for another chat can be told apart from a retry.
+func (m *Chat) Fingerprint() string {
+	sum := sha256.Sum256([]byte(m.Sender + "\x00" + m.Receiver + "\x00" + m.Body))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateIdempotencyKey checks a key given in the Idempotency-Key header.
+func ValidateIdempotencyKey(key string) utils.ChatErr {
+	if len(key) > MaxIdempotencyKeyLength {
+		return utils.ErrorKind(utils.BadRequestError, fmt.Sprintf("Idempotency-Key should be at most %d characters", MaxIdempotencyKeyLength))
+	}
+	for _, c := range key {
+		if c < '!' || c > '~' {
+			return utils.ErrorKind(utils.BadRequestError, "Idempotency-Key should only hold printable ASCII characters")
+		}
+	}
+	return nil
+}
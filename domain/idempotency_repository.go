@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"time"
+)
+
+const (
+	queryInsertIdempotencyKey = `INSERT INTO idempotency_keys(tenant_id, idempotency_key, fingerprint, chat_id, response, created_at) VALUES (?,?,?,?,?,?);`
+	queryDeleteExpiredKey     = `DELETE FROM idempotency_keys WHERE tenant_id=? AND idempotency_key=? AND created_at < ?;`
+	queryGetIdempotencyKey    = `SELECT idempotency_key, fingerprint, response, created_at FROM idempotency_keys WHERE tenant_id=? AND idempotency_key=? AND created_at >= ?;`
+	//expired keys are purged for every tenant at once
+	queryPurgeIdempotencyKeys = `DELETE FROM idempotency_keys WHERE created_at < ?;`
+)
+
+// CreateWithKey creates a chat unless key is already stored, in which case
+// it fails with a ConflictError and nothing is created. Concurrent calls
+// with the same key wait for each other on the primary key.
+func (m *chatRepo) CreateWithKey(ctx context.Context, msg *Chat, key *IdempotencyKey) (*Chat, ChatErr) {
+	return m.create(ctx, msg, key)
+}
+
+// storeIdempotencyKey saves key with created as its response, replacing the
+// row of an expired use of the same key.
+func (m *chatRepo) storeIdempotencyKey(ctx context.Context, tx *sql.Tx, key *IdempotencyKey, created *Chat) ChatErr {
+	response, err := json.Marshal(created)
+	if err != nil {
+		return ErrorKind(InternalServerError, fmt.Sprintf("error when trying to encode idempotent response: %s", err.Error()))
+	}
+	now := time.Now()
+	tenant := TenantFromContext(ctx)
+	if _, err := tx.ExecContext(ctx, m.rebind(queryDeleteExpiredKey), tenant, key.Key, now.Add(-IdempotencyKeyTTL)); err != nil {
+		return parseError(ctx, err)
+	}
+	if _, err := tx.ExecContext(ctx, m.rebind(queryInsertIdempotencyKey), tenant, key.Key, key.Fingerprint, created.Id, string(response), now); err != nil {
+		return parseError(ctx, err)
+	}
+	return nil
+}
+
+// GetIdempotencyKey returns a key stored less than IdempotencyKeyTTL ago.
+func (m *chatRepo) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var stored IdempotencyKey
+	var response string
+	row := m.db.QueryRowContext(ctx, m.rebind(queryGetIdempotencyKey), TenantFromContext(ctx), key, time.Now().Add(-IdempotencyKeyTTL))
+	if err := row.Scan(&stored.Key, &stored.Fingerprint, &response, &stored.CreatedAt); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	if err := json.Unmarshal([]byte(response), &stored.Chat); err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to decode idempotent response: %s", err.Error()))
+	}
+	return &stored, nil
+}
+
+func (m *chatRepo) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, m.rebind(queryPurgeIdempotencyKeys), before)
+	if err != nil {
+		return 0, parseError(ctx, err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to count purged idempotency keys: %s", err.Error()))
+	}
+	return purged, nil
+}
@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"context"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"time"
+)
+
+func (m *memoryChatRepo) CreateWithKey(ctx context.Context, msg *Chat, key *IdempotencyKey) (*Chat, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	scoped := memoryKey{tenant: TenantFromContext(ctx), key: key.Key}
+	if stored, ok := m.keys[scoped]; ok && !stored.CreatedAt.Before(now.Add(-IdempotencyKeyTTL)) {
+		return nil, ErrorKind(ConflictError, "record already exists")
+	}
+	created := m.create(ctx, msg)
+	m.keys[scoped] = IdempotencyKey{Key: key.Key, Fingerprint: key.Fingerprint, Chat: *created, CreatedAt: now}
+	return created, nil
+}
+
+func (m *memoryChatRepo) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.keys[memoryKey{tenant: TenantFromContext(ctx), key: key}]
+	if !ok || stored.CreatedAt.Before(time.Now().Add(-IdempotencyKeyTTL)) {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	return &stored, nil
+}
+
+func (m *memoryChatRepo) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for key, stored := range m.keys {
+		if stored.CreatedAt.Before(before) {
+			delete(m.keys, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
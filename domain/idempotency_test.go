@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestChatRepo_CreateWithKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s := NewChatRepository(db)
+	chat := &Chat{Sender: sender, Receiver: receiver, Body: body, CreatedAt: createdAt}
+	key := &IdempotencyKey{Key: "retry-1", Fingerprint: chat.Fingerprint()}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO chats").WithArgs(DefaultTenant, sender, receiver, body, createdAt, "", "").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectEvent(mock, ChatCreatedEvent, 1, nil)
+	mock.ExpectExec("DELETE FROM idempotency_keys WHERE tenant_id=\\? AND idempotency_key=\\? AND created_at < \\?;").WithArgs(DefaultTenant, "retry-1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO idempotency_keys").WithArgs(DefaultTenant, "retry-1", key.Fingerprint, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	created, chatErr := s.CreateWithKey(context.Background(), chat, key)
+	assert.Nil(t, chatErr)
+	assert.EqualValues(t, 1, created.Id)
+
+	//another request stored the key first, the chat is rolled back
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO chats").WillReturnResult(sqlmock.NewResult(2, 1))
+	expectEvent(mock, ChatCreatedEvent, 2, nil)
+	mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mock.ExpectRollback()
+
+	_, chatErr = s.CreateWithKey(context.Background(), &Chat{Sender: sender, Receiver: receiver, Body: body, CreatedAt: createdAt}, key)
+	if assert.NotNil(t, chatErr) {
+		assert.EqualValues(t, http.StatusConflict, chatErr.Status())
+	}
+
+	mock.ExpectQuery("SELECT idempotency_key, fingerprint, response, created_at FROM idempotency_keys WHERE tenant_id=\\? AND idempotency_key=\\? AND created_at >= \\?;").
+		WithArgs(DefaultTenant, "retry-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"idempotency_key", "fingerprint", "response", "created_at"}).
+			AddRow("retry-1", key.Fingerprint, `{"id":1,"sender":"+6282323231","body":"hello","version":1}`, createdAt))
+	stored, chatErr := s.GetIdempotencyKey(context.Background(), "retry-1")
+	assert.Nil(t, chatErr)
+	assert.EqualValues(t, 1, stored.Chat.Id)
+	assert.EqualValues(t, key.Fingerprint, stored.Fingerprint)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestValidateIdempotencyKey(t *testing.T) {
+	assert.Nil(t, ValidateIdempotencyKey("4f7c1d2e-retry"))
+	assert.NotNil(t, ValidateIdempotencyKey("with space"))
+	assert.NotNil(t, ValidateIdempotencyKey("é"))
+	long := make([]byte, MaxIdempotencyKeyLength+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	assert.NotNil(t, ValidateIdempotencyKey(string(long)))
+}
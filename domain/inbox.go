@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// InboxPreviewLength is how many characters of the last chat an inbox
+	// entry shows.
+	InboxPreviewLength = 100
+
+	// sortInbox ties the page cursors of an inbox to it.
+	sortInbox = "inbox"
+)
+
+// InboxEntry sums up the conversation of the owner of an inbox with Peer:
+// their last chat, when it was sent and how many chats of Peer the owner
+// didn't read.
+type InboxEntry struct {
+	Peer         string    `json:"peer"`
+	LastChatId   int64     `json:"last_chat_id"`
+	LastSender   string    `json:"last_sender"`
+	Preview      string    `json:"preview"`
+	LastActivity time.Time `json:"last_activity"`
+	Unread       int64     `json:"unread"`
+}
+
+// InboxPage is a page of an inbox, the conversation active last first.
+type InboxPage struct {
+	Entries    []InboxEntry `json:"data"`
+	NextCursor string       `json:"next_cursor"`
+	HasMore    bool         `json:"has_more"`
+}
+
+// preview shortens body to InboxPreviewLength characters.
+func preview(body string) string {
+	if utf8.RuneCountInString(body) <= InboxPreviewLength {
+		return body
+	}
+	cut := 0
+	for i := 0; i < InboxPreviewLength; i++ {
+		_, size := utf8.DecodeRuneInString(body[cut:])
+		cut += size
+	}
+	return body[:cut] + "…"
+}
+
+// newInboxPage trims the extra entry fetched to detect whether another page
+// exists. Entries are ordered by their last chat, so its position is the
+// cursor, as for a chat listing.
+func newInboxPage(phone string, entries []InboxEntry, limit int) *InboxPage {
+	page := &InboxPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.HasMore = true
+	}
+	if page.HasMore {
+		last := page.Entries[len(page.Entries)-1]
+		page.NextCursor = encodeCursor(Chat{Id: last.LastChatId, CreatedAt: last.LastActivity}, sortInbox, inboxFingerprint(phone))
+	}
+	return page
+}
+
+// inboxFingerprint ties an inbox cursor to the phone it was issued for, so
+// paging someone else's inbox with it is refused. An inbox holds the chats
+// the phone takes part in, which is what a participant filter lists.
+func inboxFingerprint(phone string) string {
+	return ChatFilter{Participant: phone}.fingerprint()
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"strings"
+)
+
+// queryInbox ranks the chats phone sent and received by peer and keeps the
+// last of each conversation. The two halves are read from the covering
+// idx_chats_sender_conversation and idx_chats_receiver_conversation indexes
+// alone, but the ranking still goes over every chat phone ever exchanged:
+// its cost grows with the history of phone, not with the page size. The
+// start of the bodies is read afterwards for the page of conversations
+// kept. Window functions need MySQL 8 or later.
+var queryInbox = fmt.Sprintf(`SELECT latest.peer, latest.id, latest.sender, SUBSTRING(chats.body FROM 1 FOR %[1]d) AS preview, latest.created_at, latest.unread FROM (`+
+	`SELECT peer, id, sender, created_at, unread FROM (`+
+	`SELECT peer, id, sender, created_at, `+
+	`ROW_NUMBER() OVER (PARTITION BY peer ORDER BY created_at DESC, id DESC) AS position, `+
+	`SUM(unseen) OVER (PARTITION BY peer) AS unread FROM (`+
+	`SELECT receiver AS peer, id, sender, created_at, 0 AS unseen `+
+	`FROM chats WHERE tenant_id = ? AND sender = ? AND deleted_at IS NULL `+
+	`UNION ALL `+
+	`SELECT sender AS peer, id, sender, created_at, CASE WHEN read_at IS NULL THEN 1 ELSE 0 END AS unseen `+
+	`FROM chats WHERE tenant_id = ? AND receiver = ? AND deleted_at IS NULL`+
+	`) AS exchanged) AS ranked WHERE %%s ORDER BY created_at DESC, id DESC LIMIT ?`+
+	`) AS latest JOIN chats ON chats.id = latest.id ORDER BY latest.created_at DESC, latest.id DESC;`, InboxPreviewLength+1)
+
+func (m *chatRepo) Inbox(ctx context.Context, phone string, page PageRequest) (*InboxPage, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := page.Validate(); err != nil {
+		return nil, err
+	}
+	if err := page.checkCursor(sortInbox, inboxFingerprint(phone)); err != nil {
+		return nil, err
+	}
+
+	query, args := buildInboxQuery(TenantFromContext(ctx), phone, page)
+	rows, err := m.db.QueryContext(ctx, m.rebind(query), args...)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	entries := make([]InboxEntry, 0)
+	for rows.Next() {
+		var entry InboxEntry
+		if scanErr := rows.Scan(&entry.Peer, &entry.LastChatId, &entry.LastSender, &entry.Preview, &entry.LastActivity, &entry.Unread); scanErr != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get inbox: %s", scanErr.Error()))
+		}
+		entry.Preview = preview(entry.Preview)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return newInboxPage(phone, entries, page.Limit), nil
+}
+
+// buildInboxQuery fills queryInbox in for the inbox of phone and the page.
+func buildInboxQuery(tenant, phone string, page PageRequest) (string, []interface{}) {
+	where := []string{"position = 1"}
+	args := []interface{}{tenant, phone, tenant, phone}
+	if page.after != nil {
+		where = append(where, "(created_at < ? OR (created_at = ? AND id < ?))")
+		args = append(args, page.after.CreatedAt, page.after.CreatedAt, page.after.Id)
+	}
+	args = append(args, page.Limit+1)
+	return fmt.Sprintf(queryInbox, strings.Join(where, " AND ")), args
+}
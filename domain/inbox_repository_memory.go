@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"context"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"sort"
+)
+
+func (m *memoryChatRepo) Inbox(ctx context.Context, phone string, page PageRequest) (*InboxPage, ChatErr) {
+	if err := page.Validate(); err != nil {
+		return nil, err
+	}
+	if err := page.checkCursor(sortInbox, inboxFingerprint(phone)); err != nil {
+		return nil, err
+	}
+
+	tenant := TenantFromContext(ctx)
+	last := make(map[string]Chat)
+	unread := make(map[string]int64)
+	m.mu.RLock()
+	for _, msg := range m.chats {
+		if msg.Tenant != tenant || msg.DeletedAt != nil || (msg.Sender != phone && msg.Receiver != phone) {
+			continue
+		}
+		peer := msg.Sender
+		if peer == phone {
+			peer = msg.Receiver
+		} else if msg.ReadAt == nil {
+			unread[peer]++
+		}
+		if current, ok := last[peer]; !ok || newer(msg, current) {
+			last[peer] = msg
+		}
+	}
+	m.mu.RUnlock()
+
+	entries := make([]InboxEntry, 0, len(last))
+	for peer, msg := range last {
+		if page.after != nil && !newer(Chat{Id: page.after.Id, CreatedAt: page.after.CreatedAt}, msg) {
+			continue
+		}
+		entries = append(entries, InboxEntry{
+			Peer:         peer,
+			LastChatId:   msg.Id,
+			LastSender:   msg.Sender,
+			Preview:      preview(msg.Body),
+			LastActivity: msg.CreatedAt,
+			Unread:       unread[peer],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return newer(Chat{Id: entries[i].LastChatId, CreatedAt: entries[i].LastActivity}, Chat{Id: entries[j].LastChatId, CreatedAt: entries[j].LastActivity})
+	})
+	if len(entries) > page.Limit+1 {
+		entries = entries[:page.Limit+1]
+	}
+	return newInboxPage(phone, entries, page.Limit), nil
+}
+
+// newer tells whether a was sent after b, the id settling chats sent at the
+// same time.
+func newer(a, b Chat) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.Id > b.Id
+}
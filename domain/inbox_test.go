@@ -0,0 +1,69 @@
+package domain
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestPreview(t *testing.T) {
+	assert.EqualValues(t, "hello", preview("hello"))
+	assert.EqualValues(t, strings.Repeat("é", InboxPreviewLength), preview(strings.Repeat("é", InboxPreviewLength)))
+	assert.EqualValues(t, strings.Repeat("é", InboxPreviewLength)+"…", preview(strings.Repeat("é", InboxPreviewLength+1)))
+}
+
+func TestChatRepo_Inbox(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := &chatRepo{db: db, driver: DriverPostgres}
+
+	rows := sqlmock.NewRows([]string{"peer", "id", "sender", "preview", "created_at", "unread"}).
+		AddRow(receiver, 9, sender, "see you", createdAt, 0).
+		AddRow("+6282323233", 4, "+6282323233", strings.Repeat("a", InboxPreviewLength+1), createdAt, 2)
+	mock.ExpectQuery("SELECT latest.peer, latest.id, latest.sender, SUBSTRING\\(chats.body FROM 1 FOR 101\\) AS preview, (.+) FROM \\(SELECT peer, id, sender, created_at, unread FROM \\((.+) WHERE tenant_id = \\$1 AND sender = \\$2 (.+) WHERE tenant_id = \\$3 AND receiver = \\$4 (.+) WHERE position = 1 ORDER BY created_at DESC, id DESC LIMIT \\$5\\) AS latest JOIN chats ON chats.id = latest.id ORDER BY latest.created_at DESC, latest.id DESC;").
+		WithArgs(DefaultTenant, sender, DefaultTenant, sender, 2).
+		WillReturnRows(rows)
+
+	//the ranking goes over the whole history, it shouldn't read the bodies
+	query, _ := buildInboxQuery(DefaultTenant, sender, PageRequest{Limit: 1})
+	ranking := query[strings.Index(query, " FROM ("):strings.Index(query, ") AS latest")]
+	assert.NotContains(t, ranking, "body")
+
+	page, inboxErr := s.Inbox(context.Background(), sender, PageRequest{Limit: 1})
+	assert.Nil(t, inboxErr)
+	if assert.Len(t, page.Entries, 1) {
+		assert.EqualValues(t, receiver, page.Entries[0].Peer)
+		assert.EqualValues(t, 9, page.Entries[0].LastChatId)
+		assert.EqualValues(t, "see you", page.Entries[0].Preview)
+	}
+	assert.True(t, page.HasMore)
+
+	//the next page starts after the last chat of the last entry
+	mock.ExpectQuery("WHERE position = 1 AND \\(created_at < \\$5 OR \\(created_at = \\$6 AND id < \\$7\\)\\) ORDER BY").
+		WithArgs(DefaultTenant, sender, DefaultTenant, sender, sqlmock.AnyArg(), sqlmock.AnyArg(), 9, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"peer", "id", "sender", "preview", "created_at", "unread"}))
+	page, inboxErr = s.Inbox(context.Background(), sender, PageRequest{Limit: 1, Cursor: page.NextCursor})
+	assert.Nil(t, inboxErr)
+	assert.Empty(t, page.Entries)
+	assert.False(t, page.HasMore)
+
+	//nor does the cursor of another phone's inbox
+	_, inboxErr = s.Inbox(context.Background(), receiver, PageRequest{Cursor: encodeCursor(Chat{Id: 9, CreatedAt: createdAt}, sortInbox, inboxFingerprint(sender))})
+	if assert.NotNil(t, inboxErr) {
+		assert.EqualValues(t, "cursor does not match the filters", inboxErr.Message())
+	}
+
+	//a cursor of a chat listing doesn't page an inbox
+	_, inboxErr = s.Inbox(context.Background(), sender, PageRequest{Cursor: encodeCursor(Chat{Id: 1, CreatedAt: createdAt}, SortCreatedAt, "")})
+	if assert.NotNil(t, inboxErr) {
+		assert.EqualValues(t, "cursor does not match sort", inboxErr.Message())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/utils"
+	"time"
+)
+
+// Chat lifecycle events, recorded in the outbox in the same transaction as
+// the change they describe.
+const (
+	ChatCreatedEvent  = "created"
+	ChatUpdatedEvent  = "updated"
+	ChatDeletedEvent  = "deleted"
+	ChatRestoredEvent = "restored"
+	ChatReadEvent     = "read"
+)
+
+// OutboxEvent is a recorded chat event waiting to be delivered. Chat is the
+// state of the chat right after the change.
+type OutboxEvent struct {
+	Id        int64
+	Type      string
+	Chat      Chat
+	CreatedAt time.Time
+	Attempts  int
+}
+
+type outboxRepoInterface interface {
+	// Pending lists the undelivered events due at now that were tried
+	// fewer than maxAttempts times, oldest first.
+	Pending(ctx context.Context, now time.Time, maxAttempts, limit int) ([]OutboxEvent, utils.ChatErr)
+	MarkDelivered(ctx context.Context, Id int64, deliveredAt time.Time) utils.ChatErr
+	// MarkFailed counts a failed attempt and postpones the event to retryAt.
+	MarkFailed(ctx context.Context, Id int64, reason string, retryAt time.Time) utils.ChatErr
+	PurgeDelivered(ctx context.Context, deliveredBefore time.Time) (int64, utils.ChatErr)
+}
+
+// OutboxRepo is set next to ChatRepo, from the same storage.
+var OutboxRepo outboxRepoInterface
+
+// NewOutboxRepository returns the outbox stored alongside chats, it has to
+// share their storage to be written in the same transaction.
+func NewOutboxRepository(chats chatRepoInterface) (outboxRepoInterface, error) {
+	switch repo := chats.(type) {
+	case *chatRepo:
+		return &outboxRepo{chats: repo}, nil
+	case *memoryChatRepo:
+		return &memoryOutboxRepo{chats: repo}, nil
+	}
+	return nil, fmt.Errorf("no outbox for chat repository %T", chats)
+}
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"time"
+)
+
+const (
+	queryInsertOutbox    = `INSERT INTO outbox(event_type, chat_id, payload, created_at, available_at) VALUES (?,?,?,?,?);`
+	queryPendingOutbox   = `SELECT id, event_type, payload, created_at, attempts FROM outbox WHERE delivered_at IS NULL AND attempts < ? AND available_at <= ? ORDER BY id LIMIT ?;`
+	queryDeliveredOutbox = `UPDATE outbox SET delivered_at=? WHERE id=?;`
+	queryFailedOutbox    = `UPDATE outbox SET attempts=attempts+1, last_error=?, available_at=? WHERE id=?;`
+	queryPurgeOutbox     = `DELETE FROM outbox WHERE delivered_at IS NOT NULL AND delivered_at < ?;`
+
+	// queryGetChatState reads a chat whatever its state, to describe it in
+	// an event.
+	queryGetChatState = `SELECT ` + chatColumns + ` FROM chats WHERE id=? AND tenant_id=?;`
+)
+
+// recordEvent reads back the chat changed within tx and stores the event
+// describing it, so the event is committed or rolled back with the change.
+func (m *chatRepo) recordEvent(ctx context.Context, tx *sql.Tx, eventType string, chatId int64) (*Chat, ChatErr) {
+	var msg Chat
+	tenant := TenantFromContext(ctx)
+	if err := scanChat(tx.QueryRowContext(ctx, m.rebind(queryGetChatState), chatId, tenant), &msg); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	msg.Tenant = tenant
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to encode chat event: %s", err.Error()))
+	}
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, m.rebind(queryInsertOutbox), eventType, chatId, string(payload), now, now); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return &msg, nil
+}
+
+// outboxRepo reads the events recorded by chatRepo.
+type outboxRepo struct {
+	chats *chatRepo
+}
+
+func (o *outboxRepo) Pending(ctx context.Context, now time.Time, maxAttempts, limit int) ([]OutboxEvent, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := o.chats.db.QueryContext(ctx, o.chats.rebind(queryPendingOutbox), maxAttempts, now, limit)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	results := make([]OutboxEvent, 0)
+	for rows.Next() {
+		var event OutboxEvent
+		var payload string
+		if err := rows.Scan(&event.Id, &event.Type, &payload, &event.CreatedAt, &event.Attempts); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get outbox event: %s", err.Error()))
+		}
+		if err := json.Unmarshal([]byte(payload), &event.Chat); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to decode outbox event %d: %s", event.Id, err.Error()))
+		}
+		results = append(results, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return results, nil
+}
+
+func (o *outboxRepo) MarkDelivered(ctx context.Context, eventId int64, deliveredAt time.Time) ChatErr {
+	return o.exec(ctx, queryDeliveredOutbox, deliveredAt, eventId)
+}
+
+func (o *outboxRepo) MarkFailed(ctx context.Context, eventId int64, reason string, retryAt time.Time) ChatErr {
+	return o.exec(ctx, queryFailedOutbox, reason, retryAt, eventId)
+}
+
+func (o *outboxRepo) PurgeDelivered(ctx context.Context, deliveredBefore time.Time) (int64, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := o.chats.db.ExecContext(ctx, o.chats.rebind(queryPurgeOutbox), deliveredBefore)
+	if err != nil {
+		return 0, parseError(ctx, err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to count purged outbox events: %s", err.Error()))
+	}
+	return purged, nil
+}
+
+func (o *outboxRepo) exec(ctx context.Context, query string, args ...interface{}) ChatErr {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := o.chats.db.ExecContext(ctx, o.chats.rebind(query), args...)
+	if err != nil {
+		return parseError(ctx, err)
+	}
+	return checkAffected(result)
+}
@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"context"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"time"
+)
+
+type memoryOutboxEntry struct {
+	event       OutboxEvent
+	availableAt time.Time
+	deliveredAt *time.Time
+	lastError   string
+}
+
+// recordEvent expects m.mu to be held for writing, like the change it
+// describes.
+func (m *memoryChatRepo) recordEvent(eventType string, msg Chat) {
+	m.outboxId++
+	now := time.Now()
+	m.outbox = append(m.outbox, memoryOutboxEntry{
+		event:       OutboxEvent{Id: m.outboxId, Type: eventType, Chat: msg, CreatedAt: now},
+		availableAt: now,
+	})
+}
+
+// memoryOutboxRepo reads the events recorded by memoryChatRepo.
+type memoryOutboxRepo struct {
+	chats *memoryChatRepo
+}
+
+func (o *memoryOutboxRepo) Pending(ctx context.Context, now time.Time, maxAttempts, limit int) ([]OutboxEvent, ChatErr) {
+	o.chats.mu.RLock()
+	defer o.chats.mu.RUnlock()
+
+	results := make([]OutboxEvent, 0)
+	for _, entry := range o.chats.outbox {
+		if len(results) == limit {
+			break
+		}
+		if entry.deliveredAt == nil && entry.event.Attempts < maxAttempts && !entry.availableAt.After(now) {
+			results = append(results, entry.event)
+		}
+	}
+	return results, nil
+}
+
+func (o *memoryOutboxRepo) MarkDelivered(ctx context.Context, eventId int64, deliveredAt time.Time) ChatErr {
+	return o.update(eventId, func(entry *memoryOutboxEntry) {
+		entry.deliveredAt = &deliveredAt
+	})
+}
+
+func (o *memoryOutboxRepo) MarkFailed(ctx context.Context, eventId int64, reason string, retryAt time.Time) ChatErr {
+	return o.update(eventId, func(entry *memoryOutboxEntry) {
+		entry.event.Attempts++
+		entry.lastError = reason
+		entry.availableAt = retryAt
+	})
+}
+
+func (o *memoryOutboxRepo) PurgeDelivered(ctx context.Context, deliveredBefore time.Time) (int64, ChatErr) {
+	o.chats.mu.Lock()
+	defer o.chats.mu.Unlock()
+
+	kept := o.chats.outbox[:0]
+	for _, entry := range o.chats.outbox {
+		if entry.deliveredAt == nil || !entry.deliveredAt.Before(deliveredBefore) {
+			kept = append(kept, entry)
+		}
+	}
+	purged := int64(len(o.chats.outbox) - len(kept))
+	o.chats.outbox = kept
+	return purged, nil
+}
+
+func (o *memoryOutboxRepo) update(eventId int64, change func(entry *memoryOutboxEntry)) ChatErr {
+	o.chats.mu.Lock()
+	defer o.chats.mu.Unlock()
+
+	for i := range o.chats.outbox {
+		if o.chats.outbox[i].event.Id == eventId {
+			change(&o.chats.outbox[i])
+			return nil
+		}
+	}
+	return ErrorKind(NotFoundError, "no record matching given id")
+}
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMemoryOutboxRepo(t *testing.T) {
+	chats := NewMemoryChatRepository()
+	outbox, err := NewOutboxRepository(chats)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	chat, chatErr := chats.Create(ctx, &Chat{Sender: sender, Receiver: receiver, Body: body})
+	assert.Nil(t, chatErr)
+	_, chatErr = chats.Update(ctx, &Chat{Id: chat.Id, Body: body})
+	assert.Nil(t, chatErr)
+	_, chatErr = chats.Update(ctx, &Chat{Id: chat.Id, Body: "edited"})
+	assert.Nil(t, chatErr)
+	assert.Nil(t, chats.Delete(ctx, chat.Id, 0))
+	_, chatErr = chats.Restore(ctx, chat.Id)
+	assert.Nil(t, chatErr)
+
+	//the unchanged body recorded nothing
+	pending, chatErr := outbox.Pending(ctx, time.Now(), 3, 10)
+	assert.Nil(t, chatErr)
+	if assert.Len(t, pending, 4) {
+		assert.EqualValues(t, ChatCreatedEvent, pending[0].Type)
+		assert.EqualValues(t, ChatUpdatedEvent, pending[1].Type)
+		assert.EqualValues(t, "edited", pending[1].Chat.Body)
+		assert.EqualValues(t, ChatDeletedEvent, pending[2].Type)
+		assert.NotNil(t, pending[2].Chat.DeletedAt)
+		assert.EqualValues(t, ChatRestoredEvent, pending[3].Type)
+	}
+
+	now := time.Now()
+	assert.Nil(t, outbox.MarkDelivered(ctx, pending[0].Id, now))
+	assert.Nil(t, outbox.MarkFailed(ctx, pending[1].Id, "boom", now.Add(time.Minute)))
+
+	pending, chatErr = outbox.Pending(ctx, now, 3, 1)
+	assert.Nil(t, chatErr)
+	if assert.Len(t, pending, 1) {
+		assert.EqualValues(t, 3, pending[0].Id)
+	}
+
+	//due again once retryAt is reached, until it runs out of attempts
+	pending, _ = outbox.Pending(ctx, now.Add(time.Minute), 3, 10)
+	assert.Len(t, pending, 3)
+	pending, _ = outbox.Pending(ctx, now.Add(time.Minute), 1, 10)
+	assert.Len(t, pending, 2)
+
+	purged, chatErr := outbox.PurgeDelivered(ctx, now.Add(time.Second))
+	assert.Nil(t, chatErr)
+	assert.EqualValues(t, 1, purged)
+
+	assert.NotNil(t, outbox.MarkDelivered(ctx, 100, now))
+}
+
+func TestOutboxRepo_Pending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	outbox, err := NewOutboxRepository(NewChatRepository(db))
+	assert.Nil(t, err)
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT id, event_type, payload, created_at, attempts FROM outbox WHERE delivered_at IS NULL AND attempts < \\? AND available_at <= \\? ORDER BY id LIMIT \\?;").
+		WithArgs(10, now, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "created_at", "attempts"}).
+			AddRow(1, ChatCreatedEvent, `{"id":7,"sender":"+6282323231","body":"hello","version":1}`, createdAt, 0).
+			AddRow(2, ChatCreatedEvent, `not json`, createdAt, 0))
+
+	_, chatErr := outbox.Pending(context.Background(), now, 10, 100)
+	if assert.NotNil(t, chatErr) {
+		assert.Contains(t, chatErr.Message(), "decode outbox event 2")
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM outbox").
+		WithArgs(10, now, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "created_at", "attempts"}).
+			AddRow(1, ChatCreatedEvent, `{"id":7,"sender":"+6282323231","body":"hello","version":1}`, createdAt, 2))
+
+	pending, chatErr := outbox.Pending(context.Background(), now, 10, 100)
+	assert.Nil(t, chatErr)
+	if assert.Len(t, pending, 1) {
+		assert.EqualValues(t, 7, pending[0].Chat.Id)
+		assert.EqualValues(t, "hello", pending[0].Chat.Body)
+		assert.EqualValues(t, 2, pending[0].Attempts)
+	}
+
+	mock.ExpectExec("UPDATE outbox SET attempts=attempts\\+1, last_error=\\?, available_at=\\? WHERE id=\\?;").
+		WithArgs("boom", now, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Nil(t, outbox.MarkFailed(context.Background(), 1, "boom", now))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
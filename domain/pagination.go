@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/SemmiDev/lets-tests/utils"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// PageRequest describes which slice of the chat list the caller wants. A
+// zero Limit, no limit given, means DefaultPageLimit. Cursor is the opaque
+// next_cursor value returned with the previous page.
+type PageRequest struct {
+	Limit  int
+	Cursor string
+
+	after *pageCursor
+}
+
+// ChatPage is the envelope returned by every paginated chat listing.
+type ChatPage struct {
+	Chats      []Chat `json:"data"`
+	NextCursor string `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// pageCursor is the position of the last chat of a page. Chats are ordered
+// by created_at and then by id, so both are needed to resume reliably. The
+// sort and a fingerprint of the filters are kept so a cursor can't be
+// replayed against a different ordering or other filters.
+type pageCursor struct {
+	Id        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Sort      string    `json:"sort"`
+	Filter    string    `json:"filter,omitempty"`
+}
+
+func (p *PageRequest) Validate() utils.ChatErr {
+	if p.Limit == 0 {
+		p.Limit = DefaultPageLimit
+	}
+	if p.Limit < 0 || p.Limit > MaxPageLimit {
+		return utils.ErrorKind(utils.BadRequestError, "limit should be between 1 and 100")
+	}
+
+	p.after = nil
+	if p.Cursor == "" {
+		return nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return utils.ErrorKind(utils.BadRequestError, "invalid cursor")
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Id <= 0 {
+		return utils.ErrorKind(utils.BadRequestError, "invalid cursor")
+	}
+	p.after = &cursor
+	return nil
+}
+
+// checkCursor refuses a cursor issued for another sort or other filters,
+// filter being their fingerprint.
+func (p *PageRequest) checkCursor(sort, filter string) utils.ChatErr {
+	if p.after == nil {
+		return nil
+	}
+	if p.after.Sort != sort {
+		return utils.ErrorKind(utils.BadRequestError, "cursor does not match sort")
+	}
+	if p.after.Filter != filter {
+		return utils.ErrorKind(utils.BadRequestError, "cursor does not match the filters")
+	}
+	return nil
+}
+
+func encodeCursor(chat Chat, sort, filter string) string {
+	raw, _ := json.Marshal(pageCursor{Id: chat.Id, CreatedAt: chat.CreatedAt, Sort: sort, Filter: filter})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// newChatPage trims the extra row fetched to detect whether another page
+// exists and derives the cursor pointing at the last returned chat.
+func newChatPage(chats []Chat, limit int, filter ChatFilter) *ChatPage {
+	page := &ChatPage{Chats: chats}
+	if len(chats) > limit {
+		page.Chats = chats[:limit]
+		page.HasMore = true
+	}
+	if page.HasMore {
+		page.NextCursor = encodeCursor(page.Chats[len(page.Chats)-1], filter.Sort, filter.fingerprint())
+	}
+	return page
+}
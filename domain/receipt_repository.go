@@ -0,0 +1,140 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"strings"
+	"time"
+)
+
+const (
+	// Receipts don't bump the version, the sender can still edit a chat
+	// that was read meanwhile.
+	queryMarkRead            = `UPDATE chats SET read_at=?, delivered_at=COALESCE(delivered_at, ?) WHERE id=? AND tenant_id=? AND deleted_at IS NULL AND read_at IS NULL;`
+	queryGetConversationChat = `SELECT id FROM chats WHERE id=? AND tenant_id=? AND ((sender=? AND receiver=?) OR (sender=? AND receiver=?));`
+	queryLockUnreadChats     = `SELECT id FROM chats WHERE tenant_id=? AND receiver=? AND sender=? AND id<=? AND deleted_at IS NULL AND read_at IS NULL ORDER BY id FOR UPDATE;`
+	queryMarkDelivered       = `UPDATE chats SET delivered_at=? WHERE tenant_id=? AND receiver=? AND delivered_at IS NULL AND id IN (%s);`
+	queryGetUnreadCounts     = `SELECT sender, COUNT(*) FROM chats WHERE tenant_id=? AND receiver=? AND deleted_at IS NULL AND read_at IS NULL GROUP BY sender ORDER BY sender;`
+)
+
+func (m *chatRepo) MarkRead(ctx context.Context, msgId int64, readAt time.Time) (*Chat, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to read chat: %s", err.Error()))
+	}
+	defer tx.Rollback()
+
+	readResult, err := tx.ExecContext(ctx, m.rebind(queryMarkRead), readAt, readAt, msgId, TenantFromContext(ctx))
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	if checkAffected(readResult) != nil {
+		//read before, or missing
+		tx.Rollback()
+		return m.Get(ctx, msgId)
+	}
+
+	read, recordErr := m.recordEvent(ctx, tx, ChatReadEvent, msgId)
+	if recordErr != nil {
+		return nil, recordErr
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to read chat: %s", err.Error()))
+	}
+	return read, nil
+}
+
+func (m *chatRepo) MarkConversationRead(ctx context.Context, receiver, sender string, upTo int64, readAt time.Time) (int64, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to read conversation: %s", err.Error()))
+	}
+	defer tx.Rollback()
+
+	tenant := TenantFromContext(ctx)
+	var found int64
+	row := tx.QueryRowContext(ctx, m.rebind(queryGetConversationChat), upTo, tenant, sender, receiver, receiver, sender)
+	if err := row.Scan(&found); err != nil {
+		return 0, parseError(ctx, err)
+	}
+	rows, err := tx.QueryContext(ctx, m.rebind(queryLockUnreadChats), tenant, receiver, sender, upTo)
+	if err != nil {
+		return 0, parseError(ctx, err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if scanErr := rows.Scan(&id); scanErr != nil {
+			rows.Close()
+			return 0, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get unread chat: %s", scanErr.Error()))
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, parseError(ctx, err)
+	}
+
+	//each chat gets its own event, like when read one by one
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, m.rebind(queryMarkRead), readAt, readAt, id, tenant); err != nil {
+			return 0, parseError(ctx, err)
+		}
+		if _, recordErr := m.recordEvent(ctx, tx, ChatReadEvent, id); recordErr != nil {
+			return 0, recordErr
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to read conversation: %s", err.Error()))
+	}
+	return int64(len(ids)), nil
+}
+
+func (m *chatRepo) MarkDelivered(ctx context.Context, receiver string, ids []int64, deliveredAt time.Time) ChatErr {
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	args := []interface{}{deliveredAt, TenantFromContext(ctx), receiver}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := fmt.Sprintf(queryMarkDelivered, strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","))
+	if _, err := m.db.ExecContext(ctx, m.rebind(query), args...); err != nil {
+		return parseError(ctx, err)
+	}
+	return nil
+}
+
+func (m *chatRepo) UnreadCounts(ctx context.Context, receiver string) ([]UnreadCount, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, m.rebind(queryGetUnreadCounts), TenantFromContext(ctx), receiver)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	counts := make([]UnreadCount, 0)
+	for rows.Next() {
+		var count UnreadCount
+		if scanErr := rows.Scan(&count.Peer, &count.Unread); scanErr != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to count unread chats: %s", scanErr.Error()))
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return counts, nil
+}
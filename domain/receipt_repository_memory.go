@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"context"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"sort"
+	"time"
+)
+
+func (m *memoryChatRepo) MarkRead(ctx context.Context, msgId int64, readAt time.Time) (*Chat, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.lookup(ctx, msgId)
+	if !ok || current.DeletedAt != nil {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	if current.ReadAt == nil {
+		current = m.read(current, readAt)
+	}
+	return &current, nil
+}
+
+// read marks msg read at readAt, it expects m.mu to be held for writing.
+func (m *memoryChatRepo) read(msg Chat, readAt time.Time) Chat {
+	msg.ReadAt = &readAt
+	if msg.DeliveredAt == nil {
+		msg.DeliveredAt = &readAt
+	}
+	m.chats[msg.Id] = msg
+	m.recordEvent(ChatReadEvent, msg)
+	return msg
+}
+
+func (m *memoryChatRepo) MarkConversationRead(ctx context.Context, receiver, sender string, upTo int64, readAt time.Time) (int64, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	last, ok := m.lookup(ctx, upTo)
+	if !ok || !((last.Sender == sender && last.Receiver == receiver) || (last.Sender == receiver && last.Receiver == sender)) {
+		return 0, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	tenant := TenantFromContext(ctx)
+	var unread []Chat
+	for _, msg := range m.chats {
+		if msg.Tenant == tenant && msg.Receiver == receiver && msg.Sender == sender && msg.Id <= upTo && msg.DeletedAt == nil && msg.ReadAt == nil {
+			unread = append(unread, msg)
+		}
+	}
+	//events come in the order of the chats, as from the database
+	sort.Slice(unread, func(i, j int) bool { return unread[i].Id < unread[j].Id })
+	for _, msg := range unread {
+		m.read(msg, readAt)
+	}
+	return int64(len(unread)), nil
+}
+
+func (m *memoryChatRepo) MarkDelivered(ctx context.Context, receiver string, ids []int64, deliveredAt time.Time) ChatErr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		msg, ok := m.lookup(ctx, id)
+		if ok && msg.Receiver == receiver && msg.DeliveredAt == nil {
+			msg.DeliveredAt = &deliveredAt
+			m.chats[id] = msg
+		}
+	}
+	return nil
+}
+
+func (m *memoryChatRepo) UnreadCounts(ctx context.Context, receiver string) ([]UnreadCount, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tenant := TenantFromContext(ctx)
+	unread := make(map[string]int64)
+	for _, msg := range m.chats {
+		if msg.Tenant == tenant && msg.Receiver == receiver && msg.DeletedAt == nil && msg.ReadAt == nil {
+			unread[msg.Sender]++
+		}
+	}
+	counts := make([]UnreadCount, 0, len(unread))
+	for peer, count := range unread {
+		counts = append(counts, UnreadCount{Peer: peer, Unread: count})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Peer < counts[j].Peer })
+	return counts, nil
+}
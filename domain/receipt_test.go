@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChatRepo_MarkRead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+	readAt := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE chats SET read_at=\\?, delivered_at=COALESCE\\(delivered_at, \\?\\) WHERE id=\\? AND tenant_id=\\? AND deleted_at IS NULL AND read_at IS NULL").
+		WithArgs(readAt, readAt, 1, DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEvent(mock, ChatReadEvent, 1, sqlmock.NewRows(chatRowColumns).AddRow(1, sender, receiver, body, createdAt, nil, nil, 0, 1, "", "", readAt, readAt))
+	mock.ExpectCommit()
+
+	read, readErr := s.MarkRead(context.Background(), 1, readAt)
+	assert.Nil(t, readErr)
+	if assert.NotNil(t, read.ReadAt) {
+		assert.True(t, readAt.Equal(*read.ReadAt))
+	}
+
+	//a chat read before is returned as it is, without another event
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE chats SET read_at=").WithArgs(readAt, readAt, 2, DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectPrepare("SELECT (.+) FROM chats WHERE id=\\? AND tenant_id=\\? AND deleted_at IS NULL").ExpectQuery().WithArgs(2, DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(chatRowColumns).AddRow(2, sender, receiver, body, createdAt, nil, nil, 0, 1, "", "", createdAt, createdAt))
+	read, readErr = s.MarkRead(context.Background(), 2, readAt)
+	assert.Nil(t, readErr)
+	if assert.NotNil(t, read.ReadAt) {
+		assert.True(t, createdAt.Equal(*read.ReadAt))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChatRepo_MarkConversationRead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+	readAt := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM chats WHERE id=\\? AND tenant_id=\\? AND \\(\\(sender=\\? AND receiver=\\?\\) OR \\(sender=\\? AND receiver=\\?\\)\\)").
+		WithArgs(5, DefaultTenant, sender, receiver, receiver, sender).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery("SELECT id FROM chats WHERE tenant_id=\\? AND receiver=\\? AND sender=\\? AND id<=\\? AND deleted_at IS NULL AND read_at IS NULL ORDER BY id FOR UPDATE").
+		WithArgs(DefaultTenant, receiver, sender, 5).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(5))
+	for _, id := range []int64{3, 5} {
+		mock.ExpectExec("UPDATE chats SET read_at=").WithArgs(readAt, readAt, id, DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectEvent(mock, ChatReadEvent, id, nil)
+	}
+	mock.ExpectCommit()
+
+	read, readErr := s.MarkConversationRead(context.Background(), receiver, sender, 5, readAt)
+	assert.Nil(t, readErr)
+	assert.EqualValues(t, 2, read)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChatRepo_MarkConversationRead_Outside_The_Conversation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := NewChatRepository(db)
+
+	//a chat that doesn't exist, or goes between other phones
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM chats WHERE id=\\? AND tenant_id=\\?").
+		WithArgs(99, DefaultTenant, sender, receiver, receiver, sender).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	read, readErr := s.MarkConversationRead(context.Background(), receiver, sender, 99, time.Now())
+	if assert.NotNil(t, readErr) {
+		assert.EqualValues(t, http.StatusNotFound, readErr.Status())
+	}
+	assert.EqualValues(t, 0, read)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChatRepo_MarkDelivered_And_UnreadCounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := &chatRepo{db: db, driver: DriverPostgres}
+	deliveredAt := time.Now()
+
+	mock.ExpectExec("UPDATE chats SET delivered_at=\\$1 WHERE tenant_id=\\$2 AND receiver=\\$3 AND delivered_at IS NULL AND id IN \\(\\$4,\\$5\\)").
+		WithArgs(deliveredAt, DefaultTenant, receiver, 1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	assert.Nil(t, s.MarkDelivered(context.Background(), receiver, []int64{1, 2}, deliveredAt))
+	assert.Nil(t, s.MarkDelivered(context.Background(), receiver, nil, deliveredAt))
+
+	mock.ExpectQuery("SELECT sender, COUNT\\(\\*\\) FROM chats WHERE tenant_id=\\$1 AND receiver=\\$2 AND deleted_at IS NULL AND read_at IS NULL GROUP BY sender ORDER BY sender").
+		WithArgs(DefaultTenant, receiver).WillReturnRows(sqlmock.NewRows([]string{"sender", "count"}).AddRow(sender, 4))
+	counts, countErr := s.UnreadCounts(context.Background(), receiver)
+	assert.Nil(t, countErr)
+	assert.EqualValues(t, []UnreadCount{{Peer: sender, Unread: 4}}, counts)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
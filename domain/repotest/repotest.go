@@ -0,0 +1,801 @@
+// Package repotest is a conformance suite for chat repositories. Every
+// storage backend is expected to pass it, so the services behave the same
+// whichever DBDRIVER is configured.
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Repository is the part of the domain chat repository exercised here.
+type Repository interface {
+	Get(ctx context.Context, Id int64) (*domain.Chat, utils.ChatErr)
+	Create(ctx context.Context, chat *domain.Chat) (*domain.Chat, utils.ChatErr)
+	Update(ctx context.Context, chat *domain.Chat) (*domain.Chat, utils.ChatErr)
+	Delete(ctx context.Context, Id int64, version int64) utils.ChatErr
+	GetAll(ctx context.Context, filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr)
+	GetConversation(ctx context.Context, a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr)
+	GetDeleted(ctx context.Context, Id int64) (*domain.Chat, utils.ChatErr)
+	Restore(ctx context.Context, Id int64) (*domain.Chat, utils.ChatErr)
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, utils.ChatErr)
+	Revisions(ctx context.Context, Id int64) ([]domain.ChatRevision, utils.ChatErr)
+	CreateWithKey(ctx context.Context, chat *domain.Chat, key *domain.IdempotencyKey) (*domain.Chat, utils.ChatErr)
+	GetIdempotencyKey(ctx context.Context, key string) (*domain.IdempotencyKey, utils.ChatErr)
+	MarkRead(ctx context.Context, Id int64, readAt time.Time) (*domain.Chat, utils.ChatErr)
+	MarkConversationRead(ctx context.Context, receiver, sender string, upTo int64, readAt time.Time) (int64, utils.ChatErr)
+	MarkDelivered(ctx context.Context, receiver string, ids []int64, deliveredAt time.Time) utils.ChatErr
+	UnreadCounts(ctx context.Context, receiver string) ([]domain.UnreadCount, utils.ChatErr)
+	Inbox(ctx context.Context, phone string, page domain.PageRequest) (*domain.InboxPage, utils.ChatErr)
+}
+
+// Factory returns an empty repository. It is called once per subtest.
+type Factory func(t *testing.T) Repository
+
+const (
+	alice = "+6282323231"
+	bob   = "+6282323232"
+	carol = "+6282323233"
+)
+
+// ctx is passed to every repository call, no test relies on cancellation.
+var ctx = context.Background()
+
+// base is whole seconds in UTC so it survives columns without sub-second
+// precision.
+var base = time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// Run exercises the repository returned by newRepo against the behaviour
+// the services rely on.
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo Repository)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"CreateAssignsDistinctIds", testCreateAssignsDistinctIds},
+		{"GetNotFound", testGetNotFound},
+		{"Update", testUpdate},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"LongestBody", testLongestBody},
+		{"Revisions", testRevisions},
+		{"Versions", testVersions},
+		{"Delete", testDelete},
+		{"DeleteNotFound", testDeleteNotFound},
+		{"Restore", testRestore},
+		{"Purge", testPurge},
+		{"GetAllEmpty", testGetAllEmpty},
+		{"GetAllOrdering", testGetAllOrdering},
+		{"GetAllPagination", testGetAllPagination},
+		{"GetAllFilters", testGetAllFilters},
+		{"GetAllInvalidCursor", testGetAllInvalidCursor},
+		{"GetConversation", testGetConversation},
+		{"IdempotencyKeys", testIdempotencyKeys},
+		{"TenantIsolation", testTenantIsolation},
+		{"ReadReceipts", testReadReceipts},
+		{"ReadConversation", testReadConversation},
+		{"Inbox", testInbox},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepo(t))
+		})
+	}
+}
+
+func create(t *testing.T, repo Repository, from, to, body string, createdAt time.Time) domain.Chat {
+	t.Helper()
+	chat, err := repo.Create(ctx, &domain.Chat{Sender: from, Receiver: to, Body: body, CreatedAt: createdAt})
+	if err != nil {
+		t.Fatalf("Create() error = %v: %s", err, err.Message())
+	}
+	return *chat
+}
+
+func expectStatus(t *testing.T, op string, err utils.ChatErr, status int) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("%s error = nil, want status %d", op, status)
+	}
+	if err.Status() != status {
+		t.Fatalf("%s status = %d (%s), want %d", op, err.Status(), err.Message(), status)
+	}
+}
+
+func ids(chats []domain.Chat) []int64 {
+	result := make([]int64, 0, len(chats))
+	for _, chat := range chats {
+		result = append(result, chat.Id)
+	}
+	return result
+}
+
+func expectIds(t *testing.T, op string, got []domain.Chat, want ...int64) {
+	t.Helper()
+	gotIds := ids(got)
+	if len(gotIds) != len(want) {
+		t.Fatalf("%s ids = %v, want %v", op, gotIds, want)
+	}
+	for i := range want {
+		if gotIds[i] != want[i] {
+			t.Fatalf("%s ids = %v, want %v", op, gotIds, want)
+		}
+	}
+}
+
+func testCreateAndGet(t *testing.T, repo Repository) {
+	created := create(t, repo, alice, bob, "hello", base)
+	if created.Id <= 0 {
+		t.Fatalf("Create() id = %d, want a positive id", created.Id)
+	}
+
+	got, err := repo.Get(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Id != created.Id || got.Sender != alice || got.Receiver != bob || got.Body != "hello" {
+		t.Errorf("Get() = %+v, want %+v", got, created)
+	}
+	if !got.CreatedAt.Equal(base) {
+		t.Errorf("Get() created_at = %v, want %v", got.CreatedAt, base)
+	}
+}
+
+func testCreateAssignsDistinctIds(t *testing.T, repo Repository) {
+	first := create(t, repo, alice, bob, "one", base)
+	second := create(t, repo, alice, bob, "two", base)
+	if first.Id == second.Id {
+		t.Errorf("Create() returned id %d twice", first.Id)
+	}
+}
+
+func testGetNotFound(t *testing.T, repo Repository) {
+	_, err := repo.Get(ctx, 12322)
+	expectStatus(t, "Get()", err, http.StatusNotFound)
+}
+
+func testUpdate(t *testing.T, repo Repository) {
+	created := create(t, repo, alice, bob, "hello", base)
+
+	updated, err := repo.Update(ctx, &domain.Chat{Id: created.Id, Sender: alice, Receiver: bob, Body: "edited", CreatedAt: base})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.Body != "edited" {
+		t.Errorf("Update() body = %q, want %q", updated.Body, "edited")
+	}
+
+	got, err := repo.Get(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Body != "edited" || got.Sender != alice || got.Receiver != bob || !got.CreatedAt.Equal(base) {
+		t.Errorf("Get() after Update() = %+v, only the body should change", got)
+	}
+
+	//writing the same body again is not a missing row
+	if _, err := repo.Update(ctx, &domain.Chat{Id: created.Id, Body: "edited"}); err != nil {
+		t.Errorf("Update() with an unchanged body error = %v", err)
+	}
+}
+
+func testUpdateNotFound(t *testing.T, repo Repository) {
+	_, err := repo.Update(ctx, &domain.Chat{Id: 12322, Body: "edited"})
+	expectStatus(t, "Update()", err, http.StatusNotFound)
+}
+
+// testLongestBody stores bodies of MaxTextBodyLength characters that are
+// as large as they get in bytes: four in UTF-8, six once JSON escapes them
+// in the outbox payload and the idempotent response.
+func testLongestBody(t *testing.T, repo Repository) {
+	emoji := strings.Repeat("\U0001F600", domain.MaxTextBodyLength)
+	escaped := strings.Repeat("<", domain.MaxTextBodyLength)
+
+	created := create(t, repo, alice, bob, emoji, base)
+	for i, body := range []string{emoji, escaped} {
+		chat := &domain.Chat{Sender: alice, Receiver: bob, Body: body, CreatedAt: base}
+		key := &domain.IdempotencyKey{Key: fmt.Sprintf("longest-%d", i), Fingerprint: chat.Fingerprint()}
+		if _, err := repo.CreateWithKey(ctx, chat, key); err != nil {
+			t.Fatalf("CreateWithKey() error = %v: %s", err, err.Message())
+		}
+		stored, err := repo.GetIdempotencyKey(ctx, key.Key)
+		if err != nil {
+			t.Fatalf("GetIdempotencyKey() error = %v", err)
+		}
+		if stored.Chat.Body != body {
+			t.Errorf("GetIdempotencyKey() body has %d bytes, want %d", len(stored.Chat.Body), len(body))
+		}
+	}
+	if _, err := repo.Update(ctx, &domain.Chat{Id: created.Id, Body: escaped}); err != nil {
+		t.Fatalf("Update() error = %v: %s", err, err.Message())
+	}
+
+	got, err := repo.Get(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Body != escaped {
+		t.Errorf("Get() body has %d bytes, want %d", len(got.Body), len(escaped))
+	}
+	revisions, err := repo.Revisions(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Revisions() error = %v", err)
+	}
+	if len(revisions) == 0 || revisions[0].Body != emoji {
+		t.Errorf("Revisions() should keep the %d bytes of the first body", len(emoji))
+	}
+}
+
+func testRevisions(t *testing.T, repo Repository) {
+	created := create(t, repo, alice, bob, "original", base)
+
+	revisions, err := repo.Revisions(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Revisions() error = %v", err)
+	}
+	if revisions == nil || len(revisions) != 0 {
+		t.Errorf("Revisions() of an unedited chat = %v, want an empty list", revisions)
+	}
+
+	for _, body := range []string{"first edit", "second edit", "second edit"} {
+		if _, err := repo.Update(ctx, &domain.Chat{Id: created.Id, Body: body}); err != nil {
+			t.Fatalf("Update(%q) error = %v", body, err)
+		}
+	}
+
+	got, err := repo.Get(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.RevisionCount != 2 || got.EditedAt == nil {
+		t.Errorf("Get() revision_count = %d, edited_at = %v, want 2 edits", got.RevisionCount, got.EditedAt)
+	}
+
+	revisions, err = repo.Revisions(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Revisions() error = %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("Revisions() = %v, want the 2 replaced bodies", revisions)
+	}
+	for i, want := range []string{"original", "first edit"} {
+		if revisions[i].Body != want || revisions[i].Revision != i+1 || revisions[i].ChatId != created.Id {
+			t.Errorf("Revisions()[%d] = %+v, want revision %d with body %q", i, revisions[i], i+1, want)
+		}
+	}
+
+	_, err = repo.Revisions(ctx, 12322)
+	expectStatus(t, "Revisions()", err, http.StatusNotFound)
+}
+
+func testVersions(t *testing.T, repo Repository) {
+	created := create(t, repo, alice, bob, "hello", base)
+	if created.Version != 1 {
+		t.Fatalf("Create() version = %d, want 1", created.Version)
+	}
+
+	updated, err := repo.Update(ctx, &domain.Chat{Id: created.Id, Body: "edited", Version: 1})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.Version != 2 {
+		t.Errorf("Update() version = %d, want 2", updated.Version)
+	}
+	got, err := repo.Get(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Version != 2 {
+		t.Errorf("Get() version = %d, want 2", got.Version)
+	}
+
+	//a writer still holding version 1 lost the race
+	_, err = repo.Update(ctx, &domain.Chat{Id: created.Id, Body: "clobbered", Version: 1})
+	expectStatus(t, "Update() with a stale version", err, http.StatusConflict)
+	expectStatus(t, "Delete() with a stale version", repo.Delete(ctx, created.Id, 1), http.StatusConflict)
+	expectStatus(t, "Delete() with a stale version of a missing chat", repo.Delete(ctx, 12322, 1), http.StatusNotFound)
+
+	if err := repo.Delete(ctx, created.Id, 2); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	restored, err := repo.Restore(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if restored.Version != 4 {
+		t.Errorf("Restore() version = %d, want 4 after a delete and a restore", restored.Version)
+	}
+}
+
+func testDelete(t *testing.T, repo Repository) {
+	kept := create(t, repo, alice, bob, "keep", base)
+	deleted := create(t, repo, alice, bob, "delete", base)
+
+	if err := repo.Delete(ctx, deleted.Id, 0); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	_, err := repo.Get(ctx, deleted.Id)
+	expectStatus(t, "Get() after Delete()", err, http.StatusNotFound)
+
+	if _, err := repo.Get(ctx, kept.Id); err != nil {
+		t.Errorf("Get() of another chat after Delete() error = %v", err)
+	}
+	_, err = repo.Update(ctx, &domain.Chat{Id: deleted.Id, Body: "edited"})
+	expectStatus(t, "Update() after Delete()", err, http.StatusNotFound)
+	expectStatus(t, "Delete() twice", repo.Delete(ctx, deleted.Id, 0), http.StatusNotFound)
+
+	page, err := repo.GetAll(ctx, domain.ChatFilter{}, domain.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	expectIds(t, "GetAll() after Delete()", page.Chats, kept.Id)
+
+	//deletes are soft, admins can still list them
+	page, err = repo.GetAll(ctx, domain.ChatFilter{IncludeDeleted: true}, domain.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetAll(include_deleted) error = %v", err)
+	}
+	expectIds(t, "GetAll(include_deleted)", page.Chats, kept.Id, deleted.Id)
+	if page.Chats[0].DeletedAt != nil || page.Chats[1].DeletedAt == nil {
+		t.Errorf("GetAll(include_deleted) deleted_at = %v, %v, want only the deleted chat marked", page.Chats[0].DeletedAt, page.Chats[1].DeletedAt)
+	}
+}
+
+func testDeleteNotFound(t *testing.T, repo Repository) {
+	expectStatus(t, "Delete()", repo.Delete(ctx, 12322, 0), http.StatusNotFound)
+}
+
+func testRestore(t *testing.T, repo Repository) {
+	created := create(t, repo, alice, bob, "hello", base)
+	_, err := repo.GetDeleted(ctx, created.Id)
+	expectStatus(t, "GetDeleted() of a live chat", err, http.StatusNotFound)
+	if err := repo.Delete(ctx, created.Id, 0); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	deleted, err := repo.GetDeleted(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("GetDeleted() error = %v", err)
+	}
+	if deleted.Sender != alice || deleted.DeletedAt == nil {
+		t.Errorf("GetDeleted() = %+v, want the deleted chat", deleted)
+	}
+
+	restored, err := repo.Restore(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if restored.Id != created.Id || restored.Body != "hello" || restored.DeletedAt != nil {
+		t.Errorf("Restore() = %+v, want the chat back without deleted_at", restored)
+	}
+	if _, err := repo.Get(ctx, created.Id); err != nil {
+		t.Errorf("Get() after Restore() error = %v", err)
+	}
+
+	_, err = repo.Restore(ctx, created.Id)
+	expectStatus(t, "Restore() of a live chat", err, http.StatusNotFound)
+	_, err = repo.Restore(ctx, 12322)
+	expectStatus(t, "Restore() of a missing chat", err, http.StatusNotFound)
+}
+
+func testPurge(t *testing.T, repo Repository) {
+	live := create(t, repo, alice, bob, "live", base)
+	deleted := create(t, repo, alice, bob, "deleted", base)
+	if err := repo.Delete(ctx, deleted.Id, 0); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	purged, err := repo.Purge(ctx, time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Fatalf("Purge() of recent deletes = %d, %v, want nothing purged", purged, err)
+	}
+
+	purged, err = repo.Purge(ctx, time.Now().Add(time.Hour))
+	if err != nil || purged != 1 {
+		t.Fatalf("Purge() = %d, %v, want 1 chat purged", purged, err)
+	}
+	_, err = repo.Restore(ctx, deleted.Id)
+	expectStatus(t, "Restore() after Purge()", err, http.StatusNotFound)
+	if _, err := repo.Get(ctx, live.Id); err != nil {
+		t.Errorf("Get() of a live chat after Purge() error = %v", err)
+	}
+}
+
+func testGetAllEmpty(t *testing.T, repo Repository) {
+	page, err := repo.GetAll(ctx, domain.ChatFilter{}, domain.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	if page.Chats == nil || len(page.Chats) != 0 || page.HasMore || page.NextCursor != "" {
+		t.Errorf("GetAll() = %+v, want an empty page", page)
+	}
+}
+
+func testGetAllOrdering(t *testing.T, repo Repository) {
+	late := create(t, repo, alice, bob, "late", base.Add(time.Hour))
+	early := create(t, repo, alice, bob, "early", base)
+	tie := create(t, repo, alice, bob, "tie", base)
+
+	tests := []struct {
+		sort string
+		want []int64
+	}{
+		{domain.SortCreatedAt, []int64{early.Id, tie.Id, late.Id}},
+		{domain.SortCreatedAtDesc, []int64{late.Id, tie.Id, early.Id}},
+		{domain.SortId, []int64{late.Id, early.Id, tie.Id}},
+	}
+	for _, tt := range tests {
+		page, err := repo.GetAll(ctx, domain.ChatFilter{Sort: tt.sort}, domain.PageRequest{})
+		if err != nil {
+			t.Fatalf("GetAll(sort=%s) error = %v", tt.sort, err)
+		}
+		expectIds(t, "GetAll(sort="+tt.sort+")", page.Chats, tt.want...)
+	}
+}
+
+func testGetAllPagination(t *testing.T, repo Repository) {
+	var want []int64
+	for i := 0; i < 5; i++ {
+		//pairs share a timestamp so the id tie-break is exercised
+		want = append(want, create(t, repo, alice, bob, "hello", base.Add(time.Duration(i/2)*time.Second)).Id)
+	}
+
+	for _, sort := range []string{domain.SortCreatedAt, domain.SortCreatedAtDesc, domain.SortId} {
+		var got []domain.Chat
+		page := domain.PageRequest{Limit: 2}
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatalf("GetAll(sort=%s) did not stop paginating", sort)
+			}
+			result, err := repo.GetAll(ctx, domain.ChatFilter{Sort: sort}, page)
+			if err != nil {
+				t.Fatalf("GetAll(sort=%s) error = %v", sort, err)
+			}
+			if len(result.Chats) > 2 {
+				t.Fatalf("GetAll(sort=%s) returned %d chats, limit is 2", sort, len(result.Chats))
+			}
+			got = append(got, result.Chats...)
+			if !result.HasMore {
+				break
+			}
+			if result.NextCursor == "" {
+				t.Fatalf("GetAll(sort=%s) has_more without a next_cursor", sort)
+			}
+			page.Cursor = result.NextCursor
+		}
+
+		expected := want
+		if sort == domain.SortCreatedAtDesc {
+			expected = make([]int64, len(want))
+			for i := range want {
+				expected[i] = want[len(want)-1-i]
+			}
+		}
+		expectIds(t, "GetAll(sort="+sort+") pages", got, expected...)
+	}
+}
+
+func testGetAllFilters(t *testing.T, repo Repository) {
+	first := create(t, repo, alice, bob, "Hello Bob", base)
+	second := create(t, repo, bob, alice, "hi alice, 100% sure", base.Add(time.Hour))
+	third := create(t, repo, carol, bob, "hello from carol", base.Add(2*time.Hour))
+
+	since, until := base.Add(30*time.Minute), base.Add(90*time.Minute)
+	tests := []struct {
+		name   string
+		filter domain.ChatFilter
+		want   []int64
+	}{
+		{"Sender", domain.ChatFilter{Sender: alice}, []int64{first.Id}},
+		{"Receiver", domain.ChatFilter{Receiver: bob}, []int64{first.Id, third.Id}},
+		{"Since", domain.ChatFilter{Since: &since}, []int64{second.Id, third.Id}},
+		{"Until", domain.ChatFilter{Until: &until}, []int64{first.Id, second.Id}},
+		{"Query Is Case Insensitive", domain.ChatFilter{Query: "hello"}, []int64{first.Id, third.Id}},
+		{"Query Wildcards Are Literal", domain.ChatFilter{Query: "100%"}, []int64{second.Id}},
+		{"Query Underscore Is Literal", domain.ChatFilter{Query: "_"}, nil},
+		{"Combined", domain.ChatFilter{Receiver: bob, Query: "carol"}, []int64{third.Id}},
+		{"Participant", domain.ChatFilter{Participant: alice}, []int64{first.Id, second.Id}},
+		{"Participant And Sender", domain.ChatFilter{Participant: bob, Sender: carol}, []int64{third.Id}},
+	}
+	for _, tt := range tests {
+		page, err := repo.GetAll(ctx, tt.filter, domain.PageRequest{})
+		if err != nil {
+			t.Fatalf("GetAll(%s) error = %v", tt.name, err)
+		}
+		expectIds(t, "GetAll("+tt.name+")", page.Chats, tt.want...)
+	}
+}
+
+func testGetAllInvalidCursor(t *testing.T, repo Repository) {
+	_, err := repo.GetAll(ctx, domain.ChatFilter{}, domain.PageRequest{Cursor: "not a cursor"})
+	expectStatus(t, "GetAll()", err, http.StatusBadRequest)
+
+	_, err = repo.GetAll(ctx, domain.ChatFilter{}, domain.PageRequest{Limit: domain.MaxPageLimit + 1})
+	expectStatus(t, "GetAll()", err, http.StatusBadRequest)
+
+	//a cursor only pages on under the filters it was issued for
+	create(t, repo, alice, bob, "one", base)
+	create(t, repo, alice, bob, "two", base.Add(time.Second))
+	filter := domain.ChatFilter{Sender: alice, Query: "o"}
+	page, err := repo.GetAll(ctx, filter, domain.PageRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	next := domain.PageRequest{Limit: 1, Cursor: page.NextCursor}
+	if _, err := repo.GetAll(ctx, filter, next); err != nil {
+		t.Fatalf("GetAll(same filters) error = %v", err)
+	}
+	for _, other := range []domain.ChatFilter{
+		{Sender: alice},
+		{Sender: alice, Receiver: bob, Query: "o"},
+		{Sender: alice, Query: "t"},
+		{Sender: alice, Query: "o", IncludeDeleted: true},
+		{Sender: alice, Query: "o", Since: &base},
+	} {
+		_, err = repo.GetAll(ctx, other, next)
+		expectStatus(t, "GetAll(other filters)", err, http.StatusBadRequest)
+	}
+}
+
+func testGetConversation(t *testing.T, repo Repository) {
+	first := create(t, repo, alice, bob, "hi bob", base)
+	create(t, repo, alice, carol, "hi carol", base.Add(time.Minute))
+	second := create(t, repo, bob, alice, "hi alice", base.Add(2*time.Minute))
+
+	for _, pair := range [][2]string{{alice, bob}, {bob, alice}} {
+		page, err := repo.GetConversation(ctx, pair[0], pair[1], domain.PageRequest{})
+		if err != nil {
+			t.Fatalf("GetConversation() error = %v", err)
+		}
+		expectIds(t, "GetConversation("+pair[0]+", "+pair[1]+")", page.Chats, first.Id, second.Id)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, repo Repository) {
+	_, err := repo.GetIdempotencyKey(ctx, "retry-1")
+	expectStatus(t, "GetIdempotencyKey(unknown)", err, http.StatusNotFound)
+
+	chat := &domain.Chat{Sender: alice, Receiver: bob, Body: "hello", CreatedAt: base}
+	created, err := repo.CreateWithKey(ctx, chat, &domain.IdempotencyKey{Key: "retry-1", Fingerprint: chat.Fingerprint()})
+	if err != nil {
+		t.Fatalf("CreateWithKey() error = %v: %s", err, err.Message())
+	}
+
+	stored, err := repo.GetIdempotencyKey(ctx, "retry-1")
+	if err != nil {
+		t.Fatalf("GetIdempotencyKey() error = %v: %s", err, err.Message())
+	}
+	if stored.Fingerprint != chat.Fingerprint() || stored.Chat.Id != created.Id || stored.Chat.Body != "hello" {
+		t.Fatalf("GetIdempotencyKey() = %+v, want the created chat %d", stored, created.Id)
+	}
+
+	//the key is taken, nothing else is created
+	_, err = repo.CreateWithKey(ctx, &domain.Chat{Sender: alice, Receiver: bob, Body: "hello", CreatedAt: base}, &domain.IdempotencyKey{Key: "retry-1", Fingerprint: chat.Fingerprint()})
+	expectStatus(t, "CreateWithKey(same key)", err, http.StatusConflict)
+	page, err := repo.GetAll(ctx, domain.ChatFilter{}, domain.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	expectIds(t, "GetAll()", page.Chats, created.Id)
+}
+
+func testTenantIsolation(t *testing.T, repo Repository) {
+	acme := domain.WithTenant(ctx, "acme")
+	globex := domain.WithTenant(ctx, "globex")
+
+	chat := &domain.Chat{Sender: alice, Receiver: bob, Body: "hello", CreatedAt: base}
+	stored, err := repo.CreateWithKey(acme, chat, &domain.IdempotencyKey{Key: "retry-1", Fingerprint: chat.Fingerprint()})
+	if err != nil {
+		t.Fatalf("CreateWithKey() error = %v: %s", err, err.Message())
+	}
+	created := *stored
+	if created.Tenant != "acme" {
+		t.Errorf("CreateWithKey() tenant = %q, want acme", created.Tenant)
+	}
+
+	//the chat doesn't exist for another tenant, whatever is asked of it
+	_, err = repo.Get(globex, created.Id)
+	expectStatus(t, "Get() from another tenant", err, http.StatusNotFound)
+	_, err = repo.Update(globex, &domain.Chat{Id: created.Id, Body: "taken over"})
+	expectStatus(t, "Update() from another tenant", err, http.StatusNotFound)
+	expectStatus(t, "Delete() from another tenant", repo.Delete(globex, created.Id, 0), http.StatusNotFound)
+	page, err := repo.GetAll(globex, domain.ChatFilter{}, domain.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	expectIds(t, "GetAll() of another tenant", page.Chats)
+	page, err = repo.GetConversation(globex, alice, bob, domain.PageRequest{})
+	if err != nil {
+		t.Fatalf("GetConversation() error = %v", err)
+	}
+	expectIds(t, "GetConversation() of another tenant", page.Chats)
+	_, err = repo.Revisions(globex, created.Id)
+	expectStatus(t, "Revisions() from another tenant", err, http.StatusNotFound)
+	_, err = repo.GetIdempotencyKey(globex, "retry-1")
+	expectStatus(t, "GetIdempotencyKey() from another tenant", err, http.StatusNotFound)
+
+	//keys are per tenant, the same one can be used by another
+	again := &domain.Chat{Sender: alice, Receiver: bob, Body: "hello", CreatedAt: base}
+	other, err := repo.CreateWithKey(globex, again, &domain.IdempotencyKey{Key: "retry-1", Fingerprint: again.Fingerprint()})
+	if err != nil {
+		t.Fatalf("CreateWithKey() of another tenant error = %v: %s", err, err.Message())
+	}
+
+	got, err := repo.Get(acme, created.Id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Body != "hello" || got.Version != created.Version {
+		t.Errorf("Get() = %+v, want the chat untouched by the other tenant", got)
+	}
+	_, err = repo.Get(ctx, created.Id)
+	expectStatus(t, "Get() from the default tenant", err, http.StatusNotFound)
+
+	if err := repo.Delete(acme, created.Id, 0); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	_, err = repo.GetDeleted(globex, created.Id)
+	expectStatus(t, "GetDeleted() from another tenant", err, http.StatusNotFound)
+	_, err = repo.Restore(globex, created.Id)
+	expectStatus(t, "Restore() from another tenant", err, http.StatusNotFound)
+	if _, err := repo.Get(globex, other.Id); err != nil {
+		t.Errorf("Get() of the other tenant's chat error = %v", err)
+	}
+}
+
+func testReadReceipts(t *testing.T, repo Repository) {
+	chat := create(t, repo, alice, bob, "hello", base)
+	delivered := base.Add(time.Minute)
+	if err := repo.MarkDelivered(ctx, bob, []int64{chat.Id}, delivered); err != nil {
+		t.Fatalf("MarkDelivered() error = %v", err)
+	}
+	//only the receiver's chats, and only once
+	if err := repo.MarkDelivered(ctx, alice, []int64{chat.Id}, base); err != nil {
+		t.Fatalf("MarkDelivered() error = %v", err)
+	}
+	if err := repo.MarkDelivered(ctx, bob, []int64{chat.Id}, base.Add(time.Hour)); err != nil {
+		t.Fatalf("MarkDelivered() error = %v", err)
+	}
+
+	read, err := repo.MarkRead(ctx, chat.Id, base.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+	if read.DeliveredAt == nil || !read.DeliveredAt.Equal(delivered) {
+		t.Errorf("MarkRead() delivered_at = %v, want %v", read.DeliveredAt, delivered)
+	}
+	if read.ReadAt == nil || !read.ReadAt.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("MarkRead() read_at = %v, want %v", read.ReadAt, base.Add(2*time.Minute))
+	}
+	if read.Version != chat.Version {
+		t.Errorf("MarkRead() version = %d, want %d", read.Version, chat.Version)
+	}
+
+	//reading again keeps the first read time
+	again, err := repo.MarkRead(ctx, chat.Id, base.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+	if again.ReadAt == nil || !again.ReadAt.Equal(*read.ReadAt) {
+		t.Errorf("MarkRead() read_at = %v, want %v", again.ReadAt, read.ReadAt)
+	}
+
+	_, err = repo.MarkRead(ctx, 999999, base)
+	expectStatus(t, "MarkRead(missing)", err, http.StatusNotFound)
+}
+
+func testReadConversation(t *testing.T, repo Repository) {
+	first := create(t, repo, alice, bob, "one", base)
+	second := create(t, repo, alice, bob, "two", base.Add(time.Second))
+	create(t, repo, bob, alice, "reply", base.Add(2*time.Second))
+	third := create(t, repo, alice, bob, "three", base.Add(3*time.Second))
+	create(t, repo, carol, bob, "hi", base.Add(4*time.Second))
+	deleted := create(t, repo, carol, bob, "oops", base.Add(5*time.Second))
+	if err := repo.Delete(ctx, deleted.Id, 0); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	expectUnread := func(op string, want ...domain.UnreadCount) {
+		t.Helper()
+		got, err := repo.UnreadCounts(ctx, bob)
+		if err != nil {
+			t.Fatalf("UnreadCounts() error = %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s unread = %v, want %v", op, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%s unread = %v, want %v", op, got, want)
+			}
+		}
+	}
+	expectUnread("UnreadCounts()", domain.UnreadCount{Peer: alice, Unread: 3}, domain.UnreadCount{Peer: carol, Unread: 1})
+
+	//the chat read up to must be one of the conversation
+	_, err := repo.MarkConversationRead(ctx, bob, alice, deleted.Id+100, base.Add(time.Minute))
+	expectStatus(t, "MarkConversationRead(unknown)", err, http.StatusNotFound)
+	_, err = repo.MarkConversationRead(ctx, bob, alice, deleted.Id, base.Add(time.Minute))
+	expectStatus(t, "MarkConversationRead(other conversation)", err, http.StatusNotFound)
+	expectUnread("UnreadCounts(nothing read)", domain.UnreadCount{Peer: alice, Unread: 3}, domain.UnreadCount{Peer: carol, Unread: 1})
+
+	read, err := repo.MarkConversationRead(ctx, bob, alice, second.Id, base.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("MarkConversationRead() error = %v", err)
+	}
+	if read != 2 {
+		t.Errorf("MarkConversationRead() = %d, want 2", read)
+	}
+	for _, id := range []int64{first.Id, second.Id} {
+		got, _ := repo.Get(ctx, id)
+		if got.ReadAt == nil || got.DeliveredAt == nil {
+			t.Errorf("Get(%d) read_at = %v, delivered_at = %v, want both set", id, got.ReadAt, got.DeliveredAt)
+		}
+	}
+	expectUnread("UnreadCounts(after read)", domain.UnreadCount{Peer: alice, Unread: 1}, domain.UnreadCount{Peer: carol, Unread: 1})
+
+	//chats read before are left out of the count
+	read, err = repo.MarkConversationRead(ctx, bob, alice, third.Id, base.Add(time.Hour))
+	if err != nil || read != 1 {
+		t.Errorf("MarkConversationRead() = %d, %v, want 1", read, err)
+	}
+	got, _ := repo.Get(ctx, first.Id)
+	if !got.ReadAt.Equal(base.Add(time.Minute)) {
+		t.Errorf("Get(%d) read_at = %v, want %v", first.Id, got.ReadAt, base.Add(time.Minute))
+	}
+	expectUnread("UnreadCounts(all read)", domain.UnreadCount{Peer: carol, Unread: 1})
+}
+
+func testInbox(t *testing.T, repo Repository) {
+	const dave = "+6282323234"
+	create(t, repo, alice, bob, "first", base)
+	fromCarol := create(t, repo, carol, alice, "unread", base.Add(time.Second))
+	create(t, repo, carol, alice, "unread too", base.Add(2*time.Second))
+	toBob := create(t, repo, alice, bob, strings.Repeat("ü", domain.InboxPreviewLength+5), base.Add(3*time.Second))
+	create(t, repo, bob, carol, "not alice's", base.Add(4*time.Second))
+	deleted := create(t, repo, dave, alice, "deleted", base.Add(5*time.Second))
+	if err := repo.Delete(ctx, deleted.Id, 0); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := repo.MarkRead(ctx, fromCarol.Id, base.Add(time.Minute)); err != nil {
+		t.Fatalf("MarkRead() error = %v", err)
+	}
+
+	first, err := repo.Inbox(ctx, alice, domain.PageRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("Inbox() error = %v: %s", err, err.Message())
+	}
+	if len(first.Entries) != 1 || !first.HasMore {
+		t.Fatalf("Inbox() = %+v, want one entry and more", first)
+	}
+	entry := first.Entries[0]
+	if entry.Peer != bob || entry.LastChatId != toBob.Id || entry.LastSender != alice || entry.Unread != 0 || !entry.LastActivity.Equal(toBob.CreatedAt) {
+		t.Errorf("Inbox() entry = %+v, want the chat alice sent bob last", entry)
+	}
+	if entry.Preview != strings.Repeat("ü", domain.InboxPreviewLength)+"…" {
+		t.Errorf("Inbox() preview = %q, want %d characters and an ellipsis", entry.Preview, domain.InboxPreviewLength)
+	}
+
+	second, err := repo.Inbox(ctx, alice, domain.PageRequest{Limit: 1, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("Inbox() error = %v: %s", err, err.Message())
+	}
+	if len(second.Entries) != 1 || second.HasMore {
+		t.Fatalf("Inbox() = %+v, want the last entry", second)
+	}
+	entry = second.Entries[0]
+	if entry.Peer != carol || entry.Preview != "unread too" || entry.Unread != 1 {
+		t.Errorf("Inbox() entry = %+v, want carol's last chat and 1 unread", entry)
+	}
+
+	_, err = repo.Inbox(ctx, alice, domain.PageRequest{Cursor: "nope"})
+	expectStatus(t, "Inbox(bad cursor)", err, http.StatusBadRequest)
+	_, err = repo.Inbox(ctx, bob, domain.PageRequest{Limit: 1, Cursor: first.NextCursor})
+	expectStatus(t, "Inbox(cursor of another phone)", err, http.StatusBadRequest)
+}
@@ -0,0 +1,157 @@
+package repotest
+
+import (
+	"github.com/SemmiDev/lets-tests/domain"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SearchFactory returns an empty repository and the searcher over it. It is
+// called once per subtest.
+type SearchFactory func(t *testing.T) (Repository, domain.Searcher)
+
+// RunSearch exercises the searcher returned by newSearch. The words are long
+// enough, and no stopwords, for every backend to index them.
+func RunSearch(t *testing.T, newSearch SearchFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo Repository, searcher domain.Searcher)
+	}{
+		{"Ranking", testSearchRanking},
+		{"Phrase", testSearchPhrase},
+		{"Prefix", testSearchPrefix},
+		{"Filters", testSearchFilters},
+		{"Pagination", testSearchPagination},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			repo, searcher := newSearch(t)
+			tt.test(t, repo, searcher)
+		})
+	}
+}
+
+func search(t *testing.T, searcher domain.Searcher, query url.Values, limit int, cursor string) *domain.SearchPage {
+	t.Helper()
+	request, err := domain.NewSearchRequest(query, domain.PageRequest{Limit: limit, Cursor: cursor})
+	if err != nil {
+		t.Fatalf("NewSearchRequest(%v) error = %v: %s", query, err, err.Message())
+	}
+	page, err := searcher.Search(ctx, request)
+	if err != nil {
+		t.Fatalf("Search(%v) error = %v: %s", query, err, err.Message())
+	}
+	return page
+}
+
+func expectHits(t *testing.T, op string, page *domain.SearchPage, want ...int64) {
+	t.Helper()
+	chats := make([]domain.Chat, 0, len(page.Hits))
+	for _, hit := range page.Hits {
+		chats = append(chats, hit.Chat)
+	}
+	expectIds(t, op, chats, want...)
+}
+
+func testSearchRanking(t *testing.T, repo Repository, searcher domain.Searcher) {
+	once := create(t, repo, alice, bob, "pizza tonight", base)
+	create(t, repo, alice, bob, "sushi tonight", base)
+	twice := create(t, repo, bob, alice, "pizza pizza pizza, always pizza", base)
+
+	page := search(t, searcher, url.Values{"q": {"pizza"}}, 0, "")
+	expectHits(t, "Search(pizza)", page, twice.Id, once.Id)
+	if page.Hits[0].Score <= page.Hits[1].Score {
+		t.Errorf("Search(pizza) scores = %v, %v, want the first higher", page.Hits[0].Score, page.Hits[1].Score)
+	}
+	if len(page.Hits[1].Highlights) != 1 || page.Hits[1].Highlights[0] != "<mark>pizza</mark> tonight" {
+		t.Errorf("Search(pizza) highlights = %q", page.Hits[1].Highlights)
+	}
+
+	//every word is required
+	expectHits(t, "Search(pizza sushi)", search(t, searcher, url.Values{"q": {"pizza sushi"}}, 0, ""))
+}
+
+func testSearchPhrase(t *testing.T, repo Repository, searcher domain.Searcher) {
+	phrase := create(t, repo, alice, bob, "meeting moved to the green room", base)
+	create(t, repo, alice, bob, "green paint for the meeting room", base)
+
+	page := search(t, searcher, url.Values{"q": {`"green room"`}}, 0, "")
+	expectHits(t, `Search("green room")`, page, phrase.Id)
+	if len(page.Hits[0].Highlights) != 1 || !strings.Contains(page.Hits[0].Highlights[0], "<mark>green room</mark>") {
+		t.Errorf(`Search("green room") highlights = %q`, page.Hits[0].Highlights)
+	}
+}
+
+func testSearchPrefix(t *testing.T, repo Repository, searcher domain.Searcher) {
+	first := create(t, repo, alice, bob, "planning session", base)
+	second := create(t, repo, alice, bob, "planned outage", base.Add(time.Second))
+	create(t, repo, alice, bob, "airplane seats", base.Add(2*time.Second))
+
+	//equally relevant hits come newest first
+	expectHits(t, "Search(plan*)", search(t, searcher, url.Values{"q": {"plan*"}}, 0, ""), second.Id, first.Id)
+	expectHits(t, "Search(plan)", search(t, searcher, url.Values{"q": {"plan"}}, 0, ""))
+}
+
+func testSearchFilters(t *testing.T, repo Repository, searcher domain.Searcher) {
+	early := create(t, repo, alice, bob, "invoice attached", base)
+	late := create(t, repo, alice, carol, "invoice attached", base.Add(48*time.Hour))
+	fromBob := create(t, repo, bob, alice, "invoice attached", base)
+	deleted := create(t, repo, alice, bob, "invoice attached", base)
+	if err := repo.Delete(ctx, deleted.Id, 0); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	expectHits(t, "Search(sender)", search(t, searcher, url.Values{"q": {"invoice"}, "sender": {alice}}, 0, ""), late.Id, early.Id)
+	expectHits(t, "Search(receiver)", search(t, searcher, url.Values{"q": {"invoice"}, "receiver": {alice}}, 0, ""), fromBob.Id)
+	expectHits(t, "Search(since)", search(t, searcher, url.Values{"q": {"invoice"}, "since": {"2021-05-02"}}, 0, ""), late.Id)
+	expectHits(t, "Search(until)", search(t, searcher, url.Values{"q": {"invoice"}, "sender": {alice}, "until": {"2021-05-02"}}, 0, ""), early.Id)
+	//an until date keeps the chats of that day
+	expectHits(t, "Search(until day)", search(t, searcher, url.Values{"q": {"invoice"}, "sender": {alice}, "until": {"2021-05-01"}}, 0, ""), early.Id)
+}
+
+func testSearchPagination(t *testing.T, repo Repository, searcher domain.Searcher) {
+	var want []int64
+	for i := 0; i < 5; i++ {
+		chat := create(t, repo, alice, bob, "weekly report", base.Add(time.Duration(i)*time.Second))
+		want = append([]int64{chat.Id}, want...)
+	}
+
+	var got []int64
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("Search() did not stop paginating")
+		}
+		page := search(t, searcher, url.Values{"q": {"report"}}, 2, cursor)
+		for _, hit := range page.Hits {
+			got = append(got, hit.Id)
+		}
+		if !page.HasMore {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Search() pages = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Search() pages = %v, want %v", got, want)
+		}
+	}
+
+	_, err := domain.NewSearchRequest(url.Values{"q": {"weekly"}}, domain.PageRequest{Cursor: cursor})
+	expectStatus(t, "NewSearchRequest(other q)", err, http.StatusBadRequest)
+	for name, filter := range map[string]url.Values{
+		"sender": {"q": {"report"}, "sender": {alice}},
+		"since":  {"q": {"report"}, "since": {"2021-05-01"}},
+		"until":  {"q": {"report"}, "until": {"2021-05-02"}},
+	} {
+		_, err = domain.NewSearchRequest(filter, domain.PageRequest{Cursor: cursor})
+		expectStatus(t, "NewSearchRequest(other "+name+")", err, http.StatusBadRequest)
+	}
+}
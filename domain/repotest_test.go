@@ -0,0 +1,24 @@
+package domain_test
+
+import (
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/domain/repotest"
+	"testing"
+)
+
+func TestMemoryChatRepo_Contract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repository {
+		return domain.NewMemoryChatRepository()
+	})
+}
+
+func TestMemorySearcher_Contract(t *testing.T) {
+	repotest.RunSearch(t, func(t *testing.T) (repotest.Repository, domain.Searcher) {
+		repo := domain.NewMemoryChatRepository()
+		searcher, err := domain.NewSearcher(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo, searcher
+	})
+}
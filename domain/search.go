@@ -0,0 +1,353 @@
+package domain
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/utils"
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxSearchTerms bounds the words and phrases of a search query.
+const MaxSearchTerms = 10
+
+// Highlights are at most maxHighlights fragments of a body, each with about
+// highlightContext bytes of text on both sides of the matches.
+const (
+	maxHighlights    = 3
+	highlightContext = 40
+)
+
+// searchParams are the query parameters understood by the search.
+var searchParams = map[string]bool{
+	"q":        true,
+	"sender":   true,
+	"receiver": true,
+	"since":    true,
+	"until":    true,
+	"limit":    true,
+	"cursor":   true,
+}
+
+// SearchTerm is a word of a search query, or a phrase of consecutive words
+// when it has several. Prefix also matches words starting with the last one.
+type SearchTerm struct {
+	Words  []string
+	Prefix bool
+}
+
+// SearchRequest finds the chats whose body holds every term of Query, among
+// those Filter lets through. Its Query and Sort are unused, hits are ranked
+// by relevance.
+type SearchRequest struct {
+	Query  string
+	Terms  []SearchTerm
+	Filter ChatFilter
+	Limit  int
+	Cursor string
+
+	offset int
+}
+
+// SearchHit is a chat found by a search. Highlights are HTML escaped
+// fragments of its body with the matches wrapped in <mark>.
+type SearchHit struct {
+	Chat
+	Score      float64  `json:"score"`
+	Highlights []string `json:"highlights"`
+}
+
+// SearchPage is the envelope of the hits, shaped like ChatPage.
+type SearchPage struct {
+	Hits       []SearchHit `json:"data"`
+	NextCursor string      `json:"next_cursor"`
+	HasMore    bool        `json:"has_more"`
+}
+
+// searchCursor is the position of the next page of a search. The query and
+// a fingerprint of the filters are kept so a cursor can't be replayed
+// against other ones.
+type searchCursor struct {
+	Offset int    `json:"offset"`
+	Query  string `json:"q"`
+	Filter string `json:"filter"`
+}
+
+// Searcher runs full-text searches over the bodies of the chats. Each chat
+// storage comes with its own.
+type Searcher interface {
+	Search(ctx context.Context, request SearchRequest) (*SearchPage, utils.ChatErr)
+}
+
+// ChatSearcher is set next to ChatRepo, over the same storage.
+var ChatSearcher Searcher
+
+// NewSearcher returns the searcher of the chats stored in chats.
+func NewSearcher(chats chatRepoInterface) (Searcher, error) {
+	switch repo := chats.(type) {
+	case *chatRepo:
+		return &chatSearcher{chats: repo}, nil
+	case *memoryChatRepo:
+		return &memorySearcher{chats: repo}, nil
+	}
+	return nil, fmt.Errorf("no search for chat repository %T", chats)
+}
+
+// NewSearchRequest parses the query string of a search, page holds its
+// limit and cursor.
+func NewSearchRequest(query url.Values, page PageRequest) (SearchRequest, utils.ChatErr) {
+	request := SearchRequest{Query: strings.TrimSpace(query.Get("q")), Limit: page.Limit, Cursor: page.Cursor}
+	for key := range query {
+		if !searchParams[key] {
+			return request, utils.ErrorKind(utils.BadRequestError, fmt.Sprintf("unknown query parameter: %s", key))
+		}
+	}
+
+	filter, err := parseFilterParams(query)
+	if err != nil {
+		return request, err
+	}
+	request.Filter = filter
+	return request, request.Validate()
+}
+
+// Validate parses Query into Terms and checks the rest of the request.
+func (s *SearchRequest) Validate() utils.ChatErr {
+	if s.Query == "" {
+		return utils.ErrorKind(utils.BadRequestError, "Required q")
+	}
+	s.Terms = parseSearchQuery(s.Query)
+	if len(s.Terms) == 0 {
+		return utils.ErrorKind(utils.BadRequestError, "q should have at least one word")
+	}
+	if len(s.Terms) > MaxSearchTerms {
+		return utils.ErrorKind(utils.BadRequestError, fmt.Sprintf("q should have at most %d words and phrases", MaxSearchTerms))
+	}
+	if err := s.Filter.Validate(); err != nil {
+		return err
+	}
+
+	if s.Limit == 0 {
+		s.Limit = DefaultPageLimit
+	}
+	if s.Limit < 0 || s.Limit > MaxPageLimit {
+		return utils.ErrorKind(utils.BadRequestError, "limit should be between 1 and 100")
+	}
+
+	s.offset = 0
+	if s.Cursor == "" {
+		return nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s.Cursor)
+	if err != nil {
+		return utils.ErrorKind(utils.BadRequestError, "invalid cursor")
+	}
+	var cursor searchCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Offset <= 0 {
+		return utils.ErrorKind(utils.BadRequestError, "invalid cursor")
+	}
+	if cursor.Query != s.Query {
+		return utils.ErrorKind(utils.BadRequestError, "cursor does not match q")
+	}
+	if cursor.Filter != s.Filter.fingerprint() {
+		return utils.ErrorKind(utils.BadRequestError, "cursor does not match the filters")
+	}
+	s.offset = cursor.Offset
+	return nil
+}
+
+// newSearchPage trims the extra hit fetched to detect whether another page
+// exists, and highlights the others.
+func newSearchPage(hits []SearchHit, request SearchRequest) *SearchPage {
+	page := &SearchPage{Hits: hits}
+	if len(hits) > request.Limit {
+		page.Hits = hits[:request.Limit]
+		page.HasMore = true
+	}
+	for i := range page.Hits {
+		page.Hits[i].Highlights = highlight(page.Hits[i].Body, request.Terms)
+	}
+	if page.HasMore {
+		raw, _ := json.Marshal(searchCursor{Offset: request.offset + request.Limit, Query: request.Query, Filter: request.Filter.fingerprint()})
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return page
+}
+
+// parseSearchQuery splits a query into terms: "quoted words" are a phrase,
+// a word ending with * a prefix. Words are lower cased letters and digits,
+// anything else separates them.
+func parseSearchQuery(query string) []SearchTerm {
+	var terms []SearchTerm
+	add := func(text string, prefix bool) {
+		words := tokenize(text)
+		if len(words) == 0 {
+			return
+		}
+		term := SearchTerm{Prefix: prefix}
+		for _, word := range words {
+			term.Words = append(term.Words, word.text)
+		}
+		terms = append(terms, term)
+	}
+
+	quoted := false
+	for _, part := range strings.Split(query, `"`) {
+		if quoted {
+			add(part, false)
+		} else {
+			for _, field := range strings.Fields(part) {
+				add(field, strings.HasSuffix(field, "*"))
+			}
+		}
+		quoted = !quoted
+	}
+	return terms
+}
+
+// token is a word of a body, start and end its byte offsets.
+type token struct {
+	text       string
+	start, end int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits text into lower cased words.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{text: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{text: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// matchAt tells whether term matches the tokens from position i.
+func (t SearchTerm) matchAt(tokens []token, i int) bool {
+	if i+len(t.Words) > len(tokens) {
+		return false
+	}
+	last := len(t.Words) - 1
+	for j, word := range t.Words {
+		text := tokens[i+j].text
+		if j == last && t.Prefix {
+			if !strings.HasPrefix(text, word) {
+				return false
+			}
+		} else if text != word {
+			return false
+		}
+	}
+	return true
+}
+
+// span is a byte range of a body.
+type span struct {
+	start, end int
+}
+
+// matchSpans finds the matches of terms in body, in order and merged where
+// they overlap.
+func matchSpans(body string, terms []SearchTerm) []span {
+	tokens := tokenize(body)
+	var spans []span
+	for i := range tokens {
+		for _, term := range terms {
+			if term.matchAt(tokens, i) {
+				spans = append(spans, span{tokens[i].start, tokens[i+len(term.Words)-1].end})
+			}
+		}
+	}
+	sort.Slice(spans, func(a, b int) bool { return spans[a].start < spans[b].start })
+
+	merged := spans[:0]
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+			if s.end > merged[n-1].end {
+				merged[n-1].end = s.end
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// highlight cuts the fragments of body around the matches of terms. A body
+// without any, as a storage may match words differently, gets its start.
+func highlight(body string, terms []SearchTerm) []string {
+	matches := matchSpans(body, terms)
+	if len(matches) == 0 {
+		return []string{fragment(body, span{0, runeBoundary(body, highlightContext*2)}, nil)}
+	}
+
+	var fragments []string
+	for len(matches) > 0 && len(fragments) < maxHighlights {
+		window := span{runeBoundary(body, matches[0].start-highlightContext), runeBoundary(body, matches[0].end+highlightContext)}
+		inside := 1
+		for inside < len(matches) && matches[inside].start < window.end {
+			if end := runeBoundary(body, matches[inside].end+highlightContext); end > window.end {
+				window.end = end
+			}
+			inside++
+		}
+		fragments = append(fragments, fragment(body, window, matches[:inside]))
+		matches = matches[inside:]
+	}
+	return fragments
+}
+
+// fragment escapes the window of body, marking the matches within it.
+func fragment(body string, window span, matches []span) string {
+	var sb strings.Builder
+	if window.start > 0 {
+		sb.WriteString("…")
+	}
+	at := window.start
+	for _, match := range matches {
+		sb.WriteString(html.EscapeString(body[at:match.start]))
+		sb.WriteString("<mark>" + html.EscapeString(body[match.start:match.end]) + "</mark>")
+		at = match.end
+	}
+	sb.WriteString(html.EscapeString(body[at:window.end]))
+	if window.end < len(body) {
+		sb.WriteString("…")
+	}
+	return sb.String()
+}
+
+// runeBoundary clamps the offset i to body and moves it back to the start
+// of a character.
+func runeBoundary(body string, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	if i >= len(body) {
+		return len(body)
+	}
+	for i > 0 && !utf8.RuneStart(body[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"strings"
+)
+
+// MySQL ranks and matches with the FULLTEXT index of the body, Postgres with
+// the GIN index over its tsvector. Both are created by migration
+// 0012_add_chats_body_fulltext.
+const (
+	searchMySQL         = `MATCH(body) AGAINST(? IN BOOLEAN MODE)`
+	searchScorePostgres = `ts_rank(to_tsvector('simple', body), to_tsquery('simple', ?))`
+	searchMatchPostgres = `to_tsvector('simple', body) @@ to_tsquery('simple', ?)`
+)
+
+// chatSearcher searches the chats of a SQL database.
+type chatSearcher struct {
+	chats *chatRepo
+}
+
+func (m *chatSearcher) Search(ctx context.Context, request SearchRequest) (*SearchPage, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	request.Filter.tenant = TenantFromContext(ctx)
+	query, args := buildSearchQuery(m.chats.driver, request)
+
+	rows, err := m.chats.db.QueryContext(ctx, m.chats.rebind(query), args...)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	hits := make([]SearchHit, 0)
+	for rows.Next() {
+		var hit SearchHit
+		if scanErr := scanChat(scoredRow{rows: rows, score: &hit.Score}, &hit.Chat); scanErr != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get chat: %s", scanErr.Error()))
+		}
+		hit.Tenant = request.Filter.tenant
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return newSearchPage(hits, request), nil
+}
+
+// scoredRow reads the score selected after chatColumns.
+type scoredRow struct {
+	rows  *sql.Rows
+	score *float64
+}
+
+func (r scoredRow) Scan(dest ...interface{}) error {
+	return r.rows.Scan(append(dest, r.score)...)
+}
+
+// buildSearchQuery selects the chats matching request, best first. An extra
+// row is fetched to tell whether another page exists.
+func buildSearchQuery(driver string, request SearchRequest) (string, []interface{}) {
+	where, args := filterConditions(request.Filter)
+
+	score, match, text := searchMySQL, searchMySQL, mysqlSearchText(request.Terms)
+	if driver == DriverPostgres {
+		score, match, text = searchScorePostgres, searchMatchPostgres, postgresSearchText(request.Terms)
+	}
+	where = append(where, match)
+	args = append([]interface{}{text}, append(args, text, request.Limit+1, request.offset)...)
+
+	query := "SELECT " + chatColumns + ", " + score + " AS score FROM chats WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY score DESC, created_at DESC, id DESC LIMIT ? OFFSET ?;"
+	return query, args
+}
+
+// mysqlSearchText requires every term in boolean mode. A phrase can't end
+// with a prefix there, its last word is required on its own instead.
+func mysqlSearchText(terms []SearchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		words := term.Words
+		if term.Prefix {
+			last := words[len(words)-1]
+			words = words[:len(words)-1]
+			parts = append(parts, "+"+last+"*")
+		}
+		switch len(words) {
+		case 0:
+		case 1:
+			parts = append(parts, "+"+words[0])
+		default:
+			parts = append(parts, `+"`+strings.Join(words, " ")+`"`)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// postgresSearchText requires every term, phrases as words following each
+// other.
+func postgresSearchText(terms []SearchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		words := append([]string(nil), term.Words...)
+		if term.Prefix {
+			words[len(words)-1] += ":*"
+		}
+		if len(words) == 1 {
+			parts = append(parts, words[0])
+		} else {
+			parts = append(parts, "("+strings.Join(words, " <-> ")+")")
+		}
+	}
+	return strings.Join(parts, " & ")
+}
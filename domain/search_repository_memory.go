@@ -0,0 +1,135 @@
+package domain
+
+import (
+	"context"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"math"
+	"sort"
+	"strings"
+)
+
+// searchIndex is an inverted index of the words of the bodies: how many
+// times each word is in each chat.
+type searchIndex map[string]map[int64]int
+
+func (s searchIndex) add(chat Chat) {
+	for _, word := range tokenize(chat.Body) {
+		if s[word.text] == nil {
+			s[word.text] = make(map[int64]int)
+		}
+		s[word.text][chat.Id]++
+	}
+}
+
+func (s searchIndex) remove(chat Chat) {
+	for _, word := range tokenize(chat.Body) {
+		delete(s[word.text], chat.Id)
+		if len(s[word.text]) == 0 {
+			delete(s, word.text)
+		}
+	}
+}
+
+// candidates are the chats holding the first word of term, or a word it
+// starts with for a single prefix.
+func (s searchIndex) candidates(term SearchTerm) map[int64]bool {
+	found := make(map[int64]bool)
+	if term.Prefix && len(term.Words) == 1 {
+		for word, chats := range s {
+			if strings.HasPrefix(word, term.Words[0]) {
+				for id := range chats {
+					found[id] = true
+				}
+			}
+		}
+		return found
+	}
+	for id := range s[term.Words[0]] {
+		found[id] = true
+	}
+	return found
+}
+
+// memorySearcher searches the chats of a memoryChatRepo through its index.
+type memorySearcher struct {
+	chats *memoryChatRepo
+}
+
+// Search ranks the chats by tf-idf: words found more often in a chat, and
+// in fewer chats overall, weigh more.
+func (m *memorySearcher) Search(ctx context.Context, request SearchRequest) (*SearchPage, ChatErr) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	request.Filter.tenant = TenantFromContext(ctx)
+
+	m.chats.mu.RLock()
+	defer m.chats.mu.RUnlock()
+
+	total := float64(len(m.chats.chats))
+	var matched map[int64]bool
+	weights := make([]float64, len(request.Terms))
+	for i, term := range request.Terms {
+		candidates := m.chats.index.candidates(term)
+		weights[i] = math.Log(1 + total/float64(len(candidates)+1))
+		if matched == nil {
+			matched = candidates
+			continue
+		}
+		for id := range matched {
+			if !candidates[id] {
+				delete(matched, id)
+			}
+		}
+	}
+
+	hits := make([]SearchHit, 0)
+	for id := range matched {
+		chat := m.chats.chats[id]
+		if !request.Filter.matches(chat) {
+			continue
+		}
+		if score := scoreChat(chat.Body, request.Terms, weights); score > 0 {
+			hits = append(hits, SearchHit{Chat: chat, Score: score})
+		}
+	}
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		if !hits[a].CreatedAt.Equal(hits[b].CreatedAt) {
+			return hits[a].CreatedAt.After(hits[b].CreatedAt)
+		}
+		return hits[a].Id > hits[b].Id
+	})
+
+	if request.offset >= len(hits) {
+		hits = hits[:0]
+	} else {
+		hits = hits[request.offset:]
+	}
+	if len(hits) > request.Limit+1 {
+		hits = hits[:request.Limit+1]
+	}
+	return newSearchPage(hits, request), nil
+}
+
+// scoreChat weighs the matches of every term in body, or gives 0 when one
+// of them is missing, as a phrase whose words aren't consecutive.
+func scoreChat(body string, terms []SearchTerm, weights []float64) float64 {
+	tokens := tokenize(body)
+	var score float64
+	for i, term := range terms {
+		count := 0
+		for at := range tokens {
+			if term.matchAt(tokens, at) {
+				count++
+			}
+		}
+		if count == 0 {
+			return 0
+		}
+		score += (1 + math.Log(float64(count))) * weights[i]
+	}
+	return score
+}
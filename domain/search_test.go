@@ -0,0 +1,137 @@
+package domain
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []SearchTerm
+	}{
+		{name: "Words", query: "Hello  World", want: []SearchTerm{{Words: []string{"hello"}}, {Words: []string{"world"}}}},
+		{name: "Phrase", query: `see "Green Room" soon`, want: []SearchTerm{{Words: []string{"see"}}, {Words: []string{"green", "room"}}, {Words: []string{"soon"}}}},
+		{name: "Prefix", query: "meet*", want: []SearchTerm{{Words: []string{"meet"}, Prefix: true}}},
+		{name: "Punctuation", query: "e-mail* +hello!", want: []SearchTerm{{Words: []string{"e", "mail"}, Prefix: true}, {Words: []string{"hello"}}}},
+		{name: "Unclosed Quote", query: `"green room`, want: []SearchTerm{{Words: []string{"green", "room"}}}},
+		{name: "Unicode", query: "Café ÜBER", want: []SearchTerm{{Words: []string{"café"}}, {Words: []string{"über"}}}},
+		{name: "No Words", query: `*** "" -`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.EqualValues(t, tt.want, parseSearchQuery(tt.query))
+		})
+	}
+}
+
+func TestNewSearchRequest(t *testing.T) {
+	request, err := NewSearchRequest(url.Values{"q": {" pizza "}, "sender": {"0823-2323-1"}, "since": {"2021-05-01"}}, PageRequest{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "pizza", request.Query)
+	assert.EqualValues(t, "+6282323231", request.Filter.Sender)
+	assert.EqualValues(t, DefaultPageLimit, request.Limit)
+
+	for name, query := range map[string]url.Values{
+		"Missing q":   {"sender": {"+6282323231"}},
+		"No Words":    {"q": {"***"}},
+		"Unknown":     {"q": {"pizza"}, "sort": {"id"}},
+		"Bad Sender":  {"q": {"pizza"}, "sender": {"pizza"}},
+		"Bad Range":   {"q": {"pizza"}, "since": {"2021-05-02"}, "until": {"2021-05-01"}},
+		"Many Terms":  {"q": {"a b c d e f g h i j k"}},
+		"Bad Cursor":  {"q": {"pizza"}, "cursor": {"nope"}},
+		"Bad Cursor2": {"q": {"pizza"}, "cursor": {"eyJvZmZzZXQiOjB9"}},
+	} {
+		page := PageRequest{Cursor: query.Get("cursor")}
+		query.Del("cursor")
+		_, err := NewSearchRequest(query, page)
+		if assert.NotNil(t, err, name) {
+			assert.EqualValues(t, http.StatusBadRequest, err.Status(), name)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	terms := parseSearchQuery(`pizza "green room"`)
+	assert.EqualValues(t, []string{"<mark>Pizza</mark> in the <mark>green  room</mark>"}, highlight("Pizza in the green  room", terms))
+	assert.EqualValues(t, []string{"&lt;b&gt;<mark>pizza</mark>&lt;/b&gt;"}, highlight("<b>pizza</b>", terms))
+
+	//matches far apart get fragments of their own
+	long := "pizza " + strings.Repeat("filler ", 20) + "pizza " + strings.Repeat("filler ", 20) + "pizza " + strings.Repeat("filler ", 20) + "pizza"
+	fragments := highlight(long, terms)
+	if assert.Len(t, fragments, maxHighlights) {
+		assert.Regexp(t, "^<mark>pizza</mark> filler.*…$", fragments[0])
+		assert.Regexp(t, "^….*<mark>pizza</mark>.*…$", fragments[1])
+	}
+
+	//the start of the body when the storage matched words differently
+	assert.EqualValues(t, []string{"nothing to see"}, highlight("nothing to see", terms))
+
+	//fragments are cut between characters
+	fragments = highlight("über "+strings.Repeat("ü", 60), parseSearchQuery("über"))
+	if assert.Len(t, fragments, 1) {
+		assert.True(t, utf8.ValidString(fragments[0]))
+		assert.EqualValues(t, "<mark>über</mark> "+strings.Repeat("ü", 19)+"…", fragments[0])
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	terms := parseSearchQuery(`pizza "green room" meet* e-mail*`)
+	assert.EqualValues(t, `+pizza +"green room" +meet* +mail* +e`, mysqlSearchText(terms))
+	assert.EqualValues(t, `pizza & (green <-> room) & meet:* & (e <-> mail:*)`, postgresSearchText(terms))
+}
+
+func TestChatSearcher_Search(t *testing.T) {
+	for _, driver := range []string{DriverMySQL, DriverPostgres} {
+		t.Run(driver, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			searcher := &chatSearcher{chats: &chatRepo{db: db, driver: driver}}
+
+			text, match := "+pizza", "MATCH\\(body\\) AGAINST\\(\\? IN BOOLEAN MODE\\)"
+			if driver == DriverPostgres {
+				text, match = "pizza", "to_tsvector\\('simple', body\\) @@ to_tsquery\\('simple', \\$4\\)"
+			}
+			rows := sqlmock.NewRows(append(chatRowColumns, "score")).
+				AddRow(2, sender, receiver, "pizza pizza", createdAt, nil, nil, 0, 1, "", "", nil, nil, 2.5).
+				AddRow(1, sender, receiver, "pizza tonight", createdAt, nil, nil, 0, 1, "", "", nil, nil, 1.5)
+			mock.ExpectQuery("SELECT (.+), (.+) AS score FROM chats WHERE tenant_id = (.+) AND deleted_at IS NULL AND sender = (.+) AND "+match+" ORDER BY score DESC, created_at DESC, id DESC LIMIT (.+) OFFSET (.+);").
+				WithArgs(text, DefaultTenant, "+6282323231", text, 2, 0).
+				WillReturnRows(rows)
+
+			request, _ := NewSearchRequest(url.Values{"q": {"pizza"}, "sender": {"+6282323231"}}, PageRequest{Limit: 1})
+			page, searchErr := searcher.Search(context.Background(), request)
+			assert.Nil(t, searchErr)
+			if assert.Len(t, page.Hits, 1) {
+				assert.EqualValues(t, 2, page.Hits[0].Id)
+				assert.EqualValues(t, 2.5, page.Hits[0].Score)
+				assert.EqualValues(t, DefaultTenant, page.Hits[0].Tenant)
+				assert.EqualValues(t, []string{"<mark>pizza</mark> <mark>pizza</mark>"}, page.Hits[0].Highlights)
+			}
+			assert.True(t, page.HasMore)
+			assert.NotEmpty(t, page.NextCursor)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestNewSearcher(t *testing.T) {
+	for _, driver := range []string{DriverMySQL, DriverPostgres, DriverMemory} {
+		repo, _ := NewRepository(driver)
+		searcher, err := NewSearcher(repo)
+		assert.Nil(t, err)
+		assert.NotNil(t, searcher)
+	}
+}
@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/utils"
+	"regexp"
+)
+
+// DefaultTenant owns the chats of deployments serving a single workspace,
+// and every chat stored before tenants existed.
+const DefaultTenant = "default"
+
+// tenantRegexp keeps tenant ids short and safe in logs and URLs.
+var tenantRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+func ValidateTenant(tenant string) utils.ChatErr {
+	if !tenantRegexp.MatchString(tenant) {
+		return utils.ErrorKind(utils.BadRequestError, "Invalid Tenant, should be up to 64 lower case letters, digits, _ and -")
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx whose repository calls only reach the
+// data of tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext is the tenant the repositories scope their queries by,
+// DefaultTenant when ctx names none.
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
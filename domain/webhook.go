@@ -0,0 +1,157 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/utils"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Webhook asks for the chat events of the given types to be POSTed to Url.
+// An empty Receiver means every chat, otherwise only the chats sent to it.
+type Webhook struct {
+	Id       int64    `json:"id"`
+	Url      string   `json:"url"`
+	Events   []string `json:"events"`
+	Receiver string   `json:"receiver,omitempty"`
+	// Secret signs the deliveries. It is only returned when the webhook is
+	// created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Tenant only gets the events of its own chats, set by the repository.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Delivery states of a WebhookDelivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is an event sent, or to be sent, to a webhook. Payload is
+// the signed body, kept so every attempt sends the same bytes.
+type WebhookDelivery struct {
+	Id         int64      `json:"id"`
+	WebhookId  int64      `json:"webhook_id"`
+	EventId    int64      `json:"event_id"`
+	EventType  string     `json:"event_type"`
+	Payload    string     `json:"-"`
+	Status     string     `json:"status"`
+	Attempts   int        `json:"attempts"`
+	StatusCode int        `json:"status_code,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	NextTryAt  *time.Time `json:"next_try_at,omitempty"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+
+	// Url and Secret are those of the webhook, filled in by Due for the
+	// sender.
+	Url    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// MinWebhookSecretLength keeps signatures from being guessed.
+const MinWebhookSecretLength = 16
+
+var webhookEvents = []string{ChatCreatedEvent, ChatUpdatedEvent, ChatDeletedEvent, ChatRestoredEvent, ChatReadEvent}
+
+func (w *Webhook) Validate() utils.ChatErr {
+	w.Url = strings.TrimSpace(w.Url)
+	w.Receiver = strings.TrimSpace(w.Receiver)
+
+	if w.Url == "" {
+		return utils.ErrorKind(utils.UnprocessableEntityError, "Required Url")
+	}
+	target, err := url.Parse(w.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return utils.ErrorKind(utils.UnprocessableEntityError, "Invalid Url")
+	}
+
+	if len(w.Events) == 0 {
+		return utils.ErrorKind(utils.UnprocessableEntityError, "Required Events")
+	}
+	seen := make(map[string]bool)
+	events := make([]string, 0, len(w.Events))
+	for _, event := range w.Events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if !isWebhookEvent(event) {
+			return utils.ErrorKind(utils.UnprocessableEntityError, fmt.Sprintf("Invalid Event %q, should be one of %s", event, strings.Join(webhookEvents, ", ")))
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	w.Events = events
+
+	if w.Receiver = strings.TrimSpace(w.Receiver); w.Receiver != "" {
+		receiver, err := NormalizePhone(w.Receiver)
+		if err != nil {
+			return utils.ErrorKind(utils.UnprocessableEntityError, "Invalid Receiver Phone Number")
+		}
+		w.Receiver = receiver
+	}
+	if w.Secret != "" && len(w.Secret) < MinWebhookSecretLength {
+		return utils.ErrorKind(utils.UnprocessableEntityError, fmt.Sprintf("Secret should be at least %d characters", MinWebhookSecretLength))
+	}
+	return nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, known := range webhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches tells whether an event about chat should be sent to the webhook.
+func (w *Webhook) Matches(eventType string, chat Chat) bool {
+	if w.Receiver != "" && w.Receiver != chat.Receiver {
+		return false
+	}
+	for _, event := range w.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type webhookRepoInterface interface {
+	Create(ctx context.Context, webhook *Webhook) (*Webhook, utils.ChatErr)
+	Get(ctx context.Context, Id int64) (*Webhook, utils.ChatErr)
+	GetAll(ctx context.Context) ([]Webhook, utils.ChatErr)
+	Update(ctx context.Context, webhook *Webhook) (*Webhook, utils.ChatErr)
+	Delete(ctx context.Context, Id int64) utils.ChatErr
+
+	// Enqueue stores pending deliveries, skipping those already stored for
+	// the same webhook and event.
+	Enqueue(ctx context.Context, deliveries []WebhookDelivery) utils.ChatErr
+	// Due lists the pending deliveries whose next try is at or before now.
+	Due(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, utils.ChatErr)
+	// SaveAttempt stores the outcome of an attempt made on a delivery.
+	SaveAttempt(ctx context.Context, delivery *WebhookDelivery) utils.ChatErr
+	// Deliveries lists the deliveries of a webhook, newest first.
+	Deliveries(ctx context.Context, webhookId int64, limit int) ([]WebhookDelivery, utils.ChatErr)
+}
+
+// WebhookRepo is set next to ChatRepo, from the same storage.
+var WebhookRepo webhookRepoInterface
+
+// NewWebhookRepository returns the webhooks stored alongside chats.
+func NewWebhookRepository(chats chatRepoInterface) (webhookRepoInterface, error) {
+	switch repo := chats.(type) {
+	case *chatRepo:
+		return &webhookRepo{chats: repo}, nil
+	case *memoryChatRepo:
+		return NewMemoryWebhookRepository(), nil
+	}
+	return nil, fmt.Errorf("no webhook storage for chat repository %T", chats)
+}
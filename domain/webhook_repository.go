@@ -0,0 +1,253 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"strings"
+	"time"
+)
+
+const (
+	webhookColumns  = `id, url, event_types, receiver, created_at, updated_at`
+	deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, status_code, last_error, created_at, next_try_at, sent_at`
+
+	queryInsertWebhook  = `INSERT INTO webhooks(tenant_id, url, event_types, receiver, secret, created_at, updated_at) VALUES (?,?,?,?,?,?,?);`
+	queryGetWebhook     = `SELECT ` + webhookColumns + ` FROM webhooks WHERE id=? AND tenant_id=?;`
+	queryGetAllWebhooks = `SELECT ` + webhookColumns + ` FROM webhooks WHERE tenant_id=? ORDER BY id;`
+	queryUpdateWebhook  = `UPDATE webhooks SET url=?, event_types=?, receiver=?, updated_at=? WHERE id=? AND tenant_id=?;`
+	queryRotateSecret   = `UPDATE webhooks SET secret=? WHERE id=?;`
+	queryDeleteWebhook  = `DELETE FROM webhooks WHERE id=? AND tenant_id=?;`
+	//deliveries are reached through a webhook of the tenant, only the
+	//sender works through those of every tenant
+	//a webhook gets each event once, however often it is enqueued
+	queryEnqueueDelivery         = `INSERT IGNORE INTO webhook_deliveries(webhook_id, event_id, event_type, payload, status, created_at, next_try_at) VALUES (?,?,?,?,?,?,?);`
+	queryEnqueueDeliveryPostgres = `INSERT INTO webhook_deliveries(webhook_id, event_id, event_type, payload, status, created_at, next_try_at) VALUES (?,?,?,?,?,?,?) ON CONFLICT (webhook_id, event_id) DO NOTHING;`
+	queryDueDeliveries           = `SELECT d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.status_code, d.last_error, d.created_at, d.next_try_at, d.sent_at, w.url, w.secret FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id WHERE d.status=? AND d.next_try_at <= ? ORDER BY d.id LIMIT ?;`
+	querySaveAttempt             = `UPDATE webhook_deliveries SET status=?, attempts=?, status_code=?, last_error=?, next_try_at=?, sent_at=? WHERE id=?;`
+	queryGetDeliveries           = `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id=? ORDER BY id DESC LIMIT ?;`
+)
+
+// webhookEventsDivider joins the event types of a webhook in one column.
+const webhookEventsDivider = ","
+
+// webhookRepo stores webhooks in the database of the chats.
+type webhookRepo struct {
+	chats *chatRepo
+}
+
+func (m *webhookRepo) Create(ctx context.Context, webhook *Webhook) (*Webhook, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	tenant := TenantFromContext(ctx)
+	args := []interface{}{tenant, webhook.Url, strings.Join(webhook.Events, webhookEventsDivider), webhook.Receiver, webhook.Secret, now, now}
+	var id int64
+	if m.chats.driver == DriverPostgres {
+		query := strings.TrimSuffix(queryInsertWebhook, ";") + " RETURNING id;"
+		if err := m.chats.db.QueryRowContext(ctx, m.chats.rebind(query), args...).Scan(&id); err != nil {
+			return nil, parseError(ctx, err)
+		}
+	} else {
+		result, err := m.chats.db.ExecContext(ctx, m.chats.rebind(queryInsertWebhook), args...)
+		if err != nil {
+			return nil, parseError(ctx, err)
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to save webhook: %s", err.Error()))
+		}
+	}
+
+	created := *webhook
+	created.Id = id
+	created.CreatedAt = now
+	created.UpdatedAt = now
+	created.Tenant = tenant
+	return &created, nil
+}
+
+func (m *webhookRepo) Get(ctx context.Context, webhookId int64) (*Webhook, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tenant := TenantFromContext(ctx)
+	var webhook Webhook
+	if err := scanWebhook(m.chats.db.QueryRowContext(ctx, m.chats.rebind(queryGetWebhook), webhookId, tenant), &webhook); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	webhook.Tenant = tenant
+	return &webhook, nil
+}
+
+func (m *webhookRepo) GetAll(ctx context.Context) ([]Webhook, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tenant := TenantFromContext(ctx)
+	rows, err := m.chats.db.QueryContext(ctx, m.chats.rebind(queryGetAllWebhooks), tenant)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	results := make([]Webhook, 0)
+	for rows.Next() {
+		var webhook Webhook
+		if err := scanWebhook(rows, &webhook); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get webhook: %s", err.Error()))
+		}
+		webhook.Tenant = tenant
+		results = append(results, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return results, nil
+}
+
+// Update replaces the target of a webhook, and its secret when one is given.
+func (m *webhookRepo) Update(ctx context.Context, webhook *Webhook) (*Webhook, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.chats.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to start webhook update: %s", err.Error()))
+	}
+	defer tx.Rollback()
+
+	tenant := TenantFromContext(ctx)
+	result, err := tx.ExecContext(ctx, m.chats.rebind(queryUpdateWebhook), webhook.Url, strings.Join(webhook.Events, webhookEventsDivider), webhook.Receiver, time.Now(), webhook.Id, tenant)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	if err := checkAffected(result); err != nil {
+		return nil, err
+	}
+	if webhook.Secret != "" {
+		if _, err := tx.ExecContext(ctx, m.chats.rebind(queryRotateSecret), webhook.Secret, webhook.Id); err != nil {
+			return nil, parseError(ctx, err)
+		}
+	}
+
+	var updated Webhook
+	if err := scanWebhook(tx.QueryRowContext(ctx, m.chats.rebind(queryGetWebhook), webhook.Id, tenant), &updated); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	updated.Tenant = tenant
+	if err := tx.Commit(); err != nil {
+		return nil, ErrorKind(InternalServerError, fmt.Sprintf("error when trying to update webhook: %s", err.Error()))
+	}
+	return &updated, nil
+}
+
+// Delete removes a webhook together with its deliveries.
+func (m *webhookRepo) Delete(ctx context.Context, webhookId int64) ChatErr {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.chats.db.ExecContext(ctx, m.chats.rebind(queryDeleteWebhook), webhookId, TenantFromContext(ctx))
+	if err != nil {
+		return parseError(ctx, err)
+	}
+	return checkAffected(result)
+}
+
+func (m *webhookRepo) Enqueue(ctx context.Context, deliveries []WebhookDelivery) ChatErr {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	query := queryEnqueueDelivery
+	if m.chats.driver == DriverPostgres {
+		query = queryEnqueueDeliveryPostgres
+	}
+	for _, delivery := range deliveries {
+		if _, err := m.chats.db.ExecContext(ctx, m.chats.rebind(query), delivery.WebhookId, delivery.EventId, delivery.EventType, delivery.Payload, DeliveryPending, delivery.CreatedAt, delivery.NextTryAt); err != nil {
+			return parseError(ctx, err)
+		}
+	}
+	return nil
+}
+
+func (m *webhookRepo) Due(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.chats.db.QueryContext(ctx, m.chats.rebind(queryDueDeliveries), DeliveryPending, now, limit)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	results := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := scanDelivery(rows, &delivery, &delivery.Url, &delivery.Secret); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get webhook delivery: %s", err.Error()))
+		}
+		results = append(results, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return results, nil
+}
+
+func (m *webhookRepo) SaveAttempt(ctx context.Context, delivery *WebhookDelivery) ChatErr {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := m.chats.db.ExecContext(ctx, m.chats.rebind(querySaveAttempt), delivery.Status, delivery.Attempts, delivery.StatusCode, delivery.LastError, delivery.NextTryAt, delivery.SentAt, delivery.Id)
+	if err != nil {
+		return parseError(ctx, err)
+	}
+	return checkAffected(result)
+}
+
+func (m *webhookRepo) Deliveries(ctx context.Context, webhookId int64, limit int) ([]WebhookDelivery, ChatErr) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.chats.db.QueryContext(ctx, m.chats.rebind(queryGetDeliveries), webhookId, limit)
+	if err != nil {
+		return nil, parseError(ctx, err)
+	}
+	defer rows.Close()
+
+	results := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := scanDelivery(rows, &delivery); err != nil {
+			return nil, ErrorKind(InternalServerError, fmt.Sprintf("Error when trying to get webhook delivery: %s", err.Error()))
+		}
+		results = append(results, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, parseError(ctx, err)
+	}
+	return results, nil
+}
+
+func scanWebhook(row rowScanner, webhook *Webhook) error {
+	var events string
+	if err := row.Scan(&webhook.Id, &webhook.Url, &events, &webhook.Receiver, &webhook.CreatedAt, &webhook.UpdatedAt); err != nil {
+		return err
+	}
+	webhook.Events = strings.Split(events, webhookEventsDivider)
+	return nil
+}
+
+// scanDelivery reads the delivery columns followed by any extra ones.
+func scanDelivery(row rowScanner, delivery *WebhookDelivery, extra ...interface{}) error {
+	var lastError *string
+	dest := append([]interface{}{&delivery.Id, &delivery.WebhookId, &delivery.EventId, &delivery.EventType, &delivery.Payload, &delivery.Status,
+		&delivery.Attempts, &delivery.StatusCode, &lastError, &delivery.CreatedAt, &delivery.NextTryAt, &delivery.SentAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	if lastError != nil {
+		delivery.LastError = *lastError
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package domain
+
+import (
+	"context"
+	. "github.com/SemmiDev/lets-tests/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryWebhookRepo keeps webhooks in process memory, next to the chats of
+// memoryChatRepo.
+type memoryWebhookRepo struct {
+	mu         sync.RWMutex
+	webhooks   map[int64]Webhook
+	lastId     int64
+	deliveries []WebhookDelivery
+	deliveryId int64
+}
+
+func NewMemoryWebhookRepository() webhookRepoInterface {
+	return &memoryWebhookRepo{webhooks: make(map[int64]Webhook)}
+}
+
+func (m *memoryWebhookRepo) Create(ctx context.Context, webhook *Webhook) (*Webhook, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastId++
+	stored := *webhook
+	stored.Id = m.lastId
+	stored.Events = append([]string(nil), webhook.Events...)
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = stored.CreatedAt
+	stored.Tenant = TenantFromContext(ctx)
+	m.webhooks[stored.Id] = stored
+	return &stored, nil
+}
+
+func (m *memoryWebhookRepo) Get(ctx context.Context, webhookId int64) (*Webhook, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	webhook, ok := m.lookup(ctx, webhookId)
+	if !ok {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	webhook.Secret = ""
+	return &webhook, nil
+}
+
+func (m *memoryWebhookRepo) GetAll(ctx context.Context) ([]Webhook, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tenant := TenantFromContext(ctx)
+	results := make([]Webhook, 0, len(m.webhooks))
+	for _, webhook := range m.webhooks {
+		if webhook.Tenant != tenant {
+			continue
+		}
+		webhook.Secret = ""
+		results = append(results, webhook)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Id < results[j].Id })
+	return results, nil
+}
+
+func (m *memoryWebhookRepo) Update(ctx context.Context, webhook *Webhook) (*Webhook, ChatErr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.lookup(ctx, webhook.Id)
+	if !ok {
+		return nil, ErrorKind(NotFoundError, "no record matching given id")
+	}
+	current.Url = webhook.Url
+	current.Events = append([]string(nil), webhook.Events...)
+	current.Receiver = webhook.Receiver
+	if webhook.Secret != "" {
+		current.Secret = webhook.Secret
+	}
+	current.UpdatedAt = time.Now()
+	m.webhooks[webhook.Id] = current
+
+	current.Secret = ""
+	return &current, nil
+}
+
+func (m *memoryWebhookRepo) Delete(ctx context.Context, webhookId int64) ChatErr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.lookup(ctx, webhookId); !ok {
+		return ErrorKind(NotFoundError, "no record matching given id")
+	}
+	delete(m.webhooks, webhookId)
+
+	kept := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookId != webhookId {
+			kept = append(kept, delivery)
+		}
+	}
+	m.deliveries = kept
+	return nil
+}
+
+// lookup finds a webhook of the tenant of ctx, the caller holds the lock.
+func (m *memoryWebhookRepo) lookup(ctx context.Context, webhookId int64) (Webhook, bool) {
+	webhook, ok := m.webhooks[webhookId]
+	if !ok || webhook.Tenant != TenantFromContext(ctx) {
+		return Webhook{}, false
+	}
+	return webhook, true
+}
+
+func (m *memoryWebhookRepo) Enqueue(ctx context.Context, deliveries []WebhookDelivery) ChatErr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, delivery := range deliveries {
+		if _, ok := m.webhooks[delivery.WebhookId]; !ok || m.enqueued(delivery.WebhookId, delivery.EventId) {
+			continue
+		}
+		m.deliveryId++
+		delivery.Id = m.deliveryId
+		delivery.Status = DeliveryPending
+		delivery.Url, delivery.Secret = "", ""
+		m.deliveries = append(m.deliveries, delivery)
+	}
+	return nil
+}
+
+func (m *memoryWebhookRepo) enqueued(webhookId, eventId int64) bool {
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookId == webhookId && delivery.EventId == eventId {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryWebhookRepo) Due(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]WebhookDelivery, 0)
+	for _, delivery := range m.deliveries {
+		if len(results) == limit {
+			break
+		}
+		if delivery.Status != DeliveryPending || delivery.NextTryAt == nil || delivery.NextTryAt.After(now) {
+			continue
+		}
+		webhook := m.webhooks[delivery.WebhookId]
+		delivery.Url, delivery.Secret = webhook.Url, webhook.Secret
+		results = append(results, delivery)
+	}
+	return results, nil
+}
+
+func (m *memoryWebhookRepo) SaveAttempt(ctx context.Context, delivery *WebhookDelivery) ChatErr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.deliveries {
+		if m.deliveries[i].Id == delivery.Id {
+			stored := &m.deliveries[i]
+			stored.Status = delivery.Status
+			stored.Attempts = delivery.Attempts
+			stored.StatusCode = delivery.StatusCode
+			stored.LastError = delivery.LastError
+			stored.NextTryAt = delivery.NextTryAt
+			stored.SentAt = delivery.SentAt
+			return nil
+		}
+	}
+	return ErrorKind(NotFoundError, "no record matching given id")
+}
+
+func (m *memoryWebhookRepo) Deliveries(ctx context.Context, webhookId int64, limit int) ([]WebhookDelivery, ChatErr) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := make([]WebhookDelivery, 0)
+	for i := len(m.deliveries) - 1; i >= 0 && len(results) < limit; i-- {
+		if m.deliveries[i].WebhookId == webhookId {
+			results = append(results, m.deliveries[i])
+		}
+	}
+	return results, nil
+}
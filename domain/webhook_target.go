@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/utils"
+	"net"
+	"net/url"
+)
+
+// AllowPrivateWebhookTargets lets webhooks reach loopback, private and link
+// local addresses, for development against a local receiver. It is off by
+// default so webhooks can't be used to reach the internal network.
+var AllowPrivateWebhookTargets bool
+
+// LookupWebhookHost resolves the host of a webhook Url when it is
+// registered. Tests replace it to stay off the network.
+var LookupWebhookHost = net.DefaultResolver.LookupIPAddr
+
+// privateNetworks are the ranges not reachable from the internet, or that
+// reach this host or its cloud metadata, beyond what net.IP tells apart.
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"fc00::/7",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// IsPublicIP tells whether ip is an address of the internet, and not of
+// this host, its link or a private network.
+func IsPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckWebhookTarget refuses a webhook Url whose host is, or resolves to, an
+// address that isn't public. The sender checks again when it connects, as
+// the name may resolve elsewhere by then.
+func CheckWebhookTarget(ctx context.Context, rawUrl string) utils.ChatErr {
+	if AllowPrivateWebhookTargets {
+		return nil
+	}
+	target, err := url.Parse(rawUrl)
+	if err != nil {
+		return utils.ErrorKind(utils.UnprocessableEntityError, "Invalid Url")
+	}
+	host := target.Hostname()
+	addresses := []net.IPAddr{{IP: net.ParseIP(host)}}
+	if addresses[0].IP == nil {
+		addresses, err = LookupWebhookHost(ctx, host)
+		if err != nil || len(addresses) == 0 {
+			return utils.ErrorKind(utils.UnprocessableEntityError, fmt.Sprintf("Url host %q can't be resolved", host))
+		}
+	}
+	for _, address := range addresses {
+		if !IsPublicIP(address.IP) {
+			return utils.ErrorKind(utils.UnprocessableEntityError, "Url should point at a public address")
+		}
+	}
+	return nil
+}
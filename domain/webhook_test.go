@@ -0,0 +1,211 @@
+package domain
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWebhook_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook Webhook
+		message string
+	}{
+		{"missing url", Webhook{Events: []string{"created"}}, "Required Url"},
+		{"relative url", Webhook{Url: "/hooks", Events: []string{"created"}}, "Invalid Url"},
+		{"other scheme", Webhook{Url: "ftp://example.com", Events: []string{"created"}}, "Invalid Url"},
+		{"missing events", Webhook{Url: "https://example.com/hooks"}, "Required Events"},
+		{"unknown event", Webhook{Url: "https://example.com/hooks", Events: []string{"seen"}}, `Invalid Event "seen", should be one of created, updated, deleted, restored, read`},
+		{"invalid receiver", Webhook{Url: "https://example.com/hooks", Events: []string{"created"}, Receiver: "abc"}, "Invalid Receiver Phone Number"},
+		{"short secret", Webhook{Url: "https://example.com/hooks", Events: []string{"created"}, Secret: "short"}, "Secret should be at least 16 characters"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.webhook.Validate()
+			if assert.NotNil(t, err) {
+				assert.EqualValues(t, tc.message, err.Message())
+			}
+		})
+	}
+
+	webhook := Webhook{Url: " https://example.com/hooks ", Events: []string{"Created", " updated", "created"}, Receiver: "+6282323232"}
+	assert.Nil(t, webhook.Validate())
+	assert.EqualValues(t, "https://example.com/hooks", webhook.Url)
+	assert.EqualValues(t, []string{"created", "updated"}, webhook.Events)
+}
+
+func TestCheckWebhookTarget(t *testing.T) {
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) { LookupWebhookHost = lookup }(LookupWebhookHost)
+	LookupWebhookHost = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		case "internal.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.0.0.7")}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+
+	for _, target := range []string{"https://example.com/hooks", "http://93.184.216.34:8080/hooks", "https://[2606:2800:220:1::]/hooks"} {
+		assert.Nil(t, CheckWebhookTarget(context.Background(), target), target)
+	}
+	for _, target := range []string{
+		"http://127.0.0.1/hooks",
+		"http://localhost.invalid/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.1.2.3/hooks",
+		"http://172.16.0.1/hooks",
+		"http://192.168.1.1/hooks",
+		"http://[::1]/hooks",
+		"http://[fd00::1]/hooks",
+		"http://[fe80::1]/hooks",
+		"http://0.0.0.0/hooks",
+		"https://internal.example.com/hooks",
+	} {
+		err := CheckWebhookTarget(context.Background(), target)
+		if assert.NotNil(t, err, target) {
+			assert.EqualValues(t, http.StatusUnprocessableEntity, err.Status(), target)
+		}
+	}
+
+	AllowPrivateWebhookTargets = true
+	defer func() { AllowPrivateWebhookTargets = false }()
+	assert.Nil(t, CheckWebhookTarget(context.Background(), "http://127.0.0.1/hooks"))
+}
+
+func TestWebhook_Matches(t *testing.T) {
+	chat := Chat{Sender: "+6282323231", Receiver: "+6282323232"}
+
+	all := Webhook{Events: []string{ChatCreatedEvent}}
+	assert.True(t, all.Matches(ChatCreatedEvent, chat))
+	assert.False(t, all.Matches(ChatDeletedEvent, chat))
+
+	one := Webhook{Events: []string{ChatCreatedEvent}, Receiver: "+6282323232"}
+	assert.True(t, one.Matches(ChatCreatedEvent, chat))
+	chat.Receiver = "+6282323233"
+	assert.False(t, one.Matches(ChatCreatedEvent, chat))
+}
+
+func TestMemoryWebhookRepo(t *testing.T) {
+	s := NewMemoryWebhookRepository()
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, &Webhook{Url: "https://example.com/hooks", Events: []string{ChatCreatedEvent}, Secret: "0123456789abcdef"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, created.Id)
+	assert.EqualValues(t, "0123456789abcdef", created.Secret)
+
+	//the secret is never read back
+	got, err := s.Get(ctx, created.Id)
+	assert.Nil(t, err)
+	assert.Empty(t, got.Secret)
+	all, err := s.GetAll(ctx)
+	assert.Nil(t, err)
+	if assert.Len(t, all, 1) {
+		assert.Empty(t, all[0].Secret)
+	}
+
+	updated, err := s.Update(ctx, &Webhook{Id: created.Id, Url: "https://example.com/other", Events: []string{ChatDeletedEvent}})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "https://example.com/other", updated.Url)
+	_, err = s.Update(ctx, &Webhook{Id: 42, Url: "https://example.com/other", Events: []string{ChatDeletedEvent}})
+	assert.NotNil(t, err)
+
+	now := time.Now()
+	assert.Nil(t, s.Enqueue(ctx, []WebhookDelivery{
+		{WebhookId: created.Id, EventId: 1, EventType: ChatDeletedEvent, Payload: "{}", CreatedAt: now, NextTryAt: &now},
+		{WebhookId: created.Id, EventId: 1, EventType: ChatDeletedEvent, Payload: "{}", CreatedAt: now, NextTryAt: &now},
+		{WebhookId: 42, EventId: 1, EventType: ChatDeletedEvent, Payload: "{}", CreatedAt: now, NextTryAt: &now},
+	}))
+
+	due, err := s.Due(ctx, now, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, due, 1) {
+		//the sender gets the target and the secret the update kept
+		assert.EqualValues(t, "0123456789abcdef", due[0].Secret)
+		assert.EqualValues(t, "https://example.com/other", due[0].Url)
+	}
+
+	due[0].Status = DeliveryDelivered
+	due[0].Attempts = 1
+	due[0].SentAt = &now
+	due[0].NextTryAt = nil
+	assert.Nil(t, s.SaveAttempt(ctx, &due[0]))
+	due, _ = s.Due(ctx, now, 10)
+	assert.Len(t, due, 0)
+
+	deliveries, err := s.Deliveries(ctx, created.Id, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.EqualValues(t, DeliveryDelivered, deliveries[0].Status)
+	}
+
+	//another tenant can neither see nor remove the webhook
+	other := WithTenant(ctx, "acme")
+	_, err = s.Get(other, created.Id)
+	assert.NotNil(t, err)
+	all, _ = s.GetAll(other)
+	assert.Len(t, all, 0)
+	assert.NotNil(t, s.Delete(other, created.Id))
+
+	assert.Nil(t, s.Delete(ctx, created.Id))
+	assert.NotNil(t, s.Delete(ctx, created.Id))
+	deliveries, _ = s.Deliveries(ctx, created.Id, 10)
+	assert.Len(t, deliveries, 0)
+}
+
+func TestWebhookRepo_SQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s := &webhookRepo{chats: &chatRepo{db: db, driver: DriverPostgres}}
+	ctx := context.Background()
+
+	mock.ExpectQuery("INSERT INTO webhooks\\(tenant_id, url, event_types, receiver, secret, created_at, updated_at\\) VALUES \\(\\$1,\\$2,\\$3,\\$4,\\$5,\\$6,\\$7\\) RETURNING id;").
+		WithArgs(DefaultTenant, "https://example.com/hooks", "created,deleted", "", "0123456789abcdef", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	created, chatErr := s.Create(ctx, &Webhook{Url: "https://example.com/hooks", Events: []string{"created", "deleted"}, Secret: "0123456789abcdef"})
+	assert.Nil(t, chatErr)
+	assert.EqualValues(t, 3, created.Id)
+
+	mock.ExpectQuery("SELECT id, url, event_types, receiver, created_at, updated_at FROM webhooks WHERE tenant_id=\\$1 ORDER BY id;").
+		WithArgs(DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "event_types", "receiver", "created_at", "updated_at"}).
+			AddRow(3, "https://example.com/hooks", "created,deleted", "", createdAt, createdAt))
+	all, chatErr := s.GetAll(ctx)
+	assert.Nil(t, chatErr)
+	if assert.Len(t, all, 1) {
+		assert.EqualValues(t, []string{"created", "deleted"}, all[0].Events)
+		assert.EqualValues(t, DefaultTenant, all[0].Tenant)
+	}
+
+	now := time.Now()
+	mock.ExpectExec("INSERT INTO webhook_deliveries(.+) ON CONFLICT \\(webhook_id, event_id\\) DO NOTHING;").
+		WithArgs(3, 9, "created", "{}", DeliveryPending, now, &now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.Nil(t, s.Enqueue(ctx, []WebhookDelivery{{WebhookId: 3, EventId: 9, EventType: "created", Payload: "{}", CreatedAt: now, NextTryAt: &now}}))
+
+	mock.ExpectQuery("SELECT (.+) FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id WHERE d.status=\\$1 AND d.next_try_at <= \\$2 ORDER BY d.id LIMIT \\$3;").
+		WithArgs(DeliveryPending, now, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "event_id", "event_type", "payload", "status", "attempts", "status_code", "last_error", "created_at", "next_try_at", "sent_at", "url", "secret"}).
+			AddRow(1, 3, 9, "created", "{}", DeliveryPending, 0, 0, nil, now, now, nil, "https://example.com/hooks", "0123456789abcdef"))
+	due, chatErr := s.Due(ctx, now, 50)
+	assert.Nil(t, chatErr)
+	if assert.Len(t, due, 1) {
+		assert.EqualValues(t, "https://example.com/hooks", due[0].Url)
+		assert.EqualValues(t, "0123456789abcdef", due[0].Secret)
+		assert.Empty(t, due[0].LastError)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,186 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outbox is where the dispatcher reads the recorded events from.
+type Outbox interface {
+	Pending(ctx context.Context, now time.Time, maxAttempts, limit int) ([]domain.OutboxEvent, utils.ChatErr)
+	MarkDelivered(ctx context.Context, Id int64, deliveredAt time.Time) utils.ChatErr
+	MarkFailed(ctx context.Context, Id int64, reason string, retryAt time.Time) utils.ChatErr
+	PurgeDelivered(ctx context.Context, deliveredBefore time.Time) (int64, utils.ChatErr)
+}
+
+// DeliveryHandler processes an outbox event. An error makes the dispatcher
+// try the event again later.
+type DeliveryHandler func(ctx context.Context, event Event) error
+
+// Dispatcher delivers outbox events to the registered handlers. Delivery is
+// at least once: an event is retried, for every handler, until all of them
+// succeed in the same attempt, so handlers must tolerate duplicates.
+type Dispatcher struct {
+	outbox   Outbox
+	mu       sync.RWMutex
+	handlers map[string]DeliveryHandler
+	wake     chan struct{}
+
+	// PollInterval is how long the dispatcher sleeps when it isn't notified.
+	PollInterval time.Duration
+	BatchSize    int
+	// MaxAttempts is how many times an event is tried before it is left in
+	// the outbox for someone to look at.
+	MaxAttempts int
+	// Retention is how long delivered events are kept.
+	Retention time.Duration
+}
+
+// DefaultDispatcher is set up by the app once the outbox storage is known.
+var DefaultDispatcher *Dispatcher
+
+func NewDispatcher(outbox Outbox) *Dispatcher {
+	return &Dispatcher{
+		outbox:       outbox,
+		handlers:     make(map[string]DeliveryHandler),
+		wake:         make(chan struct{}, 1),
+		PollInterval: time.Second,
+		BatchSize:    100,
+		MaxAttempts:  10,
+		Retention:    24 * time.Hour,
+	}
+}
+
+// Handle registers a handler under a name used in logs.
+func (d *Dispatcher) Handle(name string, handler DeliveryHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[name] = handler
+}
+
+// Notify tells the dispatcher an event was just recorded, so it doesn't
+// wait for the next poll.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		for {
+			delivered, err := d.Dispatch(ctx)
+			if err != nil {
+				log.Printf("dispatching outbox events: %v", err)
+			}
+			//a full batch means there may be more waiting
+			if err != nil || delivered < d.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		case <-cleanup.C:
+			if _, err := d.outbox.PurgeDelivered(ctx, time.Now().Add(-d.Retention)); err != nil {
+				log.Printf("purging delivered outbox events: %s", err.Message())
+			}
+		}
+	}
+}
+
+// Dispatch makes one pass over the pending events and returns how many it
+// looked at.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	pending, err := d.outbox.Pending(ctx, time.Now(), d.MaxAttempts, d.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("reading the outbox: %s", err.Message())
+	}
+
+	for _, recorded := range pending {
+		event := Event{Id: recorded.Id, Type: Type(recorded.Type), Chat: recorded.Chat}
+		if deliveryErr := d.deliver(ctx, event); deliveryErr != nil {
+			attempts := recorded.Attempts + 1
+			if attempts >= d.MaxAttempts {
+				log.Printf("giving up on outbox event %d after %d attempts: %v", recorded.Id, attempts, deliveryErr)
+			}
+			if err := d.outbox.MarkFailed(ctx, recorded.Id, deliveryErr.Error(), time.Now().Add(RetryDelay(attempts))); err != nil {
+				return 0, fmt.Errorf("recording failure of outbox event %d: %s", recorded.Id, err.Message())
+			}
+			continue
+		}
+		if err := d.outbox.MarkDelivered(ctx, recorded.Id, time.Now()); err != nil {
+			return 0, fmt.Errorf("recording delivery of outbox event %d: %s", recorded.Id, err.Message())
+		}
+	}
+	return len(pending), nil
+}
+
+// deliver runs every handler in a stable order and reports the first
+// failure. A panicking handler counts as a failure.
+func (d *Dispatcher) deliver(ctx context.Context, event Event) error {
+	d.mu.RLock()
+	names := make([]string, 0, len(d.handlers))
+	for name := range d.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	handlers := make([]DeliveryHandler, len(names))
+	for i, name := range names {
+		handlers[i] = d.handlers[name]
+	}
+	d.mu.RUnlock()
+
+	for i, handler := range handlers {
+		if err := safeHandle(ctx, handler, event); err != nil {
+			return fmt.Errorf("%s: %v", names[i], err)
+		}
+	}
+	return nil
+}
+
+func safeHandle(ctx context.Context, handler DeliveryHandler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, event)
+}
+
+// RetryDelay backs off exponentially from one second, up to an hour.
+func RetryDelay(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// PublishTo is a DeliveryHandler handing events over to a bus, such as the
+// one feeding the real-time endpoints.
+func PublishTo(bus *Bus) DeliveryHandler {
+	return func(ctx context.Context, event Event) error {
+		bus.Publish(event)
+		return nil
+	}
+}
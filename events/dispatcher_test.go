@@ -0,0 +1,114 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestDispatcher(t *testing.T) (*Dispatcher, domain.Chat) {
+	chats := domain.NewMemoryChatRepository()
+	outbox, err := domain.NewOutboxRepository(chats)
+	assert.Nil(t, err)
+
+	chat, chatErr := chats.Create(context.Background(), &domain.Chat{Sender: "+6282323231", Receiver: "+6282323232", Body: "hello"})
+	assert.Nil(t, chatErr)
+	return NewDispatcher(outbox), *chat
+}
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	dispatcher, chat := newTestDispatcher(t)
+	bus := NewBus()
+	var published []Event
+	bus.Subscribe(func(e Event) { published = append(published, e) })
+	dispatcher.Handle("bus", PublishTo(bus))
+
+	delivered, err := dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, delivered)
+	if assert.Len(t, published, 1) {
+		assert.EqualValues(t, ChatCreated, published[0].Type)
+		assert.EqualValues(t, chat, published[0].Chat)
+	}
+
+	//delivered events are not sent twice
+	delivered, err = dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, delivered)
+	assert.Len(t, published, 1)
+}
+
+func TestDispatcher_Dispatch_Failure(t *testing.T) {
+	dispatcher, _ := newTestDispatcher(t)
+	calls := 0
+	dispatcher.Handle("failing", func(ctx context.Context, event Event) error {
+		calls++
+		return errors.New("unreachable")
+	})
+	dispatcher.Handle("panicking", func(ctx context.Context, event Event) error {
+		panic("boom")
+	})
+
+	delivered, err := dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, delivered)
+	assert.EqualValues(t, 1, calls)
+
+	//the event waits for its retry delay
+	delivered, err = dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, delivered)
+	assert.EqualValues(t, 1, calls)
+}
+
+func TestDispatcher_Dispatch_Panic(t *testing.T) {
+	dispatcher, _ := newTestDispatcher(t)
+	dispatcher.MaxAttempts = 1
+	dispatcher.Handle("panicking", func(ctx context.Context, event Event) error {
+		panic("boom")
+	})
+
+	delivered, err := dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, delivered)
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	dispatcher, _ := newTestDispatcher(t)
+	dispatcher.PollInterval = time.Hour
+	received := make(chan Event, 1)
+	dispatcher.Handle("channel", func(ctx context.Context, event Event) error {
+		received <- event
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case event := <-received:
+		assert.EqualValues(t, ChatCreated, event.Type)
+	case <-time.After(time.Second):
+		t.Fatal("the recorded event was not delivered")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not stop with its context")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.EqualValues(t, time.Second, RetryDelay(1))
+	assert.EqualValues(t, 4*time.Second, RetryDelay(3))
+	assert.EqualValues(t, time.Hour, RetryDelay(50))
+}
@@ -0,0 +1,84 @@
+// Package events delivers the chat lifecycle changes recorded in the outbox
+// to whoever wants to react to them, such as the real-time endpoints.
+package events
+
+import (
+	"github.com/SemmiDev/lets-tests/domain"
+	"sync"
+)
+
+type Type string
+
+const (
+	ChatCreated  Type = domain.ChatCreatedEvent
+	ChatUpdated  Type = domain.ChatUpdatedEvent
+	ChatDeleted  Type = domain.ChatDeletedEvent
+	ChatRestored Type = domain.ChatRestoredEvent
+	ChatRead     Type = domain.ChatReadEvent
+)
+
+// Event is a change that happened to a chat, Chat is its state afterwards.
+// Id is the outbox id of the event, it stays the same when the event is
+// delivered again.
+type Event struct {
+	Id   int64       `json:"-"`
+	Type Type        `json:"type"`
+	Chat domain.Chat `json:"chat"`
+}
+
+// Participants are the phone numbers allowed to see the event.
+func (e Event) Participants() []string {
+	return []string{e.Chat.Sender, e.Chat.Receiver}
+}
+
+// Tenant owns the chat of the event, events recorded before tenants existed
+// belong to domain.DefaultTenant.
+func (e Event) Tenant() string {
+	if e.Chat.Tenant == "" {
+		return domain.DefaultTenant
+	}
+	return e.Chat.Tenant
+}
+
+// Handler reacts to an event. It is called on the publisher's goroutine, so
+// it should hand slow work off instead of blocking.
+type Handler func(Event)
+
+// Bus fans events out to every subscribed handler.
+type Bus struct {
+	mu       sync.RWMutex
+	nextId   int
+	handlers map[int]Handler
+}
+
+// Default is the bus the outbox dispatcher publishes chat events to.
+var Default = NewBus()
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[int]Handler)}
+}
+
+// Subscribe registers handler for every later event and returns the
+// function that removes it again.
+func (b *Bus) Subscribe(handler Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextId++
+	id := b.nextId
+	b.handlers[id] = handler
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+}
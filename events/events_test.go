@@ -0,0 +1,26 @@
+package events
+
+import (
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBus_Publish(t *testing.T) {
+	bus := NewBus()
+	var first, second []Type
+	unsubscribe := bus.Subscribe(func(e Event) { first = append(first, e.Type) })
+	bus.Subscribe(func(e Event) { second = append(second, e.Type) })
+
+	bus.Publish(Event{Type: ChatCreated})
+	unsubscribe()
+	bus.Publish(Event{Type: ChatUpdated})
+
+	assert.EqualValues(t, []Type{ChatCreated}, first)
+	assert.EqualValues(t, []Type{ChatCreated, ChatUpdated}, second)
+}
+
+func TestEvent_Participants(t *testing.T) {
+	event := Event{Type: ChatCreated, Chat: domain.Chat{Sender: "+6282323231", Receiver: "+6282323232"}}
+	assert.EqualValues(t, []string{"+6282323231", "+6282323232"}, event.Participants())
+}
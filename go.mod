@@ -9,6 +9,9 @@ require (
 	github.com/go-chi/cors v1.2.0
 	github.com/go-chi/httprate v0.5.1
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/gorilla/websocket v1.5.0
 	github.com/joho/godotenv v1.3.0
+	github.com/lib/pq v1.10.2
 	github.com/stretchr/testify v1.7.0
 )
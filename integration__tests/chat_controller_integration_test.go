@@ -277,14 +277,47 @@ func TestGetAllMessage(t *testing.T) {
 	rr := httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
 
-	var msgs []domain.Chat
+	var page domain.ChatPage
 
-	err = json.Unmarshal(rr.Body.Bytes(), &msgs)
+	err = json.Unmarshal(rr.Body.Bytes(), &page)
 	if err != nil {
 		log.Fatalf("Cannot convert to json: %v\n", err)
 	}
 	assert.Equal(t, rr.Code, http.StatusOK)
-	assert.Equal(t, len(msgs), 2)
+	assert.Equal(t, len(page.Chats), 2)
+	assert.False(t, page.HasMore)
+
+	//walk the same rows one page at a time
+	req, err = http.NewRequest(http.MethodGet, "/api/v1/chats?limit=1", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var first domain.ChatPage
+	err = json.Unmarshal(rr.Body.Bytes(), &first)
+	if err != nil {
+		log.Fatalf("Cannot convert to json: %v\n", err)
+	}
+	assert.Equal(t, len(first.Chats), 1)
+	assert.True(t, first.HasMore)
+
+	req, err = http.NewRequest(http.MethodGet, "/api/v1/chats?limit=1&cursor="+first.NextCursor, nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var second domain.ChatPage
+	err = json.Unmarshal(rr.Body.Bytes(), &second)
+	if err != nil {
+		log.Fatalf("Cannot convert to json: %v\n", err)
+	}
+	assert.Equal(t, len(second.Chats), 1)
+	assert.False(t, second.HasMore)
+	assert.NotEqual(t, first.Chats[0].Id, second.Chats[0].Id)
 }
 
 func TestDeleteMessage(t *testing.T) {
@@ -346,3 +379,41 @@ func TestDeleteMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestGetConversation(t *testing.T) {
+	database()
+	err := refreshChatsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	message, err := seedOneChat()
+	if err != nil {
+		t.Errorf("Error while seeding table: %s", err)
+	}
+	_, err = seedChats()
+	if err != nil {
+		t.Errorf("Error while seeding table: %s", err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/v1/conversations/{a}/{b}", controllers.GetConversation)
+
+	//the conversation is the same whichever participant comes first
+	for _, path := range []string{message.Sender + "/" + message.Receiver, message.Receiver + "/" + message.Sender} {
+		req, err := http.NewRequest(http.MethodGet, "/api/v1/conversations/"+path, nil)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		var page domain.ChatPage
+		err = json.Unmarshal(rr.Body.Bytes(), &page)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		assert.Equal(t, rr.Code, http.StatusOK)
+		assert.Equal(t, len(page.Chats), 1)
+		assert.Equal(t, page.Chats[0].Id, message.Id)
+	}
+}
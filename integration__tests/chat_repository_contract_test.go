@@ -0,0 +1,32 @@
+package integration__tests
+
+import (
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/domain/repotest"
+	"log"
+	"testing"
+)
+
+func TestChatRepository_Contract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repository {
+		database()
+		if err := refreshChatsTable(); err != nil {
+			log.Fatal(err)
+		}
+		return domain.ChatRepo
+	})
+}
+
+func TestChatSearcher_Contract(t *testing.T) {
+	repotest.RunSearch(t, func(t *testing.T) (repotest.Repository, domain.Searcher) {
+		database()
+		if err := refreshChatsTable(); err != nil {
+			log.Fatal(err)
+		}
+		searcher, err := domain.NewSearcher(domain.ChatRepo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return domain.ChatRepo, searcher
+	})
+}
@@ -3,6 +3,7 @@ package integration__tests
 import (
 	"database/sql"
 	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/migrations"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
 	"log"
@@ -12,11 +13,21 @@ import (
 )
 
 const (
-	queryTruncateChat = "TRUNCATE TABLE chats;"
-	queryInsertChat   = "INSERT INTO chats(sender,receiver, body, created_at) VALUES(?, ?, ?, ?);"
-	queryGetAllChats  = "SELECT id, sender, receiver, body, created_at FROM chats;"
+	queryInsertChat  = "INSERT INTO chats(sender,receiver, body, created_at) VALUES(?, ?, ?, ?);"
+	queryGetAllChats = "SELECT id, sender, receiver, body, created_at FROM chats;"
 )
 
+// refreshQueries empty the tables child first, TRUNCATE is refused on
+// tables referenced by a foreign key.
+var refreshQueries = []string{
+	"DELETE FROM chat_revisions;",
+	"DELETE FROM webhook_deliveries;",
+	"DELETE FROM webhooks;",
+	"DELETE FROM idempotency_keys;",
+	"DELETE FROM outbox;",
+	"DELETE FROM chats;",
+}
+
 var dbConn *sql.DB
 
 func TestMain(m *testing.M) {
@@ -25,6 +36,15 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		log.Fatalf("Error getting env %v\n", err)
 	}
+
+	database()
+	runner, err := migrations.NewRunner(dbConn, os.Getenv("DBDRIVER_TEST"), migrations.Input{DefaultCountryCode: "62"})
+	if err != nil {
+		log.Fatalf("Error loading migrations %v\n", err)
+	}
+	if _, err := runner.Up(); err != nil {
+		log.Fatalf("Error migrating test database %v\n", err)
+	}
 	os.Exit(m.Run())
 }
 
@@ -40,13 +60,16 @@ func database() {
 }
 
 func refreshChatsTable() error {
-	stmt, err := dbConn.Prepare(queryTruncateChat)
-	if err != nil {
-		panic(err.Error())
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		log.Fatalf("Error truncating messages table: %s", err)
+	for _, query := range refreshQueries {
+		stmt, err := dbConn.Prepare(query)
+		if err != nil {
+			panic(err.Error())
+		}
+		_, err = stmt.Exec()
+		stmt.Close()
+		if err != nil {
+			log.Fatalf("Error truncating messages table: %s", err)
+		}
 	}
 	return nil
 }
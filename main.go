@@ -1,7 +1,18 @@
 package main
 
-import "github.com/SemmiDev/lets-tests/app"
+import (
+	"github.com/SemmiDev/lets-tests/app"
+	"os"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		app.Migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		app.ApiKey(os.Args[2:])
+		return
+	}
 	app.StartApp()
 }
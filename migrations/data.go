@@ -0,0 +1,164 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// DataFunc rewrites rows a migration can't express in SQL. It runs after the
+// statements of the up script, within the same transaction; rebind adapts
+// the ? placeholders of a query to the driver.
+type DataFunc func(tx *sql.Tx, rebind func(string) string, input Input) error
+
+// Input is what data migrations depend on besides the rows, given by
+// whoever runs them. Data migrations don't read the environment or call
+// into the application, so what they write only depends on their version
+// and this input.
+type Input struct {
+	// DefaultCountryCode is the calling code normalize_phones gives numbers
+	// stored without one, such as "62".
+	DefaultCountryCode string
+}
+
+// dataMigrations are the Go steps of migrations, by name, for every driver.
+var dataMigrations = map[string]DataFunc{
+	"normalize_phones": normalizePhones,
+}
+
+type phoneRow struct {
+	id      int64
+	numbers []string
+}
+
+// normalizePhones rewrites the senders and receivers of chats, and the
+// receivers of webhooks, stored before numbers were kept in E.164. Numbers
+// normalizePhone refuses, such as ones with an extension, are left as they
+// are and only logged.
+func normalizePhones(tx *sql.Tx, rebind func(string) string, input Input) error {
+	if !countryCodeRegexp.MatchString(input.DefaultCountryCode) {
+		return fmt.Errorf("normalizing phones needs a default country code, such as 62, got %q", input.DefaultCountryCode)
+	}
+	if err := normalizeColumns(tx, rebind, input.DefaultCountryCode, "chats", "sender", "receiver"); err != nil {
+		return err
+	}
+	return normalizeColumns(tx, rebind, input.DefaultCountryCode, "webhooks", "receiver")
+}
+
+func normalizeColumns(tx *sql.Tx, rebind func(string) string, countryCode, table string, columns ...string) error {
+	rows, err := tx.Query("SELECT id, " + strings.Join(columns, ", ") + " FROM " + table + ";")
+	if err != nil {
+		return err
+	}
+
+	//updates are run once the rows are read, as a connection can't do both
+	var updates []phoneRow
+	skipped := 0
+	for rows.Next() {
+		row := phoneRow{numbers: make([]string, len(columns))}
+		dest := []interface{}{&row.id}
+		for i := range row.numbers {
+			dest = append(dest, &row.numbers[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			_ = rows.Close()
+			return err
+		}
+
+		changed := false
+		for i, number := range row.numbers {
+			if number == "" {
+				continue
+			}
+			normalized, err := normalizePhone(number, countryCode)
+			if err != nil {
+				skipped++
+				continue
+			}
+			if normalized != number {
+				row.numbers[i] = normalized
+				changed = true
+			}
+		}
+		if changed {
+			updates = append(updates, row)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	query := rebind("UPDATE " + table + " SET " + strings.Join(columns, "=?, ") + "=? WHERE id=?;")
+	for _, row := range updates {
+		args := make([]interface{}, 0, len(columns)+1)
+		for _, number := range row.numbers {
+			args = append(args, number)
+		}
+		if _, err := tx.Exec(query, append(args, row.id)...); err != nil {
+			return err
+		}
+	}
+	if skipped > 0 {
+		log.Printf("%d phone numbers of %s can't be normalized and were left as they are", skipped, table)
+	}
+	return nil
+}
+
+// The rules below are those of the phone package when normalize_phones was
+// written. They are copied rather than imported so the migration keeps
+// writing the same numbers whatever the phone package becomes.
+
+var (
+	errInvalidPhone   = errors.New("not a phone number")
+	errPhoneExtension = errors.New("phone numbers can't have an extension")
+)
+
+var (
+	countryCodeRegexp = regexp.MustCompile(`^[1-9][0-9]{0,2}$`)
+	extensionRegexp   = regexp.MustCompile(`(?i)[ \-./\\]*(?:#|ext\.?|extension|x)[ \-./\\]*([0-9]+)$`)
+	phoneSeparators   = strings.NewReplacer(" ", "", "-", "", ".", "", "/", "", `\`, "", "(", "", ")", "")
+)
+
+// normalizePhone returns the E.164 form of raw. Numbers without a + or 00
+// international prefix belong to countryCode and lose their leading trunk
+// 0. Numbers with an extension are refused.
+func normalizePhone(raw, countryCode string) (string, error) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return "", errInvalidPhone
+	}
+	if extensionRegexp.MatchString(value) {
+		return "", errPhoneExtension
+	}
+
+	digits := phoneSeparators.Replace(value)
+	international := false
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits, international = digits[1:], true
+	case strings.HasPrefix(digits, "00"):
+		digits, international = digits[2:], true
+	}
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return "", errInvalidPhone
+	}
+	if !international {
+		digits = strings.TrimLeft(digits, "0")
+		if digits == "" {
+			return "", errInvalidPhone
+		}
+		digits = countryCode + digits
+	}
+	//country codes never start with 0, E.164 numbers have 7 to 15 digits
+	if digits[0] == '0' || len(digits) < 7 || len(digits) > 15 {
+		return "", errInvalidPhone
+	}
+	return "+" + digits, nil
+}
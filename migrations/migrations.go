@@ -0,0 +1,254 @@
+// Package migrations holds the numbered schema changes for every SQL driver
+// and a runner that records which of them were applied.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed mysql/*.sql postgres/*.sql
+var files embed.FS
+
+const (
+	queryCreateVersionTable = `CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL);`
+	queryGetVersions        = `SELECT version, applied_at FROM schema_migrations;`
+	queryInsertVersion      = `INSERT INTO schema_migrations(version, name, applied_at) VALUES (?,?,?);`
+	queryDeleteVersion      = `DELETE FROM schema_migrations WHERE version=?;`
+)
+
+// Migration is a single numbered schema change, read from
+// <driver>/<version>_<name>.up.sql and the matching .down.sql. UpData, if
+// any, rewrites rows after the Up statements.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	UpData  DataFunc
+}
+
+// Status is a migration together with when it was applied, if ever.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Load returns the migrations of a driver ordered by version.
+func Load(driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, driver)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %q", driver)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		stem := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(stem, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s should be named <version>_<name>.%s.sql", name, direction)
+		}
+		content, err := fs.ReadFile(files, path.Join(driver, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1], UpData: dataMigrations[parts[1]]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// Runner applies the migrations of one driver to a database.
+type Runner struct {
+	db         *sql.DB
+	driver     string
+	input      Input
+	migrations []Migration
+}
+
+// NewRunner returns a runner giving input to the data migrations it
+// applies.
+func NewRunner(db *sql.DB, driver string, input Input) (*Runner, error) {
+	migrations, err := Load(driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{db: db, driver: driver, input: input, migrations: migrations}, nil
+}
+
+// Up applies every pending migration in order and returns the ones applied.
+func (r *Runner) Up() ([]Migration, error) {
+	statuses, err := r.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			continue
+		}
+		if err := r.apply(s.Migration, s.Up, s.UpData, queryInsertVersion, s.Version, s.Name, time.Now().UTC()); err != nil {
+			return applied, err
+		}
+		applied = append(applied, s.Migration)
+	}
+	return applied, nil
+}
+
+// Down reverts the most recently applied migration. It returns nil when
+// nothing is applied.
+func (r *Runner) Down() (*Migration, error) {
+	statuses, err := r.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		s := statuses[i]
+		if s.AppliedAt == nil {
+			continue
+		}
+		if err := r.apply(s.Migration, s.Down, nil, queryDeleteVersion, s.Version); err != nil {
+			return nil, err
+		}
+		return &s.Migration, nil
+	}
+	return nil, nil
+}
+
+// Status lists every known migration and whether it was applied.
+func (r *Runner) Status() ([]Status, error) {
+	if _, err := r.db.Exec(queryCreateVersionTable); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	rows, err := r.db.Query(queryGetVersions)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("reading schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+
+	result := make([]Status, 0, len(r.migrations))
+	for _, m := range r.migrations {
+		s := Status{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			at := at
+			s.AppliedAt = &at
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// apply runs the statements of script, then data, and records the change in
+// schema_migrations within one transaction. MySQL commits DDL implicitly,
+// so there a failing script can still leave earlier statements applied.
+func (r *Runner) apply(m Migration, script string, data DataFunc, record string, args ...interface{}) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, statement := range splitStatements(script) {
+		if _, err := tx.Exec(statement); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	if data != nil {
+		if err := data(tx, r.rebind, r.input); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	if _, err := tx.Exec(r.rebind(record), args...); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("migration %d_%s: recording version: %w", m.Version, m.Name, err)
+	}
+	return tx.Commit()
+}
+
+func (r *Runner) rebind(query string) string {
+	if r.driver != "postgres" {
+		return query
+	}
+	for n := 1; strings.Contains(query, "?"); n++ {
+		query = strings.Replace(query, "?", "$"+strconv.Itoa(n), 1)
+	}
+	return query
+}
+
+// splitStatements splits a script on the semicolons ending its lines, as
+// the drivers only accept one statement per Exec. Lines starting with -- are
+// comments and left out.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			if statement := strings.TrimSpace(current.String()); statement != ";" {
+				statements = append(statements, statement)
+			}
+			current.Reset()
+		}
+	}
+	if statement := strings.TrimSpace(current.String()); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
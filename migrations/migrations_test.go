@@ -0,0 +1,231 @@
+package migrations
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	for _, driver := range []string{"mysql", "postgres"} {
+		t.Run(driver, func(t *testing.T) {
+			migrations, err := Load(driver)
+			assert.Nil(t, err)
+			assert.NotEmpty(t, migrations)
+			for i, m := range migrations {
+				assert.NotEmpty(t, m.Up)
+				assert.NotEmpty(t, m.Down)
+				if i > 0 {
+					assert.Greater(t, m.Version, migrations[i-1].Version)
+				}
+			}
+		})
+	}
+
+	_, err := Load("memory")
+	assert.NotNil(t, err)
+}
+
+// Both drivers must know the same versions, otherwise switching DBDRIVER
+// would leave some features without a schema.
+func TestLoad_Drivers_In_Sync(t *testing.T) {
+	mysql, err := Load("mysql")
+	assert.Nil(t, err)
+	postgres, err := Load("postgres")
+	assert.Nil(t, err)
+
+	assert.Equal(t, len(mysql), len(postgres))
+	for i := range mysql {
+		assert.Equal(t, mysql[i].Version, postgres[i].Version)
+		assert.Equal(t, mysql[i].Name, postgres[i].Name)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := "CREATE TABLE a\n(\n    id INT\n);\nCREATE INDEX idx ON a (id);\n\n"
+	assert.Equal(t, []string{"CREATE TABLE a\n(\n    id INT\n);", "CREATE INDEX idx ON a (id);"}, splitStatements(script))
+
+	assert.Empty(t, splitStatements("-- done in Go;\n-- nothing to run\n"))
+}
+
+func newTestRunner(t *testing.T, driver string) (*Runner, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Runner{
+		db:     db,
+		driver: driver,
+		input:  Input{DefaultCountryCode: "62"},
+		migrations: []Migration{
+			{Version: 1, Name: "first", Up: "CREATE TABLE a (id INT);", Down: "DROP TABLE a;"},
+			{Version: 2, Name: "second", Up: "CREATE TABLE b (id INT);\nCREATE TABLE c (id INT);", Down: "DROP TABLE c;\nDROP TABLE b;"},
+		},
+	}, mock
+}
+
+func TestRunner_Up(t *testing.T) {
+	runner, mock := newTestRunner(t, "postgres")
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE b").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE c").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations\\(version, name, applied_at\\) VALUES \\(\\$1,\\$2,\\$3\\)").
+		WithArgs(2, "second", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	applied, err := runner.Up()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 1)
+	assert.EqualValues(t, 2, applied[0].Version)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRunner_Down(t *testing.T) {
+	runner, mock := newTestRunner(t, "mysql")
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(2, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE c").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE b").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations WHERE version=\\?").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	reverted, err := runner.Down()
+	assert.Nil(t, err)
+	assert.NotNil(t, reverted)
+	assert.EqualValues(t, 2, reverted.Version)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRunner_Up_Failure_Rolls_Back(t *testing.T) {
+	runner, mock := newTestRunner(t, "mysql")
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE a").WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	applied, err := runner.Up()
+	assert.NotNil(t, err)
+	assert.Empty(t, applied)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRunner_Status(t *testing.T) {
+	runner, mock := newTestRunner(t, "mysql")
+	appliedAt := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, appliedAt))
+
+	statuses, err := runner.Status()
+	assert.Nil(t, err)
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, appliedAt, *statuses[0].AppliedAt)
+	assert.Nil(t, statuses[1].AppliedAt)
+}
+
+func TestRunner_Up_Normalizes_Phones(t *testing.T) {
+	runner, mock := newTestRunner(t, "postgres")
+	runner.migrations = []Migration{
+		{Version: 15, Name: "normalize_phones", Up: "-- in Go", Down: "-- nothing", UpData: normalizePhones},
+	}
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, sender, receiver FROM chats").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender", "receiver"}).
+			AddRow(1, "0811 132 431", "+62-811-132-432").
+			AddRow(2, "+62811132431", "+62811132432").
+			AddRow(3, "0811 132 431 ext 12", "0811132432"))
+	mock.ExpectExec("UPDATE chats SET sender=\\$1, receiver=\\$2 WHERE id=\\$3").
+		WithArgs("+62811132431", "+62811132432", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE chats SET sender=\\$1, receiver=\\$2 WHERE id=\\$3").
+		WithArgs("0811 132 431 ext 12", "+62811132432", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, receiver FROM webhooks").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "receiver"}).AddRow(1, "").AddRow(2, "0811-132-431"))
+	mock.ExpectExec("UPDATE webhooks SET receiver=\\$1 WHERE id=\\$2").
+		WithArgs("+62811132431", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(15, "normalize_phones", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	applied, err := runner.Up()
+	assert.Nil(t, err)
+	assert.Len(t, applied, 1)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRunner_Up_Normalizes_Phones_With_The_Given_Country_Code(t *testing.T) {
+	runner, mock := newTestRunner(t, "mysql")
+	runner.input = Input{DefaultCountryCode: "1"}
+	runner.migrations = []Migration{
+		{Version: 15, Name: "normalize_phones", Up: "-- in Go", Down: "-- nothing", UpData: normalizePhones},
+	}
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, sender, receiver FROM chats").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender", "receiver"}).AddRow(1, "(415) 555-2671", "+62811132432"))
+	mock.ExpectExec("UPDATE chats SET sender=\\?, receiver=\\? WHERE id=\\?").
+		WithArgs("+14155552671", "+62811132432", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, receiver FROM webhooks").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "receiver"}))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(15, "normalize_phones", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err := runner.Up()
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRunner_Up_Normalize_Phones_Needs_A_Country_Code(t *testing.T) {
+	runner, mock := newTestRunner(t, "mysql")
+	runner.input = Input{}
+	runner.migrations = []Migration{
+		{Version: 15, Name: "normalize_phones", Up: "-- in Go", Down: "-- nothing", UpData: normalizePhones},
+	}
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	applied, err := runner.Up()
+	assert.NotNil(t, err)
+	assert.Empty(t, applied)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// A data step whose migration was renamed would silently never run.
+func TestLoad_Data_Migrations(t *testing.T) {
+	for _, driver := range []string{"mysql", "postgres"} {
+		migrations, err := Load(driver)
+		assert.Nil(t, err)
+		withData := 0
+		for _, m := range migrations {
+			if m.UpData != nil {
+				withData++
+			}
+		}
+		assert.Equal(t, len(dataMigrations), withData, driver)
+	}
+}
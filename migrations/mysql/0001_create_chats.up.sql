@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `chats`
+(
+    `id`         int(11) NOT NULL AUTO_INCREMENT,
+    `sender`     varchar(100) NOT NULL,
+    `receiver`   varchar(100) NOT NULL,
+    `body`       varchar(255) NOT NULL,
+    `created_at` timestamp    NOT NULL DEFAULT current_timestamp(),
+    PRIMARY KEY (`id`),
+    KEY `idx_chats_created_at_id` (`created_at`, `id`),
+    KEY `idx_chats_sender_receiver` (`sender`, `receiver`, `created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,2 @@
+DROP INDEX `idx_chats_deleted_at` ON `chats`;
+ALTER TABLE `chats` DROP COLUMN `deleted_at`;
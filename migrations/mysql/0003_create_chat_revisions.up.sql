@@ -0,0 +1,12 @@
+ALTER TABLE `chats` ADD COLUMN `edited_at` timestamp NULL DEFAULT NULL, ADD COLUMN `revision_count` int(11) NOT NULL DEFAULT 0;
+CREATE TABLE `chat_revisions`
+(
+    `id`          int(11) NOT NULL AUTO_INCREMENT,
+    `chat_id`     int(11) NOT NULL,
+    `revision`    int(11) NOT NULL,
+    `body`        varchar(255) NOT NULL,
+    `replaced_at` timestamp    NOT NULL DEFAULT current_timestamp(),
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_chat_revisions_chat_revision` (`chat_id`, `revision`),
+    CONSTRAINT `fk_chat_revisions_chat` FOREIGN KEY (`chat_id`) REFERENCES `chats` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
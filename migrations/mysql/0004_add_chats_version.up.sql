@@ -0,0 +1 @@
+ALTER TABLE `chats` ADD COLUMN `version` bigint NOT NULL DEFAULT 1;
@@ -0,0 +1,14 @@
+CREATE TABLE `outbox`
+(
+    `id`           bigint(20)  NOT NULL AUTO_INCREMENT,
+    `event_type`   varchar(32) NOT NULL,
+    `chat_id`      int(11)     NOT NULL,
+    `payload`      text        NOT NULL,
+    `created_at`   timestamp   NOT NULL DEFAULT current_timestamp(),
+    `available_at` timestamp   NOT NULL DEFAULT current_timestamp(),
+    `attempts`     int(11)     NOT NULL DEFAULT 0,
+    `last_error`   text        NULL,
+    `delivered_at` timestamp   NULL DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    KEY `idx_outbox_pending` (`delivered_at`, `available_at`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,30 @@
+CREATE TABLE `webhooks`
+(
+    `id`          bigint(20)    NOT NULL AUTO_INCREMENT,
+    `url`         varchar(2048) NOT NULL,
+    `event_types` varchar(255)  NOT NULL,
+    `receiver`    varchar(100)  NOT NULL DEFAULT '',
+    `secret`      varchar(128)  NOT NULL,
+    `created_at`  timestamp     NOT NULL DEFAULT current_timestamp(),
+    `updated_at`  timestamp     NOT NULL DEFAULT current_timestamp(),
+    PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE TABLE `webhook_deliveries`
+(
+    `id`          bigint(20)  NOT NULL AUTO_INCREMENT,
+    `webhook_id`  bigint(20)  NOT NULL,
+    `event_id`    bigint(20)  NOT NULL,
+    `event_type`  varchar(32) NOT NULL,
+    `payload`     text        NOT NULL,
+    `status`      varchar(16) NOT NULL,
+    `attempts`    int(11)     NOT NULL DEFAULT 0,
+    `status_code` int(11)     NOT NULL DEFAULT 0,
+    `last_error`  text        NULL,
+    `created_at`  timestamp   NOT NULL DEFAULT current_timestamp(),
+    `next_try_at` timestamp   NULL DEFAULT NULL,
+    `sent_at`     timestamp   NULL DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_webhook_deliveries_webhook_event` (`webhook_id`, `event_id`),
+    KEY `idx_webhook_deliveries_due` (`status`, `next_try_at`),
+    CONSTRAINT `fk_webhook_deliveries_webhook` FOREIGN KEY (`webhook_id`) REFERENCES `webhooks` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,10 @@
+CREATE TABLE `idempotency_keys`
+(
+    `idempotency_key` varchar(255) NOT NULL,
+    `fingerprint`     char(64)     NOT NULL,
+    `chat_id`         int(11)      NOT NULL,
+    `response`        text         NOT NULL,
+    `created_at`      timestamp    NOT NULL DEFAULT current_timestamp(),
+    PRIMARY KEY (`idempotency_key`),
+    KEY `idx_idempotency_keys_created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,13 @@
+CREATE TABLE `api_keys`
+(
+    `id`           bigint(20)   NOT NULL AUTO_INCREMENT,
+    `name`         varchar(100) NOT NULL,
+    `scopes`       varchar(255) NOT NULL,
+    `prefix`       varchar(16)  NOT NULL,
+    `key_hash`     char(64)     NOT NULL,
+    `created_at`   timestamp    NOT NULL DEFAULT current_timestamp(),
+    `last_used_at` timestamp    NULL DEFAULT NULL,
+    `revoked_at`   timestamp    NULL DEFAULT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_api_keys_key_hash` (`key_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
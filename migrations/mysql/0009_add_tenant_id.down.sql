@@ -0,0 +1,7 @@
+ALTER TABLE `api_keys` DROP COLUMN `tenant_id`;
+DROP INDEX `idx_webhooks_tenant_id` ON `webhooks`;
+ALTER TABLE `webhooks` DROP COLUMN `tenant_id`;
+ALTER TABLE `idempotency_keys` DROP PRIMARY KEY, ADD PRIMARY KEY (`idempotency_key`);
+ALTER TABLE `idempotency_keys` DROP COLUMN `tenant_id`;
+DROP INDEX `idx_chats_tenant_id` ON `chats`;
+ALTER TABLE `chats` DROP COLUMN `tenant_id`;
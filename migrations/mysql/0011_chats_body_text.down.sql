@@ -0,0 +1,7 @@
+ALTER TABLE `idempotency_keys` MODIFY `response` text NOT NULL;
+ALTER TABLE `webhook_deliveries` MODIFY `payload` text NOT NULL;
+ALTER TABLE `outbox` MODIFY `payload` text NOT NULL;
+UPDATE `chat_revisions` SET `body` = LEFT(`body`, 255) WHERE CHAR_LENGTH(`body`) > 255;
+ALTER TABLE `chat_revisions` MODIFY `body` varchar(255) NOT NULL;
+UPDATE `chats` SET `body` = LEFT(`body`, 255) WHERE CHAR_LENGTH(`body`) > 255;
+ALTER TABLE `chats` MODIFY `body` varchar(255) NOT NULL;
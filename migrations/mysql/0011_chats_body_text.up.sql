@@ -0,0 +1,5 @@
+ALTER TABLE `chats` MODIFY `body` text NOT NULL;
+ALTER TABLE `chat_revisions` MODIFY `body` text NOT NULL;
+ALTER TABLE `outbox` MODIFY `payload` mediumtext NOT NULL;
+ALTER TABLE `webhook_deliveries` MODIFY `payload` mediumtext NOT NULL;
+ALTER TABLE `idempotency_keys` MODIFY `response` mediumtext NOT NULL;
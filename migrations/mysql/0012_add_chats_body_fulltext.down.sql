@@ -0,0 +1 @@
+DROP INDEX `idx_chats_body_fulltext` ON `chats`;
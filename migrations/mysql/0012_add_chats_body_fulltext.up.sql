@@ -0,0 +1 @@
+ALTER TABLE `chats` ADD FULLTEXT INDEX `idx_chats_body_fulltext` (`body`);
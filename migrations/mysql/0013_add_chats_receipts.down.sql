@@ -0,0 +1,3 @@
+DROP INDEX `idx_chats_unread` ON `chats`;
+ALTER TABLE `chats` DROP COLUMN `read_at`;
+ALTER TABLE `chats` DROP COLUMN `delivered_at`;
@@ -0,0 +1,3 @@
+ALTER TABLE `chats` ADD COLUMN `delivered_at` timestamp NULL DEFAULT NULL;
+ALTER TABLE `chats` ADD COLUMN `read_at` timestamp NULL DEFAULT NULL;
+CREATE INDEX `idx_chats_unread` ON `chats` (`tenant_id`, `receiver`, `read_at`);
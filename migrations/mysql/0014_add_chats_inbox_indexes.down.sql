@@ -0,0 +1,2 @@
+DROP INDEX `idx_chats_receiver` ON `chats`;
+DROP INDEX `idx_chats_sender` ON `chats`;
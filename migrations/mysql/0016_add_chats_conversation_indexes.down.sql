@@ -0,0 +1,2 @@
+DROP INDEX `idx_chats_receiver_conversation` ON `chats`;
+DROP INDEX `idx_chats_sender_conversation` ON `chats`;
@@ -0,0 +1,4 @@
+-- Cover the inbox ranking: each half of it reads the chats of one phone
+-- peer by peer from these indexes alone, without touching the rows.
+CREATE INDEX `idx_chats_sender_conversation` ON `chats` (`tenant_id`, `sender`, `receiver`, `created_at`, `id`, `deleted_at`, `read_at`);
+CREATE INDEX `idx_chats_receiver_conversation` ON `chats` (`tenant_id`, `receiver`, `sender`, `created_at`, `id`, `deleted_at`, `read_at`);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS chats
+(
+    id         BIGSERIAL PRIMARY KEY,
+    sender     VARCHAR(100) NOT NULL,
+    receiver   VARCHAR(100) NOT NULL,
+    body       VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_chats_created_at_id ON chats (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_chats_sender_receiver ON chats (sender, receiver, created_at);
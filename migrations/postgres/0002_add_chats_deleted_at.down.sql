@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_chats_deleted_at;
+ALTER TABLE chats DROP COLUMN deleted_at;
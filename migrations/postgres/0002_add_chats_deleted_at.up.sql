@@ -0,0 +1,2 @@
+ALTER TABLE chats ADD COLUMN deleted_at TIMESTAMPTZ NULL;
+CREATE INDEX idx_chats_deleted_at ON chats (deleted_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS chat_revisions;
+ALTER TABLE chats DROP COLUMN revision_count, DROP COLUMN edited_at;
@@ -0,0 +1,10 @@
+ALTER TABLE chats ADD COLUMN edited_at TIMESTAMPTZ NULL, ADD COLUMN revision_count INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE chat_revisions
+(
+    id          BIGSERIAL PRIMARY KEY,
+    chat_id     BIGINT       NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    revision    INTEGER      NOT NULL,
+    body        VARCHAR(255) NOT NULL,
+    replaced_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    UNIQUE (chat_id, revision)
+);
@@ -0,0 +1 @@
+ALTER TABLE chats ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,13 @@
+CREATE TABLE outbox
+(
+    id           BIGSERIAL PRIMARY KEY,
+    event_type   VARCHAR(32) NOT NULL,
+    chat_id      BIGINT      NOT NULL,
+    payload      TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    available_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    attempts     INTEGER     NOT NULL DEFAULT 0,
+    last_error   TEXT        NULL,
+    delivered_at TIMESTAMPTZ NULL
+);
+CREATE INDEX idx_outbox_pending ON outbox (delivered_at, available_at, id);
@@ -0,0 +1,27 @@
+CREATE TABLE webhooks
+(
+    id          BIGSERIAL PRIMARY KEY,
+    url         VARCHAR(2048) NOT NULL,
+    event_types VARCHAR(255)  NOT NULL,
+    receiver    VARCHAR(100)  NOT NULL DEFAULT '',
+    secret      VARCHAR(128)  NOT NULL,
+    created_at  TIMESTAMPTZ   NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
+CREATE TABLE webhook_deliveries
+(
+    id          BIGSERIAL PRIMARY KEY,
+    webhook_id  BIGINT      NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_id    BIGINT      NOT NULL,
+    event_type  VARCHAR(32) NOT NULL,
+    payload     TEXT        NOT NULL,
+    status      VARCHAR(16) NOT NULL,
+    attempts    INTEGER     NOT NULL DEFAULT 0,
+    status_code INTEGER     NOT NULL DEFAULT 0,
+    last_error  TEXT        NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    next_try_at TIMESTAMPTZ NULL,
+    sent_at     TIMESTAMPTZ NULL,
+    UNIQUE (webhook_id, event_id)
+);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_try_at);
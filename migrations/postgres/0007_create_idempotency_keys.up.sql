@@ -0,0 +1,9 @@
+CREATE TABLE idempotency_keys
+(
+    idempotency_key VARCHAR(255) PRIMARY KEY,
+    fingerprint     CHAR(64)    NOT NULL,
+    chat_id         BIGINT      NOT NULL,
+    response        TEXT        NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys (created_at);
@@ -0,0 +1,8 @@
+ALTER TABLE api_keys DROP COLUMN tenant_id;
+DROP INDEX IF EXISTS idx_webhooks_tenant_id;
+ALTER TABLE webhooks DROP COLUMN tenant_id;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys DROP COLUMN tenant_id;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (idempotency_key);
+DROP INDEX IF EXISTS idx_chats_tenant_id;
+ALTER TABLE chats DROP COLUMN tenant_id;
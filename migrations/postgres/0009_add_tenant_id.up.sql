@@ -0,0 +1,8 @@
+ALTER TABLE chats ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+CREATE INDEX idx_chats_tenant_id ON chats (tenant_id, created_at, id);
+ALTER TABLE idempotency_keys ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (tenant_id, idempotency_key);
+ALTER TABLE webhooks ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
+CREATE INDEX idx_webhooks_tenant_id ON webhooks (tenant_id);
+ALTER TABLE api_keys ADD COLUMN tenant_id VARCHAR(64) NOT NULL DEFAULT 'default';
@@ -0,0 +1,2 @@
+ALTER TABLE chats DROP COLUMN receiver_raw;
+ALTER TABLE chats DROP COLUMN sender_raw;
@@ -0,0 +1,3 @@
+ALTER TABLE chats ADD COLUMN sender_raw VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE chats ADD COLUMN receiver_raw VARCHAR(100) NOT NULL DEFAULT '';
+UPDATE chats SET sender_raw = sender, receiver_raw = receiver;
@@ -0,0 +1,2 @@
+ALTER TABLE chat_revisions ALTER COLUMN body TYPE VARCHAR(255) USING LEFT(body, 255);
+ALTER TABLE chats ALTER COLUMN body TYPE VARCHAR(255) USING LEFT(body, 255);
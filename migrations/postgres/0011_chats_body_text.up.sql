@@ -0,0 +1,2 @@
+ALTER TABLE chats ALTER COLUMN body TYPE TEXT;
+ALTER TABLE chat_revisions ALTER COLUMN body TYPE TEXT;
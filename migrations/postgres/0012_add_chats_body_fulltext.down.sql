@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_chats_body_fulltext;
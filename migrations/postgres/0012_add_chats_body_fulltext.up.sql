@@ -0,0 +1 @@
+CREATE INDEX idx_chats_body_fulltext ON chats USING GIN (to_tsvector('simple', body));
@@ -0,0 +1,2 @@
+ALTER TABLE chats ADD COLUMN delivered_at TIMESTAMPTZ NULL, ADD COLUMN read_at TIMESTAMPTZ NULL;
+CREATE INDEX idx_chats_unread ON chats (tenant_id, receiver, read_at);
@@ -0,0 +1,2 @@
+DROP INDEX idx_chats_receiver;
+DROP INDEX idx_chats_sender;
@@ -0,0 +1,2 @@
+CREATE INDEX idx_chats_sender ON chats (tenant_id, sender, created_at);
+CREATE INDEX idx_chats_receiver ON chats (tenant_id, receiver, created_at);
@@ -0,0 +1,2 @@
+-- The numbers as typed are still in sender_raw and receiver_raw, so the
+-- normalized ones are kept.
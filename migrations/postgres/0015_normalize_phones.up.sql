@@ -0,0 +1,3 @@
+-- Rewrites the phone numbers of chats and webhooks to E.164 with
+-- normalizePhones in migrations/data.go. Numbers that can't be normalized
+-- are left as they are.
@@ -0,0 +1,100 @@
+// Package phone parses the phone numbers chats are sent between and
+// normalises them to E.164, so one person has a single number however it was
+// typed.
+package phone
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// DefaultCountryCode is the calling code of numbers typed without one, such
+// as "0812-3456-789". It is set from PHONE_DEFAULT_COUNTRY_CODE.
+var DefaultCountryCode = "62"
+
+// E.164 numbers have at most 15 digits. The shortest ones in use, a
+// country code and a 4 digit subscriber number, have 7.
+const (
+	minDigits = 7
+	maxDigits = 15
+)
+
+var (
+	ErrRequired = errors.New("phone: the number is empty")
+	ErrInvalid  = errors.New("phone: not a phone number")
+	ErrLength   = errors.New("phone: a number has 7 to 15 digits")
+)
+
+// countryCodeRegexp is what DefaultCountryCode may be set to.
+var countryCodeRegexp = regexp.MustCompile(`^[1-9][0-9]{0,2}$`)
+
+// extensionRegexp finds an extension at the end of a number, as in
+// "555-8909 ext. 12", "555-8909x12" or "555-8909 #12".
+var extensionRegexp = regexp.MustCompile(`(?i)[ \-./\\]*(?:#|ext\.?|extension|x)[ \-./\\]*([0-9]+)$`)
+
+// separators are dropped, they only group the digits for people.
+var separators = strings.NewReplacer(" ", "", "-", "", ".", "", "/", "", `\`, "", "(", "", ")", "")
+
+// Number is a parsed phone number. E164 is its canonical form, such as
+// "+6281234567890", Extension what was dialled after it, if anything.
+type Number struct {
+	E164      string
+	Extension string
+}
+
+func (n Number) String() string {
+	if n.Extension == "" {
+		return n.E164
+	}
+	return n.E164 + " ext. " + n.Extension
+}
+
+// ValidCountryCode tells whether code can be used as DefaultCountryCode.
+func ValidCountryCode(code string) bool {
+	return countryCodeRegexp.MatchString(code)
+}
+
+// Parse reads a number written with a + or 00 international prefix, or
+// without one as a number of DefaultCountryCode whose leading trunk 0 is
+// dropped.
+func Parse(raw string) (Number, error) {
+	var number Number
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return number, ErrRequired
+	}
+	if match := extensionRegexp.FindStringSubmatchIndex(value); match != nil {
+		number.Extension = value[match[2]:match[3]]
+		value = value[:match[0]]
+	}
+
+	digits := separators.Replace(value)
+	international := false
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits, international = digits[1:], true
+	case strings.HasPrefix(digits, "00"):
+		digits, international = digits[2:], true
+	}
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return number, ErrInvalid
+	}
+	if !international {
+		digits = strings.TrimLeft(digits, "0")
+		if digits == "" {
+			return number, ErrInvalid
+		}
+		digits = DefaultCountryCode + digits
+	}
+	//country codes never start with 0
+	if digits[0] == '0' {
+		return number, ErrInvalid
+	}
+	if len(digits) < minDigits || len(digits) > maxDigits {
+		return number, ErrLength
+	}
+
+	number.E164 = "+" + digits
+	return number, nil
+}
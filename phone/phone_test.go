@@ -0,0 +1,78 @@
+package phone
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		raw       string
+		want      string
+		extension string
+		err       error
+	}{
+		{raw: "+6285274507699", want: "+6285274507699"},
+		{raw: "+62-811-132-431", want: "+62811132431"},
+		{raw: " +62 811 132 431 ", want: "+62811132431"},
+		{raw: "(+351) 282 43 50 50", want: "+351282435050"},
+		{raw: "001 6867684", want: "+16867684"},
+		{raw: "00 44 20 7946 0958", want: "+442079460958"},
+		//national numbers belong to DefaultCountryCode, without their trunk 0
+		{raw: "089899992834", want: "+6289899992834"},
+		{raw: "85274507699", want: "+6285274507699"},
+		{raw: "555-8909", want: "+625558909"},
+		{raw: "62(751)142345", want: "+6262751142345"},
+		{raw: "1-234-567-8901 ext1234", want: "+6212345678901", extension: "1234"},
+		{raw: "1(234)5678901x1234", want: "+6212345678901", extension: "1234"},
+		{raw: "+1 234 567 8901 #55", want: "+12345678901", extension: "55"},
+		{raw: "+1 234 567 8901 extension 55", want: "+12345678901", extension: "55"},
+		{raw: "", err: ErrRequired},
+		{raw: "   ", err: ErrRequired},
+		{raw: "hemhemhem", err: ErrInvalid},
+		{raw: "+62 811 abc", err: ErrInvalid},
+		{raw: "+", err: ErrInvalid},
+		{raw: "000", err: ErrInvalid},
+		{raw: "+0811132431", err: ErrInvalid},
+		{raw: "+62 81", err: ErrLength},
+		{raw: "+62 8112 3456 7890 1234", err: ErrLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := Parse(tt.raw)
+			assert.Equal(t, tt.err, err)
+			if tt.err == nil {
+				assert.Equal(t, tt.want, got.E164)
+				assert.Equal(t, tt.extension, got.Extension)
+			}
+		})
+	}
+}
+
+func TestParse_Default_Country_Code(t *testing.T) {
+	defer func(code string) { DefaultCountryCode = code }(DefaultCountryCode)
+	DefaultCountryCode = "351"
+
+	got, err := Parse("282 43 50 50")
+	assert.Nil(t, err)
+	assert.Equal(t, "+351282435050", got.E164)
+	//international numbers ignore it
+	got, err = Parse("+62811132431")
+	assert.Nil(t, err)
+	assert.Equal(t, "+62811132431", got.E164)
+}
+
+func TestValidCountryCode(t *testing.T) {
+	assert.True(t, ValidCountryCode("62"))
+	assert.True(t, ValidCountryCode("1"))
+	assert.True(t, ValidCountryCode("351"))
+	assert.False(t, ValidCountryCode(""))
+	assert.False(t, ValidCountryCode("062"))
+	assert.False(t, ValidCountryCode("+62"))
+	assert.False(t, ValidCountryCode("1234"))
+}
+
+func TestNumber_String(t *testing.T) {
+	assert.Equal(t, "+62811132431", Number{E164: "+62811132431"}.String())
+	assert.Equal(t, "+62811132431 ext. 12", Number{E164: "+62811132431", Extension: "12"}.String())
+}
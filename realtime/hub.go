@@ -0,0 +1,103 @@
+// Package realtime pushes chat events to connected clients as they happen.
+package realtime
+
+import (
+	"github.com/SemmiDev/lets-tests/events"
+	"sync"
+)
+
+// clientBuffer is how many events a client may lag behind before it is
+// dropped, so one slow connection can't hold up the others.
+const clientBuffer = 64
+
+// Client is one subscription of a phone number to its chat events.
+type Client struct {
+	subscriber
+	events chan events.Event
+}
+
+// subscriber is a phone number within a tenant, the same number in another
+// tenant is someone else.
+type subscriber struct {
+	tenant string
+	phone  string
+}
+
+// Events is closed once the client is unregistered, either by its
+// connection going away or by falling too far behind.
+func (c *Client) Events() <-chan events.Event {
+	return c.events
+}
+
+// Hub routes each chat event to the clients of its sender and receiver.
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[subscriber]map[*Client]bool
+}
+
+// DefaultHub serves the WebSocket endpoint.
+var DefaultHub = NewHub()
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[subscriber]map[*Client]bool)}
+}
+
+func (h *Hub) Register(tenant, phone string) *Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := subscriber{tenant: tenant, phone: phone}
+	client := &Client{subscriber: key, events: make(chan events.Event, clientBuffer)}
+	if h.clients[key] == nil {
+		h.clients[key] = make(map[*Client]bool)
+	}
+	h.clients[key][client] = true
+	return client
+}
+
+func (h *Hub) Unregister(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(client)
+}
+
+// remove expects h.mu to be held.
+func (h *Hub) remove(client *Client) {
+	clients := h.clients[client.subscriber]
+	if !clients[client] {
+		return
+	}
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(h.clients, client.subscriber)
+	}
+	close(client.events)
+}
+
+// Publish is an events.Handler, it never blocks on a client.
+func (h *Hub) Publish(event events.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, phone := range event.Participants() {
+		if seen[phone] {
+			continue
+		}
+		seen[phone] = true
+		for client := range h.clients[subscriber{tenant: event.Tenant(), phone: phone}] {
+			select {
+			case client.events <- event:
+			default:
+				h.remove(client)
+			}
+		}
+	}
+}
+
+// Clients counts the connected clients of a phone number of tenant.
+func (h *Hub) Clients(tenant, phone string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[subscriber{tenant: tenant, phone: phone}])
+}
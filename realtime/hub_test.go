@@ -0,0 +1,74 @@
+package realtime
+
+import (
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/events"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const (
+	alice = "+6282323231"
+	bob   = "+6282323232"
+	carol = "+6282323233"
+)
+
+func chatEvent(eventType events.Type, sender, receiver string) events.Event {
+	return events.Event{Type: eventType, Chat: domain.Chat{Id: 1, Sender: sender, Receiver: receiver, Body: "hi"}}
+}
+
+func TestHub_Publish_To_Participants(t *testing.T) {
+	hub := NewHub()
+	aliceClient := hub.Register(domain.DefaultTenant, alice)
+	bobClient := hub.Register(domain.DefaultTenant, bob)
+	carolClient := hub.Register(domain.DefaultTenant, carol)
+
+	hub.Publish(chatEvent(events.ChatCreated, alice, bob))
+
+	assert.EqualValues(t, events.ChatCreated, (<-aliceClient.Events()).Type)
+	assert.EqualValues(t, events.ChatCreated, (<-bobClient.Events()).Type)
+	assert.Len(t, carolClient.Events(), 0)
+}
+
+func TestHub_Publish_Within_Tenant(t *testing.T) {
+	hub := NewHub()
+	local := hub.Register(domain.DefaultTenant, bob)
+	other := hub.Register("acme", bob)
+
+	event := chatEvent(events.ChatCreated, alice, bob)
+	event.Chat.Tenant = "acme"
+	hub.Publish(event)
+
+	assert.EqualValues(t, events.ChatCreated, (<-other.Events()).Type)
+	assert.Len(t, local.Events(), 0)
+}
+
+func TestHub_Unregister(t *testing.T) {
+	hub := NewHub()
+	client := hub.Register(domain.DefaultTenant, alice)
+	assert.EqualValues(t, 1, hub.Clients(domain.DefaultTenant, alice))
+
+	hub.Unregister(client)
+	hub.Unregister(client)
+	_, open := <-client.Events()
+	assert.False(t, open)
+	assert.EqualValues(t, 0, hub.Clients(domain.DefaultTenant, alice))
+
+	//nobody is listening anymore, publishing must not panic
+	hub.Publish(chatEvent(events.ChatDeleted, alice, bob))
+}
+
+func TestHub_Drops_Slow_Clients(t *testing.T) {
+	hub := NewHub()
+	slow := hub.Register(domain.DefaultTenant, alice)
+	for i := 0; i <= clientBuffer; i++ {
+		hub.Publish(chatEvent(events.ChatUpdated, alice, bob))
+	}
+
+	assert.EqualValues(t, 0, hub.Clients(domain.DefaultTenant, alice))
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+	assert.EqualValues(t, clientBuffer, received)
+}
@@ -0,0 +1,141 @@
+package realtime
+
+import (
+	"github.com/SemmiDev/lets-tests/events"
+	"sync"
+	"time"
+)
+
+// DefaultReplaySize is how many past events DefaultStream keeps for
+// clients resuming with Last-Event-ID.
+const DefaultReplaySize = 1000
+
+// redeliveryWindow is how many outbox ids a Stream remembers to skip
+// events the dispatcher delivers again.
+const redeliveryWindow = 10000
+
+// Entry is an event numbered by the stream. Ids only ever increase, also
+// across restarts as numbering starts from the time the stream was created.
+type Entry struct {
+	Id    int64
+	Event events.Event
+}
+
+// Subscription receives the entries published after it was opened.
+type Subscription struct {
+	entries chan Entry
+}
+
+// Entries is closed when the subscription is closed or falls too far
+// behind.
+func (s *Subscription) Entries() <-chan Entry {
+	return s.entries
+}
+
+// Stream numbers every chat event and keeps the latest ones in a bounded
+// buffer, so a client that reconnects can replay what it missed.
+type Stream struct {
+	mu          sync.Mutex
+	lastId      int64
+	replay      []Entry
+	size        int
+	seen        map[int64]bool
+	seenOrder   []int64
+	window      int
+	subscribers map[*Subscription]bool
+}
+
+// DefaultStream serves the Server-Sent Events endpoint.
+var DefaultStream = NewStream(DefaultReplaySize)
+
+func NewStream(size int) *Stream {
+	if size <= 0 {
+		size = DefaultReplaySize
+	}
+	return &Stream{
+		lastId:      time.Now().UnixNano() / int64(time.Microsecond),
+		size:        size,
+		seen:        make(map[int64]bool),
+		window:      redeliveryWindow,
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Publish is an events.Handler, it never blocks on a subscriber. An event
+// whose outbox id was among the last redeliveryWindow published is not sent
+// again, as the dispatcher delivers it once more whenever another handler
+// failed.
+func (s *Stream) Publish(event events.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.Id != 0 {
+		if s.seen[event.Id] {
+			return
+		}
+		if len(s.seenOrder) == s.window {
+			delete(s.seen, s.seenOrder[0])
+			s.seenOrder = s.seenOrder[1:]
+		}
+		s.seen[event.Id] = true
+		s.seenOrder = append(s.seenOrder, event.Id)
+	}
+
+	s.lastId++
+	entry := Entry{Id: s.lastId, Event: event}
+	if len(s.replay) == s.size {
+		copy(s.replay, s.replay[1:])
+		s.replay = s.replay[:s.size-1]
+	}
+	s.replay = append(s.replay, entry)
+
+	for sub := range s.subscribers {
+		select {
+		case sub.entries <- entry:
+		default:
+			s.remove(sub)
+		}
+	}
+}
+
+// Subscribe opens a subscription together with the entries published
+// after lastId that are still buffered. complete is false when some of
+// them were already dropped, or when lastId comes from before a restart,
+// so the caller can't rely on the replay alone. A zero lastId replays
+// nothing.
+func (s *Stream) Subscribe(lastId int64) (sub *Subscription, missed []Entry, complete bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub = &Subscription{entries: make(chan Entry, clientBuffer)}
+	s.subscribers[sub] = true
+
+	if lastId == 0 || lastId == s.lastId {
+		return sub, nil, true
+	}
+	if lastId > s.lastId {
+		return sub, nil, false
+	}
+	for _, entry := range s.replay {
+		if entry.Id > lastId {
+			missed = append(missed, entry)
+		}
+	}
+	complete = len(s.replay) > 0 && s.replay[0].Id <= lastId+1
+	return sub, missed, complete
+}
+
+func (s *Stream) Unsubscribe(sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(sub)
+}
+
+// remove expects s.mu to be held.
+func (s *Stream) remove(sub *Subscription) {
+	if !s.subscribers[sub] {
+		return
+	}
+	delete(s.subscribers, sub)
+	close(sub.entries)
+}
@@ -0,0 +1,121 @@
+package realtime
+
+import (
+	"github.com/SemmiDev/lets-tests/events"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func outboxIds(entries []Entry) []int64 {
+	ids := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.Event.Id)
+	}
+	return ids
+}
+
+func numberedEvent(id int64) events.Event {
+	event := chatEvent(events.ChatCreated, alice, bob)
+	event.Id = id
+	return event
+}
+
+func TestStream_Live(t *testing.T) {
+	stream := NewStream(10)
+	sub, missed, complete := stream.Subscribe(0)
+	assert.Empty(t, missed)
+	assert.True(t, complete)
+
+	updated := chatEvent(events.ChatUpdated, alice, bob)
+	updated.Id = 8
+	stream.Publish(numberedEvent(7))
+	stream.Publish(updated)
+
+	first, second := <-sub.Entries(), <-sub.Entries()
+	assert.EqualValues(t, first.Id+1, second.Id)
+	assert.EqualValues(t, events.ChatCreated, first.Event.Type)
+	assert.EqualValues(t, events.ChatUpdated, second.Event.Type)
+
+	stream.Unsubscribe(sub)
+	stream.Unsubscribe(sub)
+	_, open := <-sub.Entries()
+	assert.False(t, open)
+}
+
+func TestStream_Ids_Increase_Across_Restarts(t *testing.T) {
+	before := NewStream(10)
+	before.Publish(numberedEvent(1))
+	time.Sleep(time.Millisecond)
+	after := NewStream(10)
+	after.Publish(numberedEvent(2))
+
+	assert.Greater(t, after.replay[0].Id, before.replay[0].Id)
+}
+
+func TestStream_Replay(t *testing.T) {
+	stream := NewStream(3)
+	//event 3 failed a first delivery and came after 4 and 5
+	for _, id := range []int64{1, 2, 4, 5, 3} {
+		stream.Publish(numberedEvent(id))
+	}
+	last := stream.lastId
+
+	tests := []struct {
+		name     string
+		lastId   int64
+		missed   []int64
+		complete bool
+	}{
+		{name: "Up To Date", lastId: last, missed: []int64{}, complete: true},
+		{name: "Buffered", lastId: last - 1, missed: []int64{3}, complete: true},
+		{name: "Oldest Buffered", lastId: last - 2, missed: []int64{5, 3}, complete: true},
+		{name: "Just Before The Buffer", lastId: last - 3, missed: []int64{4, 5, 3}, complete: true},
+		{name: "Dropped", lastId: last - 4, missed: []int64{4, 5, 3}, complete: false},
+		{name: "From Before A Restart", lastId: 42, missed: []int64{4, 5, 3}, complete: false},
+		{name: "From The Future", lastId: last + 1, missed: []int64{}, complete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, missed, complete := stream.Subscribe(tt.lastId)
+			defer stream.Unsubscribe(sub)
+			assert.EqualValues(t, tt.missed, outboxIds(missed))
+			assert.EqualValues(t, tt.complete, complete)
+		})
+	}
+}
+
+func TestStream_Skips_Redelivered_Events(t *testing.T) {
+	stream := NewStream(2)
+	stream.window = 3
+	sub, _, _ := stream.Subscribe(0)
+	defer stream.Unsubscribe(sub)
+
+	//1 is redelivered after leaving the replay buffer, but not the window
+	for _, id := range []int64{1, 2, 3, 1, 4, 1} {
+		stream.Publish(numberedEvent(id))
+	}
+
+	var received []Entry
+	for i := 0; i < 5; i++ {
+		received = append(received, <-sub.Entries())
+	}
+	assert.EqualValues(t, []int64{1, 2, 3, 4, 1}, outboxIds(received))
+	for i := 1; i < len(received); i++ {
+		assert.EqualValues(t, received[i-1].Id+1, received[i].Id)
+	}
+}
+
+func TestStream_Drops_Slow_Subscribers(t *testing.T) {
+	stream := NewStream(10)
+	sub, _, _ := stream.Subscribe(0)
+	for i := 0; i <= clientBuffer; i++ {
+		stream.Publish(numberedEvent(int64(i + 1)))
+	}
+
+	received := 0
+	for range sub.Entries() {
+		received++
+	}
+	assert.EqualValues(t, clientBuffer, received)
+}
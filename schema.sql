@@ -1,27 +1,4 @@
-CREATE
-DATABASE chats;
-USE
-chats;
-CREATE TABLE `chats`
-(
-    `id`         int(11) NOT NULL AUTO_INCREMENT,
-    `sender`     varchar(100) NOT NULL,
-    `receiver`   varchar(100) NOT NULL,
-    `body`       varchar(255) NOT NULL,
-    `created_at` timestamp    NOT NULL DEFAULT current_timestamp(),
-    PRIMARY KEY (`id`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4
-
-CREATE
-DATABASE chats_tests;
-USE
-chats_tests;
-CREATE TABLE `chats`
-(
-    `id`         int(11) NOT NULL AUTO_INCREMENT,
-    `sender`     varchar(100) NOT NULL,
-    `receiver`   varchar(100) NOT NULL,
-    `body`       varchar(255) NOT NULL,
-    `created_at` timestamp    NOT NULL DEFAULT current_timestamp(),
-    PRIMARY KEY (`id`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4
+-- Tables are created by the numbered migrations in migrations/mysql,
+-- run `make migrate-up` once the databases exist.
+CREATE DATABASE IF NOT EXISTS chats;
+CREATE DATABASE IF NOT EXISTS chats_tests;
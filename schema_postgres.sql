@@ -0,0 +1,4 @@
+-- Tables are created by the numbered migrations in migrations/postgres,
+-- run `make migrate-up` once the databases exist.
+CREATE DATABASE chats;
+CREATE DATABASE chats_tests;
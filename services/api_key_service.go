@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/auth"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ApiKeyTouchInterval limits how often the last use of a key is written,
+// a busy key would otherwise cost a write per request.
+var ApiKeyTouchInterval = time.Minute
+
+// apiKeyPrefixLength is how much of a key is kept in clear to list it.
+const apiKeyPrefixLength = len(domain.ApiKeyPrefix) + 8
+
+var (
+	ApiKeysService apiKeyServiceInterface = &apiKeysService{}
+)
+
+type apiKeysService struct{}
+
+type apiKeyServiceInterface interface {
+	IssueApiKey(context.Context, *domain.ApiKey) (*domain.ApiKey, utils.ChatErr)
+	GetAllApiKeys(context.Context) ([]domain.ApiKey, utils.ChatErr)
+	RevokeApiKey(context.Context, int64) (*domain.ApiKey, utils.ChatErr)
+	VerifyKey(context.Context, string) (auth.Identity, utils.ChatErr)
+}
+
+// IssueApiKey generates a key with the given name and scopes. The response
+// is the only place the key can be read from.
+func (s *apiKeysService) IssueApiKey(ctx context.Context, key *domain.ApiKey) (*domain.ApiKey, utils.ChatErr) {
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+	plain := domain.ApiKeyPrefix + secret
+	key.Prefix = plain[:apiKeyPrefixLength]
+	key.Hash = domain.HashApiKey(plain)
+
+	issued, err := domain.ApiKeyRepo.Create(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	issued.Key = plain
+	return issued, nil
+}
+
+func (s *apiKeysService) GetAllApiKeys(ctx context.Context) ([]domain.ApiKey, utils.ChatErr) {
+	return domain.ApiKeyRepo.GetAll(ctx)
+}
+
+// RevokeApiKey refuses a key from now on. Revoking it again changes nothing.
+func (s *apiKeysService) RevokeApiKey(ctx context.Context, keyId int64) (*domain.ApiKey, utils.ChatErr) {
+	key, err := domain.ApiKeyRepo.Get(ctx, keyId)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+	return domain.ApiKeyRepo.Revoke(ctx, keyId, time.Now())
+}
+
+// VerifyKey returns the identity of a service calling with key, and records
+// when the key was last used.
+func (s *apiKeysService) VerifyKey(ctx context.Context, key string) (auth.Identity, utils.ChatErr) {
+	stored, err := domain.ApiKeyRepo.GetByHash(ctx, domain.HashApiKey(key))
+	if err != nil {
+		if err.Status() == http.StatusNotFound {
+			return auth.Identity{}, utils.ErrorKind(utils.UnauthorizedError, "invalid API key")
+		}
+		return auth.Identity{}, err
+	}
+	if stored.RevokedAt != nil {
+		return auth.Identity{}, utils.ErrorKind(utils.UnauthorizedError, "API key was revoked")
+	}
+
+	now := time.Now()
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= ApiKeyTouchInterval {
+		//a failed write shouldn't fail the request it was made for
+		if err := domain.ApiKeyRepo.Touch(ctx, stored.Id, now); err != nil {
+			log.Printf("could not record the use of API key %d: %s", stored.Id, err.Message())
+		}
+	}
+	return auth.Identity{Scopes: stored.Scopes, Tenant: stored.Tenant}, nil
+}
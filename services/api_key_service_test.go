@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestApiKeysService_Issue_Verify_Revoke(t *testing.T) {
+	domain.ApiKeyRepo = domain.NewMemoryApiKeyRepository()
+	ctx := context.Background()
+
+	_, err := ApiKeysService.IssueApiKey(ctx, &domain.ApiKey{Name: "reports"})
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusUnprocessableEntity, err.Status())
+	}
+
+	issued, err := ApiKeysService.IssueApiKey(ctx, &domain.ApiKey{Name: "reports", Scopes: []string{domain.ScopeChatsRead}})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(issued.Key, domain.ApiKeyPrefix))
+	assert.True(t, strings.HasPrefix(issued.Key, issued.Prefix))
+
+	//only the hash is kept
+	keys, err := ApiKeysService.GetAllApiKeys(ctx)
+	assert.Nil(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.Empty(t, keys[0].Key)
+		assert.EqualValues(t, domain.HashApiKey(issued.Key), keys[0].Hash)
+		assert.Nil(t, keys[0].LastUsedAt)
+	}
+
+	identity, err := ApiKeysService.VerifyKey(ctx, issued.Key)
+	assert.Nil(t, err)
+	assert.Empty(t, identity.Phone)
+	assert.True(t, identity.HasScope(domain.ScopeChatsRead))
+	assert.False(t, identity.HasScope(domain.ScopeChatsWrite))
+	keys, _ = ApiKeysService.GetAllApiKeys(ctx)
+	assert.NotNil(t, keys[0].LastUsedAt)
+
+	_, err = ApiKeysService.VerifyKey(ctx, issued.Key+"x")
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusUnauthorized, err.Status())
+	}
+
+	revoked, err := ApiKeysService.RevokeApiKey(ctx, issued.Id)
+	assert.Nil(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	again, err := ApiKeysService.RevokeApiKey(ctx, issued.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, revoked.RevokedAt.Unix(), again.RevokedAt.Unix())
+
+	_, err = ApiKeysService.VerifyKey(ctx, issued.Key)
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusUnauthorized, err.Status())
+		assert.EqualValues(t, "API key was revoked", err.Message())
+	}
+	_, err = ApiKeysService.RevokeApiKey(ctx, 12322)
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusNotFound, err.Status())
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/auth"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+)
+
+// authorizeRead hides the chats the caller takes no part in, as if they
+// didn't exist.
+func authorizeRead(ctx context.Context, chat *domain.Chat) utils.ChatErr {
+	identity, ok := auth.UserFromContext(ctx)
+	if ok && !identity.CanRead(chat.Sender, chat.Receiver) {
+		return utils.ErrorKind(utils.NotFoundError, "no record matching given id")
+	}
+	return nil
+}
+
+// authorizeWrite only lets the sender change a chat. The receiver is told
+// so, anybody else doesn't learn the chat exists.
+func authorizeWrite(ctx context.Context, chat *domain.Chat, action string) utils.ChatErr {
+	if err := authorizeRead(ctx, chat); err != nil {
+		return err
+	}
+	identity, ok := auth.UserFromContext(ctx)
+	if ok && !identity.CanWrite(chat.Sender) {
+		return utils.ErrorKind(utils.ForbiddenError, "only the sender can "+action+" a chat")
+	}
+	return nil
+}
+
+// authorizeSender keeps callers from sending chats as somebody else.
+func authorizeSender(ctx context.Context, chat *domain.Chat) utils.ChatErr {
+	identity, ok := auth.UserFromContext(ctx)
+	if ok && !identity.CanWrite(chat.Sender) {
+		return utils.ErrorKind(utils.ForbiddenError, "chats can only be sent from your own phone number")
+	}
+	return nil
+}
+
+// authorizeReceipt only lets the receiver mark a chat read. The sender is
+// told so, anybody else doesn't learn the chat exists.
+func authorizeReceipt(ctx context.Context, chat *domain.Chat) utils.ChatErr {
+	if err := authorizeRead(ctx, chat); err != nil {
+		return err
+	}
+	identity, ok := auth.UserFromContext(ctx)
+	if ok && !identity.CanMarkRead(chat.Receiver) {
+		return utils.ErrorKind(utils.ForbiddenError, "only the receiver can mark a chat read")
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/auth"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+const (
+	alice = "+6282323231"
+	bob   = "+6282323232"
+	carol = "+6282323233"
+)
+
+func as(phone string) context.Context {
+	return auth.WithIdentity(context.Background(), auth.Identity{Phone: phone})
+}
+
+func expectStatus(t *testing.T, err utils.ChatErr, status int) {
+	t.Helper()
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, status, err.Status())
+	}
+}
+
+func TestChatsService_Authorization(t *testing.T) {
+	domain.ChatRepo = domain.NewMemoryChatRepository()
+
+	_, err := ChatsService.CreateChat(as(bob), &domain.Chat{Sender: alice, Receiver: bob, Body: "hello"})
+	expectStatus(t, err, http.StatusForbidden)
+	_, _, err = ChatsService.CreateChatWithKey(as(bob), "retry-1", &domain.Chat{Sender: alice, Receiver: bob, Body: "hello"})
+	expectStatus(t, err, http.StatusForbidden)
+
+	chat, err := ChatsService.CreateChat(as(alice), &domain.Chat{Sender: alice, Receiver: bob, Body: "hello"})
+	assert.Nil(t, err)
+	_, err = ChatsService.CreateChat(context.Background(), &domain.Chat{Sender: carol, Receiver: bob, Body: "hi bob"})
+	assert.Nil(t, err)
+
+	//both participants read it, anybody else is told it doesn't exist
+	for _, phone := range []string{alice, bob} {
+		_, err = ChatsService.GetChat(as(phone), chat.Id)
+		assert.Nil(t, err)
+		_, err = ChatsService.GetChatRevisions(as(phone), chat.Id)
+		assert.Nil(t, err)
+	}
+	_, err = ChatsService.GetChat(as(carol), chat.Id)
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = ChatsService.GetChatRevisions(as(carol), chat.Id)
+	expectStatus(t, err, http.StatusNotFound)
+
+	page, err := ChatsService.GetAllChats(as(alice), domain.ChatFilter{}, domain.PageRequest{})
+	assert.Nil(t, err)
+	if assert.Len(t, page.Chats, 1) {
+		assert.EqualValues(t, chat.Id, page.Chats[0].Id)
+	}
+	page, err = ChatsService.GetAllChats(as(bob), domain.ChatFilter{}, domain.PageRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, page.Chats, 2)
+
+	_, err = ChatsService.GetConversation(as(alice), alice, bob, domain.PageRequest{})
+	assert.Nil(t, err)
+	_, err = ChatsService.GetConversation(as(alice), carol, bob, domain.PageRequest{})
+	expectStatus(t, err, http.StatusForbidden)
+
+	//only the sender changes it
+	_, err = ChatsService.UpdateChat(as(bob), &domain.Chat{Id: chat.Id, Body: "edited"})
+	expectStatus(t, err, http.StatusForbidden)
+	_, err = ChatsService.UpdateChat(as(carol), &domain.Chat{Id: chat.Id, Body: "edited"})
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = ChatsService.UpdateChat(as(alice), &domain.Chat{Id: chat.Id, Body: "edited"})
+	assert.Nil(t, err)
+
+	expectStatus(t, ChatsService.DeleteChat(as(bob), chat.Id, 0), http.StatusForbidden)
+	expectStatus(t, ChatsService.DeleteChat(as(carol), chat.Id, 0), http.StatusNotFound)
+	assert.Nil(t, ChatsService.DeleteChat(as(alice), chat.Id, 0))
+
+	_, err = ChatsService.RestoreChat(as(bob), chat.Id)
+	expectStatus(t, err, http.StatusForbidden)
+	_, err = ChatsService.RestoreChat(as(carol), chat.Id)
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = ChatsService.RestoreChat(as(alice), chat.Id)
+	assert.Nil(t, err)
+
+	//services calling with an API key act for every number
+	service := auth.WithIdentity(context.Background(), auth.Identity{Scopes: []string{domain.ScopeChatsWrite}})
+	page, err = ChatsService.GetAllChats(service, domain.ChatFilter{}, domain.PageRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, page.Chats, 2)
+	_, err = ChatsService.UpdateChat(service, &domain.Chat{Id: chat.Id, Body: "edited by a job"})
+	assert.Nil(t, err)
+}
+
+func TestChatsService_GetAllChats_IncludeDeleted(t *testing.T) {
+	domain.ChatRepo = domain.NewMemoryChatRepository()
+
+	chat, err := ChatsService.CreateChat(as(alice), &domain.Chat{Sender: alice, Receiver: bob, Body: "hello"})
+	assert.Nil(t, err)
+	assert.Nil(t, ChatsService.DeleteChat(as(alice), chat.Id, 0))
+
+	//only admins list deleted chats, even the ones they sent
+	filter := domain.ChatFilter{IncludeDeleted: true}
+	reader := auth.WithIdentity(context.Background(), auth.Identity{Phone: alice, Scopes: []string{domain.ScopeChatsRead, domain.ScopeChatsWrite}})
+	_, err = ChatsService.GetAllChats(reader, filter, domain.PageRequest{})
+	expectStatus(t, err, http.StatusForbidden)
+	service := auth.WithIdentity(context.Background(), auth.Identity{Scopes: []string{domain.ScopeChatsRead}})
+	_, err = ChatsService.GetAllChats(service, filter, domain.PageRequest{})
+	expectStatus(t, err, http.StatusForbidden)
+
+	admin := auth.WithIdentity(context.Background(), auth.Identity{Scopes: []string{domain.ScopeChatsAdmin}})
+	page, err := ChatsService.GetAllChats(admin, filter, domain.PageRequest{})
+	assert.Nil(t, err)
+	if assert.Len(t, page.Chats, 1) {
+		assert.NotNil(t, page.Chats[0].DeletedAt)
+	}
+}
+
+func TestWebhooksService_Authorization(t *testing.T) {
+	domain.WebhookRepo = domain.NewMemoryWebhookRepository()
+	resolvePublic(t)
+
+	_, err := WebhooksService.CreateWebhook(as(alice), &domain.Webhook{Url: "https://example.com/hooks", Events: []string{"created"}, Receiver: bob})
+	expectStatus(t, err, http.StatusForbidden)
+
+	//the receiver defaults to the caller
+	owned, err := WebhooksService.CreateWebhook(as(alice), &domain.Webhook{Url: "https://example.com/hooks", Events: []string{"created"}})
+	assert.Nil(t, err)
+	assert.EqualValues(t, alice, owned.Receiver)
+	//the own number can be typed in any form
+	typed, err := WebhooksService.CreateWebhook(as(alice), &domain.Webhook{Url: "https://example.com/hooks", Events: []string{"created"}, Receiver: "0823 2323 1"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, alice, typed.Receiver)
+	assert.Nil(t, WebhooksService.DeleteWebhook(as(alice), typed.Id))
+	other, err := WebhooksService.CreateWebhook(context.Background(), &domain.Webhook{Url: "https://example.com/hooks", Events: []string{"created"}})
+	assert.Nil(t, err)
+
+	webhooks, err := WebhooksService.GetAllWebhooks(as(alice))
+	assert.Nil(t, err)
+	if assert.Len(t, webhooks, 1) {
+		assert.EqualValues(t, owned.Id, webhooks[0].Id)
+	}
+
+	_, err = WebhooksService.GetWebhook(as(alice), other.Id)
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = WebhooksService.GetWebhook(as(bob), owned.Id)
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = WebhooksService.UpdateWebhook(as(bob), &domain.Webhook{Id: owned.Id, Url: "https://example.com/hooks", Events: []string{"created"}})
+	expectStatus(t, err, http.StatusNotFound)
+	_, err = WebhooksService.GetDeliveries(as(bob), owned.Id, 0)
+	expectStatus(t, err, http.StatusNotFound)
+	expectStatus(t, WebhooksService.DeleteWebhook(as(bob), owned.Id), http.StatusNotFound)
+
+	_, err = WebhooksService.UpdateWebhook(as(alice), &domain.Webhook{Id: owned.Id, Url: "https://example.com/other", Events: []string{"created"}, Receiver: bob})
+	expectStatus(t, err, http.StatusForbidden)
+	updated, err := WebhooksService.UpdateWebhook(as(alice), &domain.Webhook{Id: owned.Id, Url: "https://example.com/other", Events: []string{"created"}, Receiver: "+62 823-2323-1"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, alice, updated.Receiver)
+	assert.Nil(t, WebhooksService.DeleteWebhook(as(alice), owned.Id))
+}
+
+func TestChatsService_Tenant_Isolation(t *testing.T) {
+	domain.ChatRepo = domain.NewMemoryChatRepository()
+	acme := domain.WithTenant(as(alice), "acme")
+	globex := domain.WithTenant(as(alice), "globex")
+
+	chat, err := ChatsService.CreateChat(acme, &domain.Chat{Sender: alice, Receiver: bob, Body: "hello"})
+	assert.Nil(t, err)
+
+	//the same phone number in another tenant is told the chat doesn't exist
+	_, err = ChatsService.GetChat(globex, chat.Id)
+	expectStatus(t, err, http.StatusNotFound)
+	expectStatus(t, ChatsService.DeleteChat(globex, chat.Id, 0), http.StatusNotFound)
+	page, err := ChatsService.GetAllChats(globex, domain.ChatFilter{}, domain.PageRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, page.Chats, 0)
+
+	got, err := ChatsService.GetChat(acme, chat.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "acme", got.Tenant)
+	assert.Nil(t, ChatsService.DeleteChat(acme, chat.Id, 0))
+}
+
+func TestChatsService_Search_Authorization(t *testing.T) {
+	domain.ChatRepo = domain.NewMemoryChatRepository()
+	domain.ChatSearcher, _ = domain.NewSearcher(domain.ChatRepo)
+
+	ours, err := ChatsService.CreateChat(as(alice), &domain.Chat{Sender: alice, Receiver: bob, Body: "dinner plans"})
+	assert.Nil(t, err)
+	_, err = ChatsService.CreateChat(as(carol), &domain.Chat{Sender: carol, Receiver: bob, Body: "dinner plans"})
+	assert.Nil(t, err)
+	_, err = ChatsService.CreateChat(domain.WithTenant(as(alice), "acme"), &domain.Chat{Sender: alice, Receiver: carol, Body: "dinner plans"})
+	assert.Nil(t, err)
+
+	//alice only finds her own chats of her tenant, bob those of both
+	for phone, want := range map[string]int{alice: 1, bob: 2} {
+		page, err := ChatsService.SearchChats(as(phone), domain.SearchRequest{Query: "dinner"})
+		assert.Nil(t, err)
+		assert.Len(t, page.Hits, want, phone)
+	}
+	page, err := ChatsService.SearchChats(as(alice), domain.SearchRequest{Query: "dinner"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, ours.Id, page.Hits[0].Id)
+}
+
+func TestChatsService_ReadReceipts(t *testing.T) {
+	domain.ChatRepo = domain.NewMemoryChatRepository()
+
+	first, _ := ChatsService.CreateChat(as(alice), &domain.Chat{Sender: alice, Receiver: bob, Body: "hello"})
+	second, _ := ChatsService.CreateChat(as(alice), &domain.Chat{Sender: alice, Receiver: bob, Body: "are you there?"})
+
+	//fetching as the sender doesn't deliver it, as the receiver does
+	chat, err := ChatsService.GetChat(as(alice), first.Id)
+	assert.Nil(t, err)
+	assert.Nil(t, chat.DeliveredAt)
+	page, err := ChatsService.GetConversation(as(bob), bob, alice, domain.PageRequest{})
+	assert.Nil(t, err)
+	for _, chat := range page.Chats {
+		assert.NotNil(t, chat.DeliveredAt)
+	}
+
+	//only the receiver marks it read
+	_, err = ChatsService.ReadChat(as(alice), first.Id)
+	expectStatus(t, err, http.StatusForbidden)
+	_, err = ChatsService.ReadChat(as(carol), first.Id)
+	expectStatus(t, err, http.StatusNotFound)
+	chat, err = ChatsService.ReadChat(as(bob), first.Id)
+	assert.Nil(t, err)
+	assert.NotNil(t, chat.ReadAt)
+
+	counts, err := ChatsService.GetUnreadCounts(as(bob), bob)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []domain.UnreadCount{{Peer: alice, Unread: 1}}, counts)
+	_, err = ChatsService.GetUnreadCounts(as(alice), bob)
+	expectStatus(t, err, http.StatusForbidden)
+
+	_, err = ChatsService.ReadConversation(as(alice), bob, alice, second.Id)
+	expectStatus(t, err, http.StatusForbidden)
+	read, err := ChatsService.ReadConversation(as(bob), bob, alice, second.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, read)
+
+	counts, err = ChatsService.GetUnreadCounts(context.Background(), bob)
+	assert.Nil(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestChatsService_GetInbox(t *testing.T) {
+	domain.ChatRepo = domain.NewMemoryChatRepository()
+
+	_, err := ChatsService.CreateChat(as(alice), &domain.Chat{Sender: alice, Receiver: bob, Body: "hello"})
+	assert.Nil(t, err)
+	_, err = ChatsService.CreateChat(as(carol), &domain.Chat{Sender: carol, Receiver: bob, Body: "hi bob"})
+	assert.Nil(t, err)
+
+	inbox, err := ChatsService.GetInbox(as(bob), "0823-2323-2", domain.PageRequest{})
+	assert.Nil(t, err)
+	if assert.Len(t, inbox.Entries, 2) {
+		assert.EqualValues(t, carol, inbox.Entries[0].Peer)
+		assert.EqualValues(t, 1, inbox.Entries[0].Unread)
+	}
+
+	_, err = ChatsService.GetInbox(as(alice), bob, domain.PageRequest{})
+	expectStatus(t, err, http.StatusForbidden)
+	_, err = ChatsService.GetInbox(context.Background(), "not a phone", domain.PageRequest{})
+	expectStatus(t, err, http.StatusBadRequest)
+}
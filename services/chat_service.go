@@ -1,8 +1,14 @@
 package services
 
 import (
+	"context"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/auth"
 	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/events"
 	"github.com/SemmiDev/lets-tests/utils"
+	"log"
+	"net/http"
 	"time"
 )
 
@@ -13,66 +19,345 @@ var (
 type chatsService struct{}
 
 type chatServiceInterface interface {
-	GetChat(int64) (*domain.Chat, utils.ChatErr)
-	CreateChat(*domain.Chat) (*domain.Chat, utils.ChatErr)
-	UpdateChat(*domain.Chat) (*domain.Chat, utils.ChatErr)
-	DeleteChat(int64) utils.ChatErr
-	GetAllChats() ([]domain.Chat, utils.ChatErr)
+	GetChat(context.Context, int64) (*domain.Chat, utils.ChatErr)
+	CreateChat(context.Context, *domain.Chat) (*domain.Chat, utils.ChatErr)
+	CreateChatWithKey(context.Context, string, *domain.Chat) (*domain.Chat, bool, utils.ChatErr)
+	UpdateChat(context.Context, *domain.Chat) (*domain.Chat, utils.ChatErr)
+	DeleteChat(context.Context, int64, int64) utils.ChatErr
+	GetAllChats(context.Context, domain.ChatFilter, domain.PageRequest) (*domain.ChatPage, utils.ChatErr)
+	GetConversation(context.Context, string, string, domain.PageRequest) (*domain.ChatPage, utils.ChatErr)
+	SearchChats(context.Context, domain.SearchRequest) (*domain.SearchPage, utils.ChatErr)
+	RestoreChat(context.Context, int64) (*domain.Chat, utils.ChatErr)
+	PurgeDeletedChats(context.Context, time.Duration) (int64, utils.ChatErr)
+	GetChatRevisions(context.Context, int64) ([]domain.ChatRevision, utils.ChatErr)
+	ReadChat(context.Context, int64) (*domain.Chat, utils.ChatErr)
+	ReadConversation(context.Context, string, string, int64) (int64, utils.ChatErr)
+	GetUnreadCounts(context.Context, string) ([]domain.UnreadCount, utils.ChatErr)
+	GetInbox(context.Context, string, domain.PageRequest) (*domain.InboxPage, utils.ChatErr)
 }
 
-func (c *chatsService) GetChat(id int64) (*domain.Chat, utils.ChatErr) {
-	message, err := domain.ChatRepo.Get(id)
+func (c *chatsService) GetChat(ctx context.Context, id int64) (*domain.Chat, utils.ChatErr) {
+	message, err := domain.ChatRepo.Get(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	return message, nil
+	if err := authorizeRead(ctx, message); err != nil {
+		return nil, err
+	}
+	chats := []domain.Chat{*message}
+	markDelivered(ctx, chats)
+	return &chats[0], nil
 }
 
-func (c *chatsService) CreateChat(chat *domain.Chat) (*domain.Chat, utils.ChatErr) {
+func (c *chatsService) CreateChat(ctx context.Context, chat *domain.Chat) (*domain.Chat, utils.ChatErr) {
 	if err := chat.Validate(""); err != nil {
 		return nil, err
 	}
+	if err := authorizeSender(ctx, chat); err != nil {
+		return nil, err
+	}
 	chat.CreatedAt = time.Now()
-	chat, err := domain.ChatRepo.Create(chat)
+	chat, err := domain.ChatRepo.Create(ctx, chat)
 	if err != nil {
 		return nil, err
 	}
+	notifyOutbox()
 	return chat, nil
 }
 
-func (c *chatsService) UpdateChat(chat *domain.Chat) (*domain.Chat, utils.ChatErr) {
+// CreateChatWithKey creates a chat at most once per idempotency key. A retry
+// with the same key and payload gets the chat created the first time and
+// replayed set; the same key with another payload is a ConflictError.
+func (c *chatsService) CreateChatWithKey(ctx context.Context, key string, chat *domain.Chat) (*domain.Chat, bool, utils.ChatErr) {
+	if err := domain.ValidateIdempotencyKey(key); err != nil {
+		return nil, false, err
+	}
+	if err := chat.Validate(""); err != nil {
+		return nil, false, err
+	}
+	if err := authorizeSender(ctx, chat); err != nil {
+		return nil, false, err
+	}
+	fingerprint := chat.Fingerprint()
+
+	stored, err := domain.ChatRepo.GetIdempotencyKey(ctx, key)
+	if err == nil {
+		return replay(stored, fingerprint)
+	}
+	if err.Status() != http.StatusNotFound {
+		return nil, false, err
+	}
+
+	chat.CreatedAt = time.Now()
+	created, err := domain.ChatRepo.CreateWithKey(ctx, chat, &domain.IdempotencyKey{Key: key, Fingerprint: fingerprint})
+	if err != nil {
+		if err.Status() != http.StatusConflict {
+			return nil, false, err
+		}
+		//a concurrent request with the same key created the chat first
+		stored, getErr := domain.ChatRepo.GetIdempotencyKey(ctx, key)
+		if getErr != nil {
+			return nil, false, err
+		}
+		return replay(stored, fingerprint)
+	}
+	notifyOutbox()
+	return created, false, nil
+}
+
+func replay(stored *domain.IdempotencyKey, fingerprint string) (*domain.Chat, bool, utils.ChatErr) {
+	if stored.Fingerprint != fingerprint {
+		return nil, false, utils.ErrorKind(utils.ConflictError, "Idempotency-Key was already used for a different chat")
+	}
+	chat := stored.Chat
+	return &chat, true, nil
+}
+
+// UpdateChat replaces the body of a chat. A non zero chat.Version is the
+// version the caller last saw; the update is refused if the chat moved on.
+func (c *chatsService) UpdateChat(ctx context.Context, chat *domain.Chat) (*domain.Chat, utils.ChatErr) {
 	if err := chat.Validate("update"); err != nil {
 		return nil, err
 	}
-	current, err := domain.ChatRepo.Get(chat.Id)
+	current, err := domain.ChatRepo.Get(ctx, chat.Id)
 	if err != nil {
 		return nil, err
 	}
+	if err := authorizeWrite(ctx, current, "edit"); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(current, chat.Version); err != nil {
+		return nil, err
+	}
 
 	current.Body = chat.Body
-	updateMsg, err := domain.ChatRepo.Update(current)
+	updateMsg, err := domain.ChatRepo.Update(ctx, current)
 	if err != nil {
 		return nil, err
 	}
+	notifyOutbox()
 	return updateMsg, nil
 }
 
-func (c *chatsService) DeleteChat(chatId int64) utils.ChatErr {
-	msg, err := domain.ChatRepo.Get(chatId)
+// DeleteChat soft deletes a chat. As in UpdateChat, a non zero version
+// makes the delete conditional.
+func (c *chatsService) DeleteChat(ctx context.Context, chatId int64, version int64) utils.ChatErr {
+	msg, err := domain.ChatRepo.Get(ctx, chatId)
 	if err != nil {
 		return err
 	}
-	deleteErr := domain.ChatRepo.Delete(msg.Id)
+	if err := authorizeWrite(ctx, msg, "delete"); err != nil {
+		return err
+	}
+	if err := checkVersion(msg, version); err != nil {
+		return err
+	}
+	deleteErr := domain.ChatRepo.Delete(ctx, msg.Id, msg.Version)
 	if deleteErr != nil {
 		return deleteErr
 	}
+	notifyOutbox()
 	return nil
 }
 
-func (c *chatsService) GetAllChats() ([]domain.Chat, utils.ChatErr) {
-	chats, err := domain.ChatRepo.GetAll()
+func (c *chatsService) GetAllChats(ctx context.Context, filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+	if identity, ok := auth.FromContext(ctx); ok && filter.IncludeDeleted && !identity.HasScope(domain.ScopeChatsAdmin) {
+		return nil, utils.ErrorKind(utils.ForbiddenError, "include_deleted needs the "+domain.ScopeChatsAdmin+" scope")
+	}
+	if identity, ok := auth.UserFromContext(ctx); ok {
+		filter.Participant = identity.Phone
+	}
+	chats, err := domain.ChatRepo.GetAll(ctx, filter, page)
+	if err != nil {
+		return nil, err
+	}
+	markDelivered(ctx, chats.Chats)
+	return chats, nil
+}
+
+func (c *chatsService) GetConversation(ctx context.Context, a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+	a, b, err := domain.NormalizeConversation(a, b)
 	if err != nil {
 		return nil, err
 	}
+	if identity, ok := auth.UserFromContext(ctx); ok && identity.Phone != a && identity.Phone != b {
+		return nil, utils.ErrorKind(utils.ForbiddenError, "only the participants can read a conversation")
+	}
+	chats, err := domain.ChatRepo.GetConversation(ctx, a, b, page)
+	if err != nil {
+		return nil, err
+	}
+	markDelivered(ctx, chats.Chats)
 	return chats, nil
 }
+
+// SearchChats finds chats by their body. Like the listing, callers only
+// search the chats they take part in.
+func (c *chatsService) SearchChats(ctx context.Context, request domain.SearchRequest) (*domain.SearchPage, utils.ChatErr) {
+	if identity, ok := auth.UserFromContext(ctx); ok {
+		request.Filter.Participant = identity.Phone
+	}
+	hits, err := domain.ChatSearcher.Search(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+func (c *chatsService) RestoreChat(ctx context.Context, chatId int64) (*domain.Chat, utils.ChatErr) {
+	if _, ok := auth.UserFromContext(ctx); ok {
+		deleted, err := domain.ChatRepo.GetDeleted(ctx, chatId)
+		if err != nil {
+			return nil, err
+		}
+		if err := authorizeWrite(ctx, deleted, "restore"); err != nil {
+			return nil, err
+		}
+	}
+	chat, err := domain.ChatRepo.Restore(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	notifyOutbox()
+	return chat, nil
+}
+
+// PurgeDeletedChats permanently removes chats deleted more than retention ago.
+func (c *chatsService) PurgeDeletedChats(ctx context.Context, retention time.Duration) (int64, utils.ChatErr) {
+	if retention <= 0 {
+		return 0, utils.ErrorKind(utils.BadRequestError, "retention should be positive")
+	}
+	return domain.ChatRepo.Purge(ctx, time.Now().Add(-retention))
+}
+
+func (c *chatsService) GetChatRevisions(ctx context.Context, chatId int64) ([]domain.ChatRevision, utils.ChatErr) {
+	//reading the edits of a chat doesn't deliver it, so GetChat isn't used
+	if _, ok := auth.UserFromContext(ctx); ok {
+		chat, err := domain.ChatRepo.Get(ctx, chatId)
+		if err != nil {
+			return nil, err
+		}
+		if err := authorizeRead(ctx, chat); err != nil {
+			return nil, err
+		}
+	}
+	revisions, err := domain.ChatRepo.Revisions(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// ReadChat marks a chat read by its receiver. Reading it again keeps the
+// time it was first read.
+func (c *chatsService) ReadChat(ctx context.Context, chatId int64) (*domain.Chat, utils.ChatErr) {
+	msg, err := domain.ChatRepo.Get(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeReceipt(ctx, msg); err != nil {
+		return nil, err
+	}
+	if msg.ReadAt != nil {
+		return msg, nil
+	}
+	read, err := domain.ChatRepo.MarkRead(ctx, chatId, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	notifyOutbox()
+	return read, nil
+}
+
+// ReadConversation marks every chat peer sent to reader, up to and including
+// upTo, read. It returns how many chats weren't read before.
+func (c *chatsService) ReadConversation(ctx context.Context, reader, peer string, upTo int64) (int64, utils.ChatErr) {
+	reader, peer, err := domain.NormalizeConversation(reader, peer)
+	if err != nil {
+		return 0, err
+	}
+	if upTo <= 0 {
+		return 0, utils.ErrorKind(utils.BadRequestError, "chat id should be a number")
+	}
+	if identity, ok := auth.UserFromContext(ctx); ok && !identity.CanMarkRead(reader) {
+		return 0, utils.ErrorKind(utils.ForbiddenError, "only the receiver can mark a chat read")
+	}
+	read, err := domain.ChatRepo.MarkConversationRead(ctx, reader, peer, upTo, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if read > 0 {
+		notifyOutbox()
+	}
+	return read, nil
+}
+
+// GetUnreadCounts tells, per peer, how many chats sent to phone are unread.
+func (c *chatsService) GetUnreadCounts(ctx context.Context, phone string) ([]domain.UnreadCount, utils.ChatErr) {
+	phone, err := domain.NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	if identity, ok := auth.UserFromContext(ctx); ok && identity.Phone != phone {
+		return nil, utils.ErrorKind(utils.ForbiddenError, "you can only count your own unread chats")
+	}
+	return domain.ChatRepo.UnreadCounts(ctx, phone)
+}
+
+// GetInbox lists the conversations of phone with their last chat and unread
+// count, the one active last first.
+func (c *chatsService) GetInbox(ctx context.Context, phone string, page domain.PageRequest) (*domain.InboxPage, utils.ChatErr) {
+	phone, err := domain.NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	if identity, ok := auth.UserFromContext(ctx); ok && identity.Phone != phone {
+		return nil, utils.ErrorKind(utils.ForbiddenError, "you can only read your own inbox")
+	}
+	return domain.ChatRepo.Inbox(ctx, phone, page)
+}
+
+// markDelivered records that the user calling got the chats sent to them.
+// It is best effort: the chats were fetched either way.
+func markDelivered(ctx context.Context, chats []domain.Chat) {
+	identity, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return
+	}
+	var ids []int64
+	for _, chat := range chats {
+		if chat.Receiver == identity.Phone && chat.DeliveredAt == nil {
+			ids = append(ids, chat.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	deliveredAt := time.Now()
+	if err := domain.ChatRepo.MarkDelivered(ctx, identity.Phone, ids, deliveredAt); err != nil {
+		log.Printf("could not mark %d chats delivered: %s", len(ids), err.Message())
+		return
+	}
+	for i := range chats {
+		if chats[i].Receiver == identity.Phone && chats[i].DeliveredAt == nil {
+			chats[i].DeliveredAt = &deliveredAt
+		}
+	}
+}
+
+// checkVersion fails with a PreconditionFailedError when the caller expected
+// another version than the current one. Zero means no expectation.
+func checkVersion(current *domain.Chat, expected int64) utils.ChatErr {
+	if expected != 0 && expected != current.Version {
+		return utils.ErrorKind(utils.PreconditionFailedError, fmt.Sprintf("chat %d is at version %d, not %d", current.Id, current.Version, expected))
+	}
+	return nil
+}
+
+// notifyOutbox wakes the dispatcher up so the event the repository just
+// recorded is delivered without waiting for the next poll.
+func notifyOutbox() {
+	if events.DefaultDispatcher != nil {
+		events.DefaultDispatcher.Notify()
+	}
+}
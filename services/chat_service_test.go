@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/SemmiDev/lets-tests/domain"
@@ -20,26 +21,80 @@ var (
 	getChatDomain     func(chatId int64) (*domain.Chat, utils.ChatErr)
 	createChatDomain  func(msg *domain.Chat) (*domain.Chat, utils.ChatErr)
 	updateChatDomain  func(msg *domain.Chat) (*domain.Chat, utils.ChatErr)
-	deleteChatDomain  func(chatId int64) utils.ChatErr
-	getAllChatsDomain func() ([]domain.Chat, utils.ChatErr)
+	deleteChatDomain  func(chatId int64, version int64) utils.ChatErr
+	getAllChatsDomain func(filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr)
+	getConversation   func(a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr)
+	getDeletedDomain  func(chatId int64) (*domain.Chat, utils.ChatErr)
+	restoreChatDomain func(chatId int64) (*domain.Chat, utils.ChatErr)
+	purgeChatsDomain  func(deletedBefore time.Time) (int64, utils.ChatErr)
+	revisionsDomain   func(chatId int64) ([]domain.ChatRevision, utils.ChatErr)
+	createWithKey     func(msg *domain.Chat, key *domain.IdempotencyKey) (*domain.Chat, utils.ChatErr)
+	getKeyDomain      func(key string) (*domain.IdempotencyKey, utils.ChatErr)
+	markReadDomain    func(chatId int64, readAt time.Time) (*domain.Chat, utils.ChatErr)
+	readConversation  func(receiver, sender string, upTo int64, readAt time.Time) (int64, utils.ChatErr)
+	markDeliveredDB   func(receiver string, ids []int64, deliveredAt time.Time) utils.ChatErr
+	unreadCounts      func(receiver string) ([]domain.UnreadCount, utils.ChatErr)
+	inboxDomain       func(phone string, page domain.PageRequest) (*domain.InboxPage, utils.ChatErr)
 )
 
 type getDBMock struct{}
 
-func (m *getDBMock) Get(chatId int64) (*domain.Chat, utils.ChatErr) {
+func (m *getDBMock) Get(ctx context.Context, chatId int64) (*domain.Chat, utils.ChatErr) {
 	return getChatDomain(chatId)
 }
-func (m *getDBMock) Create(msg *domain.Chat) (*domain.Chat, utils.ChatErr) {
+func (m *getDBMock) Create(ctx context.Context, msg *domain.Chat) (*domain.Chat, utils.ChatErr) {
 	return createChatDomain(msg)
 }
-func (m *getDBMock) Update(msg *domain.Chat) (*domain.Chat, utils.ChatErr) {
+func (m *getDBMock) Update(ctx context.Context, msg *domain.Chat) (*domain.Chat, utils.ChatErr) {
 	return updateChatDomain(msg)
 }
-func (m *getDBMock) Delete(chatId int64) utils.ChatErr {
-	return deleteChatDomain(chatId)
+func (m *getDBMock) Delete(ctx context.Context, chatId int64, version int64) utils.ChatErr {
+	return deleteChatDomain(chatId, version)
 }
-func (m *getDBMock) GetAll() ([]domain.Chat, utils.ChatErr) {
-	return getAllChatsDomain()
+func (m *getDBMock) GetAll(ctx context.Context, filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+	return getAllChatsDomain(filter, page)
+}
+func (m *getDBMock) GetConversation(ctx context.Context, a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+	return getConversation(a, b, page)
+}
+func (m *getDBMock) GetDeleted(ctx context.Context, chatId int64) (*domain.Chat, utils.ChatErr) {
+	return getDeletedDomain(chatId)
+}
+func (m *getDBMock) Restore(ctx context.Context, chatId int64) (*domain.Chat, utils.ChatErr) {
+	return restoreChatDomain(chatId)
+}
+func (m *getDBMock) Purge(ctx context.Context, deletedBefore time.Time) (int64, utils.ChatErr) {
+	return purgeChatsDomain(deletedBefore)
+}
+func (m *getDBMock) Revisions(ctx context.Context, chatId int64) ([]domain.ChatRevision, utils.ChatErr) {
+	return revisionsDomain(chatId)
+}
+func (m *getDBMock) CreateWithKey(ctx context.Context, msg *domain.Chat, key *domain.IdempotencyKey) (*domain.Chat, utils.ChatErr) {
+	return createWithKey(msg, key)
+}
+func (m *getDBMock) GetIdempotencyKey(ctx context.Context, key string) (*domain.IdempotencyKey, utils.ChatErr) {
+	return getKeyDomain(key)
+}
+func (m *getDBMock) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, utils.ChatErr) {
+	return 0, nil
+}
+func (m *getDBMock) MarkRead(ctx context.Context, chatId int64, readAt time.Time) (*domain.Chat, utils.ChatErr) {
+	return markReadDomain(chatId, readAt)
+}
+func (m *getDBMock) MarkConversationRead(ctx context.Context, receiver, sender string, upTo int64, readAt time.Time) (int64, utils.ChatErr) {
+	return readConversation(receiver, sender, upTo, readAt)
+}
+func (m *getDBMock) MarkDelivered(ctx context.Context, receiver string, ids []int64, deliveredAt time.Time) utils.ChatErr {
+	if markDeliveredDB == nil {
+		return nil
+	}
+	return markDeliveredDB(receiver, ids, deliveredAt)
+}
+func (m *getDBMock) UnreadCounts(ctx context.Context, receiver string) ([]domain.UnreadCount, utils.ChatErr) {
+	return unreadCounts(receiver)
+}
+func (m *getDBMock) Inbox(ctx context.Context, phone string, page domain.PageRequest) (*domain.InboxPage, utils.ChatErr) {
+	return inboxDomain(phone, page)
 }
 func (m *getDBMock) Initialize(string, string, string, string, string, string) *sql.DB {
 	return nil
@@ -58,7 +113,7 @@ func TestChatsService_GetChat_Success(t *testing.T) {
 		}, nil
 	}
 
-	msg, err := ChatsService.GetChat(1)
+	msg, err := ChatsService.GetChat(context.Background(), 1)
 
 	fmt.Println("this is the chat: ", msg)
 	assert.NotNil(t, msg)
@@ -70,6 +125,24 @@ func TestChatsService_GetChat_Success(t *testing.T) {
 	assert.EqualValues(t, now, msg.CreatedAt)
 }
 
+func TestChatsService_GetChat_Delivers(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	getChatDomain = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return &domain.Chat{Id: chatId, Sender: sender, Receiver: receiver, Body: body, CreatedAt: now}, nil
+	}
+	var delivered []int64
+	markDeliveredDB = func(to string, ids []int64, deliveredAt time.Time) utils.ChatErr {
+		delivered = append(delivered, ids...)
+		return nil
+	}
+	defer func() { markDeliveredDB = nil }()
+
+	msg, err := ChatsService.GetChat(as(receiver), 1)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int64{1}, delivered)
+	assert.NotNil(t, msg.DeliveredAt)
+}
+
 func TestChatsService_GetChat_NotFoundID(t *testing.T) {
 	domain.ChatRepo = &getDBMock{}
 
@@ -77,7 +150,7 @@ func TestChatsService_GetChat_NotFoundID(t *testing.T) {
 		return nil, utils.ErrorKind(utils.NotFoundError, "the id is not found")
 	}
 
-	msg, err := ChatsService.GetChat(1)
+	msg, err := ChatsService.GetChat(context.Background(), 1)
 	assert.Nil(t, msg)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.Status())
@@ -105,10 +178,12 @@ func TestChatsService_CreateChat_Success(t *testing.T) {
 		CreatedAt: now,
 	}
 
-	msg, err := ChatsService.CreateChat(request)
+	msg, err := ChatsService.CreateChat(context.Background(), request)
 	fmt.Println("this is the chat: ", msg)
+	if err != nil {
+		t.Fatalf("CreateChat() error = %v: %s", err, err.Message())
+	}
 	assert.NotNil(t, msg)
-	assert.Nil(t, err)
 	assert.EqualValues(t, 1, msg.Id)
 	assert.EqualValues(t, sender, msg.Sender)
 	assert.EqualValues(t, receiver, msg.Receiver)
@@ -180,7 +255,7 @@ func TestChatsService_CreateChat_Invalid_Request(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		msg, err := ChatsService.CreateChat(tt.request)
+		msg, err := ChatsService.CreateChat(context.Background(), tt.request)
 		assert.Nil(t, msg)
 		assert.NotNil(t, err)
 		assert.EqualValues(t, tt.errMsg, err.Message())
@@ -216,7 +291,7 @@ func TestChatsService_UpdateChat_Success(t *testing.T) {
 		Body: newBody,
 	}
 
-	msg, err := ChatsService.UpdateChat(request)
+	msg, err := ChatsService.UpdateChat(context.Background(), request)
 	assert.NotNil(t, msg)
 	assert.Nil(t, err)
 	assert.EqualValues(t, 1, msg.Id)
@@ -240,7 +315,7 @@ func TestChatsService_UpdateMessage_Empty_Body(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		msg, err := ChatsService.UpdateChat(tt.request)
+		msg, err := ChatsService.UpdateChat(context.Background(), tt.request)
 		assert.Nil(t, msg)
 		assert.NotNil(t, err)
 		assert.EqualValues(t, tt.statusCode, err.Status())
@@ -262,7 +337,7 @@ func TestChatsService_UpdateMessage_Failure_Getting_Former_Chat(t *testing.T) {
 		CreatedAt: time.Time{},
 	}
 
-	msg, err := ChatsService.UpdateChat(request)
+	msg, err := ChatsService.UpdateChat(context.Background(), request)
 	assert.Nil(t, msg)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, "error getting chat", err.Message())
@@ -289,7 +364,7 @@ func TestChatsService_UpdateMessage_Failure_Updating_Chat(t *testing.T) {
 		Body: utils.RandomBody(),
 	}
 
-	msg, err := ChatsService.UpdateChat(request)
+	msg, err := ChatsService.UpdateChat(context.Background(), request)
 	assert.Nil(t, msg)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, "error updating message", err.Message())
@@ -307,11 +382,11 @@ func TestChatsService_DeleteChat_Success(t *testing.T) {
 		}, nil
 	}
 
-	deleteChatDomain = func(chatId int64) utils.ChatErr {
+	deleteChatDomain = func(chatId int64, version int64) utils.ChatErr {
 		return nil
 	}
 
-	err := ChatsService.DeleteChat(1)
+	err := ChatsService.DeleteChat(context.Background(), 1, 0)
 	assert.Nil(t, err)
 }
 
@@ -321,7 +396,7 @@ func TestChatsService_DeleteMessage_Error_Getting_Chat(t *testing.T) {
 		return nil, utils.ErrorKind(utils.InternalServerError, "Something went wrong getting chat")
 	}
 
-	err := ChatsService.DeleteChat(1)
+	err := ChatsService.DeleteChat(context.Background(), 1, 0)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, "Something went wrong getting chat", err.Message())
 	assert.EqualValues(t, http.StatusInternalServerError, err.Status())
@@ -339,17 +414,66 @@ func TestChatsService_DeleteMessage_Error_Deleting_Chat(t *testing.T) {
 		}, nil
 	}
 
-	deleteChatDomain = func(chatId int64) utils.ChatErr {
+	deleteChatDomain = func(chatId int64, version int64) utils.ChatErr {
 		return utils.ErrorKind(utils.InternalServerError, "error deleting chat")
 	}
 
-	err := ChatsService.DeleteChat(1)
+	err := ChatsService.DeleteChat(context.Background(), 1, 0)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, "error deleting chat", err.Message())
 	assert.EqualValues(t, http.StatusInternalServerError, err.Status())
 	assert.EqualValues(t, "server_error", err.Error())
 }
 
+func TestChatsService_Stale_Version(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	getChatDomain = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return &domain.Chat{Id: 1, Sender: sender, Receiver: receiver, Body: body, Version: 3}, nil
+	}
+	updateChatDomain = func(msg *domain.Chat) (*domain.Chat, utils.ChatErr) {
+		t.Fatal("Update() should not be reached with a stale version")
+		return nil, nil
+	}
+	deleteChatDomain = func(chatId int64, version int64) utils.ChatErr {
+		t.Fatal("Delete() should not be reached with a stale version")
+		return nil
+	}
+
+	msg, err := ChatsService.UpdateChat(context.Background(), &domain.Chat{Id: 1, Body: utils.RandomBody(), Version: 2})
+	assert.Nil(t, msg)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusPreconditionFailed, err.Status())
+	assert.EqualValues(t, "chat 1 is at version 3, not 2", err.Message())
+
+	err = ChatsService.DeleteChat(context.Background(), 1, 2)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusPreconditionFailed, err.Status())
+}
+
+func TestChatsService_Matching_Version(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	getChatDomain = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return &domain.Chat{Id: 1, Sender: sender, Receiver: receiver, Body: body, Version: 3}, nil
+	}
+	updateChatDomain = func(msg *domain.Chat) (*domain.Chat, utils.ChatErr) {
+		msg.Version++
+		return msg, nil
+	}
+	var deletedVersion int64
+	deleteChatDomain = func(chatId int64, version int64) utils.ChatErr {
+		deletedVersion = version
+		return nil
+	}
+
+	msg, err := ChatsService.UpdateChat(context.Background(), &domain.Chat{Id: 1, Body: utils.RandomBody(), Version: 3})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4, msg.Version)
+
+	//the repository re-checks the version it read, so a concurrent write still fails
+	assert.Nil(t, ChatsService.DeleteChat(context.Background(), 1, 0))
+	assert.EqualValues(t, 3, deletedVersion)
+}
+
 func TestChatsService_GetAllChats(t *testing.T) {
 	domain.ChatRepo = &getDBMock{}
 
@@ -357,26 +481,36 @@ func TestChatsService_GetAllChats(t *testing.T) {
 	newReceiver := utils.RandomReceiver()
 	newBody := utils.RandomBody()
 
-	getAllChatsDomain = func() ([]domain.Chat, utils.ChatErr) {
-		return []domain.Chat{
-			{
-				Id:       1,
-				Sender:   sender,
-				Receiver: receiver,
-				Body:     body,
-			},
-			{
-				Id:       2,
-				Sender:   newSender,
-				Receiver: newReceiver,
-				Body:     newBody,
+	getAllChatsDomain = func(filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+		assert.EqualValues(t, sender, filter.Sender)
+		assert.EqualValues(t, 2, page.Limit)
+		return &domain.ChatPage{
+			Chats: []domain.Chat{
+				{
+					Id:       1,
+					Sender:   sender,
+					Receiver: receiver,
+					Body:     body,
+				},
+				{
+					Id:       2,
+					Sender:   newSender,
+					Receiver: newReceiver,
+					Body:     newBody,
+				},
 			},
+			NextCursor: "next",
+			HasMore:    true,
 		}, nil
 	}
 
-	messages, err := ChatsService.GetAllChats()
+	page, err := ChatsService.GetAllChats(context.Background(), domain.ChatFilter{Sender: sender}, domain.PageRequest{Limit: 2})
 	assert.Nil(t, err)
-	assert.NotNil(t, messages)
+	assert.NotNil(t, page)
+	assert.True(t, page.HasMore)
+	assert.EqualValues(t, "next", page.NextCursor)
+
+	messages := page.Chats
 	assert.EqualValues(t, messages[0].Id, 1)
 	assert.EqualValues(t, messages[0].Sender, sender)
 	assert.EqualValues(t, messages[0].Receiver, receiver)
@@ -390,14 +524,204 @@ func TestChatsService_GetAllChats(t *testing.T) {
 
 func TestChatsService_GetAllChats_Error_Getting_Chats(t *testing.T) {
 	domain.ChatRepo = &getDBMock{}
-	getAllChatsDomain = func() ([]domain.Chat, utils.ChatErr) {
+	getAllChatsDomain = func(filter domain.ChatFilter, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
 		return nil, utils.ErrorKind(utils.InternalServerError, "error getting chats")
 	}
 
-	messages, err := ChatsService.GetAllChats()
+	messages, err := ChatsService.GetAllChats(context.Background(), domain.ChatFilter{}, domain.PageRequest{})
 	assert.NotNil(t, err)
 	assert.Nil(t, messages)
 	assert.EqualValues(t, http.StatusInternalServerError, err.Status())
 	assert.EqualValues(t, "error getting chats", err.Message())
 	assert.EqualValues(t, "server_error", err.Error())
 }
+
+func TestChatsService_GetConversation(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+
+	getConversation = func(a, b string, page domain.PageRequest) (*domain.ChatPage, utils.ChatErr) {
+		return &domain.ChatPage{
+			Chats: []domain.Chat{
+				{Id: 1, Sender: a, Receiver: b, Body: body},
+				{Id: 2, Sender: b, Receiver: a, Body: body},
+			},
+		}, nil
+	}
+
+	page, err := ChatsService.GetConversation(context.Background(), "+6282323231", "+6282323232", domain.PageRequest{})
+	assert.Nil(t, err)
+	assert.NotNil(t, page)
+	assert.EqualValues(t, 2, len(page.Chats))
+	assert.EqualValues(t, "+6282323231", page.Chats[0].Sender)
+	assert.EqualValues(t, "+6282323231", page.Chats[1].Receiver)
+}
+
+func TestChatsService_GetConversation_Invalid_Participants(t *testing.T) {
+	tests := []struct {
+		a      string
+		b      string
+		errMsg string
+	}{
+		{a: "", b: "+6282323232", errMsg: "Required Conversation Participants"},
+		{a: "hemhemhem", b: "+6282323232", errMsg: "Invalid Conversation Phone Number"},
+		{a: "+6282323232", b: "+6282323232", errMsg: "Conversation participants must different"},
+	}
+	for _, tt := range tests {
+		page, err := ChatsService.GetConversation(context.Background(), tt.a, tt.b, domain.PageRequest{})
+		assert.Nil(t, page)
+		assert.NotNil(t, err)
+		assert.EqualValues(t, tt.errMsg, err.Message())
+		assert.EqualValues(t, http.StatusBadRequest, err.Status())
+		assert.EqualValues(t, "bad_request", err.Error())
+	}
+}
+
+func TestChatsService_RestoreChat(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	restoreChatDomain = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return &domain.Chat{Id: chatId, Sender: sender, Receiver: receiver, Body: body}, nil
+	}
+
+	msg, err := ChatsService.RestoreChat(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.NotNil(t, msg)
+	assert.EqualValues(t, 1, msg.Id)
+	assert.Nil(t, msg.DeletedAt)
+}
+
+func TestChatsService_PurgeDeletedChats(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	purgeChatsDomain = func(deletedBefore time.Time) (int64, utils.ChatErr) {
+		//a 24h retention purges what was deleted before yesterday
+		assert.WithinDuration(t, time.Now().Add(-24*time.Hour), deletedBefore, time.Minute)
+		return 2, nil
+	}
+
+	purged, err := ChatsService.PurgeDeletedChats(context.Background(), 24*time.Hour)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, purged)
+
+	_, err = ChatsService.PurgeDeletedChats(context.Background(), 0)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.Status())
+}
+
+func TestChatsService_GetChatRevisions(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	revisionsDomain = func(chatId int64) ([]domain.ChatRevision, utils.ChatErr) {
+		return []domain.ChatRevision{
+			{Id: 1, ChatId: chatId, Revision: 1, Body: "original", ReplacedAt: now},
+		}, nil
+	}
+
+	revisions, err := ChatsService.GetChatRevisions(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+	assert.EqualValues(t, "original", revisions[0].Body)
+}
+
+func TestChatsService_GetChatRevisions_Does_Not_Deliver(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	getChatDomain = func(chatId int64) (*domain.Chat, utils.ChatErr) {
+		return &domain.Chat{Id: chatId, Sender: sender, Receiver: receiver, Body: body, CreatedAt: now}, nil
+	}
+	revisionsDomain = func(chatId int64) ([]domain.ChatRevision, utils.ChatErr) {
+		return []domain.ChatRevision{}, nil
+	}
+	markDeliveredDB = func(to string, ids []int64, deliveredAt time.Time) utils.ChatErr {
+		t.Errorf("reading revisions marked %v delivered", ids)
+		return nil
+	}
+	defer func() { markDeliveredDB = nil }()
+
+	_, err := ChatsService.GetChatRevisions(as(receiver), 1)
+	assert.Nil(t, err)
+}
+
+func TestChatsService_GetChatRevisions_Not_Found(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	revisionsDomain = func(chatId int64) ([]domain.ChatRevision, utils.ChatErr) {
+		return nil, utils.ErrorKind(utils.NotFoundError, "no record matching given id")
+	}
+
+	revisions, err := ChatsService.GetChatRevisions(context.Background(), 1)
+	assert.Nil(t, revisions)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.Status())
+}
+
+func TestChatsService_CreateChatWithKey(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	chat := domain.Chat{Sender: sender, Receiver: receiver, Body: body}
+	stored := map[string]domain.IdempotencyKey{}
+
+	getKeyDomain = func(key string) (*domain.IdempotencyKey, utils.ChatErr) {
+		if found, ok := stored[key]; ok {
+			return &found, nil
+		}
+		return nil, utils.ErrorKind(utils.NotFoundError, "no record matching given id")
+	}
+	createWithKey = func(msg *domain.Chat, key *domain.IdempotencyKey) (*domain.Chat, utils.ChatErr) {
+		msg.Id, msg.Version = 1, 1
+		stored[key.Key] = domain.IdempotencyKey{Key: key.Key, Fingerprint: key.Fingerprint, Chat: *msg}
+		return msg, nil
+	}
+
+	first := chat
+	created, replayed, err := ChatsService.CreateChatWithKey(context.Background(), "retry-1", &first)
+	assert.Nil(t, err)
+	assert.False(t, replayed)
+	assert.EqualValues(t, 1, created.Id)
+
+	//the retry gets the same chat, nothing new is created
+	createWithKey = func(msg *domain.Chat, key *domain.IdempotencyKey) (*domain.Chat, utils.ChatErr) {
+		t.Fatal("a replayed request created a chat")
+		return nil, nil
+	}
+	retry := chat
+	replay, replayed, err := ChatsService.CreateChatWithKey(context.Background(), "retry-1", &retry)
+	assert.Nil(t, err)
+	assert.True(t, replayed)
+	assert.EqualValues(t, created, replay)
+
+	other := chat
+	other.Body = "something else"
+	_, _, err = ChatsService.CreateChatWithKey(context.Background(), "retry-1", &other)
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusConflict, err.Status())
+		assert.EqualValues(t, "Idempotency-Key was already used for a different chat", err.Message())
+	}
+
+	invalid := chat
+	_, _, err = ChatsService.CreateChatWithKey(context.Background(), "not a key", &invalid)
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusBadRequest, err.Status())
+	}
+}
+
+func TestChatsService_CreateChatWithKey_Concurrent(t *testing.T) {
+	domain.ChatRepo = &getDBMock{}
+	chat := domain.Chat{Sender: sender, Receiver: receiver, Body: body}
+	//keys remember the fingerprint of the normalized chat
+	stored := chat
+	_ = stored.Validate("")
+	lookups := 0
+
+	//the key is free when checked, then taken by a concurrent request
+	getKeyDomain = func(key string) (*domain.IdempotencyKey, utils.ChatErr) {
+		lookups++
+		if lookups == 1 {
+			return nil, utils.ErrorKind(utils.NotFoundError, "no record matching given id")
+		}
+		return &domain.IdempotencyKey{Key: key, Fingerprint: stored.Fingerprint(), Chat: domain.Chat{Id: 9, Version: 1}}, nil
+	}
+	createWithKey = func(msg *domain.Chat, key *domain.IdempotencyKey) (*domain.Chat, utils.ChatErr) {
+		return nil, utils.ErrorKind(utils.ConflictError, "record already exists")
+	}
+
+	retry := chat
+	replay, replayed, err := ChatsService.CreateChatWithKey(context.Background(), "retry-1", &retry)
+	assert.Nil(t, err)
+	assert.True(t, replayed)
+	assert.EqualValues(t, 9, replay.Id)
+}
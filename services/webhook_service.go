@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/SemmiDev/lets-tests/auth"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/utils"
+)
+
+// DefaultDeliveryLogLimit is how many deliveries are listed when the caller
+// doesn't say.
+const DefaultDeliveryLogLimit = 50
+
+var (
+	WebhooksService webhookServiceInterface = &webhooksService{}
+)
+
+type webhooksService struct{}
+
+type webhookServiceInterface interface {
+	CreateWebhook(context.Context, *domain.Webhook) (*domain.Webhook, utils.ChatErr)
+	GetWebhook(context.Context, int64) (*domain.Webhook, utils.ChatErr)
+	GetAllWebhooks(context.Context) ([]domain.Webhook, utils.ChatErr)
+	UpdateWebhook(context.Context, *domain.Webhook) (*domain.Webhook, utils.ChatErr)
+	DeleteWebhook(context.Context, int64) utils.ChatErr
+	GetDeliveries(context.Context, int64, int) ([]domain.WebhookDelivery, utils.ChatErr)
+}
+
+// CreateWebhook registers a webhook. Without a secret one is generated, the
+// response is the only place it can be read from.
+func (s *webhooksService) CreateWebhook(ctx context.Context, webhook *domain.Webhook) (*domain.Webhook, utils.ChatErr) {
+	if err := webhook.Validate(); err != nil {
+		return nil, err
+	}
+	if err := authorizeReceiver(ctx, webhook); err != nil {
+		return nil, err
+	}
+	if err := domain.CheckWebhookTarget(ctx, webhook.Url); err != nil {
+		return nil, err
+	}
+	if webhook.Secret == "" {
+		secret, err := generateSecret()
+		if err != nil {
+			return nil, err
+		}
+		webhook.Secret = secret
+	}
+	return domain.WebhookRepo.Create(ctx, webhook)
+}
+
+func (s *webhooksService) GetWebhook(ctx context.Context, webhookId int64) (*domain.Webhook, utils.ChatErr) {
+	webhook, err := domain.WebhookRepo.Get(ctx, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if identity, ok := auth.UserFromContext(ctx); ok && webhook.Receiver != identity.Phone {
+		return nil, utils.ErrorKind(utils.NotFoundError, "no record matching given id")
+	}
+	return webhook, nil
+}
+
+func (s *webhooksService) GetAllWebhooks(ctx context.Context) ([]domain.Webhook, utils.ChatErr) {
+	webhooks, err := domain.WebhookRepo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	identity, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return webhooks, nil
+	}
+	owned := make([]domain.Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if webhook.Receiver == identity.Phone {
+			owned = append(owned, webhook)
+		}
+	}
+	return owned, nil
+}
+
+// UpdateWebhook replaces a webhook. An empty secret keeps the current one.
+func (s *webhooksService) UpdateWebhook(ctx context.Context, webhook *domain.Webhook) (*domain.Webhook, utils.ChatErr) {
+	if _, err := s.GetWebhook(ctx, webhook.Id); err != nil {
+		return nil, err
+	}
+	if err := webhook.Validate(); err != nil {
+		return nil, err
+	}
+	if err := authorizeReceiver(ctx, webhook); err != nil {
+		return nil, err
+	}
+	if err := domain.CheckWebhookTarget(ctx, webhook.Url); err != nil {
+		return nil, err
+	}
+	return domain.WebhookRepo.Update(ctx, webhook)
+}
+
+func (s *webhooksService) DeleteWebhook(ctx context.Context, webhookId int64) utils.ChatErr {
+	if _, err := s.GetWebhook(ctx, webhookId); err != nil {
+		return err
+	}
+	return domain.WebhookRepo.Delete(ctx, webhookId)
+}
+
+// GetDeliveries lists the latest deliveries of a webhook, newest first.
+func (s *webhooksService) GetDeliveries(ctx context.Context, webhookId int64, limit int) ([]domain.WebhookDelivery, utils.ChatErr) {
+	if limit == 0 {
+		limit = DefaultDeliveryLogLimit
+	}
+	if limit < 0 || limit > domain.MaxPageLimit {
+		return nil, utils.ErrorKind(utils.BadRequestError, "limit should be between 1 and 100")
+	}
+	if _, err := s.GetWebhook(ctx, webhookId); err != nil {
+		return nil, err
+	}
+	return domain.WebhookRepo.Deliveries(ctx, webhookId, limit)
+}
+
+// authorizeReceiver keeps the webhooks of a caller on the chats they
+// receive, the receiver defaults to the caller. It expects the webhook to
+// be validated, so the receiver is compared in E.164.
+func authorizeReceiver(ctx context.Context, webhook *domain.Webhook) utils.ChatErr {
+	identity, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if webhook.Receiver == "" {
+		webhook.Receiver = identity.Phone
+	}
+	if webhook.Receiver != identity.Phone {
+		return utils.ErrorKind(utils.ForbiddenError, "webhooks can only follow the chats you receive")
+	}
+	return nil
+}
+
+func generateSecret() (string, utils.ChatErr) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", utils.ErrorKind(utils.InternalServerError, "error when trying to generate a secret")
+	}
+	return hex.EncodeToString(raw), nil
+}
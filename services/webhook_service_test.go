@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// resolvePublic keeps the lookups of webhook hosts off the network, every
+// name resolves to a public address.
+func resolvePublic(t *testing.T) {
+	lookup := domain.LookupWebhookHost
+	domain.LookupWebhookHost = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+	}
+	t.Cleanup(func() { domain.LookupWebhookHost = lookup })
+}
+
+func TestWebhooksService_CreateWebhook(t *testing.T) {
+	domain.WebhookRepo = domain.NewMemoryWebhookRepository()
+	resolvePublic(t)
+
+	_, err := WebhooksService.CreateWebhook(context.Background(), &domain.Webhook{Url: "example.com", Events: []string{"created"}})
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusUnprocessableEntity, err.Status())
+	}
+
+	first, err := WebhooksService.CreateWebhook(context.Background(), &domain.Webhook{Url: "https://example.com/hooks", Events: []string{"created"}})
+	assert.Nil(t, err)
+	second, err := WebhooksService.CreateWebhook(context.Background(), &domain.Webhook{Url: "https://example.com/hooks", Events: []string{"created"}})
+	assert.Nil(t, err)
+	//a secret is generated when none is given
+	assert.Len(t, first.Secret, 64)
+	assert.NotEqual(t, first.Secret, second.Secret)
+
+	given, err := WebhooksService.CreateWebhook(context.Background(), &domain.Webhook{Url: "https://example.com/hooks", Events: []string{"created"}, Secret: "my-own-long-secret"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "my-own-long-secret", given.Secret)
+
+	//private addresses are refused, on creation and on update
+	_, err = WebhooksService.CreateWebhook(context.Background(), &domain.Webhook{Url: "http://169.254.169.254/latest/meta-data", Events: []string{"created"}})
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusUnprocessableEntity, err.Status())
+	}
+	_, err = WebhooksService.UpdateWebhook(context.Background(), &domain.Webhook{Id: first.Id, Url: "http://127.0.0.1:8080/hooks", Events: []string{"created"}})
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusUnprocessableEntity, err.Status())
+	}
+}
+
+func TestWebhooksService_GetDeliveries(t *testing.T) {
+	domain.WebhookRepo = domain.NewMemoryWebhookRepository()
+	resolvePublic(t)
+
+	_, err := WebhooksService.GetDeliveries(context.Background(), 1, 0)
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusNotFound, err.Status())
+	}
+
+	webhook, _ := WebhooksService.CreateWebhook(context.Background(), &domain.Webhook{Url: "https://example.com/hooks", Events: []string{"created"}})
+	deliveries, err := WebhooksService.GetDeliveries(context.Background(), webhook.Id, 0)
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 0)
+
+	_, err = WebhooksService.GetDeliveries(context.Background(), webhook.Id, 101)
+	if assert.NotNil(t, err) {
+		assert.EqualValues(t, http.StatusBadRequest, err.Status())
+	}
+}
@@ -1,23 +1,57 @@
 package utils
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"strings"
 )
 
+// Driver specific codes for a unique constraint violation, and for a value
+// longer than its column.
+const (
+	mysqlDuplicateEntry     = 1062
+	mysqlDataTooLong        = 1406
+	postgresUniqueViolation = "23505"
+	postgresDataTooLong     = "22001"
+)
+
 func ParseError(err error) ChatErr {
-	sqlErr, ok := err.(*mysql.MySQLError)
-	if !ok {
+	if err == sql.ErrNoRows {
+		return ErrorKind(NotFoundError, "no record matching given id")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorKind(TimeoutError, "the database did not answer in time")
+	}
+	//the client went away, which is no failure of the server, so it is
+	//logged as a client error
+	if errors.Is(err, context.Canceled) {
+		return ErrorKind(CanceledError, "the request was canceled")
+	}
+
+	switch dbErr := err.(type) {
+	case *mysql.MySQLError:
+		if dbErr.Number == mysqlDuplicateEntry {
+			return ErrorKind(ConflictError, "record already exists")
+		}
+		if dbErr.Number == mysqlDataTooLong {
+			return ErrorKind(UnprocessableEntityError, "a value is too long to be stored")
+		}
+	case *pq.Error:
+		if dbErr.Code == postgresUniqueViolation {
+			return ErrorKind(ConflictError, "record already exists")
+		}
+		if dbErr.Code == postgresDataTooLong {
+			return ErrorKind(UnprocessableEntityError, "a value is too long to be stored")
+		}
+	default:
 		if strings.Contains(err.Error(), "no rows in result set") {
 			return ErrorKind(NotFoundError, "no record matching given id")
 		}
 		return ErrorKind(InternalServerError, fmt.Sprintf("error_utils when trying to save chat: %s", err.Error()))
 	}
-
-	switch sqlErr.Number {
-	case 1062:
-		return ErrorKind(InternalServerError, "title already taken")
-	}
 	return ErrorKind(InternalServerError, fmt.Sprintf("error_utils when processing request: %s", err.Error()))
 }
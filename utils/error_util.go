@@ -3,18 +3,60 @@ package utils
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 )
 
 type ChatErr interface {
 	Message() string
 	Status() int
 	Error() string
+	// Fields lists the invalid fields of a request, empty for other errors.
+	Fields() []FieldError
 }
 
 type chatErr struct {
-	ErrMessage string `json:"message"`
-	ErrStatus  int    `json:"status"`
-	ErrError   string `json:"error"`
+	ErrMessage string       `json:"message"`
+	ErrStatus  int          `json:"status"`
+	ErrError   string       `json:"error"`
+	ErrFields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is one invalid field of a request. Field is its JSON name and
+// Code a stable reason clients can switch on, Message is for people.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Codes of a FieldError shared by every request.
+const (
+	FieldRequired = "required"
+	FieldInvalid  = "invalid"
+)
+
+// FieldErrors collects the violations of a request, so they can all be
+// reported at once.
+type FieldErrors []FieldError
+
+func (f *FieldErrors) Add(field, code, message string) {
+	*f = append(*f, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Err is nil when nothing was added. Otherwise it is an
+// UnprocessableEntityError whose message joins those of the fields, so a
+// single failure reads as it did before fields were reported.
+func (f FieldErrors) Err() ChatErr {
+	if len(f) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(f))
+	for _, field := range f {
+		messages = append(messages, field.Message)
+	}
+	err := unprocessableEntity(strings.Join(messages, "; "))
+	err.ErrFields = append([]FieldError(nil), f...)
+	return err
 }
 
 func (e *chatErr) Error() string {
@@ -29,15 +71,30 @@ func (e *chatErr) Status() int {
 	return e.ErrStatus
 }
 
+func (e *chatErr) Fields() []FieldError {
+	return e.ErrFields
+}
+
 type ErrKind string
 
 const (
 	NotFoundError            ErrKind = "NotFoundError"
 	BadRequestError          ErrKind = "BadRequestError"
 	UnprocessableEntityError ErrKind = "UnprocessableEntityError"
+	UnauthorizedError        ErrKind = "UnauthorizedError"
+	ForbiddenError           ErrKind = "ForbiddenError"
+	ConflictError            ErrKind = "ConflictError"
+	PreconditionFailedError  ErrKind = "PreconditionFailedError"
+	PayloadTooLargeError     ErrKind = "PayloadTooLargeError"
 	InternalServerError      ErrKind = "InternalServerError"
+	TimeoutError             ErrKind = "TimeoutError"
+	CanceledError            ErrKind = "CanceledError"
 )
 
+// StatusClientClosedRequest is the status nginx logs for a client that went
+// away before the answer, net/http has no name for it.
+const StatusClientClosedRequest = 499
+
 func ErrorKind(errKind ErrKind, chat string) ChatErr {
 	switch errKind {
 	case NotFoundError:
@@ -46,8 +103,22 @@ func ErrorKind(errKind ErrKind, chat string) ChatErr {
 		return badRequest(chat)
 	case UnprocessableEntityError:
 		return unprocessableEntity(chat)
+	case UnauthorizedError:
+		return unauthorized(chat)
+	case ForbiddenError:
+		return forbidden(chat)
+	case ConflictError:
+		return conflict(chat)
+	case PreconditionFailedError:
+		return preconditionFailed(chat)
+	case PayloadTooLargeError:
+		return payloadTooLarge(chat)
 	case InternalServerError:
 		return internalServer(chat)
+	case TimeoutError:
+		return timeout(chat)
+	case CanceledError:
+		return canceled(chat)
 	}
 	return nil
 }
@@ -68,7 +139,7 @@ func badRequest(msg string) ChatErr {
 	}
 }
 
-func unprocessableEntity(msg string) ChatErr {
+func unprocessableEntity(msg string) *chatErr {
 	return &chatErr{
 		ErrMessage: msg,
 		ErrStatus:  http.StatusUnprocessableEntity,
@@ -76,6 +147,46 @@ func unprocessableEntity(msg string) ChatErr {
 	}
 }
 
+func unauthorized(msg string) ChatErr {
+	return &chatErr{
+		ErrMessage: msg,
+		ErrStatus:  http.StatusUnauthorized,
+		ErrError:   "unauthorized",
+	}
+}
+
+func forbidden(msg string) ChatErr {
+	return &chatErr{
+		ErrMessage: msg,
+		ErrStatus:  http.StatusForbidden,
+		ErrError:   "forbidden",
+	}
+}
+
+func conflict(msg string) ChatErr {
+	return &chatErr{
+		ErrMessage: msg,
+		ErrStatus:  http.StatusConflict,
+		ErrError:   "conflict",
+	}
+}
+
+func preconditionFailed(msg string) ChatErr {
+	return &chatErr{
+		ErrMessage: msg,
+		ErrStatus:  http.StatusPreconditionFailed,
+		ErrError:   "precondition_failed",
+	}
+}
+
+func payloadTooLarge(msg string) ChatErr {
+	return &chatErr{
+		ErrMessage: msg,
+		ErrStatus:  http.StatusRequestEntityTooLarge,
+		ErrError:   "payload_too_large",
+	}
+}
+
 func internalServer(msg string) ChatErr {
 	return &chatErr{
 		ErrMessage: msg,
@@ -84,10 +195,39 @@ func internalServer(msg string) ChatErr {
 	}
 }
 
+func timeout(msg string) ChatErr {
+	return &chatErr{
+		ErrMessage: msg,
+		ErrStatus:  http.StatusGatewayTimeout,
+		ErrError:   "timeout",
+	}
+}
+
+func canceled(msg string) ChatErr {
+	return &chatErr{
+		ErrMessage: msg,
+		ErrStatus:  StatusClientClosedRequest,
+		ErrError:   "canceled",
+	}
+}
+
+// NewApiErrFromBytes reads an error body, either the JSON of a ChatErr or
+// application/problem+json.
 func NewApiErrFromBytes(body []byte) (ChatErr, error) {
-	var result chatErr
+	var result struct {
+		chatErr
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
 	if err := json.Unmarshal(body, &result); err != nil {
 		return nil, err
 	}
-	return &result, nil
+	if result.ErrMessage == "" {
+		//problem details say it in detail, or only in their title
+		result.ErrMessage = result.Detail
+		if result.ErrMessage == "" {
+			result.ErrMessage = result.Title
+		}
+	}
+	return &result.chatErr, nil
 }
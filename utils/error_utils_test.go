@@ -1,6 +1,13 @@
 package utils
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"testing"
@@ -35,6 +42,34 @@ func TestErrsKind(t *testing.T) {
 			ErrStatus:  http.StatusUnprocessableEntity,
 			ErrError:   "invalid_request",
 		},
+		{
+			Name:       "Unauthorized Error",
+			ErrKind:    UnauthorizedError,
+			ErrMessage: "missing token",
+			ErrStatus:  http.StatusUnauthorized,
+			ErrError:   "unauthorized",
+		},
+		{
+			Name:       "Forbidden Error",
+			ErrKind:    ForbiddenError,
+			ErrMessage: "not yours",
+			ErrStatus:  http.StatusForbidden,
+			ErrError:   "forbidden",
+		},
+		{
+			Name:       "Conflict Error",
+			ErrKind:    ConflictError,
+			ErrMessage: "already exists",
+			ErrStatus:  http.StatusConflict,
+			ErrError:   "conflict",
+		},
+		{
+			Name:       "Precondition Failed Error",
+			ErrKind:    PreconditionFailedError,
+			ErrMessage: "stale version",
+			ErrStatus:  http.StatusPreconditionFailed,
+			ErrError:   "precondition_failed",
+		},
 		{
 			Name:       "Internal Server Error",
 			ErrKind:    InternalServerError,
@@ -42,6 +77,27 @@ func TestErrsKind(t *testing.T) {
 			ErrStatus:  http.StatusInternalServerError,
 			ErrError:   "server_error",
 		},
+		{
+			Name:       "Payload Too Large Error",
+			ErrKind:    PayloadTooLargeError,
+			ErrMessage: "too big",
+			ErrStatus:  http.StatusRequestEntityTooLarge,
+			ErrError:   "payload_too_large",
+		},
+		{
+			Name:       "Timeout Error",
+			ErrKind:    TimeoutError,
+			ErrMessage: "too slow",
+			ErrStatus:  http.StatusGatewayTimeout,
+			ErrError:   "timeout",
+		},
+		{
+			Name:       "Canceled Error",
+			ErrKind:    CanceledError,
+			ErrMessage: "gone",
+			ErrStatus:  StatusClientClosedRequest,
+			ErrError:   "canceled",
+		},
 	}
 
 	for _, tt := range tests {
@@ -53,3 +109,97 @@ func TestErrsKind(t *testing.T) {
 		})
 	}
 }
+
+func TestParseError(t *testing.T) {
+	var tests = []struct {
+		Name      string
+		Err       error
+		ErrStatus int
+		ErrError  string
+	}{
+		{
+			Name:      "No Rows",
+			Err:       sql.ErrNoRows,
+			ErrStatus: http.StatusNotFound,
+			ErrError:  "not_found",
+		},
+		{
+			Name:      "MySQL Duplicate Entry",
+			Err:       &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+			ErrStatus: http.StatusConflict,
+			ErrError:  "conflict",
+		},
+		{
+			Name:      "Postgres Unique Violation",
+			Err:       &pq.Error{Code: "23505", Message: "duplicate key value"},
+			ErrStatus: http.StatusConflict,
+			ErrError:  "conflict",
+		},
+		{
+			Name:      "MySQL Data Too Long",
+			Err:       &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'body'"},
+			ErrStatus: http.StatusUnprocessableEntity,
+			ErrError:  "invalid_request",
+		},
+		{
+			Name:      "Postgres Data Too Long",
+			Err:       &pq.Error{Code: "22001", Message: "value too long for type character varying(255)"},
+			ErrStatus: http.StatusUnprocessableEntity,
+			ErrError:  "invalid_request",
+		},
+		{
+			Name:      "Postgres Other Error",
+			Err:       &pq.Error{Code: "42P01", Message: "relation does not exist"},
+			ErrStatus: http.StatusInternalServerError,
+			ErrError:  "server_error",
+		},
+		{
+			Name:      "Deadline Exceeded",
+			Err:       fmt.Errorf("query: %w", context.DeadlineExceeded),
+			ErrStatus: http.StatusGatewayTimeout,
+			ErrError:  "timeout",
+		},
+		{
+			Name:      "Canceled",
+			Err:       fmt.Errorf("query: %w", context.Canceled),
+			ErrStatus: StatusClientClosedRequest,
+			ErrError:  "canceled",
+		},
+		{
+			Name:      "Unknown Error",
+			Err:       errors.New("connection refused"),
+			ErrStatus: http.StatusInternalServerError,
+			ErrError:  "server_error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			got := ParseError(tt.Err)
+			assert.Equal(t, tt.ErrStatus, got.Status())
+			assert.Equal(t, tt.ErrError, got.Error())
+		})
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	var fields FieldErrors
+	assert.Nil(t, fields.Err())
+
+	fields.Add("sender", FieldRequired, "Required Sender")
+	fields.Add("body", FieldRequired, "Required Body")
+	err := fields.Err()
+	assert.Equal(t, http.StatusUnprocessableEntity, err.Status())
+	assert.Equal(t, "invalid_request", err.Error())
+	assert.Equal(t, "Required Sender; Required Body", err.Message())
+	assert.Len(t, err.Fields(), 2)
+
+	//the fields go through the JSON body
+	body, _ := json.Marshal(err)
+	parsed, parseErr := NewApiErrFromBytes(body)
+	assert.Nil(t, parseErr)
+	assert.Equal(t, err.Fields(), parsed.Fields())
+
+	body, _ = json.Marshal(ErrorKind(NotFoundError, "not found"))
+	assert.NotContains(t, string(body), "fields")
+}
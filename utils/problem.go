@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/middleware"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem is the RFC 7807 form of a ChatErr. Error, RequestId and Fields are
+// extension members: the short error code, the id of the request to find it
+// in the logs, and the invalid fields of a request.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	RequestId string       `json:"request_id,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// NewProblem describes err as having happened on the request r. The errors
+// have no documentation page of their own, so Type is about:blank and Title
+// the HTTP status text, as RFC 7807 suggests.
+func NewProblem(r *http.Request, status int, err ChatErr) *Problem {
+	return &Problem{
+		Type:      "about:blank",
+		Title:     statusText(status),
+		Status:    status,
+		Detail:    err.Message(),
+		Instance:  r.URL.RequestURI(),
+		Error:     err.Error(),
+		RequestId: middleware.GetReqID(r.Context()),
+		Fields:    err.Fields(),
+	}
+}
+
+func statusText(status int) string {
+	if status == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
+
+// WantsProblem tells whether the client of r asked for problem details in
+// Accept. The others get the JSON error body of before.
+func WantsProblem(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == ProblemContentType && accepts(params) {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts tells whether the quality of a media range, 1 when left out, lets
+// it be sent. A q of 0, however written, refuses it.
+func accepts(params map[string]string) bool {
+	value, ok := params["q"]
+	if !ok {
+		return true
+	}
+	q, err := strconv.ParseFloat(value, 64)
+	return err == nil && q > 0
+}
+
+// WriteError answers r with err and the given status, as problem details
+// when the client accepts them.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, err ChatErr) {
+	if WantsProblem(r) {
+		w.Header().Set("Content-Type", ProblemContentType)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(NewProblem(r, status, err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(err)
+}
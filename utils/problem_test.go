@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWantsProblem(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/problem+json", true},
+		{"application/json, application/problem+json;q=0.9", true},
+		{"application/problem+json;q=0", false},
+		{"application/problem+json;q=0.0", false},
+		{"application/problem+json; q=0.000", false},
+		{"application/problem+json;q=0.001", true},
+		{"application/problem+json;q=abc", false},
+		{"*/*", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/chats/1", nil)
+		req.Header.Set("Accept", tt.accept)
+		assert.Equal(t, tt.want, WantsProblem(req), tt.accept)
+	}
+}
+
+func TestWriteError_Problem(t *testing.T) {
+	var fields FieldErrors
+	fields.Add("sender", FieldRequired, "Required Sender")
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/chats?draft=1", nil)
+	req.Header.Set("Accept", ProblemContentType)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "host/abc-000001"))
+	rr := httptest.NewRecorder()
+
+	WriteError(rr, req, http.StatusUnprocessableEntity, fields.Err())
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+	var problem Problem
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, Problem{
+		Type:      "about:blank",
+		Title:     "Unprocessable Entity",
+		Status:    http.StatusUnprocessableEntity,
+		Detail:    "Required Sender",
+		Instance:  "/api/v1/chats?draft=1",
+		Error:     "invalid_request",
+		RequestId: "host/abc-000001",
+		Fields:    []FieldError{{Field: "sender", Code: FieldRequired, Message: "Required Sender"}},
+	}, problem)
+
+	//both formats read back the same
+	parsed, err := NewApiErrFromBytes(rr.Body.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, parsed.Status())
+	assert.Equal(t, "Required Sender", parsed.Message())
+	assert.Equal(t, "invalid_request", parsed.Error())
+	assert.Len(t, parsed.Fields(), 1)
+}
+
+func TestWriteError_Json(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/chats/1", nil)
+	rr := httptest.NewRecorder()
+
+	WriteError(rr, req, http.StatusNotFound, ErrorKind(NotFoundError, "no record matching given id"))
+
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"message": "no record matching given id", "status": 404, "error": "not_found"}`, rr.Body.String())
+}
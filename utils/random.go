@@ -30,33 +30,33 @@ func RandomString(n int) string {
 	return sb.String()
 }
 
-var seederPhone = []string{
-	"1(234)5678901x1234",
-	"(+351) 282 43 50 50",
-	"90191919908",
-	"555-8909",
-	"001 6867684",
-	"001 6867684x1",
-	"1 (234) 567-8901",
-	"1-234-567-8901 ext1234",
-	"+62811132431",
-	"+62-811-132-431",
-	"62(751)142345",
-	"6285274507699",
-	"089899992834",
-	"+6285274507699",
-	"85274507699",
-	"8527450769999",
-}
+// Senders and receivers are drawn from separate numbers that stay distinct
+// once normalized to E.164, so a random chat is never sent to its sender.
+var (
+	seederSenders = []string{
+		"(+351) 282 43 50 50",
+		"90191919908",
+		"555-8909",
+		"001 6867684",
+		"1 (234) 567-8901",
+	}
+	seederReceivers = []string{
+		"+62811132431",
+		"62(751)142345",
+		"089899992834",
+		"+6285274507699",
+		"8527450769999",
+	}
+)
 
 func RandomSender() string {
-	get := RandomInt(0, int64(len(seederPhone)-1))
-	return seederPhone[get]
+	get := RandomInt(0, int64(len(seederSenders)-1))
+	return seederSenders[get]
 }
 
 func RandomReceiver() string {
-	get := RandomInt(0, int64(len(seederPhone)-1))
-	return seederPhone[get]
+	get := RandomInt(0, int64(len(seederReceivers)-1))
+	return seederReceivers[get]
 }
 
 func RandomBody() string {
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"github.com/SemmiDev/lets-tests/phone"
+	"testing"
+)
+
+// A seed normalizing to the number of another one would let a random chat
+// go from a phone to itself.
+func TestSeederPhones_Are_Distinct(t *testing.T) {
+	seen := make(map[string]string)
+	for _, raw := range append(append([]string(nil), seederSenders...), seederReceivers...) {
+		number, err := phone.Parse(raw)
+		if err != nil {
+			t.Fatalf("phone.Parse(%q) error = %v", raw, err)
+		}
+		if other, ok := seen[number.E164]; ok {
+			t.Errorf("%q and %q are both %s", other, raw, number.E164)
+		}
+		seen[number.E164] = raw
+	}
+}
@@ -0,0 +1,250 @@
+// Package webhooks POSTs chat events to the URLs registered under
+// /api/v1/webhooks, signed with the secret of each webhook.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/events"
+	"github.com/SemmiDev/lets-tests/utils"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Headers sent with every delivery. The signature is "sha256=" followed by
+// the hex encoded HMAC-SHA256 of the body, keyed with the webhook secret.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Payload is the body of a delivery. EventId is the same for every attempt,
+// receivers can use it to ignore duplicates.
+type Payload struct {
+	EventId int64       `json:"event_id"`
+	Type    string      `json:"type"`
+	Chat    domain.Chat `json:"chat"`
+}
+
+// Store is where the webhooks and their deliveries are kept.
+type Store interface {
+	GetAll(ctx context.Context) ([]domain.Webhook, utils.ChatErr)
+	Enqueue(ctx context.Context, deliveries []domain.WebhookDelivery) utils.ChatErr
+	Due(ctx context.Context, now time.Time, limit int) ([]domain.WebhookDelivery, utils.ChatErr)
+	SaveAttempt(ctx context.Context, delivery *domain.WebhookDelivery) utils.ChatErr
+}
+
+// Sender turns chat events into deliveries and sends them, retrying the
+// failed ones with an exponential backoff.
+type Sender struct {
+	store  Store
+	client *http.Client
+	wake   chan struct{}
+
+	// PollInterval is how long the sender sleeps when it isn't notified.
+	PollInterval time.Duration
+	BatchSize    int
+	// MaxAttempts is how many times a delivery is tried before it is
+	// marked failed.
+	MaxAttempts int
+}
+
+// DefaultSender is set up by the app once the webhook storage is known.
+var DefaultSender *Sender
+
+func NewSender(store Store, timeout time.Duration) *Sender {
+	dialer := &net.Dialer{Timeout: timeout, Control: guardDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	//a proxy would connect on our behalf, past the guard
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &Sender{
+		store:        store,
+		client:       &http.Client{Timeout: timeout, Transport: transport},
+		wake:         make(chan struct{}, 1),
+		PollInterval: time.Second,
+		BatchSize:    50,
+		MaxAttempts:  8,
+	}
+}
+
+// Enqueue is an outbox handler storing a delivery of event for every
+// webhook of its tenant interested in it.
+func (s *Sender) Enqueue(ctx context.Context, event events.Event) error {
+	webhooks, err := s.store.GetAll(domain.WithTenant(ctx, event.Tenant()))
+	if err != nil {
+		return fmt.Errorf("listing webhooks: %s", err.Message())
+	}
+
+	payload, marshalErr := json.Marshal(Payload{EventId: event.Id, Type: string(event.Type), Chat: event.Chat})
+	if marshalErr != nil {
+		return marshalErr
+	}
+	now := time.Now()
+	deliveries := make([]domain.WebhookDelivery, 0)
+	for _, webhook := range webhooks {
+		if !webhook.Matches(string(event.Type), event.Chat) {
+			continue
+		}
+		deliveries = append(deliveries, domain.WebhookDelivery{
+			WebhookId: webhook.Id,
+			EventId:   event.Id,
+			EventType: string(event.Type),
+			Payload:   string(payload),
+			CreatedAt: now,
+			NextTryAt: &now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := s.store.Enqueue(ctx, deliveries); err != nil {
+		return fmt.Errorf("enqueuing webhook deliveries: %s", err.Message())
+	}
+	s.Notify()
+	return nil
+}
+
+// Notify tells the sender deliveries were just enqueued.
+func (s *Sender) Notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends deliveries until ctx is done.
+func (s *Sender) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			sent, err := s.Send(ctx)
+			if err != nil {
+				log.Printf("sending webhooks: %v", err)
+			}
+			if err != nil || sent < s.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Send attempts every due delivery once and returns how many it tried.
+func (s *Sender) Send(ctx context.Context) (int, error) {
+	due, err := s.store.Due(ctx, time.Now(), s.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("reading due deliveries: %s", err.Message())
+	}
+
+	for i := range due {
+		delivery := &due[i]
+		s.attempt(ctx, delivery)
+		if err := s.store.SaveAttempt(ctx, delivery); err != nil {
+			return 0, fmt.Errorf("saving attempt of delivery %d: %s", delivery.Id, err.Message())
+		}
+	}
+	return len(due), nil
+}
+
+func (s *Sender) attempt(ctx context.Context, delivery *domain.WebhookDelivery) {
+	delivery.Attempts++
+	statusCode, err := s.post(ctx, delivery)
+	now := time.Now()
+	delivery.StatusCode = statusCode
+
+	if err == nil {
+		delivery.Status = domain.DeliveryDelivered
+		delivery.LastError = ""
+		delivery.NextTryAt = nil
+		delivery.SentAt = &now
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= s.MaxAttempts {
+		log.Printf("giving up on webhook delivery %d after %d attempts: %v", delivery.Id, delivery.Attempts, err)
+		delivery.Status = domain.DeliveryFailed
+		delivery.NextTryAt = nil
+		return
+	}
+	next := now.Add(events.RetryDelay(delivery.Attempts))
+	delivery.NextTryAt = &next
+}
+
+// post sends the delivery and returns the status code of the answer, zero
+// when there was none. Any status other than 2xx is a failure.
+func (s *Sender) post(ctx context.Context, delivery *domain.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Url, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, []byte(delivery.Payload)))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, fmt.Sprint(delivery.Id))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	//drain a little of the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// guardDial refuses to connect to an address that isn't public. It runs on
+// the address actually dialed, so a webhook host resolving to the internal
+// network after it was registered, or redirecting there, is caught too.
+func guardDial(network, address string, _ syscall.RawConn) error {
+	if domain.AllowPrivateWebhookTargets {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !domain.IsPublicIP(net.ParseIP(host)) {
+		return fmt.Errorf("connecting to %s is not allowed", host)
+	}
+	return nil
+}
+
+// Sign computes the SignatureHeader value of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify tells whether signature is the SignatureHeader of body, for
+// receivers written in Go.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, body)))
+}
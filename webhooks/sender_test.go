@@ -0,0 +1,200 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/SemmiDev/lets-tests/domain"
+	"github.com/SemmiDev/lets-tests/events"
+	"github.com/SemmiDev/lets-tests/utils"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const secret = "0123456789abcdef0123"
+
+// deliveryLog is the memory store, read back by the tests.
+type deliveryLog interface {
+	Store
+	Deliveries(ctx context.Context, webhookId int64, limit int) ([]domain.WebhookDelivery, utils.ChatErr)
+}
+
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.received = append(rc.received, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+// newTestSender sends to url, a test server listening on loopback.
+func newTestSender(t *testing.T, url string, webhook domain.Webhook) (*Sender, deliveryLog, domain.Chat) {
+	domain.AllowPrivateWebhookTargets = true
+	t.Cleanup(func() { domain.AllowPrivateWebhookTargets = false })
+	store := domain.NewMemoryWebhookRepository()
+	webhook.Url = url
+	webhook.Secret = secret
+	_, err := store.Create(context.Background(), &webhook)
+	assert.Nil(t, err)
+
+	chat := domain.Chat{Id: 7, Sender: "+6282323231", Receiver: "+6282323232", Body: "hello", Version: 1}
+	return NewSender(store, time.Second), store, chat
+}
+
+func TestSender_Send(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	sender, store, chat := newTestSender(t, server.URL, domain.Webhook{Events: []string{domain.ChatCreatedEvent}, Receiver: "+6282323232"})
+	ctx := context.Background()
+
+	assert.Nil(t, sender.Enqueue(ctx, events.Event{Id: 1, Type: events.ChatCreated, Chat: chat}))
+	//delivered again by the outbox, enqueued once
+	assert.Nil(t, sender.Enqueue(ctx, events.Event{Id: 1, Type: events.ChatCreated, Chat: chat}))
+	//not a type or receiver the webhook asked for
+	assert.Nil(t, sender.Enqueue(ctx, events.Event{Id: 2, Type: events.ChatUpdated, Chat: chat}))
+	other := chat
+	other.Receiver = "+6282323233"
+	assert.Nil(t, sender.Enqueue(ctx, events.Event{Id: 3, Type: events.ChatCreated, Chat: other}))
+
+	sent, err := sender.Send(ctx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, sent)
+
+	if assert.Len(t, rc.received, 1) {
+		req := rc.received[0]
+		assert.EqualValues(t, http.MethodPost, req.Method)
+		assert.EqualValues(t, "application/json", req.Header.Get("Content-Type"))
+		assert.EqualValues(t, domain.ChatCreatedEvent, req.Header.Get(EventHeader))
+		assert.EqualValues(t, "1", req.Header.Get(DeliveryHeader))
+		assert.True(t, Verify(secret, rc.bodies[0], req.Header.Get(SignatureHeader)))
+		assert.False(t, Verify("another secret!!", rc.bodies[0], req.Header.Get(SignatureHeader)))
+
+		var payload Payload
+		assert.Nil(t, json.Unmarshal(rc.bodies[0], &payload))
+		assert.EqualValues(t, 1, payload.EventId)
+		assert.EqualValues(t, domain.ChatCreatedEvent, payload.Type)
+		assert.EqualValues(t, chat.Id, payload.Chat.Id)
+		assert.EqualValues(t, chat.Body, payload.Chat.Body)
+	}
+
+	deliveries, _ := store.Deliveries(ctx, 1, 10)
+	if assert.Len(t, deliveries, 1) {
+		assert.EqualValues(t, domain.DeliveryDelivered, deliveries[0].Status)
+		assert.EqualValues(t, 1, deliveries[0].Attempts)
+		assert.EqualValues(t, http.StatusNoContent, deliveries[0].StatusCode)
+		assert.NotNil(t, deliveries[0].SentAt)
+		assert.Nil(t, deliveries[0].NextTryAt)
+	}
+
+	sent, err = sender.Send(ctx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, sent)
+}
+
+func TestSender_Send_Retries(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	sender, store, chat := newTestSender(t, server.URL, domain.Webhook{Events: []string{domain.ChatCreatedEvent}})
+	sender.MaxAttempts = 2
+	ctx := context.Background()
+	assert.Nil(t, sender.Enqueue(ctx, events.Event{Id: 1, Type: events.ChatCreated, Chat: chat}))
+
+	before := time.Now()
+	sent, err := sender.Send(ctx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, sent)
+
+	deliveries, _ := store.Deliveries(ctx, 1, 10)
+	if assert.Len(t, deliveries, 1) {
+		assert.EqualValues(t, domain.DeliveryPending, deliveries[0].Status)
+		assert.EqualValues(t, 1, deliveries[0].Attempts)
+		assert.EqualValues(t, http.StatusInternalServerError, deliveries[0].StatusCode)
+		assert.EqualValues(t, "unexpected status 500", deliveries[0].LastError)
+		if assert.NotNil(t, deliveries[0].NextTryAt) {
+			assert.True(t, deliveries[0].NextTryAt.After(before.Add(events.RetryDelay(1)-time.Millisecond)))
+		}
+	}
+
+	//not due before its backoff
+	sent, err = sender.Send(ctx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, sent)
+
+	due, _ := store.Due(ctx, time.Now().Add(time.Minute), 10)
+	if assert.Len(t, due, 1) {
+		//the last attempt allowed
+		sender.attempt(ctx, &due[0])
+		assert.Nil(t, store.SaveAttempt(ctx, &due[0]))
+	}
+
+	deliveries, _ = store.Deliveries(ctx, 1, 10)
+	if assert.Len(t, deliveries, 1) {
+		assert.EqualValues(t, domain.DeliveryFailed, deliveries[0].Status)
+		assert.EqualValues(t, 2, deliveries[0].Attempts)
+		assert.Nil(t, deliveries[0].NextTryAt)
+	}
+	assert.Len(t, rc.received, 2)
+}
+
+func TestSender_Send_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	sender, store, chat := newTestSender(t, url, domain.Webhook{Events: []string{domain.ChatCreatedEvent}})
+	ctx := context.Background()
+	assert.Nil(t, sender.Enqueue(ctx, events.Event{Id: 1, Type: events.ChatCreated, Chat: chat}))
+
+	_, err := sender.Send(ctx)
+	assert.Nil(t, err)
+
+	deliveries, _ := store.Deliveries(ctx, 1, 10)
+	if assert.Len(t, deliveries, 1) {
+		assert.EqualValues(t, domain.DeliveryPending, deliveries[0].Status)
+		assert.EqualValues(t, 0, deliveries[0].StatusCode)
+		assert.NotEmpty(t, deliveries[0].LastError)
+	}
+}
+
+func TestSender_Send_Private_Address(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	sender, store, chat := newTestSender(t, server.URL, domain.Webhook{Events: []string{domain.ChatCreatedEvent}})
+	//as if the host of the webhook resolved to loopback after it was registered
+	domain.AllowPrivateWebhookTargets = false
+	ctx := context.Background()
+	assert.Nil(t, sender.Enqueue(ctx, events.Event{Id: 1, Type: events.ChatCreated, Chat: chat}))
+
+	_, err := sender.Send(ctx)
+	assert.Nil(t, err)
+
+	deliveries, _ := store.Deliveries(ctx, 1, 10)
+	if assert.Len(t, deliveries, 1) {
+		assert.EqualValues(t, domain.DeliveryPending, deliveries[0].Status)
+		assert.Contains(t, deliveries[0].LastError, "connecting to 127.0.0.1 is not allowed")
+	}
+	assert.Len(t, rc.received, 0)
+}
+
+func TestSign(t *testing.T) {
+	//echo -n '{"a":1}' | openssl dgst -sha256 -hmac secret
+	assert.EqualValues(t, "sha256=aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494", Sign("secret", []byte(`{"a":1}`)))
+}